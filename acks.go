@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var requireAcksFlag = &cli.UintFlag{
+	Name:     "require-acks",
+	Usage:    "publish to the relays in the order given, then to the ones in <config-path>/backup-relays, until this many of them accept the event, failing otherwise",
+	Category: CATEGORY_EXTRAS,
+}
+
+var requireEOSEsFlag = &cli.UintFlag{
+	Name:  "require-eoses",
+	Usage: "end the query as soon as this many relays have sent EOSE, instead of waiting for all of them",
+}
+
+// publishUntilAcks publishes to n relays at a time, replacing each one that fails with the next one
+// in the list until n of them have accepted the event or there are no relays left.
+// returns the relays that accepted the event.
+func publishUntilAcks(ctx context.Context, evt nostr.Event, urls []string, n int) []string {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	results := make(chan nostr.PublishResult)
+	next := 0
+	inflight := 0
+	startNext := func() {
+		url := urls[next]
+		next++
+		inflight++
+		go func() {
+			for res := range sys.Pool.PublishMany(ctx, []string{url}, evt) {
+				results <- res
+			}
+		}()
+	}
+
+	successRelays := make([]string, 0, n)
+	for next < len(urls) && next < n {
+		startNext()
+	}
+	for inflight > 0 {
+		res := <-results
+		inflight--
+
+		cleanUrl, _ := strings.CutPrefix(res.RelayURL, "wss://")
+		if res.Error == nil {
+			log("publishing to %s... success.\n", colors.successf(cleanUrl))
+			successRelays = append(successRelays, res.RelayURL)
+		} else {
+			log("publishing to %s... %s\n", colors.errorf(cleanUrl), unwrapAll(res.Error))
+		}
+
+		for len(successRelays)+inflight < n && next < len(urls) {
+			startNext()
+		}
+	}
+
+	return successRelays
+}
+
+// fetchManyUntilEOSEs is like FetchManyNotifyClosed, but it ends as soon as n relays have sent EOSE.
+func fetchManyUntilEOSEs(
+	ctx context.Context,
+	urls []string,
+	filter nostr.Filter,
+	opts nostr.SubscriptionOptions,
+	n int,
+) (chan nostr.RelayEvent, chan nostr.RelayClosed) {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan nostr.RelayEvent)
+	closeds := make(chan nostr.RelayClosed)
+
+	mu := sync.Mutex{}
+	eoses := 0
+	seen := newStreamingDedup()
+
+	wg := sync.WaitGroup{}
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wasClosed := false
+			relayResults, relayCloseds := sys.Pool.FetchManyNotifyClosed(ctx, []string{url}, filter, opts)
+			for {
+				select {
+				case ie, ok := <-relayResults:
+					if !ok {
+						// the channel is closed after EOSE or CLOSED, only the first counts
+						if wasClosed || ctx.Err() != nil {
+							return
+						}
+						mu.Lock()
+						eoses++
+						if eoses == n {
+							logverbose("got %d EOSEs, ending query\n", n)
+							cancel()
+						}
+						mu.Unlock()
+						return
+					}
+
+					if seen.check(ie.Event.ID, url) {
+						continue
+					}
+
+					select {
+					case results <- ie:
+					case <-ctx.Done():
+						return
+					}
+				case closed := <-relayCloseds:
+					wasClosed = true
+					select {
+					case closeds <- closed:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+
+	if n > len(urls) {
+		log("only %d relays given, will wait for all of them\n", len(urls))
+	}
+
+	return results, closeds
+}
@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/nip86"
@@ -27,37 +30,15 @@ var admin = &cli.Command{
 	DisableSliceFlagSeparator: true,
 	Flags:                     defaultKeyFlags,
 	Commands: (func() []*cli.Command {
-		methods := []struct {
-			method string
-			args   []string
-		}{
-			{"allowpubkey", []string{"pubkey", "reason"}},
-			{"banpubkey", []string{"pubkey", "reason"}},
-			{"listallowedpubkeys", nil},
-			{"listbannedpubkeys", nil},
-			{"listeventsneedingmoderation", nil},
-			{"allowevent", []string{"id", "reason"}},
-			{"banevent", []string{"id", "reason"}},
-			{"listbannedevents", nil},
-			{"changerelayname", []string{"name"}},
-			{"changerelaydescription", []string{"description"}},
-			{"changerelayicon", []string{"icon"}},
-			{"allowkind", []string{"kind"}},
-			{"disallowkind", []string{"kind"}},
-			{"listallowedkinds", nil},
-			{"blockip", []string{"ip", "reason"}},
-			{"unblockip", []string{"ip", "reason"}},
-			{"listblockedips", nil},
-		}
-
-		commands := make([]*cli.Command, 0, len(methods))
-		for _, def := range methods {
+		commands := make([]*cli.Command, 0, len(nip86Methods))
+		for _, def := range nip86Methods {
 			def := def
 
 			flags := make([]cli.Flag, len(def.args), len(def.args)+4)
 			for i, argName := range def.args {
 				flags[i] = declareFlag(argName)
 			}
+			flags = append(flags, relayFlag)
 
 			cmd := &cli.Command{
 				Name:  def.method,
@@ -74,7 +55,7 @@ var admin = &cli.Command{
 					req := nip86.Request{Method: def.method, Params: params}
 					reqj, _ := json.Marshal(req)
 
-					relayUrls := c.Args().Slice()
+					relayUrls := getRelayURLs(c, c.Args().Slice())
 					if len(relayUrls) == 0 {
 						stdout(string(reqj))
 						return nil
@@ -86,66 +67,12 @@ var admin = &cli.Command{
 					}
 
 					for _, relayUrl := range relayUrls {
-						httpUrl := "http" + nostr.NormalizeURL(relayUrl)[2:]
-						log("calling '%s' on %s... ", def.method, httpUrl)
-						body := bytes.NewBuffer(nil)
-						body.Write(reqj)
-						req, err := http.NewRequestWithContext(ctx, "POST", httpUrl, body)
+						log("calling '%s' on %s... ", def.method, relayUrl)
+						response, err := callNIP86(ctx, kr, relayUrl, reqj)
 						if err != nil {
-							return fmt.Errorf("failed to create request: %w", err)
-						}
-
-						// Authorization
-						payloadHash := sha256.Sum256(reqj)
-						tokenEvent := nostr.Event{
-							Kind:      27235,
-							CreatedAt: nostr.Now(),
-							Tags: nostr.Tags{
-								{"u", httpUrl},
-								{"method", "POST"},
-								{"payload", hex.EncodeToString(payloadHash[:])},
-							},
-						}
-						if err := kr.SignEvent(ctx, &tokenEvent); err != nil {
-							return fmt.Errorf("failed to sign token event: %w", err)
-						}
-						evtj, _ := json.Marshal(tokenEvent)
-						req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(evtj))
-
-						// Content-Type
-						req.Header.Set("Content-Type", "application/nostr+json+rpc")
-
-						// make request to relay
-						resp, err := http.DefaultClient.Do(req)
-						if err != nil {
-							log("failed: %s\n", err)
-							continue
-						}
-						b, err := io.ReadAll(resp.Body)
-						if err != nil {
-							log("failed to read response: %s\n", err)
+							log("%s\n", err)
 							continue
 						}
-						if resp.StatusCode >= 300 {
-							log("failed with status %d\n", resp.StatusCode)
-							bodyPrintable := string(b)
-							if len(bodyPrintable) > 300 {
-								bodyPrintable = bodyPrintable[0:297] + "..."
-							}
-							log(bodyPrintable)
-							continue
-						}
-						var response nip86.Response
-						if err := json.Unmarshal(b, &response); err != nil {
-							log("bad json response: %s\n", err)
-							bodyPrintable := string(b)
-							if len(bodyPrintable) > 300 {
-								bodyPrintable = bodyPrintable[0:297] + "..."
-							}
-							log(bodyPrintable)
-							continue
-						}
-						resp.Body.Close()
 
 						// print the result
 						log("\n")
@@ -184,3 +111,168 @@ func getArgument(c *cli.Command, argName string) any {
 		return c.String(argName)
 	}
 }
+
+type nip86Method struct {
+	method string
+	args   []string
+}
+
+var nip86Methods = []nip86Method{
+	{"supportedmethods", nil},
+	{"allowpubkey", []string{"pubkey", "reason"}},
+	{"banpubkey", []string{"pubkey", "reason"}},
+	{"listallowedpubkeys", nil},
+	{"listbannedpubkeys", nil},
+	{"listeventsneedingmoderation", nil},
+	{"allowevent", []string{"id", "reason"}},
+	{"banevent", []string{"id", "reason"}},
+	{"listbannedevents", nil},
+	{"changerelayname", []string{"name"}},
+	{"changerelaydescription", []string{"description"}},
+	{"changerelayicon", []string{"icon"}},
+	{"allowkind", []string{"kind"}},
+	{"disallowkind", []string{"kind"}},
+	{"listallowedkinds", nil},
+	{"blockip", []string{"ip", "reason"}},
+	{"unblockip", []string{"ip", "reason"}},
+	{"listblockedips", nil},
+}
+
+// callNIP86 performs a management RPC call on the relay, authenticated with nip98.
+func callNIP86(ctx context.Context, kr nostr.Keyer, relayUrl string, reqj []byte) (nip86.Response, error) {
+	var response nip86.Response
+
+	httpUrl := "http" + nostr.NormalizeURL(relayUrl)[2:]
+	req, err := http.NewRequestWithContext(ctx, "POST", httpUrl, bytes.NewReader(reqj))
+	if err != nil {
+		return response, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Authorization
+	payloadHash := sha256.Sum256(reqj)
+	tokenEvent := nostr.Event{
+		Kind:      27235,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"u", httpUrl},
+			{"method", "POST"},
+			{"payload", hex.EncodeToString(payloadHash[:])},
+		},
+	}
+	if err := kr.SignEvent(ctx, &tokenEvent); err != nil {
+		return response, fmt.Errorf("failed to sign token event: %w", err)
+	}
+	evtj, _ := json.Marshal(tokenEvent)
+	req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(evtj))
+
+	// Content-Type
+	req.Header.Set("Content-Type", "application/nostr+json+rpc")
+
+	// make request to relay
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response, fmt.Errorf("failed: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, fmt.Errorf("failed to read response: %w", err)
+	}
+	bodyPrintable := string(b)
+	if len(bodyPrintable) > 300 {
+		bodyPrintable = bodyPrintable[0:297] + "..."
+	}
+	if resp.StatusCode >= 300 {
+		return response, fmt.Errorf("failed with status %d\n%s", resp.StatusCode, bodyPrintable)
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return response, fmt.Errorf("bad json response: %w\n%s", err, bodyPrintable)
+	}
+
+	return response, nil
+}
+
+var relayAdmin = &cli.Command{
+	Name:  "admin",
+	Usage: "calls a relay management API method, with the parameters given as positional arguments",
+	Description: `like 'nak admin', but taking the relay url first and the parameters as arguments, in order. method names can be written with dashes.
+
+examples:
+		nak relay admin myrelay.com ban-pubkey npub1... "spam"
+		nak relay admin myrelay.com list-banned-pubkeys
+		nak relay admin myrelay.com change-relay-name "My Relay"`,
+	ArgsUsage:                 "<relay-url> <method> [param...]",
+	DisableSliceFlagSeparator: true,
+	Flags:                     append(defaultKeyFlags, relayFlag),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		relayUrl, args, err := getSingleRelayURL(c)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("need a method, one of: %s", nip86MethodNames())
+		}
+		method := strings.ReplaceAll(strings.ToLower(args[0]), "-", "")
+		given := args[1:]
+
+		idx := slices.IndexFunc(nip86Methods, func(m nip86Method) bool { return m.method == method })
+		if idx == -1 {
+			return fmt.Errorf("unknown method '%s', expected one of: %s", args[0], nip86MethodNames())
+		}
+		def := nip86Methods[idx]
+
+		params := make([]any, len(def.args))
+		for i, argName := range def.args {
+			if i >= len(given) {
+				if argName == "reason" {
+					params[i] = ""
+					continue
+				}
+				return fmt.Errorf("missing <%s> for '%s'", argName, def.method)
+			}
+			switch argName {
+			case "kind":
+				kind, err := strconv.Atoi(given[i])
+				if err != nil {
+					return fmt.Errorf("invalid kind '%s'", given[i])
+				}
+				params[i] = kind
+			case "pubkey":
+				pk, err := parsePubKey(given[i])
+				if err != nil {
+					return fmt.Errorf("invalid pubkey '%s': %w", given[i], err)
+				}
+				params[i] = pk.Hex()
+			default:
+				params[i] = given[i]
+			}
+		}
+
+		kr, _, err := gatherKeyerFromArguments(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		reqj, _ := json.Marshal(nip86.Request{Method: def.method, Params: params})
+		logverbose("calling '%s' on %s...\n", def.method, relayUrl)
+		response, err := callNIP86(ctx, kr, relayUrl, reqj)
+		if err != nil {
+			return err
+		}
+		if response.Error != "" {
+			return fmt.Errorf("relay returned an error: %s", response.Error)
+		}
+
+		pretty, _ := json.MarshalIndent(response.Result, "", "  ")
+		stdout(string(pretty))
+		return nil
+	},
+}
+
+func nip86MethodNames() string {
+	names := make([]string, len(nip86Methods))
+	for i, m := range nip86Methods {
+		names[i] = m.method
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var antispamFlag = &cli.BoolFlag{
+	Name:  "antispam",
+	Usage: "score events with some spam heuristics (pubkeys without a profile, the same content from many pubkeys, too many tags, known spam patterns) and hide the ones that reach the threshold",
+}
+
+var antispamRulesFlag = &cli.StringFlag{
+	Name:      "antispam-rules",
+	Usage:     "json file with the scores and threshold used by --antispam (implies --antispam)",
+	TakesFile: true,
+}
+
+type antispamPattern struct {
+	Regex string `json:"regex"`
+	Score int    `json:"score"`
+
+	re *regexp.Regexp
+}
+
+type antispamRules struct {
+	Threshold int `json:"threshold"`
+
+	NoProfile         int `json:"no_profile"`
+	YoungProfile      int `json:"young_profile"`
+	YoungProfileHours int `json:"young_profile_hours"`
+
+	DuplicateContent          int `json:"duplicate_content"`
+	DuplicateContentMinLength int `json:"duplicate_content_min_length"`
+
+	ExcessiveTags int `json:"excessive_tags"`
+	MaxTags       int `json:"max_tags"`
+
+	Patterns []antispamPattern `json:"patterns"`
+}
+
+var defaultAntispamRules = antispamRules{
+	Threshold: 5,
+
+	NoProfile:         3,
+	YoungProfile:      2,
+	YoungProfileHours: 24,
+
+	DuplicateContent:          4,
+	DuplicateContentMinLength: 20,
+
+	ExcessiveTags: 3,
+	MaxTags:       30,
+
+	Patterns: []antispamPattern{
+		{Regex: `(?i)\b(free|claim)\b.{0,20}\b(btc|bitcoin|sats|airdrop|tokens?)\b`, Score: 4},
+		{Regex: `(?i)\bairdrop\b`, Score: 2},
+		{Regex: `(?i)t\.me/`, Score: 2},
+		{Regex: `(?i)(whatsapp|telegram)\s*:?\s*\+?\d{6,}`, Score: 4},
+	},
+}
+
+type antispam struct {
+	rules antispamRules
+
+	mu           sync.Mutex
+	contentOwner map[[32]byte]nostr.PubKey
+}
+
+func loadAntispamRules(path string) (antispamRules, error) {
+	rules := defaultAntispamRules
+	rules.Patterns = slices.Clone(defaultAntispamRules.Patterns)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return rules, fmt.Errorf("failed to read antispam rules: %w", err)
+		}
+		// fields missing from the file keep their default values
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return rules, fmt.Errorf("invalid antispam rules in %s: %w", path, err)
+		}
+	}
+
+	for i, p := range rules.Patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return rules, fmt.Errorf("invalid antispam pattern '%s': %w", p.Regex, err)
+		}
+		rules.Patterns[i].re = re
+	}
+
+	return rules, nil
+}
+
+// score returns how spammy an event looks according to the rules, along with the reasons.
+func (as *antispam) score(ctx context.Context, evt nostr.Event) (int, []string) {
+	score := 0
+	reasons := make([]string, 0, 2)
+
+	if as.rules.NoProfile != 0 || as.rules.YoungProfile != 0 {
+		pm := sys.FetchProfileMetadata(ctx, evt.PubKey)
+		if pm.Event == nil {
+			score += as.rules.NoProfile
+			reasons = append(reasons, "no profile")
+		} else if as.rules.YoungProfileHours > 0 &&
+			time.Since(pm.Event.CreatedAt.Time()) < time.Duration(as.rules.YoungProfileHours)*time.Hour {
+			score += as.rules.YoungProfile
+			reasons = append(reasons, "young profile")
+		}
+	}
+
+	if as.rules.DuplicateContent != 0 && len(evt.Content) >= as.rules.DuplicateContentMinLength {
+		hash := sha256.Sum256([]byte(strings.TrimSpace(evt.Content)))
+		as.mu.Lock()
+		if owner, ok := as.contentOwner[hash]; ok {
+			if owner != evt.PubKey {
+				score += as.rules.DuplicateContent
+				reasons = append(reasons, "duplicate content")
+			}
+		} else {
+			if len(as.contentOwner) > 100_000 {
+				// keep memory bounded on long-running streams
+				as.contentOwner = make(map[[32]byte]nostr.PubKey)
+			}
+			as.contentOwner[hash] = evt.PubKey
+		}
+		as.mu.Unlock()
+	}
+
+	if as.rules.MaxTags > 0 && len(evt.Tags) > as.rules.MaxTags {
+		score += as.rules.ExcessiveTags
+		reasons = append(reasons, fmt.Sprintf("%d tags", len(evt.Tags)))
+	}
+
+	for _, p := range as.rules.Patterns {
+		if p.re.MatchString(evt.Content) {
+			score += p.Score
+			reasons = append(reasons, "matches "+p.Regex)
+		}
+	}
+
+	return score, reasons
+}
+
+// setupAntispam makes stdout skip the events that reach the spam score threshold when --antispam is given.
+func setupAntispam(ctx context.Context, c *cli.Command) error {
+	if !c.Bool("antispam") && !c.IsSet("antispam-rules") {
+		return nil
+	}
+
+	rules, err := loadAntispamRules(c.String("antispam-rules"))
+	if err != nil {
+		return err
+	}
+	as := &antispam{
+		rules:        rules,
+		contentOwner: make(map[[32]byte]nostr.PubKey),
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				if score, reasons := as.score(ctx, evt); score >= as.rules.Threshold {
+					logverbose("hiding spam event %s with score %d (%s)\n", evt.ID.Hex(), score, strings.Join(reasons, ", "))
+					return
+				}
+			}
+		}
+		printNext(args...)
+	}
+	return nil
+}
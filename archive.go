@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var archive = &cli.Command{
+	Name:  "archive",
+	Usage: "keeps a local content-addressed archive of events, one file per event",
+	Description: `events are stored at <archive>/<first 2 chars of id>/<id>.json, so the same event is never stored twice and each file can be checked against its own name.
+
+example:
+		nak req -a <my-pubkey> relay.damus.io | nak archive add
+		nak archive verify
+		nak archive gc --kind 7`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "path",
+			Usage:       "directory where the archive is kept",
+			DefaultText: "<config-path>/archive",
+			TakesFile:   true,
+		},
+	},
+	Commands: []*cli.Command{
+		{
+			Name:                      "add",
+			Usage:                     "stores events given through stdin, after checking them",
+			DisableSliceFlagSeparator: true,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				dir := archivePath(c)
+				added, existing := 0, 0
+				for stdinEvent := range getJsonsOrBlank() {
+					if stdinEvent == "{}" {
+						return fmt.Errorf("no events given on stdin")
+					}
+
+					var evt nostr.Event
+					if err := easyjson.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+						ctx = lineProcessingError(ctx, "invalid event: %s", err)
+						continue
+					}
+					if validID, validSig := verifyEventCached(evt); !validID || !validSig {
+						ctx = lineProcessingError(ctx, "event %s has an invalid id or signature, not archiving", evt.ID.Hex())
+						continue
+					}
+
+					path := archiveEventPath(dir, evt.ID)
+					if _, err := os.Stat(path); err == nil {
+						existing++
+						continue
+					}
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						return fmt.Errorf("failed to create archive directory: %w", err)
+					}
+
+					// write to a temporary file first so we never leave a half-written event behind
+					j, _ := easyjson.Marshal(evt)
+					tmp := path + ".tmp"
+					if err := os.WriteFile(tmp, j, 0644); err != nil {
+						return fmt.Errorf("failed to write %s: %w", path, err)
+					}
+					if err := os.Rename(tmp, path); err != nil {
+						return fmt.Errorf("failed to write %s: %w", path, err)
+					}
+					added++
+					logverbose("archived %s\n", evt.ID.Hex())
+				}
+
+				log("%d events added, %d were already archived\n", added, existing)
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:                      "verify",
+			Usage:                     "checks the id and signature of every archived event, reporting corrupted files",
+			DisableSliceFlagSeparator: true,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				total := 0
+				err := walkArchive(archivePath(c), func(path string, id nostr.ID, evt *nostr.Event, err error) {
+					total++
+					if err != nil {
+						ctx = lineProcessingError(ctx, "%s: unreadable: %s", path, err)
+						return
+					}
+					if evt.ID != id {
+						ctx = lineProcessingError(ctx, "%s: contains event %s", path, evt.ID.Hex())
+						return
+					}
+					// we don't use the cache here, the whole point is to check what is in the disk
+					if evt.GetID() != evt.ID {
+						ctx = lineProcessingError(ctx, "%s: id doesn't match the event contents", path)
+						return
+					}
+					if !evt.VerifySignature() {
+						ctx = lineProcessingError(ctx, "%s: invalid signature", path)
+						return
+					}
+				})
+				if err != nil {
+					return err
+				}
+
+				log("%d events checked\n", total)
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:                      "gc",
+			Usage:                     "removes leftover temporary files and corrupted events, and optionally events matching the given filter",
+			DisableSliceFlagSeparator: true,
+			Flags:                     reqFilterFlags,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				filter := nostr.Filter{}
+				if err := applyFlagsToFilter(c, &filter); err != nil {
+					return err
+				}
+				hasFilter := len(filter.IDs) > 0 || len(filter.Authors) > 0 || len(filter.Kinds) > 0 ||
+					len(filter.Tags) > 0 || filter.Since != 0 || filter.Until != 0
+
+				dir := archivePath(c)
+				removed := 0
+				remove := func(path string, why string) {
+					if err := os.Remove(path); err != nil {
+						log("failed to remove %s: %s\n", path, err)
+						return
+					}
+					logverbose("removed %s: %s\n", path, why)
+					removed++
+				}
+
+				filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+					if err == nil && !d.IsDir() && strings.HasSuffix(path, ".tmp") {
+						remove(path, "temporary file")
+					}
+					return nil
+				})
+
+				err := walkArchive(dir, func(path string, id nostr.ID, evt *nostr.Event, err error) {
+					switch {
+					case err != nil:
+						remove(path, "unreadable")
+					case evt.ID != id || evt.GetID() != evt.ID:
+						remove(path, "corrupted")
+					case hasFilter && filter.Matches(*evt):
+						remove(path, "matches filter")
+					}
+				})
+				if err != nil {
+					return err
+				}
+
+				log("%d files removed\n", removed)
+				return nil
+			},
+		},
+	},
+}
+
+func archivePath(c *cli.Command) string {
+	if path := c.String("path"); path != "" {
+		return path
+	}
+	return filepath.Join(c.String("config-path"), "archive")
+}
+
+func archiveEventPath(dir string, id nostr.ID) string {
+	hex := id.Hex()
+	return filepath.Join(dir, hex[0:2], hex+".json")
+}
+
+// walkArchive calls fn for each event file in the archive, with the id taken from the file name.
+func walkArchive(dir string, fn func(path string, id nostr.ID, evt *nostr.Event, err error)) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("no archive at %s", dir)
+	}
+
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		id, err := nostr.IDFromHex(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			// not one of ours
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fn(path, id, nil, err)
+			return nil
+		}
+		var evt nostr.Event
+		if err := easyjson.Unmarshal(data, &evt); err != nil {
+			fn(path, id, nil, err)
+			return nil
+		}
+		fn(path, id, &evt, nil)
+		return nil
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip27"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/charmbracelet/glamour"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+	"github.com/yuin/goldmark"
+)
+
+var article = &cli.Command{
+	Name:  "article",
+	Usage: "fetches and renders nip23 long-form articles and nip54 wiki articles",
+	Description: `examples:
+		nak article get naddr1...
+		nak article get --markdown naddr1... > article.md
+		nak article get --html naddr1... > article.html
+		nak article list npub1...
+		nak article list --wiki npub1...`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "get",
+			Usage:                     "fetches an article and renders it to the terminal",
+			ArgsUsage:                 "<naddr|nevent>",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "markdown",
+					Usage: "output the article as markdown with the nostr references resolved",
+				},
+				&cli.BoolFlag{
+					Name:  "html",
+					Usage: "output the article as an html fragment",
+				},
+				&cli.BoolFlag{
+					Name:  "raw",
+					Usage: "do not resolve nostr: references in the content",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Bool("markdown") && c.Bool("html") {
+					return fmt.Errorf("incompatible flags --markdown and --html")
+				}
+
+				for code := range getStdinLinesOrArguments(c.Args()) {
+					ptr, err := parsePointer(code)
+					if err != nil {
+						ctx = lineProcessingError(ctx, "%s", err)
+						continue
+					}
+
+					evt, _, err := sys.FetchSpecificEvent(ctx, ptr, sdk.FetchSpecificEventParameters{})
+					if err != nil {
+						ctx = lineProcessingError(ctx, "failed to fetch article: %s", err)
+						continue
+					}
+					if evt.Kind != nostr.KindArticle && evt.Kind != nostr.KindWikiArticle {
+						ctx = lineProcessingError(ctx, "event is not an article (expected kind 30023 or 30818, got %d)", evt.Kind)
+						continue
+					}
+
+					content := evt.Content
+					if !c.Bool("raw") {
+						content = resolveNostrReferences(ctx, content)
+					}
+
+					md := articleHeader(ctx, *evt) + content
+
+					switch {
+					case c.Bool("markdown"):
+						stdout(md)
+					case c.Bool("html"):
+						var buf bytes.Buffer
+						if err := goldmark.Convert([]byte(md), &buf); err != nil {
+							ctx = lineProcessingError(ctx, "failed to render html: %s", err)
+							continue
+						}
+						stdout(buf.String())
+					default:
+						rendered, err := glamour.Render(md, "auto")
+						if err != nil {
+							ctx = lineProcessingError(ctx, "failed to render markdown: %s", err)
+							continue
+						}
+						stdout(rendered)
+					}
+				}
+
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:                      "list",
+			Usage:                     "lists the articles written by a pubkey",
+			ArgsUsage:                 "<pubkey> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&cli.BoolFlag{
+					Name:  "wiki",
+					Usage: "list nip54 wiki articles (kind 30818) instead of long-form articles (kind 30023)",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the article events as json",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing pubkey")
+				}
+				pk, err := parsePubKey(c.Args().First())
+				if err != nil {
+					return err
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = sys.FetchOutboxRelays(ctx, pk, 3)
+				}
+				if len(relays) == 0 {
+					return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+				}
+
+				kind := nostr.KindArticle
+				if c.Bool("wiki") {
+					kind = nostr.KindWikiArticle
+				}
+
+				results := sys.Pool.FetchManyReplaceable(ctx, relays, nostr.Filter{
+					Kinds:   []nostr.Kind{kind},
+					Authors: []nostr.PubKey{pk},
+				}, nostr.SubscriptionOptions{Label: "nak-article"})
+
+				articles := make([]nostr.Event, 0, results.Size())
+				for _, evt := range results.Range {
+					articles = append(articles, evt)
+				}
+				slices.SortFunc(articles, func(a, b nostr.Event) int {
+					return int(articlePublishedAt(b) - articlePublishedAt(a))
+				})
+
+				for _, evt := range articles {
+					if c.Bool("json") {
+						stdout(evt)
+						continue
+					}
+
+					stdout(color.YellowString(articlePublishedAt(evt).Time().Format("2006-01-02")) + " " +
+						colors.bold(articleTitle(evt)) + " " +
+						color.BlueString(nip19.EncodeNaddr(evt.PubKey, evt.Kind, evt.Tags.GetD(), nil)))
+				}
+
+				return nil
+			},
+		},
+	},
+}
+
+func articleTitle(evt nostr.Event) string {
+	if title := evt.Tags.Find("title"); title != nil && title[1] != "" {
+		return title[1]
+	}
+	return evt.Tags.GetD()
+}
+
+func articlePublishedAt(evt nostr.Event) nostr.Timestamp {
+	if pa := evt.Tags.Find("published_at"); pa != nil {
+		if ts, err := strconv.ParseInt(pa[1], 10, 64); err == nil {
+			return nostr.Timestamp(ts)
+		}
+	}
+	return evt.CreatedAt
+}
+
+func articleHeader(ctx context.Context, evt nostr.Event) string {
+	author := sys.FetchProfileMetadata(ctx, evt.PubKey)
+
+	header := "# " + articleTitle(evt) + "\n\n"
+	header += "_by " + author.ShortName() + ", " + articlePublishedAt(evt).Time().Format(time.DateOnly) + "_\n\n"
+	if summary := evt.Tags.Find("summary"); summary != nil && summary[1] != "" {
+		header += "> " + summary[1] + "\n\n"
+	}
+	if image := evt.Tags.Find("image"); image != nil && image[1] != "" {
+		header += "![](" + image[1] + ")\n\n"
+	}
+	return header + "---\n\n"
+}
+
+// resolveNostrReferences replaces nip27 "nostr:..." references in markdown content with links,
+// using the names of the mentioned profiles and titles of the mentioned articles when possible.
+func resolveNostrReferences(ctx context.Context, content string) string {
+	var result strings.Builder
+	for block := range nip27.Parse(content) {
+		code := strings.TrimPrefix(block.Text, "nostr:")
+		if !strings.HasPrefix(block.Text, "nostr:") || block.Pointer == nil {
+			// plain text or something that looked like a reference but didn't decode
+			result.WriteString(block.Text)
+			continue
+		}
+
+		text := code
+		if len(text) > 12 {
+			text = text[0:12] + "…"
+		}
+		switch ptr := block.Pointer.(type) {
+		case nostr.ProfilePointer:
+			text = "@" + sys.FetchProfileMetadata(ctx, ptr.PublicKey).ShortName()
+		case nostr.EntityPointer:
+			if evt, _, err := sys.FetchSpecificEvent(ctx, ptr, sdk.FetchSpecificEventParameters{}); err == nil {
+				text = articleTitle(*evt)
+			}
+		}
+
+		result.WriteString("[" + text + "](https://njump.me/" + code + ")")
+	}
+	return result.String()
+}
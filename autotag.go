@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip05"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip27"
+)
+
+var (
+	nip05MentionRegex = regexp.MustCompile(`(^|\s)@([\w.+-]+@[\w-]+(?:\.[\w-]+)+)`)
+	hashtagRegex      = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_]*\p{L}[\p{L}\p{N}_]*)`)
+	urlRegex          = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+// resolveNIP05Mentions replaces @name@domain.com handles in the content with nostr:nprofile references.
+// handles that can't be resolved are left as they are.
+func resolveNIP05Mentions(ctx context.Context, content string) string {
+	return nip05MentionRegex.ReplaceAllStringFunc(content, func(match string) string {
+		groups := nip05MentionRegex.FindStringSubmatch(match)
+		pp, err := nip05.QueryIdentifier(ctx, groups[2])
+		if err != nil {
+			log("failed to resolve mention @%s: %s\n", groups[2], err)
+			return match
+		}
+		harvestPointerHints(*pp)
+		return groups[1] + "nostr:" + nip19.EncodeNprofile(pp.PublicKey, pp.Relays)
+	})
+}
+
+// autotagContent adds p tags for profiles and q tags for events mentioned in the content with
+// nip27 references, returns true if any tag was added.
+func autotagContent(evt *nostr.Event) bool {
+	added := false
+	add := func(tag nostr.Tag) {
+		if evt.Tags.FindWithValue(tag[0], tag[1]) == nil {
+			evt.Tags = append(evt.Tags, tag)
+			added = true
+		}
+	}
+
+	for block := range nip27.Parse(evt.Content) {
+		if !strings.HasPrefix(block.Text, "nostr:") {
+			continue
+		}
+
+		switch ptr := block.Pointer.(type) {
+		case nostr.ProfilePointer:
+			add(ptr.AsTag())
+		case nostr.EventPointer:
+			tag := nostr.Tag{"q", ptr.ID.Hex(), "", ""}
+			if len(ptr.Relays) > 0 {
+				tag[2] = ptr.Relays[0]
+			}
+			if ptr.Author != nostr.ZeroPK {
+				tag[3] = ptr.Author.Hex()
+				add(nostr.Tag{"p", ptr.Author.Hex()})
+			} else {
+				tag = tag[0:3]
+			}
+			add(tag)
+		case nostr.EntityPointer:
+			tag := nostr.Tag{"q", ptr.AsTagReference()}
+			if len(ptr.Relays) > 0 {
+				tag = append(tag, ptr.Relays[0])
+			}
+			add(tag)
+			add(nostr.Tag{"p", ptr.PublicKey.Hex()})
+		}
+	}
+
+	return added
+}
+
+// autotagHashtagsAndURLs adds lowercase t tags for the #hashtags and r tags for the urls in the
+// content, like most clients do for kind:1 notes, returns true if any tag was added.
+func autotagHashtagsAndURLs(evt *nostr.Event) bool {
+	added := false
+
+	for _, match := range hashtagRegex.FindAllStringSubmatch(evt.Content, -1) {
+		hashtag := strings.ToLower(match[1])
+		if evt.Tags.FindWithValue("t", hashtag) == nil {
+			evt.Tags = append(evt.Tags, nostr.Tag{"t", hashtag})
+			added = true
+		}
+	}
+
+	for _, url := range urlRegex.FindAllString(evt.Content, -1) {
+		url = strings.TrimRight(url, ".,;:!?)]}'")
+		if evt.Tags.FindWithValue("r", url) == nil {
+			evt.Tags = append(evt.Tags, nostr.Tag{"r", url})
+			added = true
+		}
+	}
+
+	return added
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+type bolt11Hop struct {
+	PubKey                    string `json:"pubkey"`
+	ShortChannelID            string `json:"short_channel_id"`
+	FeeBaseMsat               uint32 `json:"fee_base_msat"`
+	FeeProportionalMillionths uint32 `json:"fee_proportional_millionths"`
+	CLTVExpiryDelta           uint16 `json:"cltv_expiry_delta"`
+}
+
+type bolt11Invoice struct {
+	Network         string        `json:"network"`
+	AmountMsat      uint64        `json:"amount_msat,omitempty"`
+	Timestamp       int64         `json:"timestamp"`
+	Expiry          int64         `json:"expiry"`
+	PaymentHash     string        `json:"payment_hash"`
+	PaymentSecret   string        `json:"payment_secret,omitempty"`
+	Description     string        `json:"description,omitempty"`
+	DescriptionHash string        `json:"description_hash,omitempty"`
+	Payee           string        `json:"payee"`
+	MinFinalCLTV    uint64        `json:"min_final_cltv_expiry"`
+	RouteHints      [][]bolt11Hop `json:"route_hints,omitempty"`
+}
+
+var bolt11Networks = map[string]string{
+	"bc":   "mainnet",
+	"tb":   "testnet",
+	"tbs":  "signet",
+	"bcrt": "regtest",
+	"sb":   "simnet",
+}
+
+// decodeBolt11 parses a lightning invoice and checks its signature, recovering the payee
+// pubkey from it when the invoice doesn't have an explicit "n" field.
+func decodeBolt11(invoice string) (*bolt11Invoice, error) {
+	invoice = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(invoice)), "lightning:")
+
+	hrp, data, err := bech32.DecodeNoLimit(invoice)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bech32: %w", err)
+	}
+	if !strings.HasPrefix(hrp, "ln") {
+		return nil, fmt.Errorf("not a lightning invoice")
+	}
+	// timestamp (7 groups) and signature (104 groups) are mandatory
+	if len(data) < 7+104 {
+		return nil, fmt.Errorf("invoice too short")
+	}
+
+	inv := &bolt11Invoice{
+		Expiry:       3600,
+		MinFinalCLTV: 18,
+	}
+
+	// human-readable part: ln + network + optional amount with multiplier
+	rest := hrp[2:]
+	idx := strings.IndexAny(rest, "0123456789")
+	network := rest
+	if idx != -1 {
+		network = rest[0:idx]
+		msat, err := parseBolt11Amount(rest[idx:])
+		if err != nil {
+			return nil, err
+		}
+		inv.AmountMsat = msat
+	}
+	if name, ok := bolt11Networks[network]; ok {
+		inv.Network = name
+	} else {
+		inv.Network = network
+	}
+
+	fields := data[0 : len(data)-104]
+	sigGroups := data[len(data)-104:]
+
+	inv.Timestamp = int64(groupsToUint(fields[0:7]))
+	fields = fields[7:]
+
+	var payee []byte
+	for len(fields) >= 3 {
+		typ := fields[0]
+		length := int(groupsToUint(fields[1:3]))
+		if len(fields) < 3+length {
+			return nil, fmt.Errorf("truncated field of type %d", typ)
+		}
+		value := fields[3 : 3+length]
+		fields = fields[3+length:]
+
+		switch typ {
+		case 1: // p
+			if b, err := bech32.ConvertBits(value, 5, 8, false); err == nil && len(b) == 32 {
+				inv.PaymentHash = hex.EncodeToString(b)
+			}
+		case 16: // s
+			if b, err := bech32.ConvertBits(value, 5, 8, false); err == nil && len(b) == 32 {
+				inv.PaymentSecret = hex.EncodeToString(b)
+			}
+		case 13: // d
+			if b, err := bech32.ConvertBits(value, 5, 8, false); err == nil {
+				inv.Description = string(b)
+			}
+		case 23: // h
+			if b, err := bech32.ConvertBits(value, 5, 8, false); err == nil && len(b) == 32 {
+				inv.DescriptionHash = hex.EncodeToString(b)
+			}
+		case 19: // n
+			if b, err := bech32.ConvertBits(value, 5, 8, false); err == nil && len(b) == 33 {
+				payee = b
+			}
+		case 6: // x
+			inv.Expiry = int64(groupsToUint(value))
+		case 24: // c
+			inv.MinFinalCLTV = groupsToUint(value)
+		case 3: // r
+			b, err := bech32.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				continue
+			}
+			route := make([]bolt11Hop, 0, len(b)/51)
+			for ; len(b) >= 51; b = b[51:] {
+				scid := binary.BigEndian.Uint64(b[33:41])
+				route = append(route, bolt11Hop{
+					PubKey:                    hex.EncodeToString(b[0:33]),
+					ShortChannelID:            fmt.Sprintf("%dx%dx%d", scid>>40, (scid>>16)&0xffffff, scid&0xffff),
+					FeeBaseMsat:               binary.BigEndian.Uint32(b[41:45]),
+					FeeProportionalMillionths: binary.BigEndian.Uint32(b[45:49]),
+					CLTVExpiryDelta:           binary.BigEndian.Uint16(b[49:51]),
+				})
+			}
+			inv.RouteHints = append(inv.RouteHints, route)
+		}
+	}
+
+	if inv.PaymentHash == "" {
+		return nil, fmt.Errorf("invoice has no payment hash")
+	}
+
+	// the signature is over the hrp and the data part (without the signature) converted to bytes
+	sig, err := bech32.ConvertBits(sigGroups, 5, 8, false)
+	if err != nil || len(sig) != 65 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	signed, err := bech32.ConvertBits(data[0:len(data)-104], 5, 8, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	hash := sha256.Sum256(append([]byte(hrp), signed...))
+
+	compact := make([]byte, 65)
+	compact[0] = 27 + 4 + sig[64]
+	copy(compact[1:], sig[0:64])
+	recovered, _, err := ecdsa.RecoverCompact(compact, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if payee != nil && hex.EncodeToString(payee) != hex.EncodeToString(recovered.SerializeCompressed()) {
+		return nil, fmt.Errorf("signature doesn't match the payee %x", payee)
+	}
+	inv.Payee = hex.EncodeToString(recovered.SerializeCompressed())
+
+	return inv, nil
+}
+
+func parseBolt11Amount(s string) (uint64, error) {
+	multiplier := s[len(s)-1]
+	digits := s
+	if multiplier >= 'a' && multiplier <= 'z' {
+		digits = s[0 : len(s)-1]
+	} else {
+		multiplier = 0
+	}
+
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s'", s)
+	}
+
+	// 1 btc is 100_000_000_000 msat
+	switch multiplier {
+	case 0:
+		return n * 100_000_000_000, nil
+	case 'm':
+		return n * 100_000_000, nil
+	case 'u':
+		return n * 100_000, nil
+	case 'n':
+		return n * 100, nil
+	case 'p':
+		if n%10 != 0 {
+			return 0, fmt.Errorf("invalid sub-millisatoshi amount '%s'", s)
+		}
+		return n / 10, nil
+	default:
+		return 0, fmt.Errorf("invalid amount multiplier '%c'", multiplier)
+	}
+}
+
+func groupsToUint(groups []byte) uint64 {
+	var n uint64
+	for _, g := range groups {
+		n = n<<5 | uint64(g)
+	}
+	return n
+}
@@ -62,11 +62,7 @@ var bunker = &cli.Command{
 			Aliases: []string{"k"},
 			Usage:   "pubkeys for which we will always respond",
 		},
-		&cli.StringSliceFlag{
-			Name:   "relay",
-			Usage:  "relays to connect to (can also be provided as naked arguments)",
-			Hidden: true,
-		},
+		relayFlag,
 		&cli.BoolFlag{
 			Name:  "qrcode",
 			Usage: "display a QR code for the bunker URI",
@@ -75,10 +71,7 @@ var bunker = &cli.Command{
 	Action: func(ctx context.Context, c *cli.Command) error {
 		// read config from file
 		config := BunkerConfig{}
-		baseRelaysUrls := appendUnique(c.Args().Slice(), c.StringSlice("relay")...)
-		for i, url := range baseRelaysUrls {
-			baseRelaysUrls[i] = nostr.NormalizeURL(url)
-		}
+		baseRelaysUrls := getRelayURLs(c, c.Args().Slice())
 		baseAuthorizedKeys := getPubKeySlice(c, "authorized-keys")
 
 		var baseSecret plainOrEncryptedKey
@@ -151,7 +144,7 @@ var bunker = &cli.Command{
 			}
 
 			for i, url := range config.Relays {
-				config.Relays[i] = nostr.NormalizeURL(url)
+				config.Relays[i] = normalizeRelayURL(url)
 			}
 			config.Relays = appendUnique(config.Relays, baseRelaysUrls...)
 			for _, bak := range baseAuthorizedKeys {
@@ -331,7 +324,7 @@ var bunker = &cli.Command{
 			}
 
 			// print QR code if requested
-			if c.Bool("qrcode") {
+			if c.Bool("qrcode") || c.Bool("qr") {
 				log("QR Code for bunker URI:\n")
 				qrterminal.Generate(bunkerURI, qrterminal.L, os.Stdout)
 				log("\n\n")
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip52"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var rsvpStatuses = []string{"accepted", "declined", "tentative"}
+
+var calendar = &cli.Command{
+	Name:  "calendar",
+	Usage: "creates, lists and replies to nip52 calendar events",
+	Description: `examples:
+		nak calendar create --title "nostr meetup" --start "next friday 18:00" --end "next friday 21:00" --location "the usual bar" wss://relay.example.com
+		nak calendar create --all-day --title "conference" --start 2025-05-02 --end 2025-05-04 --geohash u4pruydqqvj
+		nak calendar rsvp naddr1... --status accepted wss://relay.example.com
+		nak calendar list npub1...`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "create",
+			Usage:                     "creates a date-based (kind 31922) or time-based (kind 31923) calendar event",
+			ArgsUsage:                 "[relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(defaultKeyFlags,
+				relayFlag,
+				&cli.StringFlag{
+					Name:     "title",
+					Usage:    "title of the calendar event",
+					Required: true,
+				},
+				&NaturalTimeFlag{
+					Name:     "start",
+					Usage:    "when the calendar event starts",
+					Required: true,
+				},
+				&NaturalTimeFlag{
+					Name:  "end",
+					Usage: "when the calendar event ends",
+				},
+				&cli.BoolFlag{
+					Name:  "all-day",
+					Usage: "create a date-based event (kind 31922) instead of a time-based one, only the dates of --start and --end will be used",
+				},
+				&cli.StringFlag{
+					Name:        "tz",
+					Usage:       "IANA timezone of the event, like America/Sao_Paulo",
+					DefaultText: "none",
+				},
+				&cli.StringFlag{
+					Name:    "identifier",
+					Aliases: []string{"d"},
+					Usage:   "the \"d\" tag of the calendar event",
+				},
+				&cli.StringFlag{
+					Name:    "content",
+					Aliases: []string{"c"},
+					Usage:   "description of the calendar event",
+				},
+				&cli.StringFlag{
+					Name:  "summary",
+					Usage: "a short description of the calendar event",
+				},
+				&cli.StringFlag{
+					Name:  "image",
+					Usage: "url of an image for the calendar event",
+				},
+				&cli.StringSliceFlag{
+					Name:  "location",
+					Usage: "where the event will happen, can be given multiple times",
+				},
+				&cli.StringSliceFlag{
+					Name:  "geohash",
+					Usage: "geohash of the event location, can be given multiple times",
+				},
+				&PubKeySliceFlag{
+					Name:  "participant",
+					Usage: "pubkey of a participant, can be given multiple times",
+				},
+				&cli.StringSliceFlag{
+					Name:  "hashtag",
+					Usage: "hashtag for the event, can be given multiple times",
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				calev := nip52.CalendarEvent{
+					CalendarEventKind: nip52.TimeBased,
+					Identifier:        c.String("identifier"),
+					Title:             c.String("title"),
+					Start:             getNaturalDate(c, "start").Time(),
+					Locations:         c.StringSlice("location"),
+					Geohashes:         c.StringSlice("geohash"),
+					Hashtags:          c.StringSlice("hashtag"),
+				}
+				if c.IsSet("end") {
+					calev.End = getNaturalDate(c, "end").Time()
+					if calev.End.Before(calev.Start) {
+						return fmt.Errorf("--end must be after --start")
+					}
+				}
+				if c.Bool("all-day") {
+					calev.CalendarEventKind = nip52.DateBased
+				}
+				if calev.Identifier == "" {
+					calev.Identifier = randString(12)
+				}
+				for _, pk := range getPubKeySlice(c, "participant") {
+					calev.Participants = append(calev.Participants, nip52.Participant{PubKey: pk})
+				}
+
+				evt := nostr.Event{
+					Kind:    nostr.Kind(calev.CalendarEventKind),
+					Content: c.String("content"),
+					Tags:    calev.ToHashtags(),
+				}
+				if image := c.String("image"); image != "" {
+					evt.Tags = append(evt.Tags, nostr.Tag{"image", image})
+				}
+				if summary := c.String("summary"); summary != "" {
+					evt.Tags = append(evt.Tags, nostr.Tag{"summary", summary})
+				}
+				if tz := c.String("tz"); tz != "" && calev.CalendarEventKind == nip52.TimeBased {
+					if _, err := time.LoadLocation(tz); err != nil {
+						return fmt.Errorf("invalid timezone '%s': %w", tz, err)
+					}
+					evt.Tags = append(evt.Tags, nostr.Tag{"start_tzid", tz})
+					if !calev.End.IsZero() {
+						evt.Tags = append(evt.Tags, nostr.Tag{"end_tzid", tz})
+					}
+				}
+
+				return signPrintAndPublish(ctx, c, evt, c.Args().Slice())
+			},
+		},
+		{
+			Name:                      "rsvp",
+			Usage:                     "responds to a calendar event with a kind 31925 rsvp",
+			ArgsUsage:                 "<naddr> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(defaultKeyFlags,
+				relayFlag,
+				&cli.StringFlag{
+					Name:  "status",
+					Usage: "one of accepted, declined or tentative",
+					Value: "accepted",
+				},
+				&cli.StringFlag{
+					Name:  "free-busy",
+					Usage: "free or busy, whether you'll be busy during the event (ignored when declining)",
+				},
+				&cli.StringFlag{
+					Name:    "content",
+					Aliases: []string{"c"},
+					Usage:   "a note to go along with the rsvp",
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing calendar event naddr")
+				}
+				ptr, err := parsePointer(c.Args().First())
+				if err != nil {
+					return err
+				}
+				addr, ok := ptr.(nostr.EntityPointer)
+				if !ok || (addr.Kind != nip52.DateBased && addr.Kind != nip52.TimeBased) {
+					return fmt.Errorf("expected an naddr pointing to a kind %d or %d calendar event", nip52.DateBased, nip52.TimeBased)
+				}
+
+				status := c.String("status")
+				if !slices.Contains(rsvpStatuses, status) {
+					return fmt.Errorf("invalid status '%s', expected one of accepted, declined or tentative", status)
+				}
+
+				evt := nostr.Event{
+					Kind:    31925,
+					Content: c.String("content"),
+					Tags: nostr.Tags{
+						{"d", randString(12)},
+						addr.AsTag(),
+						{"status", status},
+						{"p", addr.PublicKey.Hex()},
+					},
+				}
+				if fb := c.String("free-busy"); fb != "" && status != "declined" {
+					if fb != "free" && fb != "busy" {
+						return fmt.Errorf("--free-busy must be either 'free' or 'busy'")
+					}
+					evt.Tags = append(evt.Tags, nostr.Tag{"fb", fb})
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = addr.Relays
+				}
+
+				return signPrintAndPublish(ctx, c, evt, relays)
+			},
+		},
+		{
+			Name:                      "list",
+			Usage:                     "lists upcoming calendar events created by a pubkey",
+			ArgsUsage:                 "<pubkey> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "also list past events",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the calendar events as json instead of a human-readable description",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing pubkey")
+				}
+				pk, err := parsePubKey(c.Args().First())
+				if err != nil {
+					return err
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = sys.FetchOutboxRelays(ctx, pk, 3)
+				}
+				if len(relays) == 0 {
+					return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+				}
+
+				results := sys.Pool.FetchManyReplaceable(ctx, relays, nostr.Filter{
+					Kinds:   []nostr.Kind{nip52.DateBased, nip52.TimeBased},
+					Authors: []nostr.PubKey{pk},
+				}, nostr.SubscriptionOptions{Label: "nak-calendar"})
+
+				type entry struct {
+					evt   nostr.Event
+					calev nip52.CalendarEvent
+				}
+				entries := make([]entry, 0, results.Size())
+				now := time.Now()
+				for _, evt := range results.Range {
+					calev := nip52.ParseCalendarEvent(evt)
+					if !c.Bool("all") {
+						end := calev.End
+						if end.IsZero() {
+							end = calev.Start
+						}
+						if calev.CalendarEventKind == nip52.DateBased {
+							end = end.Add(time.Hour * 24)
+						}
+						if end.Before(now) {
+							continue
+						}
+					}
+					entries = append(entries, entry{evt, calev})
+				}
+
+				slices.SortFunc(entries, func(a, b entry) int { return a.calev.Start.Compare(b.calev.Start) })
+
+				for _, e := range entries {
+					if c.Bool("json") {
+						stdout(e.evt)
+						continue
+					}
+
+					var when string
+					if e.calev.CalendarEventKind == nip52.DateBased {
+						when = e.calev.Start.Format(nip52.DateFormat)
+						if !e.calev.End.IsZero() {
+							when += " → " + e.calev.End.Format(nip52.DateFormat)
+						}
+					} else {
+						when = e.calev.Start.Local().Format("2006-01-02 15:04")
+						if !e.calev.End.IsZero() {
+							when += " → " + e.calev.End.Local().Format("2006-01-02 15:04")
+						}
+					}
+
+					line := color.YellowString(when) + " " + colors.bold(e.calev.Title)
+					if len(e.calev.Locations) > 0 {
+						line += " @ " + e.calev.Locations[0]
+					}
+					line += " " + color.BlueString(nip19.EncodeNaddr(e.evt.PubKey, e.evt.Kind, e.calev.Identifier, nil))
+					stdout(line)
+				}
+
+				return nil
+			},
+		},
+	},
+}
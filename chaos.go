@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var chaosFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:     "chaos",
+		Usage:    "misbehave on purpose to test client resilience, with sensible defaults for all the --chaos-* options",
+		Category: CATEGORY_CHAOS,
+	},
+	&cli.UintFlag{
+		Name:        "chaos-seed",
+		Usage:       "seed for the random decisions, the same seed and the same client behavior reproduce the same faults",
+		DefaultText: "random",
+		Category:    CATEGORY_CHAOS,
+	},
+	&cli.DurationFlag{
+		Name:     "chaos-latency",
+		Usage:    "delay each message sent to clients by a random duration up to this",
+		Category: CATEGORY_CHAOS,
+	},
+	&cli.FloatFlag{
+		Name:     "chaos-disconnect",
+		Usage:    "probability of dropping the connection after each message",
+		Category: CATEGORY_CHAOS,
+	},
+	&cli.FloatFlag{
+		Name:     "chaos-duplicate",
+		Usage:    "probability of sending an EVENT twice",
+		Category: CATEGORY_CHAOS,
+	},
+	&cli.FloatFlag{
+		Name:     "chaos-malformed",
+		Usage:    "probability of sending a truncated frame before a message",
+		Category: CATEGORY_CHAOS,
+	},
+	&cli.FloatFlag{
+		Name:     "chaos-eose",
+		Usage:    "probability of holding a stored EVENT until after the EOSE of its subscription",
+		Category: CATEGORY_CHAOS,
+	},
+}
+
+const CATEGORY_CHAOS = "FAULT INJECTION"
+
+type chaosConfig struct {
+	seed       uint64
+	latency    time.Duration
+	disconnect float64
+	duplicate  float64
+	malformed  float64
+	eose       float64
+}
+
+// chaosConfigFromFlags returns nil if no chaos was requested.
+func chaosConfigFromFlags(c *cli.Command) *chaosConfig {
+	enabled := c.Bool("chaos")
+	for _, name := range []string{"chaos-latency", "chaos-disconnect", "chaos-duplicate", "chaos-malformed", "chaos-eose"} {
+		if c.IsSet(name) {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return nil
+	}
+
+	cc := &chaosConfig{seed: c.Uint("chaos-seed")}
+	if !c.IsSet("chaos-seed") {
+		cc.seed = rand.Uint64()
+	}
+	if c.Bool("chaos") {
+		cc.latency = 300 * time.Millisecond
+		cc.disconnect = 0.01
+		cc.duplicate = 0.05
+		cc.malformed = 0.02
+		cc.eose = 0.1
+	}
+	if c.IsSet("chaos-latency") {
+		cc.latency = c.Duration("chaos-latency")
+	}
+	if c.IsSet("chaos-disconnect") {
+		cc.disconnect = c.Float("chaos-disconnect")
+	}
+	if c.IsSet("chaos-duplicate") {
+		cc.duplicate = c.Float("chaos-duplicate")
+	}
+	if c.IsSet("chaos-malformed") {
+		cc.malformed = c.Float("chaos-malformed")
+	}
+	if c.IsSet("chaos-eose") {
+		cc.eose = c.Float("chaos-eose")
+	}
+	return cc
+}
+
+// freeLocalPort asks the OS for a port nobody is using.
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// runChaosProxy listens at the public address and forwards everything to the actual relay running
+// at the internal port, applying the configured faults to the messages going to clients.
+func runChaosProxy(ctx context.Context, cc *chaosConfig, hostname string, port int, internalPort int) error {
+	target, _ := url.Parse("http://127.0.0.1:" + strconv.Itoa(internalPort))
+	httpProxy := httputil.NewSingleHostReverseProxy(target)
+
+	log("%s chaos enabled with seed %d (latency up to %s, disconnect %.2f, duplicate %.2f, malformed %.2f, eose %.2f)\n",
+		color.HiRedString(">"), cc.seed, cc.latency, cc.disconnect, cc.duplicate, cc.malformed, cc.eose)
+
+	var connections atomic.Uint64
+	server := &http.Server{
+		Addr: net.JoinHostPort(hostname, strconv.Itoa(port)),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") == "" {
+				httpProxy.ServeHTTP(w, r)
+				return
+			}
+
+			client, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+			if err != nil {
+				return
+			}
+			defer client.CloseNow()
+			client.SetReadLimit(-1)
+
+			upstream, _, err := websocket.Dial(r.Context(), "ws://127.0.0.1:"+strconv.Itoa(internalPort)+r.URL.Path, &websocket.DialOptions{
+				HTTPHeader: http.Header{"X-Forwarded-For": {r.RemoteAddr}},
+			})
+			if err != nil {
+				client.Close(websocket.StatusInternalError, "upstream unavailable")
+				return
+			}
+			defer upstream.CloseNow()
+			upstream.SetReadLimit(-1)
+
+			// each connection gets its own deterministic source of randomness
+			rng := rand.New(rand.NewPCG(cc.seed, connections.Add(1)))
+			connCtx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			// client -> relay, untouched
+			go func() {
+				defer cancel()
+				for {
+					typ, msg, err := client.Read(connCtx)
+					if err != nil {
+						return
+					}
+					if err := upstream.Write(connCtx, typ, msg); err != nil {
+						return
+					}
+				}
+			}()
+
+			// relay -> client, with faults
+			held := make(map[string][][]byte)
+			eosed := make(map[string]bool)
+			send := func(msg []byte) bool {
+				if cc.latency > 0 {
+					time.Sleep(time.Duration(rng.Int64N(int64(cc.latency))))
+				}
+				if len(msg) > 1 && rng.Float64() < cc.malformed {
+					logverbose("[chaos] sending a malformed frame\n")
+					client.Write(connCtx, websocket.MessageText, msg[0:rng.IntN(len(msg))])
+				}
+				if err := client.Write(connCtx, websocket.MessageText, msg); err != nil {
+					return false
+				}
+				if rng.Float64() < cc.disconnect {
+					logverbose("[chaos] dropping a connection\n")
+					client.CloseNow()
+					return false
+				}
+				return true
+			}
+			for {
+				_, msg, err := upstream.Read(connCtx)
+				if err != nil {
+					return
+				}
+
+				switch {
+				case bytes.HasPrefix(msg, []byte(`["EVENT"`)):
+					if subId := chaosSubscriptionID(msg); !eosed[subId] && rng.Float64() < cc.eose {
+						logverbose("[chaos] holding an event until after EOSE\n")
+						held[subId] = append(held[subId], msg)
+						continue
+					}
+					if rng.Float64() < cc.duplicate {
+						logverbose("[chaos] duplicating an event\n")
+						if !send(msg) {
+							return
+						}
+					}
+				case bytes.HasPrefix(msg, []byte(`["EOSE"`)):
+					subId := chaosSubscriptionID(msg)
+					eosed[subId] = true
+					if !send(msg) {
+						return
+					}
+					for _, h := range held[subId] {
+						if !send(h) {
+							return
+						}
+					}
+					delete(held, subId)
+					continue
+				}
+
+				if !send(msg) {
+					return
+				}
+			}
+		}),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("chaos proxy failed: %w", err)
+	}
+	return nil
+}
+
+func chaosSubscriptionID(msg []byte) string {
+	var envelope []stdjson.RawMessage
+	if err := stdjson.Unmarshal(msg, &envelope); err != nil || len(envelope) < 2 {
+		return ""
+	}
+	var subId string
+	stdjson.Unmarshal(envelope[1], &subId)
+	return subId
+}
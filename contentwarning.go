@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fiatjaf.com/nostr"
+)
+
+// contentWarning returns the nip36 content-warning reason of an event, if it has one.
+func contentWarning(evt nostr.Event) (reason string, isSensitive bool) {
+	tag := evt.Tags.Find("content-warning")
+	if tag == nil {
+		return "", false
+	}
+	if len(tag) >= 2 {
+		reason = tag[1]
+	}
+	return reason, true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+var copyFlag = &cli.BoolFlag{
+	Name:  "copy",
+	Usage: "put the result in the clipboard instead of printing it",
+	Action: func(ctx context.Context, c *cli.Command, b bool) error {
+		if b {
+			return setupCopyOutput(c)
+		}
+		return nil
+	},
+}
+
+var copyClearFlag = &cli.DurationFlag{
+	Name:  "copy-clear",
+	Usage: "with --copy, wait this long and then clear the clipboard (if it still has what we copied)",
+}
+
+// clipboardCommands returns the commands for writing to and reading from the system clipboard.
+func clipboardCommands() (copyCmd []string, pasteCmd []string, err error) {
+	has := func(name string) bool {
+		_, err := exec.LookPath(name)
+		return err == nil
+	}
+
+	switch {
+	case runtime.GOOS == "darwin":
+		return []string{"pbcopy"}, []string{"pbpaste"}, nil
+	case runtime.GOOS == "windows":
+		return []string{"clip.exe"}, []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}, nil
+	case os.Getenv("WAYLAND_DISPLAY") != "" && has("wl-copy"):
+		return []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}, nil
+	case has("xclip"):
+		return []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}, nil
+	case has("xsel"):
+		return []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}, nil
+	case has("termux-clipboard-set"):
+		return []string{"termux-clipboard-set"}, []string{"termux-clipboard-get"}, nil
+	}
+	return nil, nil, fmt.Errorf("no clipboard tool found, install wl-clipboard, xclip or xsel")
+}
+
+func writeClipboard(copyCmd []string, text string) error {
+	cmd := exec.Command(copyCmd[0], copyCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w %s", copyCmd[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// setupCopyOutput makes stdout collect everything and put it in the clipboard at the end, the secrets
+// never reach the terminal scrollback this way.
+func setupCopyOutput(c *cli.Command) error {
+	copyCmd, pasteCmd, err := clipboardCommands()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	stdout = func(args ...any) {
+		lines = append(lines, fmt.Sprint(args...))
+	}
+
+	finishNext := finishOutput
+	finishOutput = func() {
+		finishNext()
+		if len(lines) == 0 {
+			return
+		}
+
+		text := strings.Join(lines, "\n")
+		lines = nil
+		if err := writeClipboard(copyCmd, text); err != nil {
+			log("failed to copy to the clipboard: %s\n", err)
+			return
+		}
+
+		clearAfter := c.Duration("copy-clear")
+		if clearAfter <= 0 {
+			log("copied to the clipboard.\n")
+			return
+		}
+
+		log("copied to the clipboard, it will be cleared in %s.\n", clearAfter)
+		time.Sleep(clearAfter)
+
+		// don't clear if something else was copied in the meantime
+		if out, err := exec.Command(pasteCmd[0], pasteCmd[1:]...).Output(); err == nil &&
+			strings.TrimSpace(string(out)) != strings.TrimSpace(text) {
+			return
+		}
+		if err := writeClipboard(copyCmd, ""); err != nil {
+			log("failed to clear the clipboard: %s\n", err)
+			return
+		}
+		log("clipboard cleared.\n")
+	}
+
+	return nil
+}
@@ -18,6 +18,7 @@ var count = &cli.Command{
 	Description:               `outputs a nip45 request (the flags are mostly the same as 'nak req').`,
 	DisableSliceFlagSeparator: true,
 	Flags: []cli.Flag{
+		relayFlag,
 		&PubKeySliceFlag{
 			Name:     "author",
 			Aliases:  []string{"a"},
@@ -33,7 +34,7 @@ var count = &cli.Command{
 		&cli.StringSliceFlag{
 			Name:     "tag",
 			Aliases:  []string{"t"},
-			Usage:    "takes a tag like -t e=<id>, only accept events with these tags",
+			Usage:    "takes a tag like -t e=<id> or -t t=one;two for any of the values, only accept events with these tags (escape ';' in values as '\\;')",
 			Category: CATEGORY_FILTER_ATTRIBUTES,
 		},
 		&cli.StringSliceFlag{
@@ -68,7 +69,7 @@ var count = &cli.Command{
 	ArgsUsage: "[relay...]",
 	Action: func(ctx context.Context, c *cli.Command) error {
 		biggerUrlSize := 0
-		relayUrls := c.Args().Slice()
+		relayUrls := getRelayURLs(c, c.Args().Slice())
 		if len(relayUrls) > 0 {
 			relays := connectToAllRelays(ctx, c, relayUrls, nil, nostr.PoolOptions{})
 			if len(relays) == 0 {
@@ -99,11 +100,13 @@ var count = &cli.Command{
 
 		tags := make([][]string, 0, 5)
 		for _, tagFlag := range c.StringSlice("tag") {
-			spl := strings.SplitN(tagFlag, "=", 2)
-			if len(spl) == 2 {
-				tags = append(tags, []string{spl[0], decodeTagValue(spl[1])})
-			} else {
-				return fmt.Errorf("invalid --tag '%s'", tagFlag)
+			tag, err := parseTagFlag(tagFlag)
+			if err != nil || len(tag) < 2 {
+				return fmt.Errorf("invalid --tag '%s', expected <name>=<value>", tagFlag)
+			}
+			// in filters the values separated by ';' are alternatives
+			for _, value := range tag[1:] {
+				tags = append(tags, []string{tag[0], value})
 			}
 		}
 		for _, etag := range c.StringSlice("e") {
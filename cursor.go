@@ -0,0 +1,138 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var cursorFlag = &cli.StringFlag{
+	Name: "cursor",
+	Usage: "file where the created_at of the newest event received is saved at the end, and from where it is read " +
+		"at the start to be used as 'since', so each run only gets what is new since the previous one",
+	TakesFile: true,
+	Category:  CATEGORY_EXTRAS,
+}
+
+// reqCursor is what is stored in the --cursor file. the ids of the events with the newest timestamp are
+// kept so that the next run, which asks for events since that same second, can skip them.
+type reqCursor struct {
+	Newest nostr.Timestamp `json:"newest"`
+	IDs    []string        `json:"ids,omitempty"`
+}
+
+func loadCursor(path string) (reqCursor, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return reqCursor{}, nil
+	} else if err != nil {
+		return reqCursor{}, fmt.Errorf("failed to read cursor: %w", err)
+	}
+
+	// a file with just a timestamp also works
+	text := strings.TrimSpace(string(data))
+	if ts, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return reqCursor{Newest: nostr.Timestamp(ts)}, nil
+	}
+
+	var cursor reqCursor
+	if err := stdjson.Unmarshal([]byte(text), &cursor); err != nil {
+		return reqCursor{}, fmt.Errorf("invalid cursor file %s: %w", path, err)
+	}
+	return cursor, nil
+}
+
+func saveCursor(path string, cursor reqCursor) error {
+	data, _ := stdjson.Marshal(cursor)
+
+	// write to a temporary file first so a crash never leaves a broken cursor behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setupCursor applies --cursor: it returns a function that sets 'since' on filters from the saved cursor and
+// makes stdout keep track of the newest event, which is saved when the output finishes.
+func setupCursor(c *cli.Command) (func(filter *nostr.Filter), error) {
+	path := c.String("cursor")
+	if path == "" {
+		return func(*nostr.Filter) {}, nil
+	}
+	if c.IsSet("since") || c.IsSet("last") || c.IsSet("today") || c.IsSet("between") {
+		return nil, fmt.Errorf("--cursor can't be used with --since, --last, --today or --between")
+	}
+
+	previous, err := loadCursor(path)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Newest > 0 {
+		logverbose("resuming from cursor at %d\n", previous.Newest)
+	}
+
+	mu := sync.Mutex{}
+	current := previous
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				mu.Lock()
+				skip := evt.CreatedAt == previous.Newest && slices.Contains(previous.IDs, evt.ID.Hex())
+				switch {
+				case evt.CreatedAt > current.Newest:
+					current = reqCursor{Newest: evt.CreatedAt, IDs: []string{evt.ID.Hex()}}
+				case evt.CreatedAt == current.Newest && !slices.Contains(current.IDs, evt.ID.Hex()):
+					current.IDs = append(current.IDs, evt.ID.Hex())
+				}
+				mu.Unlock()
+
+				if skip {
+					// we got this in the previous run
+					return
+				}
+			}
+		}
+		printNext(args...)
+	}
+
+	finishNext := finishOutput
+	finishOutput = func() {
+		finishNext()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if current.Newest == previous.Newest && len(current.IDs) == len(previous.IDs) {
+			return
+		}
+		if err := saveCursor(path, current); err != nil {
+			log("failed to save cursor to %s: %s\n", path, err)
+			return
+		}
+		logverbose("saved cursor at %d to %s\n", current.Newest, path)
+	}
+
+	return func(filter *nostr.Filter) {
+		if previous.Newest > 0 {
+			filter.Since = previous.Newest
+		}
+	}, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+//go:embed daemon.proto
+var daemonSchema string
+
+// the most events we allocate space for before they arrive, whatever limit the filter asks for
+const daemonQueryPrealloc = 500
+
+var daemon = &cli.Command{
+	Name:  "daemon",
+	Usage: "exposes querying, publishing, signing and encryption as a json-rpc service in a unix socket",
+	Description: `listens on a unix socket for json-rpc 2.0 requests, one json object per line, and answers each with one line. requests on the same connection are handled concurrently, so use the ids to match the responses.
+
+methods:
+
+  getPublicKey  {}                                     -> {"pubkey": "<hex>"}
+  sign          {"event": <unsigned event>}            -> {"event": <signed event>}
+  encrypt       {"pubkey": "...", "plaintext": "..."}  -> {"ciphertext": "<nip44 ciphertext>"}
+  decrypt       {"pubkey": "...", "ciphertext": "..."} -> {"plaintext": "..."}
+  query         {"relays": [...], "filter": <filter>}  -> {"events": [<event>, ...]}
+  publish       {"relays": [...], "event": <event>}    -> {"results": [{"relay": "...", "ok": true, "reason": "..."}, ...]}
+  subscribe     {"relays": [...], "filter": <filter>}  -> {"subscription": "<id>"}
+  unsubscribe   {"subscription": "<id>"}               -> {}
+
+after subscribe the events are sent as notifications like {"jsonrpc":"2.0","method":"event","params":{"subscription":"<id>","event":<event>}}, subscriptions end when unsubscribed or when the connection is closed.
+
+when "relays" is omitted the relays given with --relay (or the ones in <config-path>/default-relays) are used. events given to "publish" must be signed already.
+
+these params and results are described by a stable protobuf schema, printed with --schema, in its proto3 json form.
+
+example:
+		nak daemon --sec ncryptsec1... &
+		echo '{"jsonrpc":"2.0","id":1,"method":"query","params":{"relays":["nos.lol"],"filter":{"kinds":[1],"limit":2}}}' | nc -U ~/.config/nak/daemon.sock`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(slices.Clip(defaultKeyFlags),
+		relayFlag,
+		&cli.StringFlag{
+			Name:        "socket",
+			Usage:       "path of the unix socket to listen on",
+			DefaultText: "<config-path>/daemon.sock",
+			TakesFile:   true,
+		},
+		&cli.BoolFlag{
+			Name:  "schema",
+			Usage: "print the protobuf schema of the methods and exit",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Bool("schema") {
+			stdout(strings.TrimSpace(daemonSchema))
+			return nil
+		}
+
+		kr, _, err := gatherKeyerFromArguments(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		path := c.String("socket")
+		if path == "" {
+			path = filepath.Join(c.String("config-path"), "daemon.sock")
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+
+		// a socket file left from a previous run that wasn't closed properly
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("another daemon is already listening on %s", path)
+		}
+		os.Remove(path)
+
+		ln, err := listenPrivately(path)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(path)
+
+		d := &rpcDaemon{kr: kr, relays: getRelayURLsOrDefaults(c, nil)}
+		log("%s daemon listening on %s\n", color.HiRedString(">"), colors.bold(path))
+		notifyReady()
+
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			go d.serve(ctx, conn)
+		}
+	},
+}
+
+// listenPrivately creates the socket inside a directory only we can access and only moves it to its final path
+// after restricting its permissions, so nobody else can connect to it in the meantime.
+func listenPrivately(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".daemon-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set the socket permissions: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to move the socket to %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+type rpcDaemon struct {
+	kr      nostr.Keyer
+	relays  []string
+	lastSub atomic.Int64
+}
+
+type rpcRequest struct {
+	ID     stdjson.RawMessage `json:"id,omitempty"`
+	Method string             `json:"method"`
+	Params stdjson.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string             `json:"jsonrpc"`
+	ID      stdjson.RawMessage `json:"id,omitempty"`
+	Method  string             `json:"method,omitempty"`
+	Params  any                `json:"params,omitempty"`
+	Result  any                `json:"result,omitempty"`
+	Error   *rpcError          `json:"error,omitempty"`
+}
+
+// serve handles one connection, it's closed when the client disconnects or the daemon stops.
+func (d *rpcDaemon) serve(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	mu := sync.Mutex{}
+	send := func(res rpcResponse) {
+		res.JSONRPC = "2.0"
+		j, _ := stdjson.Marshal(res)
+		mu.Lock()
+		defer mu.Unlock()
+		conn.Write(append(j, '\n'))
+	}
+
+	subs := make(map[string]context.CancelFunc)
+	subsMu := sync.Mutex{}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req rpcRequest
+		if err := stdjson.Unmarshal(scanner.Bytes(), &req); err != nil {
+			send(rpcResponse{ID: stdjson.RawMessage("null"), Error: &rpcError{-32700, "parse error"}})
+			continue
+		}
+
+		go func() {
+			result, err := d.handle(ctx, req, send, subs, &subsMu)
+			if len(req.ID) == 0 {
+				// notifications don't get responses
+				return
+			}
+			if err != nil {
+				code := -32000
+				if errors.Is(err, errRPCInvalidParams) {
+					code = -32602
+				} else if errors.Is(err, errRPCUnknownMethod) {
+					code = -32601
+				}
+				send(rpcResponse{ID: req.ID, Error: &rpcError{code, err.Error()}})
+				return
+			}
+			send(rpcResponse{ID: req.ID, Result: result})
+		}()
+	}
+}
+
+var (
+	errRPCInvalidParams = errors.New("invalid params")
+	errRPCUnknownMethod = errors.New("method not found")
+)
+
+func (d *rpcDaemon) handle(
+	ctx context.Context,
+	req rpcRequest,
+	send func(rpcResponse),
+	subs map[string]context.CancelFunc,
+	subsMu *sync.Mutex,
+) (any, error) {
+	var params struct {
+		Relays       []string      `json:"relays"`
+		Filter       *nostr.Filter `json:"filter"`
+		Event        *nostr.Event  `json:"event"`
+		PubKey       string        `json:"pubkey"`
+		Plaintext    string        `json:"plaintext"`
+		Ciphertext   string        `json:"ciphertext"`
+		Subscription string        `json:"subscription"`
+	}
+	if len(req.Params) > 0 {
+		if err := stdjson.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("%w: %s", errRPCInvalidParams, err)
+		}
+	}
+
+	relays := d.relays
+	if len(params.Relays) > 0 {
+		relays = params.Relays
+		if err := normalizeAndValidateRelayURLs(relays); err != nil {
+			return nil, fmt.Errorf("%w: %s", errRPCInvalidParams, err)
+		}
+	}
+
+	switch req.Method {
+	case "getPublicKey":
+		pk, err := d.kr.GetPublicKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"pubkey": pk.Hex()}, nil
+
+	case "sign":
+		if params.Event == nil {
+			return nil, fmt.Errorf("%w: missing event", errRPCInvalidParams)
+		}
+		evt := *params.Event
+		if evt.CreatedAt == 0 {
+			evt.CreatedAt = nostr.Now()
+		}
+		if err := d.kr.SignEvent(ctx, &evt); err != nil {
+			return nil, err
+		}
+		return map[string]any{"event": evt}, nil
+
+	case "encrypt", "decrypt":
+		pk, err := parsePubKey(params.PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errRPCInvalidParams, err)
+		}
+		if req.Method == "encrypt" {
+			ciphertext, err := d.kr.Encrypt(ctx, params.Plaintext, pk)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"ciphertext": ciphertext}, nil
+		}
+		plaintext, err := d.kr.Decrypt(ctx, params.Ciphertext, pk)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"plaintext": plaintext}, nil
+
+	case "query":
+		if params.Filter == nil {
+			return nil, fmt.Errorf("%w: missing filter", errRPCInvalidParams)
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("%w: no relays", errRPCInvalidParams)
+		}
+		events := make([]nostr.Event, 0, min(max(params.Filter.Limit, 10), daemonQueryPrealloc))
+		for ie := range sys.Pool.FetchMany(ctx, relays, *params.Filter, nostr.SubscriptionOptions{Label: "nak-daemon"}) {
+			events = append(events, ie.Event)
+		}
+		return map[string]any{"events": events}, nil
+
+	case "publish":
+		if params.Event == nil {
+			return nil, fmt.Errorf("%w: missing event", errRPCInvalidParams)
+		}
+		if !params.Event.CheckID() || !params.Event.VerifySignature() {
+			return nil, fmt.Errorf("%w: event is not signed", errRPCInvalidParams)
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("%w: no relays", errRPCInvalidParams)
+		}
+		results := make([]publishResult, 0, len(relays))
+		for res := range publishManyWithRetries(ctx, nil, relays, *params.Event) {
+			pr := publishResult{Relay: res.RelayURL, OK: res.Error == nil}
+			if res.Error != nil {
+				pr.Reason = unwrapAll(res.Error).Error()
+				pr.Class = classifyRelayError(res.Error)
+			}
+			results = append(results, pr)
+		}
+		return map[string]any{"results": results}, nil
+
+	case "subscribe":
+		if params.Filter == nil {
+			return nil, fmt.Errorf("%w: missing filter", errRPCInvalidParams)
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("%w: no relays", errRPCInvalidParams)
+		}
+		id := fmt.Sprintf("%d", d.lastSub.Add(1))
+		subCtx, cancel := context.WithCancel(ctx)
+		subsMu.Lock()
+		subs[id] = cancel
+		subsMu.Unlock()
+
+		events := sys.Pool.SubscribeMany(subCtx, relays, *params.Filter, nostr.SubscriptionOptions{Label: "nak-daemon"})
+		go func() {
+			for ie := range events {
+				send(rpcResponse{Method: "event", Params: map[string]any{"subscription": id, "event": ie.Event}})
+			}
+		}()
+		return map[string]string{"subscription": id}, nil
+
+	case "unsubscribe":
+		subsMu.Lock()
+		cancel, ok := subs[params.Subscription]
+		delete(subs, params.Subscription)
+		subsMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown subscription '%s'", errRPCInvalidParams, params.Subscription)
+		}
+		cancel()
+		return struct{}{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: '%s'", errRPCUnknownMethod, req.Method)
+}
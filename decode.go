@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	stdjson "encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
 
 	"fiatjaf.com/nostr"
@@ -14,12 +18,16 @@ import (
 
 var decode = &cli.Command{
 	Name:  "decode",
-	Usage: "decodes nip19, nip21, nip05 or hex entities",
+	Usage: "decodes nip19, nip21, nip05 or hex entities, events, bolt11 invoices and cashu tokens, telling what they are",
 	Description: `example usage:
 		nak decode npub1uescmd5krhrmj9rcura833xpke5eqzvcz5nxjw74ufeewf2sscxq4g7chm
 		nak decode nevent1qqs29yet5tp0qq5xu5qgkeehkzqh5qu46739axzezcxpj4tjlkx9j7gpr4mhxue69uhkummnw3ez6ur4vgh8wetvd3hhyer9wghxuet5sh59ud
 		nak decode nprofile1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gpz4mhxue69uhk2er9dchxummnw3ezumrpdejqz8thwden5te0dehhxarj94c82c3wwajkcmr0wfjx2u3wdejhgqgcwaehxw309aex2mrp0yhxummnw3exzarf9e3k7mgnp0sh5
-		nak decode nsec1jrmyhtjhgd9yqalps8hf9mayvd58852gtz66m7tqpacjedkp6kxq4dyxsr`,
+		nak decode nsec1jrmyhtjhgd9yqalps8hf9mayvd58852gtz66m7tqpacjedkp6kxq4dyxsr
+		nak decode --hex-as pubkey 3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d
+		nak event -c hello | nak decode
+		nak decode lnbc210n1p... --zap-request zaprequest.json
+		cat codes.txt | nak decode --jsonl`,
 	DisableSliceFlagSeparator: true,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
@@ -32,64 +40,283 @@ var decode = &cli.Command{
 			Aliases: []string{"p"},
 			Usage:   "return just the pubkey, if applicable",
 		},
+		jsonlFlag,
+		&cli.StringFlag{
+			Name:  "zap-request",
+			Usage: "a kind 9734 zap request (or a 9735 zap receipt), as json or a file, to check a bolt11 invoice against",
+		},
+		&cli.StringFlag{
+			Name:  "hex-as",
+			Usage: "what a 64-char hex input is: 'id', 'pubkey' or 'seckey' (if not given all the possibilities are shown)",
+			Validator: func(s string) error {
+				if s != "id" && s != "pubkey" && s != "seckey" {
+					return fmt.Errorf("invalid --hex-as '%s', expected id, pubkey or seckey", s)
+				}
+				return nil
+			},
+		},
 	},
-	ArgsUsage: "<npub | nprofile | nip05 | nevent | naddr | nsec>",
+	ArgsUsage: "<npub | nprofile | nip05 | nevent | naddr | nsec | hex | event json | bolt11 | cashu>",
 	Action: func(ctx context.Context, c *cli.Command) error {
 		for input := range getStdinLinesOrArguments(c.Args()) {
-			if strings.HasPrefix(input, "nostr:") {
-				input = input[6:]
+			typ, value, err := decodeInput(ctx, input, c.String("hex-as"))
+
+			var problems []string
+			if inv, ok := value.(*bolt11Invoice); ok {
+				if zr := c.String("zap-request"); zr != "" {
+					problems = checkInvoiceAgainstZapRequest(inv, zr)
+				}
 			}
 
-			_, data, err := nip19.Decode(input)
-			if err == nil {
-				switch v := data.(type) {
-				case nostr.SecretKey:
-					stdout(v.Hex())
-					continue
-				case nostr.PubKey:
-					stdout(v.Hex())
-					continue
-				case [32]byte:
-					stdout(hex.EncodeToString(v[:]))
-					continue
-				case nostr.EventPointer:
-					if c.Bool("id") {
-						stdout(v.ID.Hex())
-						continue
-					}
-					out, _ := stdjson.MarshalIndent(v, "", "  ")
-					stdout(string(out))
-					continue
-				case nostr.ProfilePointer:
-					if c.Bool("pubkey") {
-						stdout(v.PublicKey.Hex())
-						continue
-					}
-					out, _ := stdjson.MarshalIndent(v, "", "  ")
-					stdout(string(out))
-					continue
-				case nostr.EntityPointer:
-					out, _ := stdjson.MarshalIndent(v, "", "  ")
-					stdout(string(out))
-					continue
+			if c.Bool("jsonl") {
+				fields := map[string]any{"type": typ}
+				if err == nil {
+					decodedFields(fields, value)
+				}
+				if len(problems) > 0 {
+					fields["problems"] = problems
 				}
+				ctx = printJSONLine(ctx, input, fields, err)
+				continue
+			}
+
+			if err != nil {
+				ctx = lineProcessingError(ctx, "%s", err)
+				continue
+			}
+			for _, problem := range problems {
+				ctx = lineProcessingError(ctx, "%s", problem)
 			}
 
-			pp, _ := nip05.QueryIdentifier(ctx, input)
-			if pp != nil {
+			switch v := value.(type) {
+			case nostr.SecretKey:
+				stdout(v.Hex())
+			case nostr.PubKey:
+				stdout(v.Hex())
+			case nostr.ID:
+				stdout(v.Hex())
+			case nostr.EventPointer:
+				if c.Bool("id") {
+					stdout(v.ID.Hex())
+					continue
+				}
+				out, _ := stdjson.MarshalIndent(v, "", "  ")
+				stdout(string(out))
+			case nostr.ProfilePointer:
 				if c.Bool("pubkey") {
-					stdout(pp.PublicKey.Hex())
+					stdout(v.PublicKey.Hex())
 					continue
 				}
-				out, _ := stdjson.MarshalIndent(pp, "", "  ")
+				out, _ := stdjson.MarshalIndent(v, "", "  ")
+				stdout(string(out))
+			case stdjson.RawMessage:
+				stdout(string(v))
+			case map[string]any:
+				// descriptions of hex strings and events
+				if id, ok := v["id"]; ok && c.Bool("id") {
+					stdout(id)
+					continue
+				}
+				if pubkey, ok := v["pubkey"]; ok && c.Bool("pubkey") {
+					stdout(pubkey)
+					continue
+				}
+				out, _ := stdjson.MarshalIndent(v, "", "  ")
+				stdout(string(out))
+			default:
+				out, _ := stdjson.MarshalIndent(v, "", "  ")
 				stdout(string(out))
-				continue
 			}
-
-			ctx = lineProcessingError(ctx, "couldn't decode input '%s'", input)
 		}
 
 		exitIfLineProcessingError(ctx)
 		return nil
 	},
 }
+
+// decodeInput figures out what the input is and decodes it, returning its type name along with the decoded
+// value, which is a nostr key or id, a pointer, a *bolt11Invoice, the json of a cashu token or a description
+// of a hex string or event. hexAs says what a hex string is, if known.
+func decodeInput(ctx context.Context, input string, hexAs string) (string, any, error) {
+	input = strings.TrimSpace(strings.TrimPrefix(input, "nostr:"))
+
+	if strings.HasPrefix(input, "{") {
+		evt := nostr.Event{}
+		if err := json.Unmarshal([]byte(input), &evt); err != nil {
+			return "event", nil, fmt.Errorf("invalid event json: %w", err)
+		}
+		return "event", describeEvent(evt), nil
+	}
+
+	if len(input) == 64 {
+		if _, err := hex.DecodeString(input); err == nil {
+			desc, err := describeHex(input, hexAs)
+			if hexAs == "" {
+				return "hex", desc, err
+			}
+			return hexAs, desc, err
+		}
+	}
+
+	if isBolt11(input) {
+		inv, err := decodeBolt11(input)
+		if err != nil {
+			return "bolt11", nil, fmt.Errorf("invalid bolt11 invoice: %w", err)
+		}
+		return "bolt11", inv, nil
+	}
+
+	if isCashuToken(input) {
+		out, err := cashuTokenJSON(input)
+		if err != nil {
+			return "cashu", nil, fmt.Errorf("invalid cashu token: %w", err)
+		}
+		return "cashu", stdjson.RawMessage(out), nil
+	}
+
+	prefix, data, err := nip19.Decode(input)
+	if err == nil {
+		if ptr, ok := data.(nostr.Pointer); ok {
+			harvestPointerHints(ptr)
+		}
+		return prefix, data, nil
+	}
+
+	pp, _ := nip05.QueryIdentifier(ctx, input)
+	if pp != nil {
+		harvestPointerHints(*pp)
+		return "nip05", *pp, nil
+	}
+
+	return "", nil, fmt.Errorf("couldn't decode input '%s'", input)
+}
+
+// describeHex shows what a 64-char hex string means as the given kind of thing or, if we don't know, as
+// all the things it could be (except a secret key, which must be asked for explicitly).
+func describeHex(input string, hexAs string) (map[string]any, error) {
+	input = strings.ToLower(input)
+
+	switch hexAs {
+	case "id":
+		id, err := nostr.IDFromHex(input)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"id": id.Hex(), "nevent": nip19.EncodeNevent(id, eventRelayHints(id), nostr.ZeroPK)}, nil
+	case "pubkey":
+		pk, err := nostr.PubKeyFromHex(input)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"pubkey": pk.Hex(), "npub": nip19.EncodeNpub(pk)}, nil
+	case "seckey":
+		sk, err := nostr.SecretKeyFromHex(input)
+		if err != nil {
+			return nil, err
+		}
+		pk := sk.Public()
+		return map[string]any{"seckey": sk.Hex(), "nsec": nip19.EncodeNsec(sk), "pubkey": pk.Hex(), "npub": nip19.EncodeNpub(pk)}, nil
+	}
+
+	id, _ := nostr.IDFromHex(input)
+	desc := map[string]any{
+		"hex":       input,
+		"as_id":     nip19.EncodeNevent(id, eventRelayHints(id), nostr.ZeroPK),
+		"ambiguous": "use --hex-as to say if this is an id, a pubkey or a secret key",
+	}
+	if pk, err := nostr.PubKeyFromHex(input); err == nil {
+		desc["as_pubkey"] = nip19.EncodeNpub(pk)
+	}
+	return desc, nil
+}
+
+// describeEvent says what an event is, whether it is valid and how it can be referenced.
+func describeEvent(evt nostr.Event) map[string]any {
+	desc := map[string]any{
+		"id":              evt.ID.Hex(),
+		"pubkey":          evt.PubKey.Hex(),
+		"npub":            nip19.EncodeNpub(evt.PubKey),
+		"kind":            evt.Kind,
+		"kind_name":       evt.Kind.Name(),
+		"created_at":      evt.CreatedAt,
+		"valid_id":        evt.CheckID(),
+		"valid_signature": evt.VerifySignature(),
+		"nevent":          nip19.EncodeNevent(evt.ID, nil, evt.PubKey),
+	}
+	if !evt.CheckID() {
+		desc["expected_id"] = evt.GetID().Hex()
+	}
+	if evt.Kind.IsAddressable() {
+		desc["naddr"] = nip19.EncodeNaddr(evt.PubKey, evt.Kind, evt.Tags.GetD(), nil)
+	}
+	return desc
+}
+
+// decodedFields adds the fields of a value returned by decodeInput to a --jsonl output object.
+func decodedFields(fields map[string]any, value any) {
+	switch v := value.(type) {
+	case nostr.SecretKey:
+		fields["seckey"] = v.Hex()
+		fields["pubkey"] = v.Public().Hex()
+	case nostr.PubKey:
+		fields["pubkey"] = v.Hex()
+	case nostr.ID:
+		fields["id"] = v.Hex()
+	default:
+		// objects have their fields merged in, anything else goes under "value"
+		j, _ := stdjson.Marshal(v)
+		if err := stdjson.Unmarshal(j, &fields); err != nil {
+			fields["value"] = v
+		}
+	}
+}
+
+// checkInvoiceAgainstZapRequest returns the ways in which a bolt11 invoice doesn't match the zap
+// request it was supposedly generated for.
+func checkInvoiceAgainstZapRequest(inv *bolt11Invoice, zapRequest string) []string {
+	if data, err := os.ReadFile(zapRequest); err == nil {
+		zapRequest = string(data)
+	}
+	zapRequest = strings.TrimSpace(zapRequest)
+
+	evt := nostr.Event{}
+	if err := json.Unmarshal([]byte(zapRequest), &evt); err != nil {
+		return []string{fmt.Sprintf("invalid zap request: %s", err)}
+	}
+	if evt.Kind == 9735 {
+		// a zap receipt has the zap request in its "description" tag
+		desc := evt.Tags.Find("description")
+		if desc == nil || len(desc) < 2 {
+			return []string{"zap receipt has no 'description' tag"}
+		}
+		zapRequest = desc[1]
+		evt = nostr.Event{}
+		if err := json.Unmarshal([]byte(zapRequest), &evt); err != nil {
+			return []string{fmt.Sprintf("invalid zap request in zap receipt: %s", err)}
+		}
+	}
+	if evt.Kind != 9734 {
+		return []string{fmt.Sprintf("expected a kind 9734 zap request, got kind %d", evt.Kind)}
+	}
+
+	problems := make([]string, 0, 2)
+	hash := sha256.Sum256([]byte(zapRequest))
+	if inv.DescriptionHash != hex.EncodeToString(hash[:]) {
+		problems = append(problems, fmt.Sprintf("invoice description hash %s is not the hash of the zap request", inv.DescriptionHash))
+	}
+	if tag := evt.Tags.Find("amount"); tag != nil && len(tag) >= 2 {
+		if amount, _ := strconv.ParseUint(tag[1], 10, 64); amount != inv.AmountMsat {
+			problems = append(problems, fmt.Sprintf("invoice amount is %d msat but the zap request asked for %s msat", inv.AmountMsat, tag[1]))
+		}
+	}
+	if !evt.VerifySignature() {
+		problems = append(problems, "zap request signature is invalid")
+	}
+	return problems
+}
+
+func isBolt11(input string) bool {
+	input = strings.TrimPrefix(strings.ToLower(input), "lightning:")
+	return (strings.HasPrefix(input, "lnbc") || strings.HasPrefix(input, "lntb") || strings.HasPrefix(input, "lnsb")) &&
+		!strings.Contains(input, "@")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var diffRelays = &cli.Command{
+	Name:  "diff-relays",
+	Usage: "compares the events two relays have for the same filter and reports the ones only one of them has",
+	Description: `queries both relays with the same filter (paginating until the end, and with an 'until' fixed to now so both see the same period), then prints one line for each event that is only on one side, with the relay that has it.
+
+with --sync the missing events are published to the relay that doesn't have them. the command exits with an error when the relays differ and nothing was synced, like diff.
+
+this works with any relay, for relays that support nip77 'nak sync' is faster.
+
+example:
+		nak diff-relays -k 0 -k 3 -k 10002 wss://old.example.com wss://new.example.com
+		nak diff-relays -a <pubkey> --sync to-b wss://old.example.com wss://new.example.com
+		nak diff-relays -k 1 --since 1d --events wss://a.example.com wss://b.example.com | nak event wss://c.example.com`,
+	ArgsUsage:                 "<relay-a> <relay-b>",
+	DisableSliceFlagSeparator: true,
+	Flags: append(reqFilterFlags,
+		relayFlag,
+		&cli.StringFlag{
+			Name:  "sync",
+			Usage: "publish the missing events: 'to-a' copies the ones only in b to a, 'to-b' the ones only in a to b and 'both' does both",
+			Validator: func(s string) error {
+				if s != "to-a" && s != "to-b" && s != "both" {
+					return fmt.Errorf("invalid --sync '%s', expected to-a, to-b or both", s)
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "events",
+			Usage: "print the full events that are only on one side instead of a summary of each",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len()+len(c.StringSlice("relay")) != 2 {
+			return fmt.Errorf("need exactly two relay URLs, as arguments or with --relay")
+		}
+		urls := getRelayURLs(c, c.Args().Slice())
+		if len(urls) != 2 {
+			return fmt.Errorf("both relays are the same")
+		}
+
+		filter := nostr.Filter{}
+		if err := applyFlagsToFilter(c, &filter); err != nil {
+			return err
+		}
+		if filter.Until == 0 {
+			filter.Until = nostr.Now()
+		}
+
+		if relays := connectToAllRelays(ctx, c, urls, nil, nostr.PoolOptions{}); len(relays) != 2 {
+			return fmt.Errorf("failed to connect to both relays")
+		}
+
+		// fetch both sides at the same time
+		holdings := [2]map[nostr.ID]nostr.Event{}
+		wg := sync.WaitGroup{}
+		for i, url := range urls {
+			holdings[i] = make(map[nostr.ID]nostr.Event)
+			wg.Go(func() {
+				paginator := sys.Pool.PaginatorWithInterval(0)
+				for ie := range paginator(ctx, []string{url}, filter, nostr.SubscriptionOptions{Label: "nak-diff"}) {
+					holdings[i][ie.Event.ID] = ie.Event
+				}
+				logverbose("%s has %d events\n", url, len(holdings[i]))
+			})
+		}
+		wg.Wait()
+
+		only := [2][]nostr.Event{}
+		for i := range 2 {
+			for id, evt := range holdings[i] {
+				if _, ok := holdings[1-i][id]; !ok {
+					only[i] = append(only[i], evt)
+				}
+			}
+			slices.SortFunc(only[i], func(a, b nostr.Event) int { return int(b.CreatedAt) - int(a.CreatedAt) })
+		}
+
+		for i := range 2 {
+			for _, evt := range only[i] {
+				if c.Bool("events") {
+					stdout(evt)
+					continue
+				}
+				j, _ := json.Marshal(map[string]any{
+					"only_in":    urls[i],
+					"id":         evt.ID.Hex(),
+					"pubkey":     evt.PubKey.Hex(),
+					"kind":       evt.Kind,
+					"created_at": evt.CreatedAt,
+				})
+				stdout(string(j))
+			}
+		}
+
+		log("%s has %d events (%d only there), %s has %d events (%d only there)\n",
+			urls[0], len(holdings[0]), len(only[0]), urls[1], len(holdings[1]), len(only[1]))
+
+		syncTo := c.String("sync")
+		for i, direction := range []string{"to-b", "to-a"} {
+			if syncTo != direction && syncTo != "both" {
+				continue
+			}
+			target, err := sys.Pool.EnsureRelay(urls[1-i])
+			if err != nil {
+				return fmt.Errorf("failed to connect to %s: %w", urls[1-i], err)
+			}
+
+			published := 0
+			for _, evt := range only[i] {
+				if err := target.Publish(ctx, evt); err != nil {
+					logverbose("failed to publish %s to %s: %s\n", evt.ID.Hex(), target.URL, err)
+					continue
+				}
+				published++
+			}
+			log("published %d of %d events to %s\n", published, len(only[i]), target.URL)
+		}
+
+		if syncTo == "" && (len(only[0]) > 0 || len(only[1]) > 0) {
+			return fmt.Errorf("relays differ")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var dupes = &cli.Command{
+	Name:  "dupes",
+	Usage: "finds clusters of near-duplicate content posted by different pubkeys, like spam campaigns",
+	Description: `reads events from stdin and groups the ones with similar content (by comparing simhashes of their words) that were published close to each other in time.
+
+each cluster that has events from at least --min-pubkeys different pubkeys is printed as a json object, with the ids of its events, the pubkeys that published them and a sample of the content.
+links and numbers are ignored when comparing, so the same text pointing to different urls still counts as a duplicate.
+
+example:
+		nak req -k 1 --since 1d --paginate wss://relay.example.com | nak dupes
+		nak req -k 1 --since 1d --paginate wss://relay.example.com | nak dupes --window 10m --min-pubkeys 5 | jq -r '.pubkeys[]'`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "window",
+			Usage: "how far apart in time events can be to still be considered part of the same cluster",
+			Value: time.Hour,
+		},
+		&cli.UintFlag{
+			Name:  "max-distance",
+			Usage: "how many bits out of 64 can differ between the simhashes of two events for them to be near-duplicates, 0 means only exact matches after normalization",
+			Value: 3,
+		},
+		&cli.UintFlag{
+			Name:  "min-pubkeys",
+			Usage: "only print clusters with events from at least this many different pubkeys",
+			Value: 3,
+		},
+		&cli.UintFlag{
+			Name:  "min-length",
+			Usage: "ignore events with less than this many characters of content, as short notes like 'gm' are duplicates by nature",
+			Value: 20,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		events := make([]nostr.Event, 0, 1000)
+		for stdinEvent := range getJsonsOrBlank() {
+			if stdinEvent == "{}" {
+				continue
+			}
+			evt := nostr.Event{}
+			if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+				ctx = lineProcessingError(ctx, "invalid event: %s", err)
+				continue
+			}
+			if len(evt.Content) < int(c.Uint("min-length")) {
+				continue
+			}
+			events = append(events, evt)
+		}
+
+		// relays give us events from the newest to the oldest, but the window has to slide forward
+		slices.SortStableFunc(events, func(a, b nostr.Event) int { return int(a.CreatedAt) - int(b.CreatedAt) })
+
+		window := nostr.Timestamp(c.Duration("window").Seconds())
+		maxDistance := int(c.Uint("max-distance"))
+		minPubkeys := int(c.Uint("min-pubkeys"))
+		nclusters := 0
+
+		emit := func(cl *dupeCluster) {
+			if len(cl.Pubkeys) < minPubkeys {
+				return
+			}
+			j, _ := json.Marshal(cl)
+			stdout(string(j))
+			nclusters++
+		}
+
+		active := make([]*dupeCluster, 0, 100)
+		skipped := 0
+		for _, evt := range events {
+			// clusters that haven't seen anything during a whole window are done
+			active = slices.DeleteFunc(active, func(cl *dupeCluster) bool {
+				if evt.CreatedAt-cl.LastSeen > window {
+					emit(cl)
+					return true
+				}
+				return false
+			})
+
+			hash, ok := simhash(evt.Content)
+			if !ok {
+				// only links, numbers or symbols, these would all look the same
+				logverbose("skipping %s, it has no words to compare\n", evt.ID.Hex())
+				skipped++
+				continue
+			}
+			var closest *dupeCluster
+			closestDistance := maxDistance + 1
+			for _, cl := range active {
+				if distance := bits.OnesCount64(hash ^ cl.hash); distance < closestDistance {
+					closest = cl
+					closestDistance = distance
+				}
+			}
+
+			if closest == nil {
+				closest = &dupeCluster{
+					hash:      hash,
+					Sample:    evt.Content,
+					FirstSeen: evt.CreatedAt,
+				}
+				active = append(active, closest)
+			}
+			closest.Size++
+			closest.LastSeen = evt.CreatedAt
+			closest.IDs = append(closest.IDs, evt.ID.Hex())
+			closest.Pubkeys = appendUnique(closest.Pubkeys, evt.PubKey.Hex())
+		}
+		for _, cl := range active {
+			emit(cl)
+		}
+
+		logverbose("%d events checked (%d skipped), %d clusters found\n", len(events), skipped, nclusters)
+		exitIfLineProcessingError(ctx)
+		return nil
+	},
+}
+
+type dupeCluster struct {
+	Size      int             `json:"size"`
+	FirstSeen nostr.Timestamp `json:"first_seen"`
+	LastSeen  nostr.Timestamp `json:"last_seen"`
+	Sample    string          `json:"sample"`
+	Pubkeys   []string        `json:"pubkeys"`
+	IDs       []string        `json:"ids"`
+
+	hash uint64
+}
+
+var simhashIgnored = regexp.MustCompile(`(?i)(https?://|nostr:|www\.)\S+|\d+`)
+
+// simhash gives a 64-bit fingerprint of the text such that similar texts have fingerprints that differ
+// in few bits, computed over overlapping sequences of three words. it returns false when there are no words
+// left after ignoring links and numbers.
+func simhash(text string) (uint64, bool) {
+	words := strings.FieldsFunc(
+		strings.ToLower(simhashIgnored.ReplaceAllString(text, " ")),
+		func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) },
+	)
+	if len(words) == 0 {
+		return 0, false
+	}
+
+	var votes [64]int
+	for i := range max(1, len(words)-2) {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+3, len(words))], " ")))
+		sum := h.Sum64()
+		for bit := range 64 {
+			if sum&(1<<bit) != 0 {
+				votes[bit]++
+			} else {
+				votes[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, vote := range votes {
+		if vote > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash, true
+}
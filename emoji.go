@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+)
+
+var emojiShortcodeRegex = regexp.MustCompile(`:([a-zA-Z0-9_]+):`)
+
+// loadEmojiSet reads the emojis configured in <config-path>/emojis, one "shortcode=url" per line.
+func loadEmojiSet(configPath string) map[string]string {
+	set := make(map[string]string)
+	if configPath == "" {
+		return set
+	}
+
+	file, err := os.Open(filepath.Join(configPath, "emojis"))
+	if err != nil {
+		return set
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if shortcode, url, ok := strings.Cut(line, "="); ok {
+			set[strings.Trim(strings.TrimSpace(shortcode), ":")] = strings.TrimSpace(url)
+		}
+	}
+	return set
+}
+
+// addEmojiTags adds nip30 emoji tags for all the emojis given explicitly and for the ones from
+// the configured set that are used in the content, returns true if any tag was added.
+func addEmojiTags(evt *nostr.Event, explicit []string, set map[string]string) (bool, error) {
+	added := false
+	add := func(shortcode, url string) {
+		if evt.Tags.FindWithValue("emoji", shortcode) == nil {
+			evt.Tags = append(evt.Tags, nostr.Tag{"emoji", shortcode, url})
+			added = true
+		}
+	}
+
+	for _, e := range explicit {
+		shortcode, url, ok := strings.Cut(e, "=")
+		shortcode = strings.Trim(shortcode, ":")
+		if !ok || !emojiShortcodeRegex.MatchString(":"+shortcode+":") || !strings.HasPrefix(url, "http") {
+			return false, fmt.Errorf("invalid --emoji '%s', expected shortcode=https://...", e)
+		}
+		add(shortcode, url)
+	}
+
+	for _, match := range emojiShortcodeRegex.FindAllStringSubmatch(evt.Content, -1) {
+		if url, ok := set[match[1]]; ok {
+			add(match[1], url)
+		}
+	}
+
+	return added, nil
+}
+
+// prettyEvent renders an event for humans, noting the images behind nip30 custom emojis.
+// the content of events with a nip36 content-warning is hidden unless showSensitive is true.
+func prettyEvent(evt nostr.Event, showSensitive bool) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s\n", color.CyanString("kind %d", evt.Kind), color.HiBlackString(evt.CreatedAt.Time().Format("2006-01-02 15:04:05")))
+	fmt.Fprintf(b, "%s %s\n", color.HiBlackString("id"), evt.ID.Hex())
+	fmt.Fprintf(b, "%s %s\n", color.HiBlackString("author"), evt.PubKey.Hex())
+
+	for _, tag := range evt.Tags {
+		fmt.Fprintf(b, "%s %s\n", color.HiBlackString("tag"), strings.Join(tag, " "))
+	}
+
+	if reason, isSensitive := contentWarning(evt); isSensitive && !showSensitive {
+		if reason == "" {
+			reason = "sensitive content"
+		}
+		fmt.Fprintf(b, "\n%s\n", color.RedString("[content warning: %s, use --show-sensitive to see it]", reason))
+		return b.String()
+	}
+
+	content := emojiShortcodeRegex.ReplaceAllStringFunc(evt.Content, func(match string) string {
+		if tag := evt.Tags.FindWithValue("emoji", match[1:len(match)-1]); tag != nil && len(tag) >= 3 {
+			return color.YellowString(match) + color.HiBlackString("(%s)", tag[2])
+		}
+		return match
+	})
+	fmt.Fprintf(b, "\n%s\n", content)
+
+	return b.String()
+}
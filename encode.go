@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip05"
 	"fiatjaf.com/nostr/nip19"
 	"github.com/urfave/cli/v3"
 )
@@ -16,9 +17,11 @@ var encode = &cli.Command{
 		nak encode npub <pubkey-hex>
 		nak encode nprofile <pubkey-hex>
 		nak encode nprofile --relay <relay-url> <pubkey-hex>
+		nak encode nprofile name@domain.com
 		nak encode nevent <event-id>
 		nak encode nevent --author <pubkey-hex> --relay <relay-url> --relay <other-relay> <event-id>
 		nak encode nsec <privkey-hex>
+		cat pubkeys.txt | nak encode npub --jsonl
 		echo '{"pubkey":"7b225d32d3edb978dba1adfd9440105646babbabbda181ea383f74ba53c3be19","relays":["wss://nada.zero"]}' | nak encode
 		echo '{
 		  "id":"7b225d32d3edb978dba1adfd9440105646babbabbda181ea383f74ba53c3be19"
@@ -26,6 +29,7 @@ var encode = &cli.Command{
 		  "author":"ebb6ff85430705651b311ed51328767078fd790b14f02d22efba68d5513376bc"
 		} | nak encode`,
 	DisableSliceFlagSeparator: true,
+	Flags:                     []cli.Flag{copyFlag, copyClearFlag, jsonlFlag},
 	Action: func(ctx context.Context, c *cli.Command) error {
 		if c.Args().Len() != 0 {
 			return nil
@@ -47,23 +51,23 @@ var encode = &cli.Command{
 
 			var eventPtr nostr.EventPointer
 			if err := json.Unmarshal([]byte(jsonStr), &eventPtr); err == nil && eventPtr.ID != nostr.ZeroID {
-				stdout(nip19.EncodeNevent(eventPtr.ID, appendUnique(relays, eventPtr.Relays...), eventPtr.Author))
+				ctx = encodeResult(ctx, c, "nevent", jsonStr, nip19.EncodeNevent(eventPtr.ID, appendUnique(relays, eventPtr.Relays...), eventPtr.Author), nil)
 				continue
 			}
 
 			var profilePtr nostr.ProfilePointer
 			if err := json.Unmarshal([]byte(jsonStr), &profilePtr); err == nil && profilePtr.PublicKey != nostr.ZeroPK {
-				stdout(nip19.EncodeNprofile(profilePtr.PublicKey, appendUnique(relays, profilePtr.Relays...)))
+				ctx = encodeResult(ctx, c, "nprofile", jsonStr, nip19.EncodeNprofile(profilePtr.PublicKey, appendUnique(relays, profilePtr.Relays...)), nil)
 				continue
 			}
 
 			var entityPtr nostr.EntityPointer
 			if err := json.Unmarshal([]byte(jsonStr), &entityPtr); err == nil && entityPtr.PublicKey != nostr.ZeroPK {
-				stdout(nip19.EncodeNaddr(entityPtr.PublicKey, entityPtr.Kind, entityPtr.Identifier, appendUnique(relays, entityPtr.Relays...)))
+				ctx = encodeResult(ctx, c, "naddr", jsonStr, nip19.EncodeNaddr(entityPtr.PublicKey, entityPtr.Kind, entityPtr.Identifier, appendUnique(relays, entityPtr.Relays...)), nil)
 				continue
 			}
 
-			ctx = lineProcessingError(ctx, "couldn't decode JSON '%s'", jsonStr)
+			ctx = encodeResult(ctx, c, "", jsonStr, "", fmt.Errorf("couldn't decode JSON '%s'", jsonStr))
 		}
 
 		if !hasStdin {
@@ -77,16 +81,17 @@ var encode = &cli.Command{
 		{
 			Name:                      "npub",
 			Usage:                     "encode a hex public key into bech32 'npub' format",
+			Flags:                     []cli.Flag{jsonlFlag},
 			DisableSliceFlagSeparator: true,
 			Action: func(ctx context.Context, c *cli.Command) error {
 				for target := range getStdinLinesOrArguments(c.Args()) {
 					pk, err := nostr.PubKeyFromHexCheap(target)
 					if err != nil {
-						ctx = lineProcessingError(ctx, "invalid public key '%s': %w", target, err)
+						ctx = encodeResult(ctx, c, "npub", target, "", fmt.Errorf("invalid public key '%s': %w", target, err))
 						continue
 					}
 
-					stdout(nip19.EncodeNpub(pk))
+					ctx = encodeResult(ctx, c, "npub", target, nip19.EncodeNpub(pk), nil)
 				}
 
 				exitIfLineProcessingError(ctx)
@@ -96,16 +101,17 @@ var encode = &cli.Command{
 		{
 			Name:                      "nsec",
 			Usage:                     "encode a hex private key into bech32 'nsec' format",
+			Flags:                     []cli.Flag{jsonlFlag},
 			DisableSliceFlagSeparator: true,
 			Action: func(ctx context.Context, c *cli.Command) error {
 				for target := range getStdinLinesOrArguments(c.Args()) {
 					sk, err := nostr.SecretKeyFromHex(target)
 					if err != nil {
-						ctx = lineProcessingError(ctx, "invalid private key '%s': %w", target, err)
+						ctx = encodeResult(ctx, c, "nsec", target, "", fmt.Errorf("invalid private key '%s': %w", target, err))
 						continue
 					}
 
-					stdout(nip19.EncodeNsec(sk))
+					ctx = encodeResult(ctx, c, "nsec", target, nip19.EncodeNsec(sk), nil)
 				}
 
 				exitIfLineProcessingError(ctx)
@@ -114,7 +120,7 @@ var encode = &cli.Command{
 		},
 		{
 			Name:  "nprofile",
-			Usage: "generate profile codes with attached relay information",
+			Usage: "generate profile codes with attached relay information, from hex pubkeys or nip05 identifiers",
 			Flags: []cli.Flag{
 				&cli.StringSliceFlag{
 					Name:    "relay",
@@ -126,29 +132,48 @@ var encode = &cli.Command{
 					Usage: "automatically appends outbox relays to the code",
 					Value: 3,
 				},
+				jsonlFlag,
 			},
 			DisableSliceFlagSeparator: true,
 			Action: func(ctx context.Context, c *cli.Command) error {
 				for target := range getStdinLinesOrArguments(c.Args()) {
-					pk, err := nostr.PubKeyFromHexCheap(target)
-					if err != nil {
-						ctx = lineProcessingError(ctx, "invalid public key '%s': %w", target, err)
-						continue
-					}
-
 					relays := c.StringSlice("relay")
 
+					var pk nostr.PubKey
+					if nip05.IsValidIdentifier(target) {
+						// the relays listed in the nip05 are the best hints we can have
+						pp, err := nip05.QueryIdentifier(ctx, target)
+						if err != nil {
+							ctx = encodeResult(ctx, c, "nprofile", target, "", fmt.Errorf("failed to resolve nip05 '%s': %w", target, err))
+							continue
+						}
+						harvestPointerHints(*pp)
+						pk = pp.PublicKey
+						for _, r := range pp.Relays {
+							relays = appendUnique(relays, nostr.NormalizeURL(r))
+						}
+					} else {
+						var err error
+						if pk, err = nostr.PubKeyFromHexCheap(target); err != nil {
+							ctx = encodeResult(ctx, c, "nprofile", target, "", fmt.Errorf("invalid public key '%s': %w", target, err))
+							continue
+						}
+					}
+
 					if getBoolInt(c, "outbox") > 0 {
 						for _, r := range sys.FetchOutboxRelays(ctx, pk, int(getBoolInt(c, "outbox"))) {
 							relays = appendUnique(relays, r)
 						}
+					} else if len(relays) == 0 {
+						// use relays where we've seen this pubkey before
+						relays = sys.Hints.TopN(pk, 2)
 					}
 
 					if err := normalizeAndValidateRelayURLs(relays); err != nil {
 						return err
 					}
 
-					stdout(nip19.EncodeNprofile(pk, relays))
+					ctx = encodeResult(ctx, c, "nprofile", target, nip19.EncodeNprofile(pk, relays), nil)
 				}
 
 				exitIfLineProcessingError(ctx)
@@ -174,13 +199,14 @@ var encode = &cli.Command{
 					Usage: "automatically appends outbox relays to the code",
 					Value: 3,
 				},
+				jsonlFlag,
 			},
 			DisableSliceFlagSeparator: true,
 			Action: func(ctx context.Context, c *cli.Command) error {
 				for target := range getStdinLinesOrArguments(c.Args()) {
 					id, err := parseEventID(target)
 					if err != nil {
-						ctx = lineProcessingError(ctx, "invalid event id: %s", target)
+						ctx = encodeResult(ctx, c, "nevent", target, "", fmt.Errorf("invalid event id: %s", target))
 						continue
 					}
 
@@ -191,13 +217,19 @@ var encode = &cli.Command{
 						for _, r := range sys.FetchOutboxRelays(ctx, author, int(getBoolInt(c, "outbox"))) {
 							relays = appendUnique(relays, r)
 						}
+					} else if len(relays) == 0 {
+						// use relays where we've seen this event before
+						relays = eventRelayHints(id)
+						if len(relays) > 2 {
+							relays = relays[0:2]
+						}
 					}
 
 					if err := normalizeAndValidateRelayURLs(relays); err != nil {
 						return err
 					}
 
-					stdout(nip19.EncodeNevent(id, relays, author))
+					ctx = encodeResult(ctx, c, "nevent", target, nip19.EncodeNevent(id, relays, author), nil)
 				}
 
 				exitIfLineProcessingError(ctx)
@@ -236,6 +268,7 @@ var encode = &cli.Command{
 					Usage: "automatically appends outbox relays to the code",
 					Value: 3,
 				},
+				jsonlFlag,
 			},
 			DisableSliceFlagSeparator: true,
 			Action: func(ctx context.Context, c *cli.Command) error {
@@ -250,7 +283,7 @@ var encode = &cli.Command{
 					if d == "" {
 						d = c.String("identifier")
 						if d == "" {
-							ctx = lineProcessingError(ctx, "\"d\" tag identifier can't be empty")
+							ctx = encodeResult(ctx, c, "naddr", d, "", fmt.Errorf("\"d\" tag identifier can't be empty"))
 							continue
 						}
 					}
@@ -267,7 +300,7 @@ var encode = &cli.Command{
 						return err
 					}
 
-					stdout(nip19.EncodeNaddr(pubkey, nostr.Kind(kind), d, relays))
+					ctx = encodeResult(ctx, c, "naddr", d, nip19.EncodeNaddr(pubkey, nostr.Kind(kind), d, relays), nil)
 				}
 
 				exitIfLineProcessingError(ctx)
@@ -276,3 +309,21 @@ var encode = &cli.Command{
 		},
 	},
 }
+
+// encodeResult prints the code generated from an input line, or the failure to generate it, either as
+// plain text or as a --jsonl object.
+func encodeResult(ctx context.Context, c *cli.Command, typ string, input string, code string, err error) context.Context {
+	if c.Bool("jsonl") {
+		fields := map[string]any{"type": typ}
+		if err == nil {
+			fields["code"] = code
+		}
+		return printJSONLine(ctx, input, fields, err)
+	}
+
+	if err != nil {
+		return lineProcessingError(ctx, "%s", err)
+	}
+	stdout(code)
+	return ctx
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"fiatjaf.com/nostr"
@@ -40,6 +43,7 @@ example:
 		echo '{"tags": [["t", "spam"]]}' | nak event -c 'this is spam'`,
 	DisableSliceFlagSeparator: true,
 	Flags: append(defaultKeyFlags,
+		relayFlag,
 		// ~ these args are only for the convoluted musig2 signing process
 		// they will be generally copy-shared-pasted across some manual coordination method between participants
 		&cli.UintFlag{
@@ -81,11 +85,23 @@ example:
 			Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
 			Category: CATEGORY_EXTRAS,
 		},
+		&cli.BoolFlag{
+			Name:     "force-pre-auth",
+			Aliases:  []string{"fpa"},
+			Usage:    "after connecting, wait for a nip42 \"AUTH\" message to be received, act on it and only then send the \"EVENT\"",
+			Category: CATEGORY_SIGNER,
+		},
 		&cli.BoolFlag{
 			Name:     "nevent",
 			Usage:    "print the nevent code (to stderr) after the event is published",
 			Category: CATEGORY_EXTRAS,
 		},
+		&cli.StringFlag{
+			Name:     "results",
+			Usage:    "how to report the publishing results: \"text\", \"json\" (one {\"relay\",\"ok\",\"reason\"} object per relay after the event) or \"json-stderr\" (the same objects, but to stderr)",
+			Value:    "text",
+			Category: CATEGORY_EXTRAS,
+		},
 		&cli.UintFlag{
 			Name:        "kind",
 			Aliases:     []string{"k"},
@@ -97,15 +113,26 @@ example:
 		&cli.StringFlag{
 			Name:        "content",
 			Aliases:     []string{"c"},
-			Usage:       "event content (if it starts with an '@' will read from a file)",
+			Usage:       "event content (if it starts with an '@' will read from a file, if it is '-' will read from stdin)",
 			DefaultText: "hello from the nostr army knife",
 			Value:       "",
 			Category:    CATEGORY_EVENT_FIELDS,
 		},
+		&cli.StringFlag{
+			Name:      "content-file",
+			Usage:     "read the event content from this file",
+			TakesFile: true,
+			Category:  CATEGORY_EVENT_FIELDS,
+		},
+		&cli.BoolFlag{
+			Name:     "template",
+			Usage:    "expand {{env \"VAR\"}}, {{date}}, {{date \"2006-01-02\"}} and {{now}} placeholders in the content",
+			Category: CATEGORY_EVENT_FIELDS,
+		},
 		&cli.StringSliceFlag{
 			Name:     "tag",
 			Aliases:  []string{"t"},
-			Usage:    "sets a tag field on the event, takes a value like -t e=<id> or -t sometag=\"value one;value two;value three\"",
+			Usage:    "sets a tag field on the event, takes a value like -t e=<id> or -t sometag=\"value one;value two;value three\" (escape ';' in values as '\\;')",
 			Category: CATEGORY_EVENT_FIELDS,
 		},
 		&cli.StringSliceFlag{
@@ -131,19 +158,92 @@ example:
 			Value:       nostr.Now(),
 			Category:    CATEGORY_EVENT_FIELDS,
 		},
+		strictTimeFlag,
+		requireAcksFlag,
+		parallelFlag,
+		unorderedFlag,
 		&cli.BoolFlag{
 			Name:     "confirm",
 			Usage:    "ask before publishing the event",
 			Category: CATEGORY_EXTRAS,
 		},
+		&cli.StringSliceFlag{
+			Name:     "emoji",
+			Usage:    "add a nip30 custom emoji tag, as shortcode=https://.../emoji.png (emojis from <config-path>/emojis are added automatically when used)",
+			Category: CATEGORY_EVENT_FIELDS,
+		},
+		&cli.StringFlag{
+			Name:     "geohash",
+			Usage:    "add g tags for this geohash and all its prefixes, so it can be found by #g queries at any precision",
+			Category: CATEGORY_EVENT_FIELDS,
+		},
+		&cli.StringFlag{
+			Name:     "location",
+			Usage:    "like --geohash, but taking a \"lat,lon\" and encoding it with --geohash-precision",
+			Category: CATEGORY_EVENT_FIELDS,
+		},
+		&cli.IntFlag{
+			Name:     "geohash-precision",
+			Usage:    "number of geohash characters to use for --location (5 is around 5km, 7 around 150m)",
+			Value:    6,
+			Category: CATEGORY_EVENT_FIELDS,
+		},
+		&cli.StringFlag{
+			Name:     "content-warning",
+			Usage:    "mark the event as sensitive with a nip36 content-warning tag, with an optional reason",
+			Category: CATEGORY_EVENT_FIELDS,
+		},
+		&cli.BoolFlag{
+			Name:     "pretty",
+			Usage:    "print the event in a human-readable format instead of json",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "show-sensitive",
+			Usage:    "with --pretty, show the content of events marked with a content-warning instead of hiding it",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "imeta-auto",
+			Usage:    "download the images and videos linked in the content and add imeta tags (nip92) with their mime type, sha256, size, dimensions and blurhash",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "no-autotag",
+			Usage:    "don't resolve @nip05 mentions nor add tags for the nostr: references, #hashtags and urls in the content",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "unsigned",
+			Usage:    "don't sign the event, just print it as a template to be signed later with 'nak sign'",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.IntFlag{
+			Name:     "seed",
+			Usage:    "when --sec or --created-at are not given, derive them deterministically from this number, for reproducible tests",
+			Category: CATEGORY_EXTRAS,
+		},
 	),
 	ArgsUsage: "[relay...]",
 	Action: func(ctx context.Context, c *cli.Command) error {
+		if format := c.String("results"); format != "text" && format != "json" && format != "json-stderr" {
+			return fmt.Errorf("invalid --results '%s', expected text, json or json-stderr", format)
+		}
+
+		unsigned := c.Bool("unsigned")
+		if unsigned && (c.Args().Len() > 0 || c.IsSet("relay") || c.IsSet("pow") || c.IsSet("musig")) {
+			return fmt.Errorf("--unsigned can't be used with relays, --pow or --musig")
+		}
+
 		// try to connect to the relays here
 		var relays []*nostr.Relay
 
-		if relayUrls := c.Args().Slice(); len(relayUrls) > 0 {
-			relays = connectToAllRelays(ctx, c, relayUrls, nil,
+		if relayUrls := getRelayURLs(c, c.Args().Slice()); len(relayUrls) > 0 {
+			forcePreAuthSigner := authSigner
+			if !c.Bool("force-pre-auth") {
+				forcePreAuthSigner = nil
+			}
+			relays = connectToAllRelays(ctx, c, relayUrls, forcePreAuthSigner,
 				nostr.PoolOptions{
 					AuthRequiredHandler: func(ctx context.Context, authEvent *nostr.Event) error {
 						return authSigner(ctx, c, func(s string, args ...any) {}, authEvent)
@@ -156,19 +256,59 @@ example:
 			}
 		}
 
-		kr, sec, err := gatherKeyerFromArguments(ctx, c)
-		if err != nil {
-			return err
+		if c.IsSet("seed") && !c.IsSet("sec") && !c.Bool("prompt-sec") {
+			c.Set("sec", seededSecretKey(c.Int("seed"), 0).Hex())
+		}
+
+		var kr nostr.Keyer
+		var sec nostr.SecretKey
+		var err error
+		if !unsigned {
+			kr, sec, err = gatherKeyerFromArguments(ctx, c)
+			if err != nil {
+				return err
+			}
 		}
 
 		// then process input and generate events:
 
-		// will reuse this
-		var evt nostr.Event
+		// content may come from flags, a file or stdin, in which case stdin can't have events
+		contentWasGiven := c.IsSet("content") || c.IsSet("content-file")
+		var content string
+		if c.IsSet("content") && c.IsSet("content-file") {
+			return fmt.Errorf("--content and --content-file can't be used together")
+		} else if path := c.String("content-file"); path != "" {
+			filedata, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file '%s' for content: %w", path, err)
+			}
+			content = string(filedata)
+		} else if content = c.String("content"); content == "-" {
+			stdindata, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read content from stdin: %w", err)
+			}
+			content = string(stdindata)
+		} else if strings.HasPrefix(content, "@") {
+			filedata, err := os.ReadFile(content[1:])
+			if err != nil {
+				return fmt.Errorf("failed to read file '%s' for content: %w", content[1:], err)
+			}
+			content = string(filedata)
+		}
+		emojiSet := loadEmojiSet(c.String("config-path"))
+
+		if contentWasGiven && c.Bool("template") {
+			content, err = expandContentTemplate(content)
+			if err != nil {
+				return err
+			}
+		}
 
 		// this is called when we have a valid json from stdin
-		handleEvent := func(stdinEvent string) error {
-			evt.Content = ""
+		handleEvent := func(ctx context.Context, stdinEvent string) error {
+			var evt nostr.Event
+			var err error
 
 			kindWasSupplied := strings.Contains(stdinEvent, `"kind"`)
 			contentWasSupplied := strings.Contains(stdinEvent, `"content"`)
@@ -186,17 +326,8 @@ example:
 				mustRehashAndResign = true
 			}
 
-			if c.IsSet("content") {
-				content := c.String("content")
-				if strings.HasPrefix(content, "@") {
-					filedata, err := os.ReadFile(content[1:])
-					if err != nil {
-						return fmt.Errorf("failed to read file '%s' for content: %w", content[1:], err)
-					}
-					evt.Content = string(filedata)
-				} else {
-					evt.Content = content
-				}
+			if contentWasGiven {
+				evt.Content = content
 				mustRehashAndResign = true
 			} else if !contentWasSupplied && evt.Content == "" && evt.Kind == 1 {
 				evt.Content = "hello from the nostr army knife"
@@ -206,16 +337,9 @@ example:
 			tagFlags := c.StringSlice("tag")
 			tags := make(nostr.Tags, 0, len(tagFlags)+2)
 			for _, tagFlag := range tagFlags {
-				// tags are in the format key=value
-				tagName, tagValue, found := strings.Cut(tagFlag, "=")
-				tag := []string{tagName}
-				if found {
-					// tags may also contain extra elements separated with a ";"
-					tagValues := strings.Split(tagValue, ";")
-					if len(tagValues) >= 1 {
-						tagValues[0] = decodeTagValue(tagValues[0])
-					}
-					tag = append(tag, tagValues...)
+				tag, err := parseTagFlag(tagFlag)
+				if err != nil {
+					return err
 				}
 				tags = append(tags, tag)
 			}
@@ -245,11 +369,70 @@ example:
 				mustRehashAndResign = true
 			}
 
+			if contentWasGiven && !c.Bool("no-autotag") {
+				evt.Content = resolveNIP05Mentions(ctx, evt.Content)
+				if autotagContent(&evt) {
+					mustRehashAndResign = true
+				}
+				if evt.Kind == 1 && autotagHashtagsAndURLs(&evt) {
+					mustRehashAndResign = true
+				}
+			}
+
+			if c.Bool("imeta-auto") && addImetaTags(ctx, &evt) {
+				mustRehashAndResign = true
+			}
+
+			geohash := c.String("geohash")
+			if location := c.String("location"); location != "" {
+				lat, lon, err := parseLocation(location)
+				if err != nil {
+					return fmt.Errorf("invalid --location: %w", err)
+				}
+				precision := int(c.Int("geohash-precision"))
+				if precision < 1 || precision > 12 {
+					return fmt.Errorf("--geohash-precision must be between 1 and 12")
+				}
+				geohash = encodeGeohash(lat, lon, precision)
+			}
+			if geohash != "" {
+				geohash = strings.ToLower(geohash)
+				if strings.Trim(geohash, geohashAlphabet) != "" {
+					return fmt.Errorf("invalid geohash '%s'", geohash)
+				}
+				for i := len(geohash); i >= 1; i-- {
+					if evt.Tags.FindWithValue("g", geohash[0:i]) == nil {
+						evt.Tags = append(evt.Tags, nostr.Tag{"g", geohash[0:i]})
+					}
+				}
+				mustRehashAndResign = true
+			}
+
+			if c.IsSet("content-warning") && evt.Tags.Find("content-warning") == nil {
+				tag := nostr.Tag{"content-warning"}
+				if reason := c.String("content-warning"); reason != "" {
+					tag = append(tag, reason)
+				}
+				evt.Tags = append(evt.Tags, tag)
+				mustRehashAndResign = true
+			}
+
+			if contentWasGiven || c.IsSet("emoji") {
+				if added, err := addEmojiTags(&evt, c.StringSlice("emoji"), emojiSet); err != nil {
+					return err
+				} else if added {
+					mustRehashAndResign = true
+				}
+			}
+
 			if c.IsSet("created-at") {
 				evt.CreatedAt = getNaturalDate(c, "created-at")
 				mustRehashAndResign = true
 			} else if evt.CreatedAt == 0 {
 				evt.CreatedAt = nostr.Now()
+				if c.IsSet("seed") {
+					evt.CreatedAt = seededBaseTime
+				}
 				mustRehashAndResign = true
 			}
 
@@ -279,6 +462,17 @@ example:
 				mustRehashAndResign = true
 			}
 
+			if unsigned {
+				j, _ := json.Marshal(unsignedEvent{
+					Kind:      evt.Kind,
+					CreatedAt: evt.CreatedAt,
+					Tags:      evt.Tags,
+					Content:   evt.Content,
+				})
+				outputFor(ctx)(string(j))
+				return nil
+			}
+
 			if evt.Sig == [64]byte{} || mustRehashAndResign {
 				if numSigners := c.Uint("musig"); numSigners > 1 {
 					// must do musig
@@ -306,35 +500,113 @@ example:
 
 			// print event as json
 			var result string
-			if c.Bool("envelope") {
+			if c.Bool("pretty") {
+				result = prettyEvent(evt, c.Bool("show-sensitive"))
+			} else if c.Bool("envelope") {
 				j, _ := json.Marshal(nostr.EventEnvelope{Event: evt})
 				result = string(j)
 			} else {
 				j, _ := easyjson.Marshal(&evt)
 				result = string(j)
 			}
-			stdout(result)
+			outputFor(ctx)(result)
 
 			return publishFlow(ctx, c, kr, evt, relays)
 		}
 
-		for stdinEvent := range getJsonsOrBlank() {
-			if err := handleEvent(stdinEvent); err != nil {
-				ctx = lineProcessingError(ctx, err.Error())
-			}
+		inputs := getJsonsOrBlank()
+		if c.String("content") == "-" {
+			inputs = func(yield func(string) bool) { yield("{}") }
 		}
 
+		ctx, _ = forEachLine(ctx, c, inputs, func(ctx context.Context, stdinEvent string) (context.Context, error) {
+			if err := handleEvent(ctx, stdinEvent); err != nil {
+				return lineProcessingError(ctx, err.Error()), nil
+			}
+			return ctx, nil
+		})
+
 		exitIfLineProcessingError(ctx)
 		return nil
 	},
 }
 
-func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr.Event, relays []*nostr.Relay) error {
-	doAuth := c.Bool("auth")
+// expandContentTemplate runs the content through text/template with a few helpers so it can be
+// generated from scripts without quoting gymnastics.
+func expandContentTemplate(content string) (string, error) {
+	tmpl, err := template.New("content").Funcs(template.FuncMap{
+		"env": os.Getenv,
+		"date": func(layout ...string) string {
+			if len(layout) > 0 {
+				return time.Now().Format(layout[0])
+			}
+			return time.Now().Format(time.DateOnly)
+		},
+		"now": func() int64 { return time.Now().Unix() },
+	}).Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("invalid content template: %w", err)
+	}
+
+	b := &strings.Builder{}
+	if err := tmpl.Execute(b, nil); err != nil {
+		return "", fmt.Errorf("failed to expand content template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// unsignedEvent is what gets printed by --unsigned and is read by 'nak sign'.
+type unsignedEvent struct {
+	Kind      nostr.Kind      `json:"kind"`
+	CreatedAt nostr.Timestamp `json:"created_at"`
+	Tags      nostr.Tags      `json:"tags"`
+	Content   string          `json:"content"`
+}
+
+// signPrintAndPublish is used by commands that build a specific kind of event from their own flags:
+// it signs the event with the key given in the flags, prints it and, if any relays were given, publishes it.
+func signPrintAndPublish(ctx context.Context, c *cli.Command, evt nostr.Event, relayUrls []string) error {
+	kr, _, err := gatherKeyerFromArguments(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if evt.CreatedAt == 0 {
+		evt.CreatedAt = nostr.Now()
+	}
+	if err := kr.SignEvent(ctx, &evt); err != nil {
+		return fmt.Errorf("error signing with provided key: %w", err)
+	}
+
+	stdout(evt.String())
+
+	relayUrls = getRelayURLs(c, relayUrls)
+	if len(relayUrls) == 0 {
+		return nil
+	}
+
+	relays := connectToAllRelays(ctx, c, relayUrls, nil,
+		nostr.PoolOptions{
+			AuthRequiredHandler: func(ctx context.Context, authEvent *nostr.Event) error {
+				return authSigner(ctx, c, func(s string, args ...any) {}, authEvent)
+			},
+		},
+	)
+	if len(relays) == 0 {
+		return fmt.Errorf("failed to connect to any of the given relays")
+	}
 
+	return publishFlow(ctx, c, kr, evt, relays)
+}
+
+func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr.Event, relays []*nostr.Relay) error {
 	// publish to relays
 	successRelays := make([]string, 0, len(relays))
 	if len(relays) > 0 {
+		if err := checkCreatedAt(ctx, c, evt, relays); err != nil {
+			return err
+		}
+
 		os.Stdout.Sync()
 
 		if c.Bool("confirm") {
@@ -348,7 +620,19 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 			}
 		}
 
-		if supportsDynamicMultilineMagic() {
+		if n := int(c.Uint("require-acks")); n > 0 {
+			urls := make([]string, len(relays))
+			for i, r := range relays {
+				urls[i] = r.URL
+			}
+			urls = appendUnique(urls, loadConfigRelays(c.String("config-path"), "backup-relays")...)
+			successRelays = publishUntilAcks(ctx, evt, urls, n)
+			if len(successRelays) < n {
+				return fmt.Errorf("only %d of the required %d relays accepted the event", len(successRelays), n)
+			}
+		} else if format := c.String("results"); format == "json" || format == "json-stderr" {
+			successRelays = publishWithJSONResults(ctx, c, kr, evt, relays, format == "json-stderr")
+		} else if supportsDynamicMultilineMagic() {
 			// overcomplicated multiline rendering magic
 			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 			defer cancel()
@@ -388,7 +672,7 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 			}
 			render()
 
-			for res := range sys.Pool.PublishMany(ctx, urls, evt) {
+			for res := range publishManyWithRetries(ctx, c, urls, evt) {
 				if res.Error == nil {
 					colorizethis(res.RelayURL, colors.successf)
 					logthis(res.RelayURL, "success.")
@@ -403,7 +687,8 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 					msg := strings.ReplaceAll(low.Error(), evt.PubKey.Hex(), "author")
 
 					// do not allow the message to overflow the term window
-					msg = clampMessage(msg, 20+len(res.RelayURL))
+					class := classifyRelayError(res.Error)
+					msg = color.YellowString("(%s) ", class) + clampMessage(msg, 23+len(res.RelayURL)+len(class))
 
 					logthis(res.RelayURL, msg)
 				}
@@ -411,20 +696,22 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 		} else {
 			// normal dumb flow
 			for i, relay := range relays {
+				doAuth := c.Bool("auth") || c.Bool("force-pre-auth")
 			publish:
 				cleanUrl, _ := strings.CutPrefix(relay.URL, "wss://")
 				log("publishing to %s... ", color.CyanString(cleanUrl))
 				ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 				defer cancel()
 
-				if !relay.IsConnected() {
-					if new_, err := sys.Pool.EnsureRelay(relay.URL); err == nil {
-						relays[i] = new_
-						relay = new_
+				err := withRelayRetries(ctx, c, relay.URL, func() error {
+					if !relay.IsConnected() {
+						if new_, err := sys.Pool.EnsureRelay(relay.URL); err == nil {
+							relays[i] = new_
+							relay = new_
+						}
 					}
-				}
-
-				err := relay.Publish(ctx, evt)
+					return relay.Publish(ctx, evt)
+				})
 				if err == nil {
 					// published fine
 					log("success.\n")
@@ -445,8 +732,11 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 					} else {
 						log("auth error: %s. ", err)
 					}
+				} else if strings.HasPrefix(err.Error(), "msg: auth-required:") && !c.Bool("auth") {
+					log("failed: %s (use --auth to authenticate)\n", err)
+					continue
 				}
-				log("failed: %s\n", err)
+				log("failed (%s): %s\n", classifyRelayError(err), err)
 			}
 		}
 
@@ -457,3 +747,64 @@ func publishFlow(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr
 
 	return nil
 }
+
+type publishResult struct {
+	Relay  string          `json:"relay"`
+	OK     bool            `json:"ok"`
+	Reason string          `json:"reason,omitempty"`
+	Class  relayErrorClass `json:"class,omitempty"`
+}
+
+// publishWithJSONResults publishes to all relays in parallel (performing AUTH if needed and allowed)
+// and prints one publishResult for each relay as soon as it is known, returning the relays that accepted the event.
+func publishWithJSONResults(ctx context.Context, c *cli.Command, kr nostr.Signer, evt nostr.Event, relays []*nostr.Relay, toStderr bool) []string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	successRelays := make([]string, 0, len(relays))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(len(relays))
+	for _, relay := range relays {
+		go func() {
+			defer wg.Done()
+
+			err := withRelayRetries(ctx, c, relay.URL, func() error {
+				if !relay.IsConnected() {
+					if new_, err := sys.Pool.EnsureRelay(relay.URL); err == nil {
+						relay = new_
+					}
+				}
+				return relay.Publish(ctx, evt)
+			})
+			if err != nil && strings.HasPrefix(err.Error(), "msg: auth-required:") && kr != nil && (c.Bool("auth") || c.Bool("force-pre-auth")) {
+				if authErr := relay.Auth(ctx, kr.SignEvent); authErr == nil {
+					err = relay.Publish(ctx, evt)
+				} else {
+					err = fmt.Errorf("auth failed: %w", authErr)
+				}
+			}
+
+			res := publishResult{Relay: relay.URL, OK: err == nil}
+			if err != nil {
+				res.Reason = strings.TrimPrefix(unwrapAll(err).Error(), "msg: ")
+				res.Class = classifyRelayError(err)
+			}
+			j, _ := json.Marshal(res)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if res.OK {
+				successRelays = append(successRelays, relay.URL)
+			}
+			if toStderr {
+				log("%s\n", j)
+			} else {
+				outputFor(ctx)(string(j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return successRelays
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+var feed = &cli.Command{
+	Name:  "feed",
+	Usage: "prints the notes from the people you follow, fetched from their outbox relays",
+	Description: `reads the follow list (kind 3) of the given pubkey (or the one from --sec), finds the write relays of each followed person and queries them, printing all the notes in chronological order.
+
+the timestamp of the newest note printed is saved, so the next run only shows new notes (use --all to ignore that).
+
+example:
+		nak feed --sec $NOSTR_SECRET_KEY
+		nak feed --pubkey npub1... --since 2h --stream`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(slices.Clip(defaultKeyFlags),
+		&PubKeyFlag{
+			Name:        "pubkey",
+			Usage:       "whose feed to build",
+			DefaultText: "the pubkey from --sec",
+		},
+		&cli.IntSliceFlag{
+			Name:    "kind",
+			Aliases: []string{"k"},
+			Usage:   "kinds to include in the feed",
+			Value:   []int64{1, 6},
+		},
+		&NaturalTimeFlag{
+			Name:        "since",
+			Aliases:     []string{"s"},
+			Usage:       "only notes newer than this",
+			DefaultText: "since the last run, or the last 24 hours",
+		},
+		&cli.UintFlag{
+			Name:    "limit",
+			Aliases: []string{"l"},
+			Usage:   "maximum number of notes to print (the newest ones)",
+			Value:   200,
+		},
+		&cli.UintFlag{
+			Name:    "outbox-relays-per-pubkey",
+			Aliases: []string{"n"},
+			Usage:   "number of outbox relays to use for each followed pubkey",
+			Value:   2,
+		},
+		&cli.BoolFlag{
+			Name:  "stream",
+			Usage: "keep listening for new notes after printing the existing ones",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "ignore the saved position from the last run",
+		},
+		relayFlag,
+		applyMutesFlag,
+		antispamFlag,
+		antispamRulesFlag,
+		langFlag,
+		saneTimestampsFlag,
+		&cli.BoolFlag{
+			Name:  "show-sensitive",
+			Usage: "also print events marked with a nip36 content-warning, which are skipped by default",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("relay")); err != nil {
+			return err
+		}
+
+		pubkey := getPubKey(c, "pubkey")
+		if pubkey == nostr.ZeroPK {
+			kr, _, err := gatherKeyerFromArguments(ctx, c)
+			if err != nil {
+				return err
+			}
+			if pubkey, err = kr.GetPublicKey(ctx); err != nil {
+				return fmt.Errorf("failed to get public key: %w", err)
+			}
+		}
+
+		if err := setupMutes(ctx, c); err != nil {
+			return err
+		}
+		if err := setupAntispam(ctx, c); err != nil {
+			return err
+		}
+		if err := setupLang(c); err != nil {
+			return err
+		}
+		setupSaneTimestamps(c)
+		printEvent := func(evt nostr.Event) {
+			if reason, isSensitive := contentWarning(evt); isSensitive && !c.Bool("show-sensitive") {
+				log("%s\n", color.YellowString("skipping %s from %s with content warning '%s'", evt.ID.Hex(), evt.PubKey.Hex(), reason))
+				return
+			}
+			stdout(evt)
+		}
+
+		follows := sys.FetchFollowList(ctx, pubkey).Items
+		if len(follows) == 0 {
+			return fmt.Errorf("no follows found for %s", pubkey.Hex())
+		}
+		logverbose("%d follows found\n", len(follows))
+
+		kinds := make([]nostr.Kind, 0, len(c.IntSlice("kind")))
+		for _, kind := range c.IntSlice("kind") {
+			kinds = append(kinds, nostr.Kind(kind))
+		}
+
+		cursorPath := filepath.Join(c.String("config-path"), "feed", pubkey.Hex())
+		since := nostr.Timestamp(time.Now().Add(-24 * time.Hour).Unix())
+		if c.IsSet("since") {
+			since = getNaturalDate(c, "since")
+		} else if !c.Bool("all") {
+			if data, err := os.ReadFile(cursorPath); err == nil {
+				if ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+					since = nostr.Timestamp(ts) + 1
+					logverbose("showing notes since the last run at %s\n", nostr.Timestamp(ts).Time().Format(time.DateTime))
+				}
+			}
+		}
+		newest := since - 1
+		saveCursor := func() {
+			if newest >= since {
+				os.MkdirAll(filepath.Dir(cursorPath), 0755)
+				os.WriteFile(cursorPath, []byte(strconv.FormatInt(int64(newest), 10)), 0644)
+			}
+		}
+		defer saveCursor()
+
+		// group the follows by their outbox relays
+		perRelay := make(map[string][]nostr.PubKey)
+		mu := sync.Mutex{}
+		errg := errgroup.Group{}
+		errg.SetLimit(16)
+		for _, follow := range follows {
+			errg.Go(func() error {
+				for _, url := range sys.FetchOutboxRelays(ctx, follow.Pubkey, int(c.Uint("outbox-relays-per-pubkey"))) {
+					if !nostr.IsValidRelayURL(url) {
+						continue
+					}
+					mu.Lock()
+					perRelay[url] = append(perRelay[url], follow.Pubkey)
+					mu.Unlock()
+				}
+				return nil
+			})
+		}
+		errg.Wait()
+
+		// the relays given with --relay are queried for everybody
+		for _, url := range getRelayURLs(c, nil) {
+			for _, follow := range follows {
+				perRelay[url] = appendUnique(perRelay[url], follow.Pubkey)
+			}
+		}
+		logverbose("querying %d relays\n", len(perRelay))
+		prepareRelays(ctx, slices.Collect(maps.Keys(perRelay)), c.Bool("skip-verify"))
+
+		makeDefs := func(since nostr.Timestamp) []nostr.DirectedFilter {
+			defs := make([]nostr.DirectedFilter, 0, len(perRelay))
+			for url, authors := range perRelay {
+				defs = append(defs, nostr.DirectedFilter{
+					Relay: url,
+					Filter: nostr.Filter{
+						Authors: authors,
+						Kinds:   kinds,
+						Since:   since,
+						Limit:   int(c.Uint("limit")),
+					},
+				})
+			}
+			return defs
+		}
+
+		// collect everything first so we can print it in order
+		seen := make(map[nostr.ID]struct{})
+		events := make([]nostr.Event, 0, c.Uint("limit"))
+		results, _ := sys.Pool.BatchedQueryManyNotifyClosed(ctx, makeDefs(since), nostr.SubscriptionOptions{Label: "nak-feed"})
+		for ie := range results {
+			if _, ok := seen[ie.Event.ID]; ok {
+				continue
+			}
+			seen[ie.Event.ID] = struct{}{}
+			events = append(events, ie.Event)
+		}
+		slices.SortFunc(events, func(a, b nostr.Event) int { return int(a.CreatedAt) - int(b.CreatedAt) })
+		if limit := int(c.Uint("limit")); limit > 0 && len(events) > limit {
+			events = events[len(events)-limit:]
+		}
+		for _, evt := range events {
+			printEvent(evt)
+			if evt.CreatedAt > newest {
+				newest = evt.CreatedAt
+			}
+		}
+
+		if !c.Bool("stream") {
+			return nil
+		}
+
+		results, _ = sys.Pool.BatchedSubscribeManyNotifyClosed(ctx, makeDefs(nostr.Now()), nostr.SubscriptionOptions{Label: "nak-feed"})
+		notifyReady()
+		for ie := range results {
+			if _, ok := seen[ie.Event.ID]; ok {
+				continue
+			}
+			seen[ie.Event.ID] = struct{}{}
+			printEvent(ie.Event)
+			if ie.Event.CreatedAt > newest {
+				newest = ie.Event.CreatedAt
+				saveCursor()
+			}
+		}
+
+		return nil
+	},
+}
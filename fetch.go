@@ -3,34 +3,61 @@ package main
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/nip05"
 	"fiatjaf.com/nostr/nip19"
 	"fiatjaf.com/nostr/sdk/hints"
+	"github.com/fiatjaf/nak/lib"
 	"github.com/urfave/cli/v3"
 )
 
 var fetch = &cli.Command{
 	Name:  "fetch",
-	Usage: "fetches events related to the given nip19 or nip05 code from the included relay hints or the author's outbox relays.",
-	Description: `example usage:
+	Usage: "fetches events related to the given nip19 or nip05 code from the included relay hints, the author's outbox relays or indexer relays.",
+	Description: `accepts naddr, nevent, note, npub, nprofile and nip05 identifiers.
+
+relays are tried in order, stopping as soon as something is found:
+  1. hints: the relays given with --relay, the ones embedded in the code and the ones where the event or author were seen before;
+  2. outbox: the author's outbox relays, if the author is known;
+  3. indexers: the relays given with --indexer, or a default list of big relays and indexers.
+
+use -v to see which strategy succeeded and how long each one took.
+
+example usage:
         nak fetch nevent1qqsxrwm0hd3s3fddh4jc2574z3xzufq6qwuyz2rvv3n087zvym3dpaqprpmhxue69uhhqatzd35kxtnjv4kxz7tfdenju6t0xpnej4
-        echo npub1h8spmtw9m2huyv6v2j2qd5zv956z2zdugl6mgx02f2upffwpm3nqv0j4ps | nak fetch --relay wss://relay.nostr.band`,
+        echo npub1h8spmtw9m2huyv6v2j2qd5zv956z2zdugl6mgx02f2upffwpm3nqv0j4ps | nak fetch --relay wss://relay.nostr.band
+        nak fetch -v --max-relays 2 fiatjaf@fiatjaf.com`,
 	DisableSliceFlagSeparator: true,
 	Flags: append(reqFilterFlags,
+		relayFlag,
 		&cli.StringSliceFlag{
-			Name:    "relay",
-			Aliases: []string{"r"},
-			Usage:   "also use these relays to fetch from",
+			Name:        "indexer",
+			Usage:       "relays to try as a last resort",
+			DefaultText: "purplepag.es, user.kindpag.es and others",
+		},
+		&cli.UintFlag{
+			Name:  "max-relays",
+			Usage: "maximum number of relays to query in each strategy, 0 for unlimited",
+			Value: 6,
 		},
 	),
 	ArgsUsage: "[nip05_or_nip19_code]",
 	Action: func(ctx context.Context, c *cli.Command) error {
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("relay")); err != nil {
+			return err
+		}
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("indexer")); err != nil {
+			return err
+		}
+		maxRelays := int(c.Uint("max-relays"))
+
 		for code := range getStdinLinesOrArguments(c.Args()) {
 			filter := nostr.Filter{}
 			var authorHint nostr.PubKey
-			relays := c.StringSlice("relay")
+			relays := getRelayURLs(c, nil)
 
 			if nip05.IsValidIdentifier(code) {
 				pp, err := nip05.QueryIdentifier(ctx, code)
@@ -38,6 +65,7 @@ var fetch = &cli.Command{
 					ctx = lineProcessingError(ctx, "failed to fetch nip05: %s", err)
 					continue
 				}
+				harvestPointerHints(*pp)
 				authorHint = pp.PublicKey
 				relays = append(relays, pp.Relays...)
 				filter.Authors = append(filter.Authors, pp.PublicKey)
@@ -48,20 +76,20 @@ var fetch = &cli.Command{
 					continue
 				}
 
-				if err := normalizeAndValidateRelayURLs(relays); err != nil {
-					return err
-				}
-
 				switch prefix {
 				case "nevent":
 					v := value.(nostr.EventPointer)
+					harvestPointerHints(v)
 					filter.IDs = append(filter.IDs, v.ID)
 					if v.Author != nostr.ZeroPK {
 						authorHint = v.Author
 					}
 					relays = append(relays, v.Relays...)
+					relays = appendUnique(relays, eventRelayHints(v.ID)...)
 				case "note":
-					filter.IDs = append(filter.IDs, value.([32]byte))
+					id := value.(nostr.ID)
+					filter.IDs = append(filter.IDs, id)
+					relays = appendUnique(relays, eventRelayHints(id)...)
 				case "naddr":
 					v := value.(nostr.EntityPointer)
 					filter.Kinds = []nostr.Kind{v.Kind}
@@ -88,9 +116,8 @@ var fetch = &cli.Command{
 					sys.Hints.Save(authorHint, nostr.NormalizeURL(url), hints.LastInHint, nostr.Now())
 				}
 
-				for _, url := range sys.FetchOutboxRelays(ctx, authorHint, 3) {
-					relays = append(relays, url)
-				}
+				// relays where this author was seen before
+				relays = appendUnique(relays, sys.Hints.TopN(authorHint, 2)...)
 			}
 
 			if err := applyFlagsToFilter(c, &filter); err != nil {
@@ -101,15 +128,24 @@ var fetch = &cli.Command{
 				filter.Kinds = append(filter.Kinds, 0)
 			}
 
-			if len(relays) == 0 {
-				ctx = lineProcessingError(ctx, "no relay hints found")
-				continue
+			strategies := []fetchStrategy{
+				{"hints", func() []string { return relays }},
+				{"outbox", func() []string {
+					if authorHint == nostr.ZeroPK {
+						return nil
+					}
+					return sys.FetchOutboxRelays(ctx, authorHint, max(maxRelays, 3))
+				}},
+				{"indexers", func() []string {
+					if indexers := c.StringSlice("indexer"); len(indexers) > 0 {
+						return indexers
+					}
+					return appendUnique(slices.Clone(sys.MetadataRelays.URLs), sys.FallbackRelays.URLs...)
+				}},
 			}
 
-			for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{
-				Label: "nak-fetch",
-			}) {
-				stdout(ie.Event)
+			if !fetchWithStrategies(ctx, filter, strategies, maxRelays, c.Bool("skip-verify")) {
+				ctx = lineProcessingError(ctx, "nothing found for %s", code)
 			}
 		}
 
@@ -117,3 +153,56 @@ var fetch = &cli.Command{
 		return nil
 	},
 }
+
+type fetchStrategy struct {
+	name   string
+	relays func() []string
+}
+
+// fetchWithStrategies goes through each strategy in order, querying only relays that weren't
+// tried before, and stops at the first one that returns something.
+func fetchWithStrategies(ctx context.Context, filter nostr.Filter, strategies []fetchStrategy, maxRelays int, skipVerify bool) bool {
+	tried := make(map[string]struct{})
+	start := time.Now()
+
+	for _, strategy := range strategies {
+		relays := make([]string, 0, maxRelays)
+		for _, url := range strategy.relays() {
+			url, _, err := lib.NormalizeRelayURL(url)
+			if err != nil {
+				continue
+			}
+			if _, ok := tried[url]; ok {
+				continue
+			}
+			tried[url] = struct{}{}
+			relays = append(relays, url)
+			if maxRelays > 0 && len(relays) == maxRelays {
+				break
+			}
+		}
+		if len(relays) == 0 {
+			logverbose("%s: no relays to try\n", strategy.name)
+			continue
+		}
+
+		prepareRelays(ctx, relays, skipVerify)
+
+		strategyStart := time.Now()
+		n := 0
+		for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{
+			Label: "nak-fetch",
+		}) {
+			stdout(ie.Event)
+			n++
+		}
+
+		logverbose("%s: %d events from %v in %s\n", strategy.name, n, relays, time.Since(strategyStart).Round(time.Millisecond))
+		if n > 0 {
+			logverbose("found with strategy %s after %s\n", colors.bold(strategy.name), time.Since(start).Round(time.Millisecond))
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"github.com/fiatjaf/nak/lib"
+	"github.com/urfave/cli/v3"
+)
+
+// these are checked locally on the events received, relays never see them
+var reqNegationFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:     "not-tag",
+		Usage:    "takes a tag like --not-tag t=spam, hide events that have it (checked locally, not sent to relays)",
+		Category: CATEGORY_FILTER_ATTRIBUTES,
+	},
+	&PubKeySliceFlag{
+		Name:     "not-author",
+		Usage:    "hide events from these authors (checked locally, not sent to relays)",
+		Category: CATEGORY_FILTER_ATTRIBUTES,
+	},
+	&cli.IntSliceFlag{
+		Name:     "not-kind",
+		Usage:    "hide events with these kind numbers (checked locally, not sent to relays)",
+		Category: CATEGORY_FILTER_ATTRIBUTES,
+	},
+	&cli.StringSliceFlag{
+		Name: "or",
+		Usage: "takes a group of conditions like --or 'kind=1,#t=nostr' and sends one filter per group, each with the " +
+			"conditions from the other flags plus the group's, so events matching any of the groups are returned. " +
+			"groups accept kind, author, id, since, until, search and #<tag> (sent to relays as separate filters)",
+		Category: CATEGORY_FILTER_ATTRIBUTES,
+	},
+}
+
+// setupNegations makes stdout hide the events matching --not-tag, --not-author or --not-kind.
+func setupNegations(c *cli.Command) error {
+	notTags := make([][2]string, 0, len(c.StringSlice("not-tag")))
+	for _, value := range c.StringSlice("not-tag") {
+		tag, err := parseTagFlag(value)
+		if err != nil || len(tag) < 2 {
+			return fmt.Errorf("invalid --not-tag '%s', expected <name>=<value>", value)
+		}
+		notTags = append(notTags, [2]string{tag[0], tag[1]})
+	}
+	notAuthors := getPubKeySlice(c, "not-author")
+	notKinds := c.IntSlice("not-kind")
+
+	if len(notTags) == 0 && len(notAuthors) == 0 && len(notKinds) == 0 {
+		return nil
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				hide := slices.Contains(notAuthors, evt.PubKey) || slices.Contains(notKinds, int64(evt.Kind)) ||
+					slices.ContainsFunc(notTags, func(tag [2]string) bool {
+						return evt.Tags.FindWithValue(tag[0], tag[1]) != nil
+					})
+				if hide {
+					logverbose("hiding event %s by negation\n", evt.ID.Hex())
+					return
+				}
+			}
+		}
+		printNext(args...)
+	}
+
+	return nil
+}
+
+// expandOrGroups returns one filter for each --or group, each being a copy of the base filter with
+// the group's conditions added. without --or it's just the base filter.
+func expandOrGroups(c *cli.Command, base nostr.Filter) ([]nostr.Filter, error) {
+	groups := c.StringSlice("or")
+	if len(groups) == 0 {
+		return []nostr.Filter{base}, nil
+	}
+
+	filters := make([]nostr.Filter, 0, len(groups))
+	for _, group := range groups {
+		filter := base.Clone()
+		for _, term := range strings.Split(group, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(term, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid condition '%s' in --or '%s', expected <key>=<value>", term, group)
+			}
+
+			switch key {
+			case "kind", "k":
+				kind, err := strconv.ParseUint(value, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("invalid kind '%s' in --or '%s'", value, group)
+				}
+				filter.Kinds = append(filter.Kinds, nostr.Kind(kind))
+			case "author", "a":
+				pk, err := parsePubKey(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid author '%s' in --or '%s': %w", value, group, err)
+				}
+				filter.Authors = append(filter.Authors, pk)
+			case "id", "i":
+				id, err := parseEventID(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid id '%s' in --or '%s': %w", value, group, err)
+				}
+				filter.IDs = append(filter.IDs, id)
+			case "since", "until":
+				ts, err := lib.ParseNaturalTime(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s '%s' in --or '%s': %w", key, value, group, err)
+				}
+				if key == "since" {
+					filter.Since = ts
+				} else {
+					filter.Until = ts
+				}
+			case "search":
+				filter.Search = value
+			default:
+				tagName, ok := strings.CutPrefix(key, "#")
+				if !ok || tagName == "" {
+					return nil, fmt.Errorf("unknown key '%s' in --or '%s'", key, group)
+				}
+				if filter.Tags == nil {
+					filter.Tags = make(nostr.TagMap)
+				}
+				filter.Tags[tagName] = append(filter.Tags[tagName], decodeTagValue(value))
+			}
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// withDedupedOutput makes the output of ctx skip events already printed through it, as the same event
+// may match more than one --or group.
+func withDedupedOutput(ctx context.Context) context.Context {
+	printNext := outputFor(ctx)
+	mu := sync.Mutex{}
+	seen := newStreamingDedup()
+	return context.WithValue(ctx, lineOutputKey{}, func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok && seen.check(evt.ID, "") {
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		printNext(args...)
+	})
+}
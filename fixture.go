@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var fixtureNames = []string{
+	"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy",
+	"mallory", "niaj", "olivia", "peggy", "rupert", "sybil", "trent", "victor", "walter", "zoe",
+}
+
+var fixture = &cli.Command{
+	Name:  "fixture",
+	Usage: "prints a reproducible dataset of users and their interactions for client test environments",
+	Description: `outputs, as jsonl sorted by created_at, profile metadata, follow lists, notes, replies and reactions from a group of users whose keys are derived from --seed (the key of the n-th user, counting from 0, is the one given by 'nak key generate --seed <seed> --index <n>').
+
+everything references everything else correctly (replies and reactions point to existing notes and their authors, follows point to the other users), and the output is always the same for the same flags.
+
+example:
+		nak fixture | nak event ws://localhost:10547
+		nak fixture --seed 7 --users 20 --notes 10 > fixtures.jsonl`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "seed",
+			Usage: "number from which all keys, timestamps and contents are derived",
+		},
+		&cli.UintFlag{
+			Name:  "users",
+			Usage: "number of users",
+			Value: 5,
+		},
+		&cli.UintFlag{
+			Name:  "notes",
+			Usage: "number of notes per user",
+			Value: 3,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		nusers := int(c.Uint("users"))
+		if nusers < 2 {
+			return fmt.Errorf("--users must be at least 2")
+		}
+
+		seed := c.Int("seed")
+		rng := rand.New(rand.NewSource(seed))
+		keys := make([]nostr.SecretKey, nusers)
+		pubkeys := make([]nostr.PubKey, nusers)
+		for i := range keys {
+			keys[i] = seededSecretKey(seed, i)
+			pubkeys[i] = keys[i].Public()
+		}
+
+		events := make([]nostr.Event, 0, nusers*int(c.Uint("notes"))*3)
+		ts := seededBaseTime - 60*60*24*30
+		add := func(author int, evt nostr.Event) nostr.Event {
+			ts += nostr.Timestamp(1 + rng.Intn(60*10))
+			evt.CreatedAt = ts
+			if evt.Tags == nil {
+				evt.Tags = nostr.Tags{}
+			}
+			evt.Sign(keys[author])
+			events = append(events, evt)
+			return evt
+		}
+
+		for i := range keys {
+			name := fixtureNames[i%len(fixtureNames)]
+			if i >= len(fixtureNames) {
+				name = fmt.Sprintf("%s%d", name, i/len(fixtureNames))
+			}
+			metadata, _ := json.Marshal(map[string]string{
+				"name":    name,
+				"about":   genSentence(rng),
+				"picture": "https://robohash.org/" + name,
+			})
+			add(i, nostr.Event{Kind: 0, Content: string(metadata)})
+		}
+
+		for i := range keys {
+			follows := nostr.Tags{}
+			for j := range keys {
+				if j != i && rng.Intn(2) == 0 {
+					follows = append(follows, nostr.Tag{"p", pubkeys[j].Hex()})
+				}
+			}
+			add(i, nostr.Event{Kind: 3, Tags: follows})
+		}
+
+		for range c.Uint("notes") {
+			for i := range keys {
+				note := add(i, nostr.Event{Kind: 1, Content: genSentence(rng)})
+
+				if rng.Intn(2) == 0 {
+					replier := (i + 1 + rng.Intn(nusers-1)) % nusers
+					add(replier, nostr.Event{
+						Kind:    1,
+						Content: genSentence(rng),
+						Tags: nostr.Tags{
+							{"e", note.ID.Hex(), "", "root", note.PubKey.Hex()},
+							{"p", note.PubKey.Hex()},
+						},
+					})
+				}
+
+				for j := range keys {
+					if j != i && rng.Intn(3) == 0 {
+						add(j, nostr.Event{
+							Kind:    7,
+							Content: "+",
+							Tags: nostr.Tags{
+								{"e", note.ID.Hex()},
+								{"p", note.PubKey.Hex()},
+								{"k", "1"},
+							},
+						})
+					}
+				}
+			}
+		}
+
+		for _, evt := range events {
+			stdout(evt)
+		}
+
+		return nil
+	},
+}
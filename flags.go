@@ -1,13 +1,11 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
-	"time"
 
 	"fiatjaf.com/nostr"
-	"github.com/markusmobius/go-dateparser"
+	"github.com/fiatjaf/nak/lib"
 	"github.com/urfave/cli/v3"
 )
 
@@ -92,27 +90,13 @@ func (t naturalTimeValue) ToString(b nostr.Timestamp) string {
 }
 
 func (t *naturalTimeValue) Set(value string) error {
-	var ts time.Time
-	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
-		// when the input is a raw number, treat it as an exact timestamp
-		ts = time.Unix(n, 0)
-	} else if errors.Is(err, strconv.ErrRange) {
-		// this means a huge number, so we should fail
+	ts, err := lib.ParseNaturalTime(value)
+	if err != nil {
 		return err
-	} else {
-		// otherwise try to parse it as a human date string in natural language
-		date, err := dateparser.Parse(&dateparser.Configuration{
-			DefaultTimezone: time.Local,
-			CurrentTime:     time.Now(),
-		}, value)
-		ts = date.Time
-		if err != nil {
-			return err
-		}
 	}
 
 	if t.timestamp != nil {
-		*t.timestamp = nostr.Timestamp(ts.Unix())
+		*t.timestamp = ts
 	}
 
 	t.hasBeenSet = true
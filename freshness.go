@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var newestPerAuthorFlag = &cli.BoolFlag{
+	Name:  "newest-per-author",
+	Usage: "only print the newest event received from each author",
+}
+
+var latestReplaceableFlag = &cli.BoolFlag{
+	Name:  "latest-replaceable",
+	Usage: "only print the newest version of each replaceable or addressable event (by pubkey, kind and 'd' tag), as relays often return stale ones",
+}
+
+var maxAgeFlag = &cli.StringFlag{
+	Name:  "max-age",
+	Usage: "hide events older than this, like 30m, 12h or 7d",
+	Validator: func(s string) error {
+		_, err := parseRelativeTime(s)
+		return err
+	},
+}
+
+// setupFreshnessFilters makes stdout skip old events according to --max-age and keep only the newest
+// events for each author or replaceable address with --newest-per-author and --latest-replaceable.
+// when querying these are only printed at the end, when we know which is the newest; when streaming a
+// newer version is printed whenever it arrives.
+func setupFreshnessFilters(c *cli.Command) error {
+	if c.IsSet("max-age") {
+		since, err := parseRelativeTime(c.String("max-age"))
+		if err != nil {
+			return err
+		}
+		maxAge := time.Duration(nostr.Now()-since) * time.Second
+		if maxAge <= 0 {
+			return fmt.Errorf("invalid --max-age '%s'", c.String("max-age"))
+		}
+
+		printNext := stdout
+		stdout = func(args ...any) {
+			if len(args) == 1 {
+				if evt, ok := args[0].(nostr.Event); ok && time.Since(evt.CreatedAt.Time()) > maxAge {
+					logverbose("hiding event %s from %s ago\n", evt.ID.Hex(), time.Since(evt.CreatedAt.Time()).Round(time.Second))
+					return
+				}
+			}
+			printNext(args...)
+		}
+	}
+
+	perAuthor := c.Bool("newest-per-author")
+	replaceable := c.Bool("latest-replaceable")
+	if !perAuthor && !replaceable {
+		return nil
+	}
+
+	// events that may be superseded by a newer one are grouped under this key, the others pass through
+	freshnessKey := func(evt nostr.Event) (string, bool) {
+		switch {
+		case perAuthor:
+			return evt.PubKey.Hex(), true
+		case evt.Kind.IsReplaceable():
+			return fmt.Sprintf("%d:%s", evt.Kind, evt.PubKey.Hex()), true
+		case evt.Kind.IsAddressable():
+			return fmt.Sprintf("%d:%s:%s", evt.Kind, evt.PubKey.Hex(), evt.Tags.GetD()), true
+		}
+		return "", false
+	}
+
+	// ties are decided by the lowest id, like relays do
+	isNewer := func(evt nostr.Event, than nostr.Event) bool {
+		return evt.CreatedAt > than.CreatedAt || (evt.CreatedAt == than.CreatedAt && evt.ID.Hex() < than.ID.Hex())
+	}
+
+	mu := sync.Mutex{}
+	newest := make(map[string]nostr.Event)
+	var order []string
+	streaming := c.Bool("stream")
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) != 1 {
+			printNext(args...)
+			return
+		}
+		evt, ok := args[0].(nostr.Event)
+		if !ok {
+			printNext(args...)
+			return
+		}
+		key, ok := freshnessKey(evt)
+		if !ok {
+			printNext(args...)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		previous, seen := newest[key]
+		if seen && !isNewer(evt, previous) {
+			logverbose("hiding event %s, %s is newer\n", evt.ID.Hex(), previous.ID.Hex())
+			return
+		}
+		newest[key] = evt
+		if streaming {
+			printNext(evt)
+		} else if !seen {
+			order = append(order, key)
+		}
+	}
+
+	finishNext := finishOutput
+	finishOutput = func() {
+		mu.Lock()
+		for _, key := range order {
+			printNext(newest[key])
+		}
+		order = nil
+		mu.Unlock()
+		finishNext()
+	}
+
+	return nil
+}
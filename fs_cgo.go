@@ -105,7 +105,10 @@ var fsCmd = &cli.Command{
 		chErr := make(chan error)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
-			<-ch
+			select {
+			case <-ch:
+			case <-ctx.Done():
+			}
 			log("- unmounting... ")
 			// cgofuse doesn't have explicit unmount, it unmounts on process exit
 			log("ok\n")
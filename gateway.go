@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/fatih/color"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var gateway = &cli.Command{
+	Name:  "gateway",
+	Usage: "exposes nostr over plain http for clients that can't speak websockets",
+	Description: `starts an http server backed by nak's relay pool and hints database, with these endpoints:
+
+  GET  /e/<id>       an event, given as hex, note, nevent or naddr
+  GET  /p/<pubkey>   the profile metadata (kind 0) event of a pubkey, given as hex, npub, nprofile or nip05
+  GET  /req          events matching a filter given as query parameters: ids, authors, kinds, since, until, limit,
+                     search and tags as %23t=nostr, relays to use can be given with relay=; with stream=true or
+                     an "Accept: text/event-stream" header the events are streamed as server-sent events
+  POST /event        publishes the event in the body to the given relay= parameters, the author's outbox relays
+                     and the --relay relays
+
+all responses are json and CORS is allowed from anywhere.
+
+example:
+		nak gateway --relay wss://relay.damus.io
+		curl 'http://localhost:10549/req?kinds=1&authors=npub1...&limit=10'
+		curl -N 'http://localhost:10549/req?kinds=1&stream=true'
+		nak event -c hello | curl -d @- http://localhost:10549/event`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "hostname where to listen for connections",
+			Value: "localhost",
+		},
+		&cli.UintFlag{
+			Name:  "port",
+			Usage: "port where to listen for connections",
+			Value: 10549,
+		},
+		&cli.StringSliceFlag{
+			Name:        "relay",
+			Aliases:     []string{"r"},
+			Usage:       "relays to query when no relay= parameter is given and no outbox relays are known, published events are always sent to these too",
+			DefaultText: "nak's fallback relays",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("relay")); err != nil {
+			return err
+		}
+		defaultRelays := c.StringSlice("relay")
+		if len(defaultRelays) == 0 {
+			defaultRelays = sys.FallbackRelays.URLs
+		}
+
+		// relay= query parameters take precedence, then the outbox relays of the given authors, then the defaults
+		relaysFor := func(r *http.Request, authors []nostr.PubKey) ([]string, error) {
+			if relays := r.URL.Query()["relay"]; len(relays) > 0 {
+				if err := normalizeAndValidateRelayURLs(relays); err != nil {
+					return nil, err
+				}
+				return relays, nil
+			}
+			relays := make([]string, 0, len(authors)*3)
+			if len(authors) <= 20 {
+				for _, pk := range authors {
+					relays = appendUnique(relays, sys.FetchOutboxRelays(r.Context(), pk, 3)...)
+				}
+			}
+			if len(relays) == 0 {
+				relays = slices.Clone(defaultRelays)
+			}
+			return relays, nil
+		}
+
+		mux := http.NewServeMux()
+
+		mux.HandleFunc("GET /e/{code}", func(w http.ResponseWriter, r *http.Request) {
+			ptr, err := parsePointer(r.PathValue("code"))
+			if err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+			if _, ok := ptr.(nostr.ProfilePointer); ok {
+				gatewayError(w, http.StatusBadRequest, fmt.Errorf("that's a profile, use /p/"))
+				return
+			}
+
+			evt, _, err := sys.FetchSpecificEvent(r.Context(), ptr, sdk.FetchSpecificEventParameters{})
+			if err != nil || evt == nil {
+				gatewayError(w, http.StatusNotFound, fmt.Errorf("event not found"))
+				return
+			}
+			gatewayJSON(w, http.StatusOK, evt)
+		})
+
+		mux.HandleFunc("GET /p/{pubkey}", func(w http.ResponseWriter, r *http.Request) {
+			pk, err := parsePubKey(r.PathValue("pubkey"))
+			if err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			pm := sys.FetchProfileMetadata(r.Context(), pk)
+			if pm.Event == nil {
+				gatewayError(w, http.StatusNotFound, fmt.Errorf("profile not found"))
+				return
+			}
+			gatewayJSON(w, http.StatusOK, pm.Event)
+		})
+
+		mux.HandleFunc("GET /req", func(w http.ResponseWriter, r *http.Request) {
+			filter, err := filterFromQuery(r)
+			if err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+			relays, err := relaysFor(r, filter.Authors)
+			if err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+			opts := nostr.SubscriptionOptions{Label: "nak-gateway"}
+
+			stream := r.URL.Query().Get("stream") == "true" ||
+				strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+			if !stream {
+				events := make([]nostr.Event, 0, max(filter.Limit, 10))
+				for ie := range sys.Pool.FetchMany(r.Context(), relays, filter, opts) {
+					events = append(events, ie.Event)
+				}
+				slices.SortFunc(events, nostr.CompareEventReverse)
+				gatewayJSON(w, http.StatusOK, events)
+				return
+			}
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				gatewayError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+
+			// the subscription ends when the client goes away
+			for ie := range sys.Pool.SubscribeMany(r.Context(), relays, filter, opts) {
+				fmt.Fprintf(w, "id: %s\ndata: %s\n\n", ie.Event.ID.Hex(), ie.Event.String())
+				flusher.Flush()
+			}
+		})
+
+		mux.HandleFunc("POST /event", func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, 512*1024))
+			if err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+			evt := nostr.Event{}
+			if err := easyjson.Unmarshal(body, &evt); err != nil {
+				gatewayError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+				return
+			}
+			if !evt.CheckID() {
+				gatewayError(w, http.StatusBadRequest, fmt.Errorf("invalid event id"))
+				return
+			}
+			if !evt.VerifySignature() {
+				gatewayError(w, http.StatusBadRequest, fmt.Errorf("invalid signature"))
+				return
+			}
+
+			relays := r.URL.Query()["relay"]
+			if err := normalizeAndValidateRelayURLs(relays); err != nil {
+				gatewayError(w, http.StatusBadRequest, err)
+				return
+			}
+			relays = appendUnique(relays, sys.FetchOutboxRelays(r.Context(), evt.PubKey, 3)...)
+			relays = appendUnique(relays, c.StringSlice("relay")...)
+			if len(relays) == 0 {
+				relays = slices.Clone(defaultRelays)
+			}
+
+			type relayResult struct {
+				Relay string `json:"relay"`
+				OK    bool   `json:"ok"`
+				Error string `json:"error,omitempty"`
+			}
+			results := make([]relayResult, 0, len(relays))
+			success := false
+			for res := range sys.Pool.PublishMany(r.Context(), relays, evt) {
+				rr := relayResult{Relay: res.RelayURL, OK: res.Error == nil}
+				if res.Error != nil {
+					rr.Error = res.Error.Error()
+				} else {
+					success = true
+				}
+				results = append(results, rr)
+			}
+			logverbose("published %s to %d relays\n", evt.ID.Hex(), len(results))
+
+			status := http.StatusOK
+			if !success {
+				status = http.StatusBadGateway
+			}
+			gatewayJSON(w, status, map[string]any{"id": evt.ID.Hex(), "results": results})
+		})
+
+		server := &http.Server{
+			Addr: net.JoinHostPort(c.String("hostname"), strconv.FormatUint(c.Uint("port"), 10)),
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				logverbose("%s %s\n", r.Method, r.URL)
+				mux.ServeHTTP(w, r)
+			}),
+		}
+
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		log("%s gateway running at %s\n", color.HiRedString(">"), colors.boldf("http://%s", server.Addr))
+		notifyReady()
+
+		go func() {
+			<-ctx.Done()
+			server.Shutdown(context.Background())
+		}()
+
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	},
+}
+
+// filterFromQuery builds a filter from query parameters, list values can be repeated or comma-separated.
+func filterFromQuery(r *http.Request) (nostr.Filter, error) {
+	filter := nostr.Filter{}
+	query := r.URL.Query()
+
+	values := func(key string) []string {
+		list := make([]string, 0, len(query[key]))
+		for _, v := range query[key] {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		}
+		return list
+	}
+
+	for _, v := range values("ids") {
+		id, err := parseEventID(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.IDs = append(filter.IDs, id)
+	}
+	for _, v := range values("authors") {
+		pk, err := parsePubKey(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Authors = append(filter.Authors, pk)
+	}
+	for _, v := range values("kinds") {
+		kind, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return filter, fmt.Errorf("invalid kind '%s'", v)
+		}
+		filter.Kinds = append(filter.Kinds, nostr.Kind(kind))
+	}
+	for _, key := range []string{"since", "until", "limit"} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("invalid %s '%s'", key, v)
+		}
+		switch key {
+		case "since":
+			filter.Since = nostr.Timestamp(n)
+		case "until":
+			filter.Until = nostr.Timestamp(n)
+		case "limit":
+			filter.Limit = int(n)
+			filter.LimitZero = n == 0
+		}
+	}
+	filter.Search = query.Get("search")
+
+	for key := range query {
+		if len(key) == 2 && key[0] == '#' {
+			if filter.Tags == nil {
+				filter.Tags = nostr.TagMap{}
+			}
+			for _, v := range values(key) {
+				filter.Tags[key[1:]] = append(filter.Tags[key[1:]], decodeTagValue(v))
+			}
+		}
+	}
+
+	if len(filter.IDs) == 0 && len(filter.Authors) == 0 && len(filter.Kinds) == 0 && len(filter.Tags) == 0 && filter.Search == "" {
+		return filter, fmt.Errorf("filter is empty")
+	}
+	if filter.Limit == 0 && !filter.LimitZero {
+		filter.Limit = 100
+	}
+
+	return filter, nil
+}
+
+func gatewayJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func gatewayError(w http.ResponseWriter, status int, err error) {
+	gatewayJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var gen = &cli.Command{
+	Name:  "gen",
+	Usage: "generates synthetic events for fuzz-testing relays and clients",
+	Description: `prints --count events as jsonl. each event is randomly chosen to be valid, an edge case (still correctly signed, but weird) or invalid (bad signature, wrong id or malformed json), according to the given proportions.
+
+example:
+		nak gen --count 1000 | nak event nos.lol
+		nak gen --count 100 --valid 0 --edge 1 --invalid 0 --kind 1 --kind 30023
+		nak gen --invalid 1 --count 50 | websocat ws://localhost:10547`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.UintFlag{
+			Name:    "count",
+			Aliases: []string{"n"},
+			Usage:   "number of events to generate, 0 means infinite",
+			Value:   10,
+		},
+		&cli.FloatFlag{
+			Name:  "valid",
+			Usage: "proportion of normal valid events",
+			Value: 1,
+		},
+		&cli.FloatFlag{
+			Name:  "edge",
+			Usage: "proportion of valid events with edge-case values (huge tags, empty content, far-future timestamps etc)",
+			Value: 0,
+		},
+		&cli.FloatFlag{
+			Name:  "invalid",
+			Usage: "proportion of invalid events (bad signatures, wrong ids, malformed json)",
+			Value: 0,
+		},
+		&cli.IntSliceFlag{
+			Name:    "kind",
+			Aliases: []string{"k"},
+			Usage:   "kinds to pick from for valid events, can be given multiple times",
+		},
+		&cli.UintFlag{
+			Name:  "authors",
+			Usage: "number of different random keys to sign events with",
+			Value: 5,
+		},
+		&cli.DurationFlag{
+			Name:  "rate",
+			Usage: "wait this long between each event",
+		},
+		&cli.IntFlag{
+			Name:  "seed",
+			Usage: "generate the same keys, timestamps and events every time for the same seed",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		pValid, pEdge, pInvalid := c.Float("valid"), c.Float("edge"), c.Float("invalid")
+		if pValid < 0 || pEdge < 0 || pInvalid < 0 || pValid+pEdge+pInvalid == 0 {
+			return fmt.Errorf("--valid, --edge and --invalid must be non-negative and at least one must be positive")
+		}
+
+		kinds := c.IntSlice("kind")
+		if len(kinds) == 0 {
+			kinds = []int64{1}
+		}
+
+		if c.Uint("authors") == 0 {
+			return fmt.Errorf("--authors must be at least 1")
+		}
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		now := nostr.Now()
+		keys := make([]nostr.SecretKey, c.Uint("authors"))
+		for i := range keys {
+			if c.IsSet("seed") {
+				keys[i] = seededSecretKey(c.Int("seed"), i)
+			} else {
+				keys[i] = nostr.Generate()
+			}
+		}
+		if c.IsSet("seed") {
+			rng = rand.New(rand.NewSource(c.Int("seed")))
+			now = seededBaseTime
+		}
+
+		rate := c.Duration("rate")
+		count := c.Uint("count")
+		for i := uint64(0); count == 0 || i < count; i++ {
+			if i > 0 && rate > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(rate):
+				}
+			}
+
+			sk := keys[rng.Intn(len(keys))]
+			evt := nostr.Event{
+				Kind:      nostr.Kind(kinds[rng.Intn(len(kinds))]),
+				CreatedAt: now - nostr.Timestamp(rng.Intn(60*60*24)),
+				Content:   genSentence(rng),
+				Tags:      nostr.Tags{},
+			}
+			if evt.Kind.IsAddressable() {
+				evt.Tags = append(evt.Tags, nostr.Tag{"d", genString(rng, 8)})
+			}
+
+			switch r := rng.Float64() * (pValid + pEdge + pInvalid); {
+			case r < pValid:
+				evt.Sign(sk)
+				stdout(evt)
+			case r < pValid+pEdge:
+				genEdgeCase(rng, &evt, now)
+				evt.Sign(sk)
+				stdout(evt)
+			default:
+				evt.Sign(sk)
+				stdout(genInvalid(rng, evt))
+			}
+		}
+
+		return nil
+	},
+}
+
+var genWords = strings.Fields("nostr relay event note zap sats bitcoin key sign pubkey client hello world gm pv " +
+	"freedom protocol censorship resistant simple open decentralized follow reply repost tag")
+
+func genSentence(rng *rand.Rand) string {
+	words := make([]string, 3+rng.Intn(20))
+	for i := range words {
+		words[i] = genWords[rng.Intn(len(genWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+func genString(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letterBytes[rng.Intn(len(letterBytes))]
+	}
+	return string(b)
+}
+
+// genEdgeCase mutates the event in one of many weird-but-valid ways.
+func genEdgeCase(rng *rand.Rand, evt *nostr.Event, now nostr.Timestamp) {
+	switch rng.Intn(12) {
+	case 0:
+		evt.Content = ""
+	case 1:
+		evt.Content = strings.Repeat(genSentence(rng)+" ", 2000)
+	case 2:
+		for range 2000 {
+			evt.Tags = append(evt.Tags, nostr.Tag{"t", genWords[rng.Intn(len(genWords))]})
+		}
+	case 3:
+		tag := nostr.Tag{"x"}
+		for range 1000 {
+			tag = append(tag, genString(rng, 10))
+		}
+		evt.Tags = append(evt.Tags, tag)
+	case 4:
+		evt.Tags = append(evt.Tags, nostr.Tag{"e", ""}, nostr.Tag{"p", ""}, nostr.Tag{""})
+	case 5:
+		evt.CreatedAt = now + 60*60*24*365*10
+	case 6:
+		evt.CreatedAt = 0
+	case 7:
+		evt.Content = "​‏‮" + evt.Content + " 🏳️‍🌈👩‍👩‍👧‍👦 \x00\x01\x1b[31m \\\"'<script>alert(1)</script>"
+	case 8:
+		evt.Kind = nostr.Kind(rng.Intn(65536))
+		if evt.Kind.IsAddressable() && evt.Tags.Find("d") == nil {
+			evt.Tags = append(evt.Tags, nostr.Tag{"d", ""})
+		}
+	case 9:
+		evt.Tags = append(evt.Tags, nostr.Tag{"e", genString(rng, 64), "wss://" + strings.Repeat("a", 1000) + ".com"})
+	case 10:
+		evt.Content = strings.Repeat("\n", 500)
+	case 11:
+		evt.Tags = append(evt.Tags, nostr.Tag{"expiration", strconv.FormatInt(int64(now)-60, 10)})
+	}
+}
+
+// genInvalid takes a signed event and returns it as json, broken in one of many ways.
+func genInvalid(rng *rand.Rand, evt nostr.Event) string {
+	switch rng.Intn(7) {
+	case 0:
+		// flip a byte in the signature
+		evt.Sig[rng.Intn(64)] ^= 0xff
+	case 1:
+		// content changed after signing, so the id doesn't match
+		evt.Content += "!"
+	case 2:
+		// id recomputed, but now the signature doesn't match
+		evt.Content += "!"
+		evt.ID = evt.GetID()
+	case 3:
+		// signed by someone else
+		evt.PubKey = seededSecretKey(rng.Int63(), 0).Public()
+	case 4:
+		j, _ := json.Marshal(evt)
+		return strings.Replace(string(j), `"sig":"`+hex.EncodeToString(evt.Sig[:])+`"`, `"sig":"nope"`, 1)
+	case 5:
+		j, _ := json.Marshal(evt)
+		return strings.Replace(string(j), `"tags":[`, `"tags":{`, 1)
+	case 6:
+		j, _ := json.Marshal(evt)
+		return string(j[0 : len(j)/2])
+	}
+
+	j, _ := json.Marshal(evt)
+	return string(j)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func encodeGeohash(lat, lon float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	b := strings.Builder{}
+	b.Grow(precision)
+	even := true
+	bit, ch := 0, 0
+	for b.Len() < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+		} else {
+			b.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return b.String()
+}
+
+// geohashCellSize returns the height and width, in degrees, of the cells at the given precision.
+func geohashCellSize(precision int) (latDeg, lonDeg float64) {
+	bits := 5 * precision
+	latBits := bits / 2
+	lonBits := bits - latBits
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// parseLocation parses "lat,lon".
+func parseLocation(value string) (lat, lon float64, err error) {
+	spl := strings.Split(value, ",")
+	if len(spl) != 2 {
+		return 0, 0, fmt.Errorf("expected 'lat,lon'")
+	}
+	if lat, err = strconv.ParseFloat(strings.TrimSpace(spl[0]), 64); err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude '%s'", spl[0])
+	}
+	if lon, err = strconv.ParseFloat(strings.TrimSpace(spl[1]), 64); err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude '%s'", spl[1])
+	}
+	return lat, lon, nil
+}
+
+// parseDistance takes things like "500", "500m" or "3km" and returns meters.
+func parseDistance(value string) (float64, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	multiplier := 1.0
+	if v, ok := strings.CutSuffix(value, "km"); ok {
+		value = v
+		multiplier = 1000
+	} else if v, ok := strings.CutSuffix(value, "m"); ok {
+		value = v
+	}
+	d, err := strconv.ParseFloat(value, 64)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid distance '%s'", value)
+	}
+	return d * multiplier, nil
+}
+
+// geohashesNear returns the geohashes that cover a circle of the given radius around a point, at
+// the most precise level that doesn't need too many of them, so they can be used in a #g filter.
+func geohashesNear(lat, lon, radiusMeters float64) []string {
+	const metersPerDegree = 111_320.0
+	dLat := radiusMeters / metersPerDegree
+	dLon := 360.0
+	if cos := math.Cos(lat * math.Pi / 180); cos > 0.000001 {
+		dLon = math.Min(360, radiusMeters/(metersPerDegree*cos))
+	}
+	minLat, maxLat := math.Max(-90, lat-dLat), math.Min(90, lat+dLat)
+	minLon, maxLon := lon-dLon, lon+dLon
+
+	const maxCells = 32
+	for precision := 9; precision >= 1; precision-- {
+		h, w := geohashCellSize(precision)
+		if (math.Ceil((maxLat-minLat)/h)+1)*(math.Ceil((maxLon-minLon)/w)+1) > maxCells && precision > 1 {
+			continue
+		}
+
+		seen := make(map[string]struct{})
+		result := make([]string, 0, maxCells)
+		for y := minLat; ; y += h {
+			y = math.Min(y, maxLat)
+			for x := minLon; ; x += w {
+				x = math.Min(x, maxLon)
+				// wrap around the antimeridian
+				wrapped := math.Mod(x+540, 360) - 180
+				gh := encodeGeohash(y, wrapped, precision)
+				if _, ok := seen[gh]; !ok {
+					seen[gh] = struct{}{}
+					result = append(result, gh)
+				}
+				if x >= maxLon {
+					break
+				}
+			}
+			if y >= maxLat {
+				break
+			}
+		}
+		return result
+	}
+
+	return nil
+}
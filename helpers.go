@@ -3,14 +3,13 @@ package main
 import (
 	"bufio"
 	"context"
+	stdjson "encoding/json"
 	"errors"
 	"fmt"
 	"iter"
 	"math/rand"
-	"net/http"
-	"net/textproto"
-	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
@@ -24,6 +23,7 @@ import (
 	"fiatjaf.com/nostr/sdk"
 	"github.com/chzyer/readline"
 	"github.com/fatih/color"
+	"github.com/fiatjaf/nak/lib"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/mattn/go-isatty"
 	"github.com/mattn/go-tty"
@@ -143,25 +143,98 @@ func writeStdinLinesOrNothing(yield func(string) bool) (hasStdinLines bool) {
 }
 
 func normalizeAndValidateRelayURLs(wsurls []string) error {
-	for i, wsurl := range wsurls {
-		wsurl = nostr.NormalizeURL(wsurl)
-		wsurls[i] = wsurl
+	return lib.NormalizeAndValidateRelayURLs(wsurls)
+}
 
-		u, err := url.Parse(wsurl)
-		if err != nil {
-			return fmt.Errorf("invalid relay url '%s': %s", wsurl, err)
-		}
+// normalizeRelayURL gives the canonical form of a relay url, warning about any typo that had to be fixed.
+// invalid urls are returned as nostr.NormalizeURL would, so they can be rejected later with the usual
+// message.
+func normalizeRelayURL(url string) string {
+	normalized, fixes, err := lib.NormalizeRelayURL(url)
+	if err != nil {
+		return nostr.NormalizeURL(url)
+	}
+	if len(fixes) > 0 {
+		log("%s", color.YellowString("relay url '%s' was read as '%s' (%s)\n", strings.TrimSpace(url), normalized, strings.Join(fixes, ", ")))
+	}
+	return normalized
+}
 
-		if u.Scheme != "ws" && u.Scheme != "wss" {
-			return fmt.Errorf("relay url must use wss:// or ws:// schemes, got '%s'", wsurl)
-		}
+// relayFlag is shared by every command that talks to relays picked by the user, so these can always be given with
+// repeated --relay flags: commands that take relays as arguments use both (see getRelayURLs), commands that work
+// with a single relay take it from either place (see getSingleRelayURL) and the others add them to the relays
+// they'd use anyway. commands that only use the relays from the user's own lists (wallet, dekey, gift...) don't
+// take it, and gateway and musig keep their own --relay with the meaning documented there.
+var relayFlag = &cli.StringSliceFlag{
+	Name:    "relay",
+	Aliases: []string{"r"},
+	Usage:   "relay to use, in addition to any given as arguments, can be given multiple times",
+}
+
+// getRelayURLs merges the relays given as positional arguments with the ones given with --relay, in that order
+// and without duplicates.
+func getRelayURLs(c *cli.Command, positional []string) []string {
+	relays := make([]string, 0, len(positional))
+	for _, url := range positional {
+		relays = appendUnique(relays, normalizeRelayURL(url))
+	}
+	for _, url := range c.StringSlice("relay") {
+		relays = appendUnique(relays, normalizeRelayURL(url))
+	}
+	return relays
+}
+
+// getRelayURLsOrDefaults is like getRelayURLs, but falls back to the relays in <config-path>/default-relays when
+// none were given. only for commands that can't do anything without relays, the others must keep working
+// offline when no relays are given.
+func getRelayURLsOrDefaults(c *cli.Command, positional []string) []string {
+	if relays := getRelayURLs(c, positional); len(relays) > 0 {
+		return relays
+	}
+	return loadConfigRelays(c.String("config-path"), "default-relays")
+}
 
-		if u.Host == "" {
-			return fmt.Errorf("relay url '%s' is missing the hostname", wsurl)
+// getSingleRelayURL is for commands that work with exactly one relay: it's taken from --relay or, if that isn't
+// given, from the first positional argument. the remaining positional arguments are returned with it.
+func getSingleRelayURL(c *cli.Command) (string, []string, error) {
+	args := c.Args().Slice()
+	switch flagged := c.StringSlice("relay"); len(flagged) {
+	case 0:
+		if len(args) == 0 || args[0] == "" {
+			return "", nil, fmt.Errorf("missing relay url")
 		}
+		return normalizeRelayURL(args[0]), args[1:], nil
+	case 1:
+		return normalizeRelayURL(flagged[0]), args, nil
+	default:
+		return "", nil, fmt.Errorf("this command takes a single relay, got %d with --relay", len(flagged))
+	}
+}
+
+// loadConfigRelays reads a list of relays from a file inside <config-path>, one url per line.
+func loadConfigRelays(configPath string, name string) []string {
+	if configPath == "" {
+		return nil
+	}
+
+	file, err := os.Open(filepath.Join(configPath, name))
+	if err != nil {
+		return nil
 	}
+	defer file.Close()
 
-	return nil
+	var relays []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if url, _, err := lib.NormalizeRelayURL(line); err == nil {
+			relays = appendUnique(relays, url)
+		}
+	}
+	return relays
 }
 
 func connectToAllRelays(
@@ -173,18 +246,24 @@ func connectToAllRelays(
 ) []*nostr.Relay {
 	// first pass to check if these are valid relay URLs
 	for _, url := range relayUrls {
-		if !nostr.IsValidRelayURL(nostr.NormalizeURL(url)) {
-			log("invalid relay URL: %s\n", url)
+		if _, _, err := lib.NormalizeRelayURL(url); err != nil {
+			log("%s\n", err)
 			os.Exit(4)
 		}
 	}
 
-	opts.EventMiddleware = sys.TrackEventHints
+	opts.EventMiddleware = trackPoolEvent
 	opts.PenaltyBox = true
 	opts.RelayOptions = nostr.RelayOptions{
-		RequestHeader: http.Header{textproto.CanonicalMIMEHeaderKey("user-agent"): {"nak/s"}},
+		RequestHeader: relayRequestHeader("nak/s"),
+		NoticeHandler: handleNotice,
+	}
+	pool := nostr.NewPool(opts)
+	if preAuthSigner == nil {
+		// pre-authenticating needs fresh connections, the AUTH challenge is only sent right after connecting
+		reuseConnections(sys.Pool, pool, c.Bool("skip-verify"))
 	}
-	sys.Pool = nostr.NewPool(opts)
+	sys.Pool = pool
 
 	relays := make([]*nostr.Relay, 0, len(relayUrls))
 
@@ -242,6 +321,43 @@ func connectToAllRelays(
 	return relays
 }
 
+// reuseConnections moves the live relay connections from one pool to another, so relays we already
+// talked to (for fetching relay lists, for example) aren't connected to again. subscriptions made
+// through the new pool are multiplexed over these same websockets. connections that don't verify
+// signatures the way the new ones would (as set by --skip-verify) are left behind.
+func reuseConnections(from *nostr.Pool, to *nostr.Pool, skipVerify bool) {
+	if from == nil {
+		return
+	}
+	for url, relay := range from.Relays.Range {
+		if relay != nil && relay.IsConnected() && relay.AssumeValid == skipVerify {
+			logverbose("reusing connection to %s\n", url)
+			to.Relays.Store(url, relay)
+		}
+	}
+}
+
+// prepareRelays connects beforehand to relays that would otherwise be connected to by the pool on its own
+// (outbox relays and the like) so they also honor --skip-verify. relays that are already connected are left
+// alone, as their settings can't be changed while they may have live subscriptions.
+func prepareRelays(ctx context.Context, urls []string, skipVerify bool) {
+	if !skipVerify {
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for _, url := range urls {
+		if relay, ok := sys.Pool.Relays.Load(nostr.NormalizeURL(url)); ok && relay != nil && relay.IsConnected() {
+			continue
+		}
+		wg.Go(func() {
+			// failures are left for the pool to report when it tries again
+			lib.ConnectToRelay(ctx, sys.Pool, url, lib.ConnectOptions{SkipVerify: true})
+		})
+	}
+	wg.Wait()
+}
+
 func connectToSingleRelay(
 	ctx context.Context,
 	c *cli.Command,
@@ -250,63 +366,47 @@ func connectToSingleRelay(
 	colorizepreamble func(c func(string, ...any) string),
 	logthis func(s string, args ...any),
 ) *nostr.Relay {
-	if relay, err := sys.Pool.EnsureRelay(url); err == nil {
-		if preAuthSigner != nil {
-			if colorizepreamble != nil {
-				colorizepreamble(color.YellowString)
-			}
-			logthis("waiting for auth challenge... ")
-			time.Sleep(time.Millisecond * 200)
-
-			for range 5 {
-				if err := relay.Auth(ctx, func(ctx context.Context, authEvent *nostr.Event) error {
-					challengeTag := authEvent.Tags.Find("challenge")
-					if challengeTag[1] == "" {
-						return fmt.Errorf("auth not received yet *****") // what a giant hack
-					}
-					return preAuthSigner(ctx, c, logthis, authEvent)
-				}); err == nil {
-					// auth succeeded
-					goto preauthSuccess
-				} else {
-					// auth failed
-					if strings.HasSuffix(err.Error(), "auth not received yet *****") {
-						// it failed because we didn't receive the challenge yet, so keep waiting
-						time.Sleep(time.Second)
-						continue
-					} else {
-						// it failed for some other reason, so skip this relay
-						if colorizepreamble != nil {
-							colorizepreamble(colors.errorf)
-						}
-						logthis(err.Error())
-						return nil
-					}
-				}
-			}
-			if colorizepreamble != nil {
-				colorizepreamble(colors.errorf)
-			}
-			logthis("failed to get an AUTH challenge in enough time.")
-			return nil
-		}
-
-	preauthSuccess:
+	var preAuth func(ctx context.Context, authEvent *nostr.Event) error
+	if preAuthSigner != nil {
 		if colorizepreamble != nil {
-			colorizepreamble(colors.successf)
+			colorizepreamble(color.YellowString)
 		}
-		logthis("ok.")
-		return relay
-	} else {
+		preAuth = func(ctx context.Context, authEvent *nostr.Event) error {
+			return preAuthSigner(ctx, c, logthis, authEvent)
+		}
+	}
+
+	var relay *nostr.Relay
+	err := withRelayRetries(ctx, c, url, func() (err error) {
+		relay, err = lib.ConnectToRelay(ctx, sys.Pool, url, lib.ConnectOptions{
+			SkipVerify: c.Bool("skip-verify"),
+			PreAuth:    preAuth,
+			Progress:   func(msg string) { logthis(msg) },
+		})
+		return err
+	})
+	if err != nil {
 		if colorizepreamble != nil {
 			colorizepreamble(colors.errorf)
 		}
 
+		if authErr := (*lib.AuthError)(nil); errors.As(err, &authErr) {
+			logthis(err.Error())
+			return nil
+		}
+
 		// if we're here that means we've failed to connect, this may be a huge message
 		// but we're likely to only be interested in the lowest level error (although we can leave space)
-		logthis(clampError(err, len(url)+12))
+		class := classifyRelayError(err)
+		logthis("%s %s", color.YellowString("(%s)", class), clampError(err, len(url)+15+len(class)))
 		return nil
 	}
+
+	if colorizepreamble != nil {
+		colorizepreamble(colors.successf)
+	}
+	logthis("ok.")
+	return relay
 }
 
 func clearLines(lineCount int) {
@@ -366,8 +466,27 @@ func lineProcessingError(ctx context.Context, msg string, args ...any) context.C
 	return context.WithValue(ctx, LINE_PROCESSING_ERROR, true)
 }
 
+var jsonlFlag = &cli.BoolFlag{
+	Name:  "jsonl",
+	Usage: "output one json object per input line, with the input echoed back along with the result or the error, so failed lines don't get lost in pipelines",
+}
+
+// printJSONLine prints a line of --jsonl output, marking the line as failed if there is an error.
+func printJSONLine(ctx context.Context, input string, fields map[string]any, err error) context.Context {
+	fields["input"] = input
+	if err != nil {
+		fields["error"] = err.Error()
+		ctx = context.WithValue(ctx, LINE_PROCESSING_ERROR, true)
+	}
+	// encoding/json sorts the keys, so lines are always the same for the same input
+	j, _ := stdjson.Marshal(fields)
+	stdout(string(j))
+	return ctx
+}
+
 func exitIfLineProcessingError(ctx context.Context) {
 	if val := ctx.Value(LINE_PROCESSING_ERROR); val != nil && val.(bool) {
+		finishOutput()
 		os.Exit(123)
 	}
 }
@@ -526,6 +645,85 @@ func parseEventID(value string) (nostr.ID, error) {
 	return nostr.ID{}, fmt.Errorf("invalid event id (\"%s\"): expected hex, note, or nevent", value)
 }
 
+// parsePointer takes anything that can be used to refer to an event or a profile (hex ids, nip19 codes,
+// "<kind>:<pubkey>:<d>" addresses) and returns the corresponding pointer.
+// plain hex is always treated as an event id.
+func parsePointer(value string) (nostr.Pointer, error) {
+	value = strings.TrimPrefix(value, "nostr:")
+
+	if id, err := nostr.IDFromHex(value); err == nil {
+		return nostr.EventPointer{ID: id}, nil
+	}
+
+	if ptr, err := nip19.ToPointer(value); err == nil {
+		harvestPointerHints(ptr)
+		return ptr, nil
+	}
+
+	if ptr, err := nostr.ParseAddrString(value); err == nil {
+		return ptr, nil
+	}
+
+	return nil, fmt.Errorf("invalid reference (\"%s\"): expected hex id, npub, nprofile, note, nevent, naddr or <kind>:<pubkey>:<d>", value)
+}
+
+// parseTagFlag parses a --tag value like name=value or name=value;extra;extra. only the first '=' separates
+// the name, so values may contain '=', and a ';' that is part of a value must be escaped as '\;' (a backslash
+// as '\\'). the first value may be an npub, note, nevent, nprofile or naddr code, which is decoded.
+func parseTagFlag(value string) (nostr.Tag, error) {
+	name, rest, found := strings.Cut(value, "=")
+	if name == "" {
+		return nil, fmt.Errorf("invalid tag '%s', missing the tag name", value)
+	}
+	tag := nostr.Tag{name}
+	if !found {
+		return tag, nil
+	}
+
+	current := strings.Builder{}
+	escaping := false
+	for _, r := range rest {
+		switch {
+		case escaping:
+			if r != ';' && r != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaping = false
+		case r == '\\':
+			escaping = true
+		case r == ';':
+			tag = append(tag, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if escaping {
+		current.WriteRune('\\')
+	}
+	tag = append(tag, current.String())
+
+	tag[1] = decodeTagValue(tag[1])
+	return tag, nil
+}
+
+// parseAddress takes an naddr or <kind>:<pubkey>:<d> and checks that the kind is of a replaceable or addressable event.
+func parseAddress(value string) (nostr.EntityPointer, error) {
+	ptr, err := parsePointer(value)
+	if err != nil {
+		return nostr.EntityPointer{}, err
+	}
+	ep, ok := ptr.(nostr.EntityPointer)
+	if !ok {
+		return nostr.EntityPointer{}, fmt.Errorf("'%s' is not an address, expected naddr or <kind>:<pubkey>:<d>", value)
+	}
+	if !ep.Kind.IsAddressable() && !ep.Kind.IsReplaceable() {
+		return nostr.EntityPointer{}, fmt.Errorf("kind %d in '%s' is not replaceable or addressable", ep.Kind, value)
+	}
+	return ep, nil
+}
+
 func decodeTagValue(value string) string {
 	if strings.HasPrefix(value, "npub1") || strings.HasPrefix(value, "nevent1") || strings.HasPrefix(value, "note1") || strings.HasPrefix(value, "nprofile1") || strings.HasPrefix(value, "naddr1") {
 		if ptr, err := nip19.ToPointer(value); err == nil {
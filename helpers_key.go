@@ -2,27 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"strings"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/keyer"
-	"fiatjaf.com/nostr/nip19"
 	"fiatjaf.com/nostr/nip46"
-	"fiatjaf.com/nostr/nip49"
 	"github.com/chzyer/readline"
 	"github.com/fatih/color"
+	"github.com/fiatjaf/nak/lib"
 	"github.com/mattn/go-tty"
 	"github.com/urfave/cli/v3"
 )
 
 var defaultKey = nostr.KeyOne.Hex()
 
+// seededBaseTime is used instead of the current time when generating data from a --seed, so
+// the results are reproducible.
+const seededBaseTime nostr.Timestamp = 1700000000
+
+// seededSecretKey deterministically derives the index-th secret key from a seed.
+func seededSecretKey(seed int64, index int) nostr.SecretKey {
+	return nostr.SecretKey(sha256.Sum256([]byte(fmt.Sprintf("nak-seed:%d:%d", seed, index))))
+}
+
 var defaultKeyFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:        "sec",
-		Usage:       "secret key to sign the event, as nsec, ncryptsec or hex, or a bunker URL",
+		Usage:       "secret key to sign the event, as nsec, ncryptsec or hex, a bunker URL or hw:<serial-port> for a hardware signer",
 		DefaultText: "the key '01'",
 		Category:    CATEGORY_SIGNER,
 		Sources:     cli.EnvVars("NOSTR_SECRET_KEY"),
@@ -44,96 +53,73 @@ var defaultKeyFlags = []cli.Flag{
 }
 
 func gatherKeyerFromArguments(ctx context.Context, c *cli.Command) (nostr.Keyer, nostr.SecretKey, error) {
-	key, bunker, err := gatherSecretKeyOrBunkerFromArguments(ctx, c)
-	if err != nil {
-		return nil, nostr.SecretKey{}, err
+	if path, ok := strings.CutPrefix(c.String("sec"), "hw:"); ok {
+		hs, err := newHardwareSigner(ctx, path)
+		if err != nil {
+			return nil, nostr.SecretKey{}, err
+		}
+		return hs, nostr.SecretKey{}, nil
 	}
 
-	var kr nostr.Keyer
-	if bunker != nil {
-		kr = keyer.NewBunkerSignerFromBunkerClient(bunker)
-	} else {
-		kr = keyer.NewPlainKeySigner(key)
+	if keySessions != nil {
+		sec, bunker, err := gatherSecretKeyOrBunkerFromArguments(ctx, c)
+		if err != nil {
+			return nil, nostr.SecretKey{}, err
+		}
+		if bunker != nil {
+			return keyer.NewBunkerSignerFromBunkerClient(bunker), nostr.SecretKey{}, nil
+		}
+		return keyer.NewPlainKeySigner(sec), sec, nil
 	}
 
-	return kr, key, nil
+	return lib.GatherKeyer(ctx, keyOptionsFromArguments(c))
 }
 
-func gatherSecretKeyOrBunkerFromArguments(ctx context.Context, c *cli.Command) (nostr.SecretKey, *nip46.BunkerClient, error) {
-	sec := c.String("sec")
-	if strings.HasPrefix(sec, "bunker://") {
-		// it's a bunker
-		bunkerURL := sec
-		clientKeyHex := c.String("connect-as")
-		var clientKey nostr.SecretKey
-
-		if clientKeyHex != "" {
-			var err error
-			clientKey, err = nostr.SecretKeyFromHex(clientKeyHex)
-			if err != nil {
-				return nostr.SecretKey{}, nil, fmt.Errorf("bunker client key '%s' is invalid: %w", clientKeyHex, err)
-			}
-		} else {
-			clientKey = nostr.Generate()
-		}
-
-		logverbose("[nip46]: connecting to %s with client key %s\n", bunkerURL, clientKey.Hex())
-
-		bunker, err := nip46.ConnectBunker(ctx, clientKey, bunkerURL, nil, func(s string) {
-			log(color.CyanString("[nip46]: open the following URL: %s"), s)
-		})
-		if err != nil {
-			return nostr.SecretKey{}, nil, fmt.Errorf("failed to connect to %s: %w", bunkerURL, err)
-		}
+// keySessions, when not nil, keeps the keys and bunker connections already gathered so commands
+// running in the same process (with 'nak run') don't prompt or connect again.
+var keySessions map[string]keySession
 
-		return nostr.SecretKey{}, bunker, err
-	}
+type keySession struct {
+	sec    nostr.SecretKey
+	bunker *nip46.BunkerClient
+}
 
-	if c.Bool("prompt-sec") {
-		var err error
-		sec, err = askPassword("type your secret key as ncryptsec, nsec or hex: ", nil)
-		if err != nil {
-			return nostr.SecretKey{}, nil, fmt.Errorf("failed to get secret key: %w", err)
-		}
+func gatherSecretKeyOrBunkerFromArguments(ctx context.Context, c *cli.Command) (nostr.SecretKey, *nip46.BunkerClient, error) {
+	opts := keyOptionsFromArguments(c)
+	if keySessions == nil {
+		return lib.GatherSecretKeyOrBunker(ctx, opts)
 	}
 
-	if strings.HasPrefix(sec, "ncryptsec1") {
-		sk, err := promptDecrypt(sec)
-		if err != nil {
-			return nostr.SecretKey{}, nil, fmt.Errorf("failed to decrypt: %w", err)
-		}
-		return sk, nil, nil
+	id := fmt.Sprintf("%s\x00%s\x00%v", opts.Sec, opts.ConnectAs, opts.Prompt)
+	if session, ok := keySessions[id]; ok {
+		return session.sec, session.bunker, nil
 	}
-
-	if prefix, ski, err := nip19.Decode(sec); err == nil && prefix == "nsec" {
-		return ski.(nostr.SecretKey), nil, nil
+	sec, bunker, err := lib.GatherSecretKeyOrBunker(ctx, opts)
+	if err == nil {
+		keySessions[id] = keySession{sec, bunker}
 	}
+	return sec, bunker, err
+}
 
-	sk, err := nostr.SecretKeyFromHex(sec)
-	if err != nil {
-		return nostr.SecretKey{}, nil, fmt.Errorf("invalid secret key: %w", err)
+func keyOptionsFromArguments(c *cli.Command) lib.KeyOptions {
+	return lib.KeyOptions{
+		Sec:       c.String("sec"),
+		Prompt:    c.Bool("prompt-sec"),
+		ConnectAs: c.String("connect-as"),
+		AskPassword: func(prompt string) (string, error) {
+			return askPassword(prompt, nil)
+		},
+		OnAuthURL: func(url string) {
+			log(color.CyanString("[nip46]: open the following URL: %s"), url)
+		},
+		Logf: logverbose,
 	}
-
-	return sk, nil, nil
 }
 
 func promptDecrypt(ncryptsec string) (nostr.SecretKey, error) {
-	for i := 1; i < 4; i++ {
-		var attemptStr string
-		if i > 1 {
-			attemptStr = fmt.Sprintf(" [%d/3]", i)
-		}
-		password, err := askPassword("type the password to decrypt your secret key"+attemptStr+": ", nil)
-		if err != nil {
-			return nostr.SecretKey{}, err
-		}
-		sec, err := nip49.Decrypt(ncryptsec, password)
-		if err != nil {
-			continue
-		}
-		return sec, nil
-	}
-	return nostr.SecretKey{}, fmt.Errorf("couldn't decrypt private key")
+	return lib.DecryptNcryptsec(ncryptsec, func(prompt string) (string, error) {
+		return askPassword(prompt, nil)
+	})
 }
 
 func askPassword(msg string, shouldAskAgain func(answer string) bool) (string, error) {
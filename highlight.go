@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/urfave/cli/v3"
+)
+
+var highlight = &cli.Command{
+	Name:  "highlight",
+	Usage: "creates a nip84 highlight event (kind 9802) quoting some text from a url or from another event",
+	Description: `the source can be a url, an nevent, note, naddr or hex event id. when the source is a nostr event its author is attributed automatically, for urls use --author.
+
+example:
+		nak highlight --source https://example.com/post "the interesting part" --context "a paragraph containing the interesting part" wss://relay.example.com
+		nak highlight --source naddr1... "some quote" --comment "this is so true"`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		relayFlag,
+		&cli.StringFlag{
+			Name:     "source",
+			Aliases:  []string{"s"},
+			Usage:    "where the highlighted text comes from, a url or a nostr event",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "context",
+			Usage: "the surrounding text of the highlight",
+		},
+		&cli.StringFlag{
+			Name:    "comment",
+			Aliases: []string{"c"},
+			Usage:   "turns this into a quote highlight with the given comment",
+		},
+		&PubKeySliceFlag{
+			Name:  "author",
+			Usage: "pubkey of an author of the highlighted content, can be given multiple times",
+		},
+		&cli.BoolFlag{
+			Name:     "auth",
+			Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "nevent",
+			Usage:    "print the nevent code (to stderr) after the event is published",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "confirm",
+			Usage:    "ask before publishing the event",
+			Category: CATEGORY_EXTRAS,
+		},
+	),
+	ArgsUsage: "<text> [relay...]",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() < 1 {
+			return fmt.Errorf("missing highlighted text")
+		}
+
+		evt := nostr.Event{
+			Kind:    nostr.KindHighlights,
+			Content: c.Args().First(),
+		}
+
+		authors := getPubKeySlice(c, "author")
+		source := c.String("source")
+		if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			evt.Tags = append(evt.Tags, nostr.Tag{"r", source, "source"})
+		} else {
+			ptr, err := parsePointer(source)
+			if err != nil {
+				return fmt.Errorf("invalid source '%s': expected a url or a nostr event", source)
+			}
+
+			switch v := ptr.(type) {
+			case nostr.EventPointer:
+				if v.Author == nostr.ZeroPK {
+					if highlighted, _, err := sys.FetchSpecificEvent(ctx, v, sdk.FetchSpecificEventParameters{}); err == nil {
+						v.Author = highlighted.PubKey
+					} else {
+						log("couldn't find the author of the highlighted event, it won't be attributed\n")
+					}
+				}
+				evt.Tags = append(evt.Tags, v.AsTag())
+				if v.Author != nostr.ZeroPK {
+					authors = appendUnique(authors, v.Author)
+				}
+			case nostr.EntityPointer:
+				evt.Tags = append(evt.Tags, v.AsTag())
+				authors = appendUnique(authors, v.PublicKey)
+			default:
+				return fmt.Errorf("invalid source '%s': expected a url or a nostr event", source)
+			}
+		}
+
+		for _, pk := range authors {
+			evt.Tags = append(evt.Tags, nostr.Tag{"p", pk.Hex(), "", "author"})
+		}
+		if ctxt := c.String("context"); ctxt != "" {
+			evt.Tags = append(evt.Tags, nostr.Tag{"context", ctxt})
+		}
+		if comment := c.String("comment"); comment != "" {
+			evt.Tags = append(evt.Tags, nostr.Tag{"comment", comment})
+		}
+
+		return signPrintAndPublish(ctx, c, evt, c.Args().Tail())
+	},
+}
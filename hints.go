@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk/hints"
+)
+
+// relays seen on events are tracked by the sdk under the 'r' prefix, the ones we learn from
+// nevent codes (which may point to relays we never actually talked to) are kept under 'h'.
+const eventHintPrefix = byte('h')
+
+func makeEventHintKey(id nostr.ID) []byte {
+	key := make([]byte, 9)
+	key[0] = eventHintPrefix
+	copy(key[1:], id[:8])
+	return key
+}
+
+func saveEventRelayHints(id nostr.ID, relays []string) {
+	if len(relays) == 0 {
+		return
+	}
+
+	sys.KVStore.Update(makeEventHintKey(id), func(data []byte) ([]byte, error) {
+		var existing []string
+		if len(data) > 0 {
+			existing = strings.Split(string(data), " ")
+		}
+		for _, url := range relays {
+			if nostr.IsValidRelayURL(url) {
+				existing = appendUnique(existing, nostr.NormalizeURL(url))
+			}
+		}
+		return []byte(strings.Join(existing, " ")), nil
+	})
+}
+
+// eventRelayHints returns all the relays we know an event may be found at, first the ones
+// where we have actually seen it and then the ones we were told about.
+func eventRelayHints(id nostr.ID) []string {
+	relays := sys.GetEventRelays(id)
+	if data, _ := sys.KVStore.Get(makeEventHintKey(id)); len(data) > 0 {
+		relays = appendUnique(relays, strings.Split(string(data), " ")...)
+	}
+	return relays
+}
+
+// harvestPointerHints stores the relay hints contained in nevent, nprofile and naddr codes
+// so they can be used later when no relays are specified.
+func harvestPointerHints(ptr nostr.Pointer) {
+	now := nostr.Now()
+	saveAuthorHints := func(pk nostr.PubKey, relays []string) {
+		if pk == nostr.ZeroPK {
+			return
+		}
+		for _, url := range relays {
+			if nostr.IsValidRelayURL(url) {
+				sys.Hints.Save(pk, nostr.NormalizeURL(url), hints.LastInHint, now)
+			}
+		}
+	}
+
+	switch v := ptr.(type) {
+	case nostr.EventPointer:
+		saveEventRelayHints(v.ID, v.Relays)
+		saveAuthorHints(v.Author, v.Relays)
+	case nostr.ProfilePointer:
+		saveAuthorHints(v.PublicKey, v.Relays)
+	case nostr.EntityPointer:
+		saveAuthorHints(v.PublicKey, v.Relays)
+	}
+}
+
+// hintedRelaysForFilter picks relays from the hints database for the ids and authors in the filter.
+func hintedRelaysForFilter(filter nostr.Filter, perPubKey int) []string {
+	var relays []string
+	for _, id := range filter.IDs {
+		relays = appendUnique(relays, eventRelayHints(id)...)
+	}
+	for _, pk := range filter.Authors {
+		relays = appendUnique(relays, sys.Hints.TopN(pk, perPubKey)...)
+	}
+	return relays
+}
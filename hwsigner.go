@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip44"
+	"golang.org/x/crypto/hkdf"
+)
+
+// hardwareSigner talks to nostr signing devices (like the lnbits one) over a serial connection,
+// using their line-based protocol: we send "/<command> <args>" and they answer "/<command> <result>".
+// signing only happens after the user confirms it on the device.
+type hardwareSigner struct {
+	mu     sync.Mutex
+	port   io.ReadWriteCloser
+	lines  *bufio.Reader
+	pubkey nostr.PubKey
+}
+
+var _ nostr.Keyer = (*hardwareSigner)(nil)
+
+func newHardwareSigner(ctx context.Context, path string) (*hardwareSigner, error) {
+	port, err := openSerialPort(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port '%s': %w", path, err)
+	}
+
+	hs := &hardwareSigner{port: port, lines: bufio.NewReader(port)}
+
+	res, err := hs.call(ctx, "/public-key")
+	if err != nil {
+		port.Close()
+		return nil, fmt.Errorf("failed to get public key from device at '%s': %w", path, err)
+	}
+	hs.pubkey, err = nostr.PubKeyFromHex(res)
+	if err != nil {
+		port.Close()
+		return nil, fmt.Errorf("device returned an invalid public key '%s': %w", res, err)
+	}
+
+	logverbose("[hw]: connected to %s, pubkey %s\n", path, hs.pubkey.Hex())
+	return hs, nil
+}
+
+// call sends a command and waits for the line that answers it, ignoring everything else the device
+// may print in the meantime.
+func (hs *hardwareSigner) call(ctx context.Context, command string, args ...string) (string, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	line := strings.Join(append([]string{command}, args...), " ") + "\n"
+	if _, err := hs.port.Write([]byte(line)); err != nil {
+		return "", err
+	}
+
+	type result struct {
+		value string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		for {
+			line, err := hs.lines.ReadString('\n')
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			line = strings.TrimSpace(line)
+			logverbose("[hw]: < %s\n", line)
+			if rest, ok := strings.CutPrefix(line, command); ok {
+				fields := strings.Fields(rest)
+				if len(fields) == 0 {
+					done <- result{err: fmt.Errorf("empty response")}
+				} else {
+					done <- result{value: fields[len(fields)-1]}
+				}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// the reader goroutine is stuck, so this signer is unusable from now on
+		hs.port.Close()
+		return "", ctx.Err()
+	case res := <-done:
+		return res.value, res.err
+	}
+}
+
+func (hs *hardwareSigner) GetPublicKey(ctx context.Context) (nostr.PubKey, error) {
+	return hs.pubkey, nil
+}
+
+func (hs *hardwareSigner) SignEvent(ctx context.Context, evt *nostr.Event) error {
+	evt.PubKey = hs.pubkey
+	evt.ID = evt.GetID()
+
+	log("confirm the signature of event %s on the device...\n", colors.bold(evt.ID.Hex()))
+	res, err := hs.call(ctx, "/sign-message", evt.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	sig, err := hex.DecodeString(res)
+	if err != nil || len(sig) != 64 {
+		return fmt.Errorf("device returned an invalid signature '%s'", res)
+	}
+	copy(evt.Sig[:], sig)
+
+	if !evt.VerifySignature() {
+		return fmt.Errorf("device returned a signature that doesn't match, was it rejected?")
+	}
+	return nil
+}
+
+func (hs *hardwareSigner) conversationKey(ctx context.Context, pk nostr.PubKey) ([32]byte, error) {
+	res, err := hs.call(ctx, "/shared-secret", pk.Hex())
+	if err != nil {
+		return [32]byte{}, err
+	}
+	shared, err := hex.DecodeString(res)
+	if err != nil || len(shared) != 32 {
+		return [32]byte{}, fmt.Errorf("device returned an invalid shared secret")
+	}
+
+	var ck [32]byte
+	copy(ck[:], hkdf.Extract(sha256.New, shared, []byte("nip44-v2")))
+	return ck, nil
+}
+
+func (hs *hardwareSigner) Encrypt(ctx context.Context, plaintext string, recipient nostr.PubKey) (string, error) {
+	ck, err := hs.conversationKey(ctx, recipient)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(plaintext, ck)
+}
+
+func (hs *hardwareSigner) Decrypt(ctx context.Context, base64ciphertext string, sender nostr.PubKey) (string, error) {
+	ck, err := hs.conversationKey(ctx, sender)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(base64ciphertext, ck)
+}
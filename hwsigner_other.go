@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func openSerialPort(path string) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("hardware signers are only supported on linux")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip04"
+	"fiatjaf.com/nostr/nip17"
+	"fiatjaf.com/nostr/nip57"
+	"fiatjaf.com/nostr/nip59"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var inbox = &cli.Command{
+	Name:  "inbox",
+	Usage: "shows mentions, replies, reactions, reposts, zaps and direct messages addressed to you",
+	Description: `queries your read relays (and your dm relays) for everything that tags you since the last run, and prints it grouped by type. direct messages are decrypted when a key is given with --sec.
+
+example:
+		nak inbox --sec $NOSTR_SECRET_KEY
+		nak inbox --pubkey npub1... --since 1d --all`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(slices.Clip(defaultKeyFlags),
+		&PubKeyFlag{
+			Name:        "pubkey",
+			Usage:       "whose inbox to show",
+			DefaultText: "the pubkey from --sec",
+		},
+		&NaturalTimeFlag{
+			Name:        "since",
+			Aliases:     []string{"s"},
+			Usage:       "only things newer than this",
+			DefaultText: "since the last run, or the last 7 days",
+		},
+		relayFlag,
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "ignore the saved position from the last run",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		var kr nostr.Keyer
+		var sec nostr.SecretKey
+		pubkey := getPubKey(c, "pubkey")
+		if pubkey == nostr.ZeroPK || c.IsSet("sec") {
+			var err error
+			kr, sec, err = gatherKeyerFromArguments(ctx, c)
+			if err != nil {
+				return err
+			}
+			if pubkey == nostr.ZeroPK {
+				if pubkey, err = kr.GetPublicKey(ctx); err != nil {
+					return fmt.Errorf("failed to get public key: %w", err)
+				}
+			}
+		}
+		decrypt := kr != nil && c.IsSet("sec")
+
+		statePath := filepath.Join(c.String("config-path"), "inbox", pubkey.Hex())
+		since := nostr.Timestamp(time.Now().Add(-7 * 24 * time.Hour).Unix())
+		if c.IsSet("since") {
+			since = getNaturalDate(c, "since")
+		} else if !c.Bool("all") {
+			if data, err := os.ReadFile(statePath); err == nil {
+				if ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+					since = nostr.Timestamp(ts)
+					logverbose("showing things since the last run at %s\n", since.Time().Format(time.DateTime))
+				}
+			}
+		}
+		runStarted := nostr.Now()
+
+		relays := appendUnique(sys.FetchInboxRelays(ctx, pubkey, 6), getRelayURLs(c, nil)...)
+		if len(relays) == 0 {
+			return fmt.Errorf("no read relays found for %s, use --relay", pubkey.Hex())
+		}
+		logverbose("querying %v\n", relays)
+
+		groups := map[string][]nostr.Event{}
+		seen := make(map[nostr.ID]struct{})
+		for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{
+			Kinds: []nostr.Kind{1, 4, 6, 7, 16, 1111, 9735},
+			Tags:  nostr.TagMap{"p": []string{pubkey.Hex()}},
+			Since: since,
+		}, nostr.SubscriptionOptions{Label: "nak-inbox"}) {
+			evt := ie.Event
+			if _, ok := seen[evt.ID]; ok || evt.PubKey == pubkey {
+				continue
+			}
+			seen[evt.ID] = struct{}{}
+
+			switch evt.Kind {
+			case 1, 1111:
+				if evt.Tags.Find("e") != nil || evt.Kind == 1111 {
+					groups["replies"] = append(groups["replies"], evt)
+				} else {
+					groups["mentions"] = append(groups["mentions"], evt)
+				}
+			case 6, 16:
+				groups["reposts"] = append(groups["reposts"], evt)
+			case 7:
+				groups["reactions"] = append(groups["reactions"], evt)
+			case 9735:
+				groups["zaps"] = append(groups["zaps"], evt)
+			case 4:
+				if decrypt {
+					if plaintext, err := decryptNIP04(sec, evt.PubKey, evt.Content); err == nil {
+						evt.Content = plaintext
+					} else {
+						logverbose("failed to decrypt dm %s: %s\n", evt.ID.Hex(), err)
+					}
+				}
+				groups["direct messages"] = append(groups["direct messages"], evt)
+			}
+		}
+
+		// nip17 messages are only visible to us
+		if decrypt {
+			dmRelays := appendUnique(nip17.GetDMRelays(ctx, pubkey, sys.Pool, sys.RelayListRelays.URLs), getRelayURLs(c, nil)...)
+			for ie := range sys.Pool.FetchMany(ctx, dmRelays, nostr.Filter{
+				Kinds: []nostr.Kind{nostr.KindGiftWrap},
+				Tags:  nostr.TagMap{"p": []string{pubkey.Hex()}},
+				// gift wraps have randomized timestamps up to 2 days in the past
+				Since: since - 60*60*24*2,
+			}, nostr.SubscriptionOptions{Label: "nak-inbox"}) {
+				if _, ok := seen[ie.Event.ID]; ok {
+					continue
+				}
+				seen[ie.Event.ID] = struct{}{}
+
+				rumor, err := nip59.GiftUnwrap(ie.Event, func(otherpubkey nostr.PubKey, ciphertext string) (string, error) {
+					return kr.Decrypt(ctx, ciphertext, otherpubkey)
+				})
+				if err != nil || rumor.CreatedAt < since || rumor.PubKey == pubkey {
+					continue
+				}
+				groups["direct messages"] = append(groups["direct messages"], rumor)
+			}
+		}
+
+		total := 0
+		for _, name := range []string{"direct messages", "replies", "mentions", "zaps", "reactions", "reposts"} {
+			events := groups[name]
+			if len(events) == 0 {
+				continue
+			}
+			slices.SortFunc(events, func(a, b nostr.Event) int { return int(a.CreatedAt) - int(b.CreatedAt) })
+
+			header := fmt.Sprintf("%s (%d)", name, len(events))
+			if name == "zaps" {
+				var msats uint64
+				for _, zap := range events {
+					msats += nip57.GetAmountFromZap(zap)
+				}
+				header += fmt.Sprintf(", %d sats", msats/1000)
+			}
+			log("%s\n", color.New(color.Bold, color.FgCyan).Sprint(header))
+			for _, evt := range events {
+				stdout(evt)
+			}
+			total += len(events)
+		}
+		if total == 0 {
+			log("nothing new\n")
+		}
+
+		if !c.IsSet("since") {
+			os.MkdirAll(filepath.Dir(statePath), 0755)
+			os.WriteFile(statePath, []byte(strconv.FormatInt(int64(runStarted), 10)), 0644)
+		}
+		return nil
+	},
+}
+
+func decryptNIP04(sec nostr.SecretKey, sender nostr.PubKey, ciphertext string) (string, error) {
+	if sec == (nostr.SecretKey{}) {
+		return "", fmt.Errorf("nip04 decryption requires a local secret key")
+	}
+	ss, err := nip04.ComputeSharedSecret(sender, sec)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, ss)
+}
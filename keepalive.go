@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var keepaliveFlag = &cli.DurationFlag{
+	Name: "keepalive",
+	Usage: "with --stream, check every relay that didn't send anything for this long with a request that must be " +
+		"answered, reconnecting to the ones that don't answer instead of hanging forever (also keeps NATs from " +
+		"dropping idle connections)",
+	Category: CATEGORY_EXTRAS,
+}
+
+var keepaliveTimeoutFlag = &cli.DurationFlag{
+	Name:     "keepalive-timeout",
+	Usage:    "how long a relay has to answer a --keepalive check before its connection is considered dead",
+	Value:    10 * time.Second,
+	Category: CATEGORY_EXTRAS,
+}
+
+// keepalive is set when --keepalive is given, performReq reports to it and it's safe to call when nil.
+var keepalive *relayKeepalive
+
+type relayKeepalive struct {
+	mu           sync.Mutex
+	lastActivity map[string]time.Time
+}
+
+func setupKeepalive(ctx context.Context, c *cli.Command) error {
+	interval := c.Duration("keepalive")
+	if interval == 0 {
+		return nil
+	}
+	if !c.Bool("stream") {
+		return fmt.Errorf("--keepalive only makes sense with --stream")
+	}
+	if interval < 0 {
+		return fmt.Errorf("invalid --keepalive %s", interval)
+	}
+	timeout := c.Duration("keepalive-timeout")
+	if timeout <= 0 {
+		return fmt.Errorf("invalid --keepalive-timeout %s", timeout)
+	}
+
+	keepalive = &relayKeepalive{lastActivity: make(map[string]time.Time)}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			for url, relay := range sys.Pool.Relays.Range {
+				if !relay.IsConnected() || !keepalive.idle(url, interval) {
+					continue
+				}
+				// so it isn't checked again while this check is running
+				keepalive.activity(url)
+				go keepalive.check(ctx, relay, timeout)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// activity marks that something was received from a relay, so it doesn't need to be checked.
+func (ka *relayKeepalive) activity(url string) {
+	if ka == nil {
+		return
+	}
+	ka.mu.Lock()
+	ka.lastActivity[url] = time.Now()
+	ka.mu.Unlock()
+}
+
+func (ka *relayKeepalive) idle(url string, interval time.Duration) bool {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	return time.Since(ka.lastActivity[url]) >= interval
+}
+
+// check sends a request for an event that doesn't exist to the relay, which must answer with an EOSE. if it
+// doesn't the connection is closed, which makes the subscriptions on it reconnect.
+func (ka *relayKeepalive) check(ctx context.Context, relay *nostr.Relay, timeout time.Duration) {
+	var id nostr.ID
+	rand.Read(id[:])
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filter{IDs: []nostr.ID{id}, Limit: 1}, nostr.SubscriptionOptions{
+		Label: "nak-keepalive",
+		// we want the real EOSE, not the one the library makes up when the relay takes too long
+		MaxWaitForEOSE: math.MaxInt64,
+	})
+	if err != nil {
+		logverbose("keepalive check on %s failed: %s\n", relay.URL, err)
+		return
+	}
+	defer sub.Unsub()
+
+	select {
+	case <-sub.EndOfStoredEvents:
+		ka.activity(relay.URL)
+	case <-sub.ClosedReason:
+		// an answer is an answer
+		ka.activity(relay.URL)
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			log("%s didn't answer in %s, reconnecting\n", relay.URL, timeout)
+			relay.Close()
+		}
+	}
+}
@@ -20,6 +20,7 @@ var key = &cli.Command{
 	Usage:                     "operations on secret keys: generate, derive, encrypt, decrypt",
 	Description:               ``,
 	DisableSliceFlagSeparator: true,
+	Flags:                     []cli.Flag{copyFlag, copyClearFlag},
 	Commands: []*cli.Command{
 		generate,
 		public,
@@ -34,8 +35,23 @@ var generate = &cli.Command{
 	Usage:                     "generates a secret key",
 	Description:               ``,
 	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "seed",
+			Usage: "derive the key deterministically from this number instead of generating a random one, for reproducible tests",
+		},
+		&cli.UintFlag{
+			Name:  "index",
+			Usage: "with --seed, which of the keys derived from it to generate, in the same order 'nak fixture' gives them to its users",
+		},
+	},
 	Action: func(ctx context.Context, c *cli.Command) error {
 		sec := nostr.Generate()
+		if c.IsSet("seed") {
+			sec = seededSecretKey(c.Int("seed"), int(c.Uint("index")))
+		} else if c.IsSet("index") {
+			return fmt.Errorf("--index only makes sense with --seed")
+		}
 		stdout(sec.Hex())
 		return nil
 	},
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var label = &cli.Command{
+	Name:  "label",
+	Usage: "creates a nip32 label event (kind 1985) attaching labels to events, profiles or addresses",
+	Description: `the targets can be given as nevent, note, npub, nprofile, naddr, hex event ids or "<kind>:<pubkey>:<d>" addresses. if relays are given as arguments the label event is published to them.
+
+example:
+		nak label --namespace ISO-639-1 --label en --target nevent1... wss://relay.example.com
+		nak label --label spam --target npub1... --target npub1...`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		relayFlag,
+		&cli.StringFlag{
+			Name:        "namespace",
+			Aliases:     []string{"L"},
+			Usage:       "the label namespace",
+			DefaultText: "ugc",
+		},
+		&cli.StringSliceFlag{
+			Name:     "label",
+			Aliases:  []string{"l"},
+			Usage:    "a label to attach to the targets, can be given multiple times",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:     "target",
+			Usage:    "event, profile or address to be labeled, can be given multiple times",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "content",
+			Aliases: []string{"c"},
+			Usage:   "an explanation of the labeling",
+		},
+		&cli.BoolFlag{
+			Name:     "auth",
+			Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "nevent",
+			Usage:    "print the nevent code (to stderr) after the event is published",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "confirm",
+			Usage:    "ask before publishing the event",
+			Category: CATEGORY_EXTRAS,
+		},
+	),
+	ArgsUsage: "[relay...]",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		evt := nostr.Event{
+			Kind:    nostr.KindLabel,
+			Content: c.String("content"),
+			Tags:    make(nostr.Tags, 0, 4),
+		}
+
+		namespace := c.String("namespace")
+		if namespace != "" {
+			evt.Tags = append(evt.Tags, nostr.Tag{"L", namespace})
+		} else {
+			namespace = "ugc"
+		}
+		for _, l := range c.StringSlice("label") {
+			evt.Tags = append(evt.Tags, nostr.Tag{"l", l, namespace})
+		}
+
+		for _, target := range c.StringSlice("target") {
+			ptr, err := parsePointer(target)
+			if err != nil {
+				return err
+			}
+			evt.Tags = append(evt.Tags, labelTargetTags(ptr)...)
+		}
+
+		return signPrintAndPublish(ctx, c, evt, c.Args().Slice())
+	},
+}
+
+var labels = &cli.Command{
+	Name:  "labels",
+	Usage: "fetches nip32 labels applied to a target and aggregates them",
+	Description: `queries the given relays (or the relay hints and the outbox relays of the target author) for kind 1985 label events pointing to the target and prints one line for each distinct label with the number of labelers that applied it.
+
+example:
+		nak labels nevent1...
+		nak labels --namespace ISO-639-1 npub1... wss://relay.example.com`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		relayFlag,
+		&cli.StringFlag{
+			Name:    "namespace",
+			Aliases: []string{"L"},
+			Usage:   "only consider labels in this namespace",
+		},
+	},
+	ArgsUsage: "<target> [relay...]",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() < 1 {
+			return fmt.Errorf("missing target")
+		}
+
+		ptr, err := parsePointer(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		filter := nostr.Filter{Kinds: []nostr.Kind{nostr.KindLabel}}
+		tag := labelTargetTags(ptr)[0]
+		filter.Tags = nostr.TagMap{tag[0]: []string{tag[1]}}
+		if namespace := c.String("namespace"); namespace != "" {
+			filter.Tags["L"] = []string{namespace}
+		}
+
+		relays := pointerRelays(ctx, ptr, getRelayURLs(c, c.Args().Tail()))
+		if len(relays) == 0 {
+			return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+		}
+
+		type aggregate struct {
+			Namespace string         `json:"namespace"`
+			Label     string         `json:"label"`
+			Count     int            `json:"count"`
+			Labelers  []nostr.PubKey `json:"labelers"`
+		}
+		aggregates := make([]*aggregate, 0, 10)
+
+		for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-labels"}) {
+			for l := range ie.Event.Tags.FindAll("l") {
+				namespace := "ugc"
+				if len(l) >= 3 {
+					namespace = l[2]
+				}
+				if ns := c.String("namespace"); ns != "" && ns != namespace {
+					continue
+				}
+
+				idx := slices.IndexFunc(aggregates, func(a *aggregate) bool {
+					return a.Namespace == namespace && a.Label == l[1]
+				})
+				if idx == -1 {
+					idx = len(aggregates)
+					aggregates = append(aggregates, &aggregate{Namespace: namespace, Label: l[1]})
+				}
+				if !slices.Contains(aggregates[idx].Labelers, ie.Event.PubKey) {
+					aggregates[idx].Labelers = append(aggregates[idx].Labelers, ie.Event.PubKey)
+					aggregates[idx].Count++
+				}
+			}
+		}
+
+		slices.SortStableFunc(aggregates, func(a, b *aggregate) int { return b.Count - a.Count })
+		for _, agg := range aggregates {
+			j, _ := json.Marshal(agg)
+			stdout(string(j))
+		}
+
+		return nil
+	},
+}
+
+// labelTargetTags returns the tags that must be added to a label (or report) event to point to the given target.
+// the first tag is always the one that identifies the target directly.
+func labelTargetTags(ptr nostr.Pointer) nostr.Tags {
+	switch v := ptr.(type) {
+	case nostr.EventPointer:
+		tags := nostr.Tags{v.AsTag()}
+		if v.Author != nostr.ZeroPK {
+			tags = append(tags, nostr.Tag{"p", v.Author.Hex()})
+		}
+		return tags
+	case nostr.EntityPointer:
+		return nostr.Tags{v.AsTag(), nostr.Tag{"p", v.PublicKey.Hex()}}
+	default:
+		return nostr.Tags{ptr.AsTag()}
+	}
+}
+
+// pointerRelays returns the relays that should be queried for events related to the given pointer:
+// the explicitly given ones if any, otherwise the hints from the pointer and the outbox relays of its author.
+func pointerRelays(ctx context.Context, ptr nostr.Pointer, explicit []string) []string {
+	if len(explicit) > 0 {
+		return explicit
+	}
+
+	var relays []string
+	var author nostr.PubKey
+	switch v := ptr.(type) {
+	case nostr.EventPointer:
+		relays = append(relays, v.Relays...)
+		author = v.Author
+	case nostr.EntityPointer:
+		relays = append(relays, v.Relays...)
+		author = v.PublicKey
+	case nostr.ProfilePointer:
+		relays = append(relays, v.Relays...)
+		author = v.PublicKey
+	}
+
+	if author != nostr.ZeroPK {
+		relays = appendUnique(relays, sys.FetchOutboxRelays(ctx, author, 3)...)
+	}
+
+	return relays
+}
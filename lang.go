@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var langFlag = &cli.StringSliceFlag{
+	Name:  "lang",
+	Usage: "only show events whose content is in one of these languages (as two-letter codes, like --lang en,pt), detected from the text unless the event has a nip32 language label, use 'und' to also keep events whose language can't be told",
+}
+
+// langStopwords are the most common words of languages written in the latin alphabet, which is how
+// we tell them apart, languages with their own scripts are recognized by those.
+var langStopwords = map[string][]string{
+	"en": strings.Fields("the and is are was to of in that it you for with this have not but what on be just"),
+	"pt": strings.Fields("não que uma com para isso está muito mas por mais como você são também ou foi tem meu"),
+	"es": strings.Fields("que una con para pero por más esto está muy como los las del y también sí fue hay"),
+	"fr": strings.Fields("le la les et est une des pas que pour dans ce qui avec sur je vous mais il"),
+	"de": strings.Fields("der die das und ist nicht ich ein eine zu mit auf es sie auch sich den dem"),
+	"it": strings.Fields("il che non di è la una per sono ma con come anche questo del della gli"),
+	"nl": strings.Fields("de het een en is niet van ik dat op je te zijn met voor ook maar wat"),
+}
+
+var langIgnored = regexp.MustCompile(`(?i)(https?://|nostr:|www\.)\S+|[#@]\S+`)
+
+// detectLanguage guesses the language of a text, returning a two-letter code or "und" when there is
+// not enough text or it is too ambiguous.
+func detectLanguage(text string) string {
+	text = strings.ToLower(langIgnored.ReplaceAllString(text, " "))
+
+	letters := 0
+	scripts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case strings.ContainsRune("іїєґ", r):
+			scripts["uk"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+		case strings.ContainsRune("پچژگ", r):
+			scripts["fa"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		}
+	}
+	if letters == 0 {
+		return "und"
+	}
+
+	// japanese mixes kanji with kana, and a few distinctive letters are enough to tell apart
+	// ukrainian from russian and persian from arabic
+	nonLatin := 0
+	for _, count := range scripts {
+		nonLatin += count
+	}
+	if nonLatin*2 > letters {
+		switch {
+		case scripts["ja"] > 0:
+			return "ja"
+		case scripts["uk"] > 0:
+			return "uk"
+		case scripts["fa"] > 0:
+			return "fa"
+		}
+		best := ""
+		for lang, count := range scripts {
+			if best == "" || count > scripts[best] || (count == scripts[best] && lang < best) {
+				best = lang
+			}
+		}
+		if scripts[best]*2 > nonLatin {
+			return best
+		}
+		return "und"
+	}
+
+	words := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) })
+	scores := make(map[string]int, len(langStopwords))
+	for _, word := range words {
+		for lang, stopwords := range langStopwords {
+			if slices.Contains(stopwords, word) {
+				scores[lang]++
+			}
+		}
+	}
+	best, second := "und", 0
+	for lang, score := range scores {
+		if score > scores[best] {
+			second = max(second, scores[best])
+			best = lang
+		} else if score > second {
+			second = score
+		}
+	}
+	if scores[best] < 2 || scores[best] == second {
+		return "und"
+	}
+	return best
+}
+
+// eventLanguage uses the nip32 "ISO-639-1" label when the event has one and detects it from the
+// content otherwise.
+func eventLanguage(evt nostr.Event) string {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "l" && tag[2] == "ISO-639-1" {
+			return strings.ToLower(tag[1])
+		}
+	}
+	return detectLanguage(evt.Content)
+}
+
+// setupLang makes stdout skip the events that aren't in one of the languages given with --lang.
+func setupLang(c *cli.Command) error {
+	var langs []string
+	for _, value := range c.StringSlice("lang") {
+		for _, lang := range strings.Split(value, ",") {
+			lang = strings.ToLower(strings.TrimSpace(lang))
+			if lang == "" {
+				continue
+			}
+			if len(lang) != 2 && lang != "und" {
+				return fmt.Errorf("invalid language '%s', expected a two-letter code like 'en'", lang)
+			}
+			langs = appendUnique(langs, lang)
+		}
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				if lang := eventLanguage(evt); !slices.Contains(langs, lang) {
+					logverbose("hiding event %s in language '%s'\n", evt.ID.Hex(), lang)
+					return
+				}
+			}
+		}
+		printNext(args...)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/keyer"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip46"
+	"fiatjaf.com/nostr/nip49"
+)
+
+// KeyOptions describes where a key comes from.
+type KeyOptions struct {
+	// Sec is a secret key as nsec, ncryptsec or hex, or a bunker:// url.
+	Sec string
+
+	// Prompt makes the secret key be asked with AskPassword instead of taken from Sec.
+	Prompt bool
+
+	// ConnectAs is the hex secret key used to talk to bunkers, a random one is used if empty.
+	ConnectAs string
+
+	// AskPassword is called to get the secret key when Prompt is true and to get the
+	// password that decrypts an ncryptsec. if nil these cases fail.
+	AskPassword func(prompt string) (string, error)
+
+	// OnAuthURL is called when a bunker asks the user to open an url to authorize the connection.
+	OnAuthURL func(url string)
+
+	// Logf, if given, gets debugging messages.
+	Logf func(msg string, args ...any)
+}
+
+// GatherKeyer returns a signer for the given options, along with the secret key when it's not a bunker.
+func GatherKeyer(ctx context.Context, opts KeyOptions) (nostr.Keyer, nostr.SecretKey, error) {
+	key, bunker, err := GatherSecretKeyOrBunker(ctx, opts)
+	if err != nil {
+		return nil, nostr.SecretKey{}, err
+	}
+
+	if bunker != nil {
+		return keyer.NewBunkerSignerFromBunkerClient(bunker), nostr.SecretKey{}, nil
+	}
+	return keyer.NewPlainKeySigner(key), key, nil
+}
+
+// GatherSecretKeyOrBunker returns either a secret key or a connected bunker client.
+func GatherSecretKeyOrBunker(ctx context.Context, opts KeyOptions) (nostr.SecretKey, *nip46.BunkerClient, error) {
+	sec := opts.Sec
+	if strings.HasPrefix(sec, "bunker://") {
+		bunkerURL := sec
+		var clientKey nostr.SecretKey
+		if opts.ConnectAs != "" {
+			var err error
+			clientKey, err = nostr.SecretKeyFromHex(opts.ConnectAs)
+			if err != nil {
+				return nostr.SecretKey{}, nil, fmt.Errorf("bunker client key '%s' is invalid: %w", opts.ConnectAs, err)
+			}
+		} else {
+			clientKey = nostr.Generate()
+		}
+
+		if opts.Logf != nil {
+			opts.Logf("[nip46]: connecting to %s with client key %s\n", bunkerURL, clientKey.Hex())
+		}
+
+		bunker, err := nip46.ConnectBunker(ctx, clientKey, bunkerURL, nil, func(s string) {
+			if opts.OnAuthURL != nil {
+				opts.OnAuthURL(s)
+			}
+		})
+		if err != nil {
+			return nostr.SecretKey{}, nil, fmt.Errorf("failed to connect to %s: %w", bunkerURL, err)
+		}
+
+		return nostr.SecretKey{}, bunker, nil
+	}
+
+	if opts.Prompt {
+		if opts.AskPassword == nil {
+			return nostr.SecretKey{}, nil, fmt.Errorf("can't prompt for a secret key")
+		}
+		var err error
+		sec, err = opts.AskPassword("type your secret key as ncryptsec, nsec or hex: ")
+		if err != nil {
+			return nostr.SecretKey{}, nil, fmt.Errorf("failed to get secret key: %w", err)
+		}
+	}
+
+	if strings.HasPrefix(sec, "ncryptsec1") {
+		sk, err := DecryptNcryptsec(sec, opts.AskPassword)
+		if err != nil {
+			return nostr.SecretKey{}, nil, fmt.Errorf("failed to decrypt: %w", err)
+		}
+		return sk, nil, nil
+	}
+
+	if prefix, ski, err := nip19.Decode(sec); err == nil && prefix == "nsec" {
+		return ski.(nostr.SecretKey), nil, nil
+	}
+
+	sk, err := nostr.SecretKeyFromHex(sec)
+	if err != nil {
+		return nostr.SecretKey{}, nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+
+	return sk, nil, nil
+}
+
+// DecryptNcryptsec asks for the password up to 3 times until the ncryptsec can be decrypted.
+func DecryptNcryptsec(ncryptsec string, askPassword func(prompt string) (string, error)) (nostr.SecretKey, error) {
+	if askPassword == nil {
+		return nostr.SecretKey{}, fmt.Errorf("can't ask for the password")
+	}
+
+	for i := 1; i < 4; i++ {
+		var attemptStr string
+		if i > 1 {
+			attemptStr = fmt.Sprintf(" [%d/3]", i)
+		}
+		password, err := askPassword("type the password to decrypt your secret key" + attemptStr + ": ")
+		if err != nil {
+			return nostr.SecretKey{}, err
+		}
+		sec, err := nip49.Decrypt(ncryptsec, password)
+		if err != nil {
+			continue
+		}
+		return sec, nil
+	}
+	return nostr.SecretKey{}, fmt.Errorf("couldn't decrypt private key")
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// JSONArrayWriter writes items as a single json array, each as soon as it is given, so it works
+// for streams that never end. it is safe for concurrent use.
+type JSONArrayWriter struct {
+	w      io.Writer
+	mu     sync.Mutex
+	first  bool
+	closed bool
+}
+
+func NewJSONArrayWriter(w io.Writer) *JSONArrayWriter {
+	return &JSONArrayWriter{w: w, first: true}
+}
+
+// Write adds an item to the array. items that aren't valid json (like ids or codes) are written as strings.
+func (aw *JSONArrayWriter) Write(item string) {
+	if !json.Valid([]byte(item)) {
+		j, _ := json.Marshal(item)
+		item = string(j)
+	}
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	if aw.closed {
+		return
+	}
+	if aw.first {
+		fmt.Fprint(aw.w, "[\n")
+		aw.first = false
+	} else {
+		fmt.Fprint(aw.w, ",\n")
+	}
+	fmt.Fprint(aw.w, item)
+}
+
+// Close ends the array, writing an empty one if nothing was written. calling it again does nothing.
+func (aw *JSONArrayWriter) Close() {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	if aw.closed {
+		return
+	}
+	aw.closed = true
+	if aw.first {
+		fmt.Fprintln(aw.w, "[]")
+	} else {
+		fmt.Fprintln(aw.w, "\n]")
+	}
+}
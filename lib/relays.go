@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"golang.org/x/net/idna"
+)
+
+// NormalizeAndValidateRelayURLs normalizes the urls in place and fails on the first one that isn't a relay url.
+func NormalizeAndValidateRelayURLs(wsurls []string) error {
+	for i, wsurl := range wsurls {
+		normalized, _, err := NormalizeRelayURL(wsurl)
+		if err != nil {
+			return err
+		}
+		wsurls[i] = normalized
+	}
+
+	return nil
+}
+
+var (
+	relaySchemeTypo   = regexp.MustCompile(`(?i)^(wss|wws|wsss|ws|https|http)(:/*|;/*|//+)`)
+	relayHostLabel    = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?$`)
+	relayTrailingJunk = ",;.'\"`)>]"
+
+	// like idna.Lookup, but allowing underscores, which some relays have in their hostnames
+	relayIDNA = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+)
+
+// NormalizeRelayURL gives the canonical form of a relay url: ws:// or wss:// (inferred when missing,
+// wss:// except for localhost and other single-label hosts), lowercase punycode host, no default port,
+// no trailing slash and no fragment. Single-label hosts like "relay" in a docker network are only
+// accepted with a scheme or a port, without either they are more likely a typo. Common typos like
+// "wss:/" or "wws://" are repaired and described in fixes, anything that still isn't a relay url is
+// an error.
+func NormalizeRelayURL(raw string) (normalized string, fixes []string, err error) {
+	s := strings.TrimSpace(raw)
+	if trimmed := strings.TrimRight(s, relayTrailingJunk); trimmed != s {
+		fixes = append(fixes, fmt.Sprintf("removed trailing '%s'", s[len(trimmed):]))
+		s = trimmed
+	}
+	if s == "" {
+		return "", nil, fmt.Errorf("relay url is empty")
+	}
+
+	// repair the scheme, possibly repeated, like in "wss://wss://relay.example.com"
+	scheme := ""
+	for {
+		m := relaySchemeTypo.FindStringSubmatch(s)
+		if m == nil {
+			break
+		}
+		typed := strings.ToLower(m[1])
+		s = s[len(m[0]):]
+
+		fixed := typed
+		switch typed {
+		case "wws", "wsss":
+			fixed = "wss"
+			fixes = append(fixes, fmt.Sprintf("replaced '%s://' with 'wss://'", typed))
+		case "https":
+			fixed = "wss"
+		case "http":
+			fixed = "ws"
+		}
+		if m[2] != "://" {
+			fixes = append(fixes, fmt.Sprintf("replaced '%s' after the scheme with '://'", m[2]))
+		}
+		if scheme != "" {
+			fixes = append(fixes, "removed repeated scheme")
+		}
+		scheme = fixed
+	}
+
+	if strings.Contains(s, "://") {
+		return "", fixes, fmt.Errorf("relay url '%s' must use wss:// or ws://", raw)
+	}
+	u, err := url.Parse("placeholder://" + s)
+	if err != nil {
+		return "", fixes, fmt.Errorf("invalid relay url '%s': %w", raw, err)
+	}
+	if u.User != nil {
+		return "", fixes, fmt.Errorf("relay url '%s' must not have a user or password", raw)
+	}
+	if u.Fragment != "" || strings.HasSuffix(s, "#") {
+		fixes = append(fixes, "removed fragment")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fixes, fmt.Errorf("relay url '%s' is missing the hostname", raw)
+	}
+	singleLabel := false
+	if ip := net.ParseIP(host); ip == nil {
+		ascii, err := relayIDNA.ToASCII(host)
+		if err != nil {
+			return "", fixes, fmt.Errorf("relay url '%s' has an invalid hostname: %w", raw, err)
+		}
+		for _, label := range strings.Split(ascii, ".") {
+			if !relayHostLabel.MatchString(label) {
+				return "", fixes, fmt.Errorf("relay url '%s' has an invalid hostname '%s'", raw, host)
+			}
+		}
+		singleLabel = !strings.Contains(ascii, ".")
+		if singleLabel && ascii != "localhost" && scheme == "" && u.Port() == "" {
+			return "", fixes, fmt.Errorf("relay url '%s' has no top-level domain in '%s', add the scheme or the port if that is the whole hostname", raw, host)
+		}
+		host = ascii
+	} else if ip.To4() == nil {
+		host = "[" + ip.String() + "]"
+	}
+
+	if scheme == "" {
+		if singleLabel || host == "127.0.0.1" || host == "[::1]" {
+			scheme = "ws"
+		} else {
+			scheme = "wss"
+		}
+	}
+
+	port := u.Port()
+	if (scheme == "wss" && port == "443") || (scheme == "ws" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+
+	normalized = scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		normalized += "?" + u.RawQuery
+	}
+	return normalized, fixes, nil
+}
+
+// ConnectOptions configures ConnectToRelay.
+type ConnectOptions struct {
+	// SkipVerify makes the relay connection not check the signatures of the events it receives.
+	SkipVerify bool
+
+	// PreAuth, when given, is used to sign the response to the AUTH challenge that the relay is
+	// expected to send right after the connection is made, before the relay is returned.
+	PreAuth func(ctx context.Context, authEvent *nostr.Event) error
+
+	// Progress, if given, gets status messages while waiting for the AUTH challenge.
+	Progress func(msg string)
+}
+
+// ErrNoAuthChallenge is wrapped in the AuthError returned by ConnectToRelay when PreAuth is given but the relay doesn't send a challenge.
+var ErrNoAuthChallenge = errors.New("failed to get an AUTH challenge in enough time")
+
+// AuthError is returned by ConnectToRelay when the connection was made but the AUTH failed.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+var errChallengeNotReceived = errors.New("auth challenge not received yet")
+
+// ConnectToRelay connects to a relay through the pool, optionally performing AUTH before returning it.
+func ConnectToRelay(ctx context.Context, pool *nostr.Pool, url string, opts ConnectOptions) (*nostr.Relay, error) {
+	relay, err := pool.EnsureRelay(url)
+	if err != nil {
+		return nil, err
+	}
+	relay.AssumeValid = opts.SkipVerify
+
+	if opts.PreAuth == nil {
+		return relay, nil
+	}
+
+	if opts.Progress != nil {
+		opts.Progress("waiting for auth challenge... ")
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	for range 5 {
+		err := relay.Auth(ctx, func(ctx context.Context, authEvent *nostr.Event) error {
+			if challengeTag := authEvent.Tags.Find("challenge"); challengeTag == nil || challengeTag[1] == "" {
+				return errChallengeNotReceived
+			}
+			return opts.PreAuth(ctx, authEvent)
+		})
+		if err == nil {
+			return relay, nil
+		}
+		if !errors.Is(err, errChallengeNotReceived) {
+			// it failed for some other reason, so skip this relay
+			return nil, &AuthError{err}
+		}
+
+		// it failed because we didn't receive the challenge yet, so keep waiting
+		time.Sleep(time.Second)
+	}
+
+	return nil, &AuthError{ErrNoAuthChallenge}
+}
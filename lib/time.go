@@ -0,0 +1,38 @@
+// Package lib has the parts of nak that are useful for other programs: gathering keys from the
+// formats nak accepts, connecting to relays, parsing dates in natural language and writing output.
+package lib
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/markusmobius/go-dateparser"
+)
+
+// ParseNaturalTime parses a unix timestamp or a date in natural language, like "yesterday",
+// "two weeks ago" or "2024-03-01 10:00", relative to the current time.
+func ParseNaturalTime(value string) (nostr.Timestamp, error) {
+	return ParseNaturalTimeIn(value, time.Local)
+}
+
+// ParseNaturalTimeIn is like ParseNaturalTime, but dates without a timezone are taken as being in loc.
+func ParseNaturalTimeIn(value string, loc *time.Location) (nostr.Timestamp, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// when the input is a raw number, treat it as an exact timestamp
+		return nostr.Timestamp(n), nil
+	} else if errors.Is(err, strconv.ErrRange) {
+		// this means a huge number, so we should fail
+		return 0, err
+	}
+
+	date, err := dateparser.Parse(&dateparser.Configuration{
+		DefaultTimezone: loc,
+		CurrentTime:     time.Now().In(loc),
+	}, value)
+	if err != nil {
+		return 0, err
+	}
+	return nostr.Timestamp(date.Time.Unix()), nil
+}
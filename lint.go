@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var lint = &cli.Command{
+	Name:  "lint",
+	Usage: "checks events given through stdin against the structural rules of the nips that define their kinds",
+	Description: `reads events from stdin (or the first argument) and prints one line for each problem found, with the nip that defines the violated rule.
+
+errors are things that make the event invalid or that will cause clients to ignore it, warnings are things that go against recommendations or that will probably be displayed wrong.
+if any error is found the command exits with a non-zero code.
+
+example:
+		nak req -k 0 -l 100 nos.lol | nak lint
+		nak event -k 30023 -c 'hello' | nak lint --json`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print each issue as a json object",
+		},
+		&cli.BoolFlag{
+			Name:  "errors-only",
+			Usage: "don't print warnings",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		nerrors := 0
+		for stdinEvent := range getJsonsOrBlank() {
+			evt := nostr.Event{}
+			if stdinEvent == "{}" {
+				stdinEvent = c.Args().First()
+				if stdinEvent == "" {
+					continue
+				}
+			}
+
+			if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+				ctx = lineProcessingError(ctx, "invalid event: %s", err)
+				continue
+			}
+
+			for _, issue := range lintEvent(evt) {
+				if issue.Severity == "error" {
+					nerrors++
+				} else if c.Bool("errors-only") {
+					continue
+				}
+
+				if c.Bool("json") {
+					j, _ := json.Marshal(issue)
+					stdout(string(j))
+					continue
+				}
+
+				severity := color.YellowString(issue.Severity)
+				if issue.Severity == "error" {
+					severity = colors.error(issue.Severity)
+				}
+				stdout(fmt.Sprintf("%s %s %s %s",
+					color.CyanString(issue.Event[0:8]), severity, colors.bold("NIP-"+issue.NIP), issue.Message))
+			}
+		}
+
+		exitIfLineProcessingError(ctx)
+		if nerrors > 0 {
+			return fmt.Errorf("found %d errors", nerrors)
+		}
+		return nil
+	},
+}
+
+type lintIssue struct {
+	Event    string `json:"event"`
+	Severity string `json:"severity"`
+	NIP      string `json:"nip"`
+	Message  string `json:"message"`
+}
+
+type linter struct {
+	evt    nostr.Event
+	issues []lintIssue
+}
+
+func (l *linter) errorf(nip string, msg string, args ...any) {
+	l.issues = append(l.issues, lintIssue{l.evt.ID.Hex(), "error", nip, fmt.Sprintf(msg, args...)})
+}
+
+func (l *linter) warnf(nip string, msg string, args ...any) {
+	l.issues = append(l.issues, lintIssue{l.evt.ID.Hex(), "warning", nip, fmt.Sprintf(msg, args...)})
+}
+
+// requireTag reports an error if the event doesn't have a tag with the given name and a non-empty value.
+func (l *linter) requireTag(nip string, name string) nostr.Tag {
+	tag := l.evt.Tags.Find(name)
+	if tag == nil || tag[1] == "" {
+		l.errorf(nip, "missing \"%s\" tag", name)
+		return nil
+	}
+	return tag
+}
+
+func (l *linter) checkTimestampTag(nip string, name string) {
+	if tag := l.evt.Tags.Find(name); tag != nil {
+		if _, err := strconv.ParseInt(tag[1], 10, 64); err != nil {
+			l.errorf(nip, "\"%s\" tag must be a unix timestamp in seconds, got '%s'", name, tag[1])
+		}
+	}
+}
+
+func lintEvent(evt nostr.Event) []lintIssue {
+	l := &linter{evt: evt}
+
+	// nip01 basics
+	if evt.GetID() != evt.ID {
+		l.errorf("01", "invalid id, expected %s", evt.GetID().Hex())
+	} else if !evt.VerifySignature() {
+		l.errorf("01", "invalid signature")
+	}
+	if evt.CreatedAt > nostr.Now()+15*60 {
+		l.warnf("01", "created_at is %s in the future", time.Until(evt.CreatedAt.Time()).Round(time.Second))
+	}
+	if evt.CreatedAt < 1577836800 {
+		l.warnf("01", "created_at is before 2020, is it in milliseconds or just wrong?")
+	}
+	if evt.Kind.IsAddressable() && evt.Tags.Find("d") == nil {
+		l.errorf("01", "addressable events must have a \"d\" tag")
+	}
+
+	for i, tag := range evt.Tags {
+		if len(tag) == 0 || tag[0] == "" {
+			l.errorf("01", "tag %d is empty", i)
+			continue
+		}
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e", "q":
+			if _, err := nostr.IDFromHex(tag[1]); err != nil {
+				l.errorf("01", "\"%s\" tag value must be a 64-char lowercase hex event id, got '%s'", tag[0], tag[1])
+			}
+			if len(tag) >= 3 && tag[2] != "" && !nostr.IsValidRelayURL(tag[2]) {
+				l.warnf("01", "\"%s\" tag has an invalid relay hint '%s'", tag[0], tag[2])
+			}
+		case "p":
+			if _, err := nostr.PubKeyFromHex(tag[1]); err != nil {
+				l.errorf("01", "\"p\" tag value must be a 64-char lowercase hex pubkey, got '%s'", tag[1])
+			}
+			if len(tag) >= 3 && tag[2] != "" && !nostr.IsValidRelayURL(tag[2]) {
+				l.warnf("01", "\"p\" tag has an invalid relay hint '%s'", tag[2])
+			}
+		case "a":
+			if _, err := nostr.ParseAddrString(tag[1]); err != nil {
+				l.errorf("01", "\"a\" tag value must be \"<kind>:<pubkey>:<d>\", got '%s'", tag[1])
+			}
+		case "t":
+			if strings.ToLower(tag[1]) != tag[1] {
+				l.warnf("24", "hashtags in \"t\" tags should be lowercase, got '%s'", tag[1])
+			}
+			if strings.HasPrefix(tag[1], "#") {
+				l.warnf("24", "hashtags in \"t\" tags should not include the '#', got '%s'", tag[1])
+			}
+		case "expiration":
+			l.checkTimestampTag("40", "expiration")
+		}
+	}
+
+	if rule, ok := lintRules[evt.Kind]; ok {
+		rule(l)
+	}
+
+	return l.issues
+}
+
+var lintRules = map[nostr.Kind]func(l *linter){
+	0: func(l *linter) {
+		var metadata map[string]any
+		if err := json.Unmarshal([]byte(l.evt.Content), &metadata); err != nil {
+			l.errorf("01", "content must be a stringified json object: %s", err)
+			return
+		}
+		for _, field := range []string{"name", "display_name", "about", "picture", "banner", "website", "nip05", "lud06", "lud16"} {
+			if v, ok := metadata[field]; ok {
+				if _, isString := v.(string); !isString {
+					l.errorf("01", "\"%s\" must be a string", field)
+				}
+			}
+		}
+		for _, field := range []string{"picture", "banner", "website"} {
+			if v, ok := metadata[field].(string); ok && v != "" {
+				if u, err := url.Parse(v); err != nil || u.Host == "" {
+					l.warnf("24", "\"%s\" is not a valid url: '%s'", field, v)
+				}
+			}
+		}
+		if nip05, ok := metadata["nip05"].(string); ok && nip05 != "" {
+			if spl := strings.Split(nip05, "@"); len(spl) != 2 || !strings.Contains(spl[1], ".") {
+				l.errorf("05", "\"nip05\" must be an internet identifier like name@domain.com, got '%s'", nip05)
+			}
+		}
+		if lud16, ok := metadata["lud16"].(string); ok && lud16 != "" {
+			if spl := strings.Split(lud16, "@"); len(spl) != 2 || !strings.Contains(spl[1], ".") {
+				l.errorf("57", "\"lud16\" must be a lightning address like name@domain.com, got '%s'", lud16)
+			}
+		}
+		for deprecated, replacement := range map[string]string{"displayName": "display_name", "username": "name"} {
+			if _, ok := metadata[deprecated]; ok {
+				l.warnf("24", "\"%s\" is deprecated, use \"%s\"", deprecated, replacement)
+			}
+		}
+	},
+	3: func(l *linter) {
+		for tag := range l.evt.Tags.FindAll("p") {
+			if len(tag) >= 4 && tag[3] != "" && strings.ContainsAny(tag[3], " \n") {
+				l.warnf("02", "petname '%s' contains whitespace", tag[3])
+			}
+		}
+		if l.evt.Content != "" {
+			l.warnf("02", "content should be empty, relay lists in kind 3 are deprecated in favor of kind 10002")
+		}
+	},
+	5: func(l *linter) {
+		if l.evt.Tags.Find("e") == nil && l.evt.Tags.Find("a") == nil {
+			l.errorf("09", "deletion requests must have at least one \"e\" or \"a\" tag")
+		}
+		if l.evt.Tags.Find("k") == nil {
+			l.warnf("09", "deletion requests should have \"k\" tags with the kinds being deleted")
+		}
+	},
+	6: func(l *linter) {
+		l.requireTag("18", "e")
+		if l.evt.Tags.Find("p") == nil {
+			l.warnf("18", "reposts should have a \"p\" tag with the author of the reposted event")
+		}
+		if l.evt.Content != "" {
+			var reposted nostr.Event
+			if err := json.Unmarshal([]byte(l.evt.Content), &reposted); err != nil {
+				l.errorf("18", "content must be empty or the stringified reposted event")
+			} else if reposted.Kind != 1 {
+				l.warnf("18", "reposts of kinds other than 1 should use kind 16")
+			}
+		}
+	},
+	7: func(l *linter) {
+		l.requireTag("25", "e")
+		if l.evt.Tags.Find("p") == nil {
+			l.warnf("25", "reactions should have a \"p\" tag with the author of the reacted event")
+		}
+		if strings.HasPrefix(l.evt.Content, ":") && strings.HasSuffix(l.evt.Content, ":") && len(l.evt.Content) > 2 {
+			shortcode := l.evt.Content[1 : len(l.evt.Content)-1]
+			if !slices.ContainsFunc(l.evt.Tags, func(tag nostr.Tag) bool {
+				return len(tag) >= 3 && tag[0] == "emoji" && tag[1] == shortcode
+			}) {
+				l.errorf("30", "custom emoji reaction :%s: without a matching \"emoji\" tag", shortcode)
+			}
+		}
+	},
+	nostr.KindReporting: func(l *linter) {
+		l.requireTag("56", "p")
+		for _, tag := range l.evt.Tags {
+			if len(tag) >= 3 && (tag[0] == "p" || tag[0] == "e") && !slices.Contains(reportTypes, tag[2]) {
+				l.warnf("56", "unknown report type '%s'", tag[2])
+			}
+		}
+	},
+	nostr.KindLabel: func(l *linter) {
+		if l.evt.Tags.Find("l") == nil {
+			l.errorf("32", "label events must have at least one \"l\" tag")
+		}
+		if l.evt.Tags.Find("e") == nil && l.evt.Tags.Find("p") == nil && l.evt.Tags.Find("a") == nil &&
+			l.evt.Tags.Find("r") == nil && l.evt.Tags.Find("t") == nil {
+			l.errorf("32", "label events must target something with an \"e\", \"p\", \"a\", \"r\" or \"t\" tag")
+		}
+		for tag := range l.evt.Tags.FindAll("l") {
+			if len(tag) >= 3 && !slices.ContainsFunc(l.evt.Tags, func(t nostr.Tag) bool {
+				return len(t) >= 2 && t[0] == "L" && t[1] == tag[2]
+			}) {
+				l.warnf("32", "label namespace '%s' has no corresponding \"L\" tag", tag[2])
+			}
+		}
+	},
+	9734: func(l *linter) {
+		l.requireTag("57", "p")
+		if l.evt.Tags.Find("relays") == nil {
+			l.errorf("57", "zap requests must have a \"relays\" tag")
+		}
+	},
+	9735: func(l *linter) {
+		l.requireTag("57", "p")
+		l.requireTag("57", "bolt11")
+		if desc := l.requireTag("57", "description"); desc != nil {
+			var zapRequest nostr.Event
+			if err := json.Unmarshal([]byte(desc[1]), &zapRequest); err != nil || zapRequest.Kind != 9734 {
+				l.errorf("57", "\"description\" tag must be the stringified zap request")
+			}
+		}
+	},
+	nostr.KindHighlights: func(l *linter) {
+		if l.evt.Tags.Find("r") == nil && l.evt.Tags.Find("e") == nil && l.evt.Tags.Find("a") == nil {
+			l.warnf("84", "highlights should point to their source with an \"r\", \"e\" or \"a\" tag")
+		}
+	},
+	10002: func(l *linter) {
+		if l.evt.Tags.Find("r") == nil {
+			l.warnf("65", "relay list has no \"r\" tags")
+		}
+		for tag := range l.evt.Tags.FindAll("r") {
+			if !nostr.IsValidRelayURL(tag[1]) {
+				l.errorf("65", "invalid relay url '%s'", tag[1])
+			}
+			if len(tag) >= 3 && tag[2] != "read" && tag[2] != "write" {
+				l.errorf("65", "relay marker must be \"read\" or \"write\", got '%s'", tag[2])
+			}
+		}
+	},
+	nostr.KindArticle: func(l *linter) {
+		if l.evt.Tags.Find("title") == nil {
+			l.warnf("23", "articles should have a \"title\" tag")
+		}
+		l.checkTimestampTag("23", "published_at")
+	},
+	nostr.KindUserStatuses: func(l *linter) {
+		if d := l.evt.Tags.GetD(); d != "" && d != "general" && d != "music" {
+			l.warnf("38", "unknown status type '%s', clients usually only display \"general\" and \"music\"", d)
+		}
+	},
+	nostr.KindClassifiedListing: func(l *linter) {
+		l.requireTag("99", "title")
+		if price := l.evt.Tags.Find("price"); price != nil {
+			if _, err := strconv.ParseFloat(price[1], 64); err != nil {
+				l.errorf("99", "price amount must be a number, got '%s'", price[1])
+			}
+			if len(price) < 3 || len(price[2]) != 3 {
+				l.warnf("99", "price should have an ISO 4217 currency code")
+			}
+		}
+		l.checkTimestampTag("99", "published_at")
+	},
+	31922: func(l *linter) {
+		l.requireTag("52", "title")
+		for _, name := range []string{"start", "end"} {
+			if tag := l.evt.Tags.Find(name); tag != nil {
+				if _, err := time.Parse(time.DateOnly, tag[1]); err != nil {
+					l.errorf("52", "\"%s\" of date-based calendar events must be YYYY-MM-DD, got '%s'", name, tag[1])
+				}
+			} else if name == "start" {
+				l.errorf("52", "missing \"start\" tag")
+			}
+		}
+	},
+	31923: func(l *linter) {
+		l.requireTag("52", "title")
+		l.requireTag("52", "start")
+		l.checkTimestampTag("52", "start")
+		l.checkTimestampTag("52", "end")
+	},
+	KindPoll: func(l *linter) {
+		options := pollOptions(l.evt)
+		if len(options) < 2 {
+			l.errorf("88", "polls must have at least two \"option\" tags with an id and a label")
+		}
+		if pt := l.evt.Tags.Find("polltype"); pt != nil && pt[1] != "singlechoice" && pt[1] != "multiplechoice" {
+			l.errorf("88", "\"polltype\" must be \"singlechoice\" or \"multiplechoice\", got '%s'", pt[1])
+		}
+		l.checkTimestampTag("88", "endsAt")
+	},
+	KindPollResponse: func(l *linter) {
+		l.requireTag("88", "e")
+		l.requireTag("88", "response")
+	},
+	KindRelayDiscovery: func(l *linter) {
+		if d := l.evt.Tags.GetD(); d != "" && !nostr.IsValidRelayURL(d) {
+			l.errorf("66", "\"d\" tag must be the relay url, got '%s'", d)
+		}
+	},
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"github.com/urfave/cli/v3"
+)
+
+var listing = &cli.Command{
+	Name:  "listing",
+	Usage: "creates and searches nip99 classified listings (kind 30402)",
+	Description: `examples:
+		nak listing create --title "used bike" --price "100 USD" --image https://example.com/bike.jpg --location "Lisbon" -t bikes wss://relay.example.com
+		nak listing create --title "room for rent" --price "900 EUR month" --summary "nice and quiet"
+		nak listing search -t bikes --search "mountain" wss://relay.example.com`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "create",
+			Usage:                     "creates a classified listing",
+			ArgsUsage:                 "[relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(defaultKeyFlags,
+				relayFlag,
+				&cli.StringFlag{
+					Name:     "title",
+					Usage:    "title of the listing",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "price",
+					Usage: "price as \"<amount> <currency> [frequency]\", like \"100 USD\", \"50000 SAT\" or \"900 EUR month\"",
+				},
+				&cli.StringFlag{
+					Name:    "content",
+					Aliases: []string{"c"},
+					Usage:   "the listing description, in markdown",
+				},
+				&cli.StringFlag{
+					Name:  "summary",
+					Usage: "short tagline for the listing",
+				},
+				&cli.StringSliceFlag{
+					Name:  "image",
+					Usage: "url of an image of the item, can be given multiple times",
+				},
+				&cli.StringFlag{
+					Name:  "location",
+					Usage: "where the item or service is",
+				},
+				&cli.StringFlag{
+					Name:  "geohash",
+					Usage: "geohash of the location",
+				},
+				&cli.StringSliceFlag{
+					Name:    "hashtag",
+					Aliases: []string{"t"},
+					Usage:   "categories of the listing, can be given multiple times",
+				},
+				&cli.StringFlag{
+					Name:  "status",
+					Usage: "active or sold",
+					Value: "active",
+				},
+				&cli.StringFlag{
+					Name:    "identifier",
+					Aliases: []string{"d"},
+					Usage:   "the \"d\" tag of the listing, reuse it to update an existing listing",
+				},
+				&cli.BoolFlag{
+					Name:  "draft",
+					Usage: "create a draft listing (kind 30403)",
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				d := c.String("identifier")
+				if d == "" {
+					d = randString(12)
+				}
+
+				evt := nostr.Event{
+					Kind:      nostr.KindClassifiedListing,
+					Content:   c.String("content"),
+					CreatedAt: nostr.Now(),
+					Tags: nostr.Tags{
+						{"d", d},
+						{"title", c.String("title")},
+						{"published_at", strconv.FormatInt(int64(nostr.Now()), 10)},
+					},
+				}
+				if c.Bool("draft") {
+					evt.Kind = nostr.KindDraftClassifiedListing
+				}
+
+				if summary := c.String("summary"); summary != "" {
+					evt.Tags = append(evt.Tags, nostr.Tag{"summary", summary})
+				}
+				if price := c.String("price"); price != "" {
+					spl := strings.Fields(price)
+					if len(spl) < 2 || len(spl) > 3 {
+						return fmt.Errorf("invalid --price '%s', expected \"<amount> <currency> [frequency]\"", price)
+					}
+					if _, err := strconv.ParseFloat(spl[0], 64); err != nil {
+						return fmt.Errorf("invalid price amount '%s'", spl[0])
+					}
+					spl[1] = strings.ToUpper(spl[1])
+					evt.Tags = append(evt.Tags, append(nostr.Tag{"price"}, spl...))
+				}
+				for _, image := range c.StringSlice("image") {
+					evt.Tags = append(evt.Tags, nostr.Tag{"image", image})
+				}
+				if location := c.String("location"); location != "" {
+					evt.Tags = append(evt.Tags, nostr.Tag{"location", location})
+				}
+				if geohash := c.String("geohash"); geohash != "" {
+					evt.Tags = append(evt.Tags, nostr.Tag{"g", geohash})
+				}
+				for _, t := range c.StringSlice("hashtag") {
+					evt.Tags = append(evt.Tags, nostr.Tag{"t", strings.ToLower(t)})
+				}
+				if status := c.String("status"); status != "active" && status != "sold" {
+					return fmt.Errorf("invalid --status '%s', expected active or sold", status)
+				} else {
+					evt.Tags = append(evt.Tags, nostr.Tag{"status", status})
+				}
+
+				return signPrintAndPublish(ctx, c, evt, c.Args().Slice())
+			},
+		},
+		{
+			Name:                      "search",
+			Usage:                     "searches for classified listings and displays them as a table",
+			ArgsUsage:                 "[relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&PubKeySliceFlag{
+					Name:    "author",
+					Aliases: []string{"a"},
+					Usage:   "only listings from these authors",
+				},
+				&cli.StringSliceFlag{
+					Name:    "hashtag",
+					Aliases: []string{"t"},
+					Usage:   "only listings with these categories",
+				},
+				&cli.StringSliceFlag{
+					Name:  "geohash",
+					Usage: "only listings with these geohashes",
+				},
+				&cli.StringFlag{
+					Name:  "search",
+					Usage: "a nip50 search query, use it only with relays that explicitly support it",
+				},
+				&cli.UintFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "maximum number of listings to fetch from each relay",
+					Value:   50,
+				},
+				&cli.BoolFlag{
+					Name:  "include-sold",
+					Usage: "also show listings marked as sold",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the listing events as json instead of a table",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				filter := nostr.Filter{
+					Kinds:   []nostr.Kind{nostr.KindClassifiedListing},
+					Authors: getPubKeySlice(c, "author"),
+					Search:  c.String("search"),
+					Limit:   int(c.Uint("limit")),
+				}
+				if ts := c.StringSlice("hashtag"); len(ts) > 0 {
+					filter.Tags = nostr.TagMap{"t": ts}
+				}
+				if gs := c.StringSlice("geohash"); len(gs) > 0 {
+					if filter.Tags == nil {
+						filter.Tags = nostr.TagMap{}
+					}
+					filter.Tags["g"] = gs
+				}
+
+				relays := getRelayURLs(c, c.Args().Slice())
+				if len(relays) == 0 {
+					relays = sys.FallbackRelays.URLs
+				}
+
+				table := &strings.Builder{}
+				w := tabwriter.NewWriter(table, 0, 0, 2, ' ', 0)
+				if !c.Bool("json") {
+					fmt.Fprintln(w, "TITLE\tPRICE\tLOCATION\tSELLER\tADDRESS")
+				}
+
+				results := sys.Pool.FetchManyReplaceable(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-listing"})
+				for _, evt := range results.Range {
+					if status := evt.Tags.Find("status"); status != nil && status[1] == "sold" && !c.Bool("include-sold") {
+						continue
+					}
+
+					if c.Bool("json") {
+						stdout(evt)
+						continue
+					}
+
+					title := ""
+					if t := evt.Tags.Find("title"); t != nil {
+						title = t[1]
+					}
+					if len(title) > 40 {
+						title = title[0:39] + "…"
+					}
+					price := ""
+					if p := evt.Tags.Find("price"); p != nil {
+						price = strings.Join(p[1:], " ")
+					}
+					location := ""
+					if l := evt.Tags.Find("location"); l != nil {
+						location = l[1]
+					}
+					npub := nip19.EncodeNpub(evt.PubKey)
+
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+						title, price, location, npub[0:12]+"…",
+						nip19.EncodeNaddr(evt.PubKey, evt.Kind, evt.Tags.GetD(), nil),
+					)
+				}
+
+				if !c.Bool("json") {
+					w.Flush()
+					stdout(strings.TrimSuffix(table.String(), "\n"))
+				}
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip05"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var lud16 = &cli.Command{
+	Name:                      "lud16",
+	Usage:                     "lightning address (lud16) tools",
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:  "check",
+			Usage: "checks if a lightning address is correctly set up for receiving zaps",
+			Description: `fetches the lnurl-pay metadata of the address, checks that it allows nostr zaps, requests a test invoice with a zap request signed with the given key (nothing is paid) and validates the invoice amount and description hash.
+
+example:
+		nak lud16 check fiatjaf@fiatjaf.com
+		nak lud16 check --amount 21 --pubkey npub1... name@walletofsatoshi.com`,
+			ArgsUsage:                 "<name@domain>",
+			DisableSliceFlagSeparator: true,
+			Flags: append(slices.Clip(defaultKeyFlags),
+				&cli.UintFlag{
+					Name:        "amount",
+					Usage:       "amount in sats of the test invoice",
+					DefaultText: "the minimum accepted by the server",
+				},
+				&PubKeyFlag{
+					Name:        "pubkey",
+					Usage:       "pubkey of the zap recipient, used in the 'p' tag of the zap request",
+					DefaultText: "the pubkey from the nip05 with the same address",
+				},
+				&cli.StringSliceFlag{
+					Name:  "relay",
+					Usage: "relays to put in the zap request",
+					Value: []string{"wss://relay.damus.io", "wss://nos.lol"},
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				address := c.Args().First()
+				name, domain, ok := strings.Cut(address, "@")
+				if !ok || name == "" || !strings.Contains(domain, ".") {
+					return fmt.Errorf("'%s' is not a lightning address like name@domain.com", address)
+				}
+
+				nfailures := 0
+				pass := func(msg string, args ...any) {
+					stdout(colors.success("✓ ") + fmt.Sprintf(msg, args...))
+				}
+				fail := func(msg string, args ...any) {
+					nfailures++
+					stdout(colors.error("✗ ") + fmt.Sprintf(msg, args...))
+				}
+				warn := func(msg string, args ...any) {
+					stdout(color.YellowString("! ") + fmt.Sprintf(msg, args...))
+				}
+
+				// lnurl-pay metadata
+				scheme := "https"
+				if strings.HasSuffix(domain, ".onion") || strings.HasPrefix(domain, "localhost") {
+					scheme = "http"
+				}
+				lnurlpURL := fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, name)
+
+				var params struct {
+					Tag            string `json:"tag"`
+					Callback       string `json:"callback"`
+					MinSendable    uint64 `json:"minSendable"`
+					MaxSendable    uint64 `json:"maxSendable"`
+					Metadata       string `json:"metadata"`
+					AllowsNostr    bool   `json:"allowsNostr"`
+					NostrPubkey    string `json:"nostrPubkey"`
+					CommentAllowed int    `json:"commentAllowed"`
+					Status         string `json:"status"`
+					Reason         string `json:"reason"`
+				}
+				if err := lnurlGetJSON(ctx, lnurlpURL, &params); err != nil {
+					fail("fetching %s: %s", lnurlpURL, err)
+					return fmt.Errorf("lightning address check failed")
+				}
+				if params.Status == "ERROR" {
+					fail("server returned an error: %s", params.Reason)
+					return fmt.Errorf("lightning address check failed")
+				}
+				pass("fetched lnurl-pay metadata from %s", lnurlpURL)
+
+				if params.Tag == "payRequest" {
+					pass("tag is 'payRequest'")
+				} else {
+					fail("tag should be 'payRequest', got '%s'", params.Tag)
+				}
+
+				callback, err := url.Parse(params.Callback)
+				if err != nil || (callback.Scheme != "https" && callback.Scheme != "http") {
+					fail("invalid callback url '%s'", params.Callback)
+					return fmt.Errorf("lightning address check failed")
+				}
+				pass("callback is %s", params.Callback)
+
+				if params.MinSendable == 0 || params.MinSendable > params.MaxSendable {
+					fail("invalid sendable range: min %d msat, max %d msat", params.MinSendable, params.MaxSendable)
+				} else {
+					pass("accepts between %d and %d sats", params.MinSendable/1000, params.MaxSendable/1000)
+				}
+
+				var metadata [][]string
+				if err := json.Unmarshal([]byte(params.Metadata), &metadata); err != nil {
+					fail("metadata is not a json array of arrays: %s", err)
+				} else if !slices.ContainsFunc(metadata, func(entry []string) bool {
+					return len(entry) == 2 && entry[0] == "text/plain"
+				}) {
+					fail("metadata doesn't have a text/plain entry")
+				} else {
+					pass("metadata has a text/plain description")
+				}
+
+				if params.AllowsNostr {
+					pass("allowsNostr is true")
+				} else {
+					fail("allowsNostr is not true, zaps are not supported")
+				}
+
+				var nostrPubkey nostr.PubKey
+				if params.NostrPubkey == "" {
+					fail("nostrPubkey is missing, zap receipts can't be verified")
+				} else if pk, err := nostr.PubKeyFromHex(params.NostrPubkey); err != nil {
+					fail("nostrPubkey '%s' is not a valid hex pubkey", params.NostrPubkey)
+				} else {
+					nostrPubkey = pk
+					pass("zap receipts will be signed by %s", pk.Hex())
+				}
+
+				// test invoice with a zap request
+				amount := c.Uint("amount") * 1000
+				if amount == 0 {
+					amount = max(params.MinSendable, 1000)
+				}
+				if amount < params.MinSendable || amount > params.MaxSendable {
+					warn("the amount of %d sats is out of the accepted range, the server will probably refuse it", amount/1000)
+				}
+
+				recipient := getPubKey(c, "pubkey")
+				if recipient == nostr.ZeroPK {
+					if pp, err := nip05.QueryIdentifier(ctx, address); err == nil {
+						recipient = pp.PublicKey
+					} else if nostrPubkey != nostr.ZeroPK {
+						warn("no --pubkey given and no nip05 found for %s, using the nostrPubkey as recipient", address)
+						recipient = nostrPubkey
+					} else {
+						return fmt.Errorf("couldn't determine the recipient pubkey, use --pubkey")
+					}
+				}
+
+				bits, _ := bech32.ConvertBits([]byte(lnurlpURL), 8, 5, true)
+				lnurl, _ := bech32.Encode("lnurl", bits)
+
+				kr, _, err := gatherKeyerFromArguments(ctx, c)
+				if err != nil {
+					return err
+				}
+				zapRequest := nostr.Event{
+					Kind:      9734,
+					CreatedAt: nostr.Now(),
+					Tags: nostr.Tags{
+						append(nostr.Tag{"relays"}, c.StringSlice("relay")...),
+						{"amount", strconv.FormatUint(amount, 10)},
+						{"lnurl", lnurl},
+						{"p", recipient.Hex()},
+					},
+				}
+				if err := kr.SignEvent(ctx, &zapRequest); err != nil {
+					return fmt.Errorf("failed to sign zap request: %w", err)
+				}
+				zapRequestJSON := zapRequest.String()
+
+				q := callback.Query()
+				q.Set("amount", strconv.FormatUint(amount, 10))
+				if params.AllowsNostr {
+					q.Set("nostr", zapRequestJSON)
+					q.Set("lnurl", lnurl)
+				}
+				callback.RawQuery = q.Encode()
+
+				var invoiceResp struct {
+					PR     string `json:"pr"`
+					Status string `json:"status"`
+					Reason string `json:"reason"`
+				}
+				if err := lnurlGetJSON(ctx, callback.String(), &invoiceResp); err != nil {
+					fail("requesting invoice: %s", err)
+					return fmt.Errorf("lightning address check failed")
+				}
+				if invoiceResp.Status == "ERROR" || invoiceResp.PR == "" {
+					fail("server refused to give an invoice: %s", invoiceResp.Reason)
+					return fmt.Errorf("lightning address check failed")
+				}
+				pass("got an invoice for %d sats", amount/1000)
+
+				inv, err := decodeBolt11(invoiceResp.PR)
+				if err != nil {
+					fail("invalid invoice: %s", err)
+					return fmt.Errorf("lightning address check failed")
+				}
+
+				if inv.AmountMsat == amount {
+					pass("invoice amount matches")
+				} else {
+					fail("invoice amount is %d msat, expected %d msat", inv.AmountMsat, amount)
+				}
+
+				zapHash := sha256.Sum256([]byte(zapRequestJSON))
+				metadataHash := sha256.Sum256([]byte(params.Metadata))
+				switch inv.DescriptionHash {
+				case hex.EncodeToString(zapHash[:]):
+					pass("invoice description hash commits to the zap request")
+				case hex.EncodeToString(metadataHash[:]):
+					if params.AllowsNostr {
+						fail("invoice description hash commits to the lnurl metadata instead of the zap request")
+					} else {
+						pass("invoice description hash commits to the lnurl metadata")
+					}
+				case "":
+					fail("invoice has no description hash")
+				default:
+					fail("invoice description hash %s doesn't match the zap request or the metadata", inv.DescriptionHash)
+				}
+
+				logverbose("invoice: %s\n", invoiceResp.PR)
+
+				if nfailures > 0 {
+					return fmt.Errorf("%d checks failed", nfailures)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+func lnurlGetJSON(ctx context.Context, u string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("got status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("invalid json response: %w", err)
+	}
+	return nil
+}
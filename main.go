@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"net/http"
-	"net/textproto"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/sdk"
@@ -54,19 +54,46 @@ var app = &cli.Command{
 		nip,
 		syncCmd,
 		spell,
+		label,
+		labels,
+		report,
+		reports,
+		statusCmd,
+		calendar,
+		listing,
+		article,
+		highlight,
+		poll,
+		monitor,
+		lint,
+		gen,
+		fixture,
+		searchCmd,
+		sign,
+		musigCmd,
+		record,
+		replay,
+		archive,
+		feed,
+		inbox,
+		torrent,
+		gateway,
+		serveNip05,
+		lud16,
+		daemon,
+		media,
+		tui,
+		analytics,
+		dupes,
+		runCmd,
+		diffRelays,
 	},
 	Version: version,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:   "config-path",
 			Hidden: true,
-			Value: (func() string {
-				if home, err := os.UserHomeDir(); err == nil {
-					return filepath.Join(home, ".config/nak")
-				} else {
-					return ""
-				}
-			})(),
+			Value:  defaultConfigPath(),
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -83,6 +110,57 @@ var app = &cli.Command{
 				return nil
 			},
 		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "how to print multiple results: ndjson (one json per line) or array (a single json array, also works with --stream)",
+			Value: "ndjson",
+			Action: func(ctx context.Context, c *cli.Command, s string) error {
+				switch s {
+				case "ndjson":
+				case "array":
+					setupArrayOutput()
+				default:
+					return fmt.Errorf("invalid --output '%s', expected ndjson or array", s)
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "qr",
+			Usage: "also draw a qr code in the terminal for the npubs, nsecs, ncryptsecs, bunker and nostrconnect uris and lightning invoices printed",
+			Action: func(ctx context.Context, c *cli.Command, b bool) error {
+				if b {
+					setupQROutput()
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:      "qr-png",
+			Usage:     "like --qr, but save the qr code to this png file (more results go to numbered files next to it)",
+			TakesFile: true,
+			Action: func(ctx context.Context, c *cli.Command, path string) error {
+				setupQRPNGOutput(path)
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "no-retry",
+			Usage: "don't try again when connecting or publishing to a relay fails with a transient error (like a dial failure, a timeout or rate-limiting)",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-verify",
+			Usage: "don't verify the signatures of events received from relays, only for trusted pipelines where speed matters",
+		},
+		&cli.StringFlag{
+			Name:      "pidfile",
+			Usage:     "write the process id to this file while running, refuses to start if another nak is running with the pid from the file",
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:  "systemd-notify",
+			Usage: "tell systemd (through $NOTIFY_SOCKET) when the command is ready and when it is stopping, for services with Type=notify",
+		},
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Usage:   "print more stuff than normally",
@@ -96,22 +174,51 @@ var app = &cli.Command{
 				return nil
 			},
 		},
-	},
+	}, slices.Concat(networkFlags, noticeFlags)...),
 	Before: func(ctx context.Context, c *cli.Command) (context.Context, error) {
+		if err := setupNetwork(ctx, c); err != nil {
+			return ctx, err
+		}
+
+		ctx, err := setupService(ctx, c)
+		if err != nil {
+			return ctx, err
+		}
+
 		sys = sdk.NewSystem()
 
 		setupLocalDatabases(c, sys)
 
+		handleNotice, err = setupNotices(c)
+		if err != nil {
+			return ctx, err
+		}
+
 		sys.Pool = nostr.NewPool(nostr.PoolOptions{
 			AuthorKindQueryMiddleware: sys.TrackQueryAttempts,
-			EventMiddleware:           sys.TrackEventHints,
+			EventMiddleware:           trackPoolEvent,
 			RelayOptions: nostr.RelayOptions{
-				RequestHeader: http.Header{textproto.CanonicalMIMEHeaderKey("user-agent"): {"nak/b"}},
+				RequestHeader: relayRequestHeader("nak/b"),
+				NoticeHandler: handleNotice,
 			},
 		})
 
 		return ctx, nil
 	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Present() {
+			return pluginAction(ctx, c)
+		}
+		return cli.ShowAppHelp(c)
+	},
+}
+
+func defaultConfigPath() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config/nak")
+	} else {
+		return ""
+	}
 }
 
 func init() {
@@ -136,11 +243,23 @@ func main() {
 		return
 	}
 
-	if err := app.Run(context.Background(), os.Args); err != nil {
+	// plugins get their flags as they were given, these can't go through our flag parsing
+	if ok, err := dispatchPlugin(context.Background(), app, os.Args); ok {
+		if err != nil {
+			log("%s\n", color.RedString(err.Error()))
+			colors.reset()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := app.Run(context.Background(), os.Args); err != nil && !isShutdownError(err) {
+		finishOutput()
 		if err != nil {
 			log("%s\n", color.RedString(err.Error()))
 		}
 		colors.reset()
 		os.Exit(1)
 	}
+	finishOutput()
 }
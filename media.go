@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/urfave/cli/v3"
+)
+
+var media = &cli.Command{
+	Name:  "media",
+	Usage: "tools for media files referenced in events",
+	Commands: []*cli.Command{
+		{
+			Name:  "check",
+			Usage: "downloads media referenced by an event and checks the sha256, size, dimensions and blurhash it claims",
+			Description: `takes an event (as json, an id, note, nevent or naddr), a profile (npub, nprofile or hex pubkey) or a plain url. for events the media comes from imeta tags or, for kind 1063, from the nip94 tags; for profiles the picture and banner are checked.
+
+prints one json report per media file and exits with an error if any of them failed.
+
+example:
+		nak media check nevent1...
+		nak media check https://example.com/image.jpg`,
+			ArgsUsage:                 "<event|profile|url>...",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "max-size",
+					Usage: "don't download files bigger than this",
+					Value: "50MB",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				maxSize, err := parseByteSize(c.String("max-size"))
+				if err != nil {
+					return err
+				}
+
+				total := 0
+				failed := 0
+				for input := range getStdinLinesOrArguments(c.Args()) {
+					claims, err := mediaClaimsFromInput(ctx, input)
+					if err != nil {
+						ctx = lineProcessingError(ctx, "%s", err)
+						continue
+					}
+					if len(claims) == 0 {
+						log("no media found in %s\n", input)
+						continue
+					}
+
+					for _, claim := range claims {
+						report := checkMedia(ctx, claim, int64(maxSize))
+						total++
+						if report.OK {
+							log("%s %s\n", colors.successf("ok"), claim.URL)
+						} else {
+							failed++
+							log("%s %s\n", colors.errorf("failed"), claim.URL)
+						}
+						j, _ := stdjson.Marshal(report)
+						stdout(string(j))
+					}
+				}
+
+				exitIfLineProcessingError(ctx)
+				if failed > 0 {
+					return fmt.Errorf("%d of %d media files failed the checks", failed, total)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// mediaClaim is what an event says about a media file, zero values mean nothing was said.
+type mediaClaim struct {
+	URL      string
+	SHA256   string
+	Size     int64
+	MimeType string
+	Width    int
+	Height   int
+	Blurhash string
+}
+
+type mediaReport struct {
+	URL      string   `json:"url"`
+	OK       bool     `json:"ok"`
+	Size     int64    `json:"size,omitempty"`
+	SHA256   string   `json:"sha256,omitempty"`
+	MimeType string   `json:"mime_type,omitempty"`
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func mediaClaimsFromInput(ctx context.Context, input string) ([]mediaClaim, error) {
+	input = strings.TrimPrefix(strings.TrimSpace(input), "nostr:")
+
+	if strings.HasPrefix(input, "https://") || strings.HasPrefix(input, "http://") {
+		return []mediaClaim{{URL: input}}, nil
+	}
+
+	var evt nostr.Event
+	if strings.HasPrefix(input, "{") {
+		if err := stdjson.Unmarshal([]byte(input), &evt); err != nil {
+			return nil, fmt.Errorf("invalid event: %w", err)
+		}
+		return mediaClaimsFromEvent(evt), nil
+	}
+
+	if prefix, _, err := nip19.Decode(input); (err == nil && (prefix == "npub" || prefix == "nprofile")) || len(input) == 64 {
+		if pk, err := parsePubKey(input); err == nil {
+			if pm := sys.FetchProfileMetadata(ctx, pk); pm.Event != nil {
+				claims := make([]mediaClaim, 0, 2)
+				if pm.Picture != "" {
+					claims = append(claims, mediaClaim{URL: pm.Picture})
+				}
+				if pm.Banner != "" {
+					claims = append(claims, mediaClaim{URL: pm.Banner})
+				}
+				return claims, nil
+			}
+			if len(input) != 64 {
+				return nil, fmt.Errorf("profile for %s not found", input)
+			}
+			// a hex string may also be an event id
+		}
+	}
+
+	found, _, err := sys.FetchSpecificEventFromInput(ctx, input, sdk.FetchSpecificEventParameters{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch '%s': %w", input, err)
+	}
+	return mediaClaimsFromEvent(*found), nil
+}
+
+// mediaClaimsFromEvent reads the nip94 tags of kind 1063 events and the imeta tags (nip92) of others.
+func mediaClaimsFromEvent(evt nostr.Event) []mediaClaim {
+	parse := func(claim *mediaClaim, key string, value string) {
+		switch key {
+		case "url":
+			claim.URL = value
+		case "x":
+			claim.SHA256 = strings.ToLower(value)
+		case "m":
+			claim.MimeType = value
+		case "size":
+			claim.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "dim":
+			w, h, _ := strings.Cut(value, "x")
+			claim.Width, _ = strconv.Atoi(w)
+			claim.Height, _ = strconv.Atoi(h)
+		case "blurhash":
+			claim.Blurhash = value
+		}
+	}
+
+	var claims []mediaClaim
+	if evt.Kind == 1063 {
+		claim := mediaClaim{}
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 {
+				parse(&claim, tag[0], tag[1])
+			}
+		}
+		if claim.URL != "" {
+			claims = append(claims, claim)
+		}
+		if thumb := evt.Tags.Find("thumb"); thumb != nil {
+			claims = append(claims, mediaClaim{URL: thumb[1]})
+		}
+		return claims
+	}
+
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "imeta" {
+			continue
+		}
+		claim := mediaClaim{}
+		for _, item := range tag[1:] {
+			if key, value, ok := strings.Cut(item, " "); ok {
+				parse(&claim, key, value)
+			}
+		}
+		if claim.URL != "" {
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// downloadMedia gets a file up to maxSize bytes, returning its mime type from the headers or, when
+// the server doesn't say, from the contents.
+func downloadMedia(ctx context.Context, url string, maxSize int64) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download: %w", unwrapAll(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("file is bigger than %s", formatByteSize(uint64(maxSize)))
+	}
+
+	mimeType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
+
+// checkMedia downloads the file and compares it with what was claimed about it.
+func checkMedia(ctx context.Context, claim mediaClaim, maxSize int64) mediaReport {
+	report := mediaReport{URL: claim.URL}
+	problem := func(msg string, args ...any) {
+		report.Problems = append(report.Problems, fmt.Sprintf(msg, args...))
+	}
+
+	data, mimeType, err := downloadMedia(ctx, claim.URL, maxSize)
+	if err != nil {
+		problem("%s", err)
+		return report
+	}
+
+	hash := sha256.Sum256(data)
+	report.SHA256 = hex.EncodeToString(hash[:])
+	report.Size = int64(len(data))
+	report.MimeType = mimeType
+
+	if claim.SHA256 != "" && claim.SHA256 != report.SHA256 {
+		problem("sha256 is %s, but %s was claimed", report.SHA256, claim.SHA256)
+	}
+	if claim.Size != 0 && claim.Size != report.Size {
+		problem("size is %d, but %d was claimed", report.Size, claim.Size)
+	}
+	if claim.MimeType != "" && !strings.EqualFold(claim.MimeType, report.MimeType) {
+		problem("mime type is %s, but %s was claimed", report.MimeType, claim.MimeType)
+	}
+
+	if strings.HasPrefix(report.MimeType, "image/") || claim.Width != 0 || claim.Blurhash != "" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			if claim.Width != 0 || claim.Blurhash != "" {
+				problem("can't decode the image to check dimensions and blurhash: %s", err)
+			}
+		} else {
+			bounds := img.Bounds()
+			report.Width, report.Height = bounds.Dx(), bounds.Dy()
+			if claim.Width != 0 && (claim.Width != report.Width || claim.Height != report.Height) {
+				problem("dimensions are %dx%d, but %dx%d were claimed", report.Width, report.Height, claim.Width, claim.Height)
+			}
+			if claim.Blurhash != "" {
+				if err := checkBlurhash(claim.Blurhash, img); err != nil {
+					problem("%s", err)
+				}
+			}
+		}
+	}
+
+	report.OK = len(report.Problems) == 0
+	return report
+}
+
+const base83Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func decodeBase83(s string) (int, error) {
+	value := 0
+	for _, r := range s {
+		idx := strings.IndexRune(base83Alphabet, r)
+		if idx == -1 {
+			return 0, fmt.Errorf("invalid blurhash character '%c'", r)
+		}
+		value = value*83 + idx
+	}
+	return value, nil
+}
+
+// checkBlurhash validates the blurhash structure and compares its average color with the image's,
+// an exact comparison isn't possible since encoders resize images differently before hashing.
+func checkBlurhash(blurhash string, img image.Image) error {
+	if len(blurhash) < 6 {
+		return fmt.Errorf("blurhash '%s' is too short", blurhash)
+	}
+	sizeFlag, err := decodeBase83(blurhash[0:1])
+	if err != nil {
+		return err
+	}
+	numX, numY := sizeFlag%9+1, sizeFlag/9+1
+	if expected := 4 + 2*numX*numY; len(blurhash) != expected {
+		return fmt.Errorf("blurhash '%s' should have %d characters for %dx%d components", blurhash, expected, numX, numY)
+	}
+	dc, err := decodeBase83(blurhash[2:6])
+	if err != nil {
+		return err
+	}
+	claimed := [3]int{dc >> 16, (dc >> 8) & 255, dc & 255}
+
+	// the average is computed in linear light, like the blurhash dc component
+	var sum [3]float64
+	bounds := img.Bounds()
+	step := max(1, bounds.Dx()*bounds.Dy()/250_000) // no need to look at every pixel of huge images
+	n := 0
+	for i := 0; i < bounds.Dx()*bounds.Dy(); i += step {
+		r, g, b, _ := img.At(bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()).RGBA()
+		sum[0] += srgbToLinear(int(r >> 8))
+		sum[1] += srgbToLinear(int(g >> 8))
+		sum[2] += srgbToLinear(int(b >> 8))
+		n++
+	}
+	var actual [3]int
+	for i := range sum {
+		actual[i] = linearToSRGB(sum[i] / float64(n))
+	}
+
+	for i := range claimed {
+		if diff := claimed[i] - actual[i]; diff > 24 || diff < -24 {
+			return fmt.Errorf("blurhash average color is #%02x%02x%02x, but the image's is #%02x%02x%02x",
+				claimed[0], claimed[1], claimed[2], actual[0], actual[1], actual[2])
+		}
+	}
+	return nil
+}
+
+func srgbToLinear(value int) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// encodeBlurhash computes the blurhash of an image with the given number of components, sampling
+// at most 64x64 pixels since the result is a blur anyway.
+func encodeBlurhash(img image.Image, xComponents int, yComponents int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	sw, sh := min(width, 64), min(height, 64)
+
+	pixels := make([][3]float64, sw*sh)
+	for y := range sh {
+		for x := range sw {
+			r, g, b, _ := img.At(bounds.Min.X+x*width/sw, bounds.Min.Y+y*height/sh).RGBA()
+			pixels[y*sw+x] = [3]float64{srgbToLinear(int(r >> 8)), srgbToLinear(int(g >> 8)), srgbToLinear(int(b >> 8))}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := range yComponents {
+		for i := range xComponents {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := range sh {
+				for x := range sw {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(sw)) * math.Cos(math.Pi*float64(j*y)/float64(sh))
+					for c := range factor {
+						factor[c] += basis * pixels[y*sw+x][c]
+					}
+				}
+			}
+			for c := range factor {
+				factor[c] /= float64(sw * sh)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	hash := &strings.Builder{}
+	encodeBase83(hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, factor := range ac {
+			for _, v := range factor {
+				actualMax = max(actualMax, math.Abs(v))
+			}
+		}
+		quantised := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantised+1) / 166
+		encodeBase83(hash, quantised, 1)
+	} else {
+		encodeBase83(hash, 0, 1)
+	}
+
+	encodeBase83(hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, factor := range ac {
+		var quantised [3]int
+		for c, v := range factor {
+			v /= maxValue
+			quantised[c] = int(max(0, min(18, math.Floor(math.Copysign(math.Sqrt(math.Abs(v)), v)*9+9.5))))
+		}
+		encodeBase83(hash, quantised[0]*19*19+quantised[1]*19+quantised[2], 2)
+	}
+
+	return hash.String()
+}
+
+func encodeBase83(b *strings.Builder, value int, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83Alphabet[digit])
+	}
+}
+
+// addImetaTags downloads the media linked in the content and adds nip92 imeta tags describing each,
+// returns true if any tag was added.
+func addImetaTags(ctx context.Context, evt *nostr.Event) bool {
+	added := false
+	for _, url := range urlRegex.FindAllString(evt.Content, -1) {
+		url = strings.TrimRight(url, ".,;:!?)]}'")
+		if slices.ContainsFunc(evt.Tags, func(tag nostr.Tag) bool {
+			return len(tag) >= 2 && tag[0] == "imeta" && tag[1] == "url "+url
+		}) {
+			continue
+		}
+
+		tag, err := imetaTagForURL(ctx, url)
+		if err != nil {
+			logverbose("not adding imeta for %s: %s\n", url, err)
+			continue
+		}
+		evt.Tags = append(evt.Tags, tag)
+		added = true
+	}
+	return added
+}
+
+func imetaTagForURL(ctx context.Context, url string) (nostr.Tag, error) {
+	// check what it is first so we don't download web pages
+	headCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(headCtx, "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, unwrapAll(err)
+	}
+	resp.Body.Close()
+	if mimeType := resp.Header.Get("Content-Type"); resp.StatusCode < 300 &&
+		!strings.HasPrefix(mimeType, "image/") && !strings.HasPrefix(mimeType, "video/") &&
+		!strings.HasPrefix(mimeType, "audio/") && !strings.HasPrefix(mimeType, "application/octet-stream") {
+		return nil, fmt.Errorf("not media (%s)", mimeType)
+	}
+
+	data, mimeType, err := downloadMedia(ctx, url, 50_000_000)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+
+	tag := nostr.Tag{"imeta", "url " + url, "m " + mimeType, "x " + hex.EncodeToString(hash[:]), "size " + strconv.Itoa(len(data))}
+	if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		bounds := img.Bounds()
+		tag = append(tag,
+			fmt.Sprintf("dim %dx%d", bounds.Dx(), bounds.Dy()),
+			"blurhash "+encodeBlurhash(img, 4, 3),
+		)
+	}
+	logverbose("added imeta for %s\n", url)
+	return tag, nil
+}
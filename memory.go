@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	// how many ids are remembered for deduplication by default, around 25MB
+	defaultDedupCapacity = 250_000
+	minDedupCapacity     = 1_000
+	// an event received again after this long is printed again
+	dedupTTL = 15 * time.Minute
+	// roughly what each remembered id costs, counting the map and the ring entry
+	dedupEntrySize = 100
+)
+
+var maxMemoryFlag = &cli.StringFlag{
+	Name: "max-memory",
+	Usage: "try to keep memory usage under this size, like 200MB or 1GB. duplicate detection is then limited to a window " +
+		"of recent events that shrinks when the limit is approached, so some duplicates may be printed",
+	DefaultText: "unlimited",
+	Validator: func(s string) error {
+		_, err := parseByteSize(s)
+		return err
+	},
+}
+
+// dedupCapacity is shared by all the deduplicators and lowered by the memory watchdog.
+var dedupCapacity atomic.Int64
+
+func init() {
+	dedupCapacity.Store(defaultDedupCapacity)
+}
+
+// streamingDedup remembers the most recent event ids it has seen, forgetting the oldest when it is full or
+// when they are older than dedupTTL, so it can run forever in constant memory.
+type streamingDedup struct {
+	mu   sync.Mutex
+	ids  map[nostr.ID]struct{}
+	ring []dedupEntry
+	head int // oldest entry
+	size int
+}
+
+type dedupEntry struct {
+	id nostr.ID
+	at time.Time
+}
+
+func newStreamingDedup() *streamingDedup {
+	return &streamingDedup{
+		ids:  make(map[nostr.ID]struct{}, 1024),
+		ring: make([]dedupEntry, 1024),
+	}
+}
+
+// check returns true if the id was seen recently, otherwise it is remembered. it matches
+// the signature of nostr.SubscriptionOptions.CheckDuplicate.
+func (d *streamingDedup) check(id nostr.ID, _ string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	capacity := int(dedupCapacity.Load())
+	for d.size > 0 && (d.size >= capacity || now.Sub(d.ring[d.head].at) > dedupTTL) {
+		d.evictOldest()
+	}
+
+	if len(d.ring) > capacity*2 {
+		// the watchdog lowered the capacity
+		d.resize(capacity)
+	}
+
+	if _, ok := d.ids[id]; ok {
+		return true
+	}
+
+	if d.size == len(d.ring) {
+		// the ring only takes as much memory as it needs
+		d.resize(min(len(d.ring)*2, capacity))
+	}
+	d.ring[(d.head+d.size)%len(d.ring)] = dedupEntry{id, now}
+	d.size++
+	d.ids[id] = struct{}{}
+	return false
+}
+
+func (d *streamingDedup) evictOldest() {
+	delete(d.ids, d.ring[d.head].id)
+	d.ring[d.head] = dedupEntry{}
+	d.head = (d.head + 1) % len(d.ring)
+	d.size--
+}
+
+// resize moves the entries to a ring of the given length, which must fit them all.
+func (d *streamingDedup) resize(length int) {
+	ring := make([]dedupEntry, length)
+	for i := range d.size {
+		ring[i] = d.ring[(d.head+i)%len(d.ring)]
+	}
+	if length < len(d.ring) {
+		// maps never give memory back, so when shrinking we need a new one
+		ids := make(map[nostr.ID]struct{}, d.size)
+		for _, entry := range ring[0:d.size] {
+			ids[entry.id] = struct{}{}
+		}
+		d.ids = ids
+	}
+	d.ring = ring
+	d.head = 0
+}
+
+// setupMaxMemory applies --max-memory: it sets a soft limit for the garbage collector, sizes the
+// deduplication window to fit and watches the heap, shrinking the window when it gets close to the limit.
+func setupMaxMemory(ctx context.Context, c *cli.Command) error {
+	if !c.IsSet("max-memory") {
+		return nil
+	}
+	limit, err := parseByteSize(c.String("max-memory"))
+	if err != nil {
+		return err
+	}
+
+	debug.SetMemoryLimit(int64(limit))
+
+	// a quarter of the memory for the ids, the rest is for events in flight and everything else
+	dedupCapacity.Store(max(min(int64(limit/4/dedupEntrySize), defaultDedupCapacity*8), minDedupCapacity))
+	logverbose("remembering up to %d event ids for deduplication\n", dedupCapacity.Load())
+
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+
+		var stats runtime.MemStats
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc < limit*9/10 {
+				continue
+			}
+
+			capacity := dedupCapacity.Load()
+			if capacity <= minDedupCapacity {
+				continue
+			}
+			capacity = max(capacity/2, minDedupCapacity)
+			dedupCapacity.Store(capacity)
+			log("%s\n", color.YellowString("warning: memory usage at %s, only deduplicating the last %d events",
+				formatByteSize(stats.HeapAlloc), capacity))
+			debug.FreeOSMemory()
+		}
+	}()
+
+	return nil
+}
+
+// parseByteSize parses sizes like 512KB, 200MB, 1.5GB or 1GiB, plain numbers are bytes.
+func parseByteSize(input string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(input))
+	multiplier := uint64(1)
+	for _, unit := range []struct {
+		suffix string
+		value  uint64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	} {
+		if rest, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s = strings.TrimSpace(rest)
+			multiplier = unit.value
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected something like 200MB or 1GB", input)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
+
+func formatByteSize(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+var monitor = &cli.Command{
+	Name:  "monitor",
+	Usage: "continuously probes relays and exposes their health as prometheus metrics",
+	Description: `every --interval each relay is probed (websocket connection, nip11 document, a REQ that must be answered with EOSE and, if --write is given, an ephemeral event that must be accepted) and the results are exposed at http://<listen>/metrics in the prometheus text format.
+
+if --publish-to is given the results are also published as nip66 relay discovery events (kind 30166) signed by the given key, which becomes a relay monitor.
+
+example:
+		nak monitor --listen :9100 nos.lol relay.damus.io
+		nak monitor --write --interval 5m --sec <monitor-key> --publish-to wss://relay.nostr.watch wss://my.relay.com`,
+	ArgsUsage:                 "[relay-url...]",
+	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		relayFlag,
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "address where to serve the metrics",
+			Value: ":9100",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "time between probes",
+			Value: time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "how long to wait for each relay before considering it offline",
+			Value: time.Second * 10,
+		},
+		&cli.BoolFlag{
+			Name:  "write",
+			Usage: "also check if each relay accepts writes by publishing an ephemeral event signed by a random key",
+		},
+		&cli.StringSliceFlag{
+			Name:  "publish-to",
+			Usage: "publish nip66 monitoring events (kinds 10166 and 30166) to these relays",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		urls := getRelayURLs(c, c.Args().Slice())
+		if len(urls) == 0 {
+			return fmt.Errorf("specify some relays to monitor")
+		}
+
+		interval := c.Duration("interval")
+		timeout := c.Duration("timeout")
+		checkWrite := c.Bool("write")
+
+		var kr nostr.Keyer
+		publishTo := c.StringSlice("publish-to")
+		if len(publishTo) > 0 {
+			var err error
+			kr, _, err = gatherKeyerFromArguments(ctx, c)
+			if err != nil {
+				return err
+			}
+
+			checks := nostr.Tags{{"c", "open"}, {"c", "read"}}
+			if checkWrite {
+				checks = append(checks, nostr.Tag{"c", "write"})
+			}
+			announcement := nostr.Event{
+				Kind:      KindRelayMonitorAnnouncement,
+				CreatedAt: nostr.Now(),
+				Tags: append(nostr.Tags{
+					{"frequency", strconv.Itoa(int(interval.Seconds()))},
+					{"timeout", "open", strconv.FormatInt(timeout.Milliseconds(), 10)},
+				}, checks...),
+			}
+			if err := kr.SignEvent(ctx, &announcement); err != nil {
+				return fmt.Errorf("failed to sign monitor announcement: %w", err)
+			}
+			for res := range sys.Pool.PublishMany(ctx, publishTo, announcement) {
+				if res.Error != nil {
+					log("failed to publish monitor announcement to %s: %s\n", res.RelayURL, res.Error)
+				}
+			}
+		}
+
+		m := &monitorState{
+			results: make(map[string]*monitorRelayState, len(urls)),
+		}
+		for _, url := range urls {
+			m.results[url] = &monitorRelayState{}
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", m.serveMetrics)
+		server := &http.Server{Addr: c.String("listen"), Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log("%s", colors.errorf("metrics server failed: %s\n", err))
+			}
+		}()
+		log("serving metrics at %s/metrics\n", colors.bold(c.String("listen")))
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			errg := errgroup.Group{}
+			errg.SetLimit(32)
+			for _, url := range urls {
+				errg.Go(func() error {
+					res := pingRelay(ctx, url, timeout)
+					var writeMs int64
+					var writeErr error
+					if checkWrite && res.Online {
+						writeMs, writeErr = probeRelayWrite(ctx, url, timeout)
+					}
+					m.record(url, res, checkWrite, writeMs, writeErr)
+
+					if res.Online {
+						logverbose("%s: online, connect %dms, eose %dms\n", url, res.ConnectMs, res.REQMs)
+					} else {
+						log("%s: %s %s\n", url, colors.error("offline"), res.Error)
+					}
+					if writeErr != nil {
+						log("%s: %s %s\n", url, colors.error("write rejected"), writeErr)
+					}
+
+					if kr != nil && res.Online {
+						publishRelayDiscovery(ctx, kr, publishTo, res, checkWrite && writeErr == nil, writeMs)
+					}
+					return nil
+				})
+			}
+			errg.Wait()
+
+			select {
+			case <-ctx.Done():
+				return server.Shutdown(context.Background())
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+type monitorState struct {
+	mu      sync.Mutex
+	results map[string]*monitorRelayState
+}
+
+type monitorRelayState struct {
+	last          relayPingResult
+	writeChecked  bool
+	writeAccepted bool
+	writeMs       int64
+	probes        int
+	failures      int
+}
+
+func (m *monitorState) record(url string, res relayPingResult, writeChecked bool, writeMs int64, writeErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.results[url]
+	state.last = res
+	state.probes++
+	if !res.Online {
+		state.failures++
+	}
+	state.writeChecked = writeChecked && res.Online
+	state.writeAccepted = writeErr == nil
+	state.writeMs = writeMs
+}
+
+func (m *monitorState) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	urls := make([]string, 0, len(m.results))
+	for url := range m.results {
+		urls = append(urls, url)
+	}
+	slices.Sort(urls)
+
+	out := &strings.Builder{}
+	metric := func(name, typ, help string, value func(*monitorRelayState) (float64, bool)) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, url := range urls {
+			state := m.results[url]
+			if state.probes == 0 {
+				continue
+			}
+			if v, ok := value(state); ok {
+				fmt.Fprintf(out, "%s{relay=%q} %s\n", name, url, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+	b2f := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	metric("nak_relay_up", "gauge", "whether the relay answered the last probe",
+		func(s *monitorRelayState) (float64, bool) { return b2f(s.last.Online), true })
+	metric("nak_relay_connect_seconds", "gauge", "time it took to open the websocket connection",
+		func(s *monitorRelayState) (float64, bool) { return float64(s.last.ConnectMs) / 1000, s.last.Online })
+	metric("nak_relay_eose_seconds", "gauge", "time it took for the relay to answer a REQ with EOSE",
+		func(s *monitorRelayState) (float64, bool) { return float64(s.last.REQMs) / 1000, s.last.Online })
+	metric("nak_relay_nip11_seconds", "gauge", "time it took to fetch the nip11 information document",
+		func(s *monitorRelayState) (float64, bool) { return float64(s.last.NIP11Ms) / 1000, s.last.NIP11Ms > 0 })
+	metric("nak_relay_write_accepted", "gauge", "whether the relay accepted the last ephemeral event",
+		func(s *monitorRelayState) (float64, bool) { return b2f(s.writeAccepted), s.writeChecked })
+	metric("nak_relay_write_seconds", "gauge", "time it took for the relay to accept the last ephemeral event",
+		func(s *monitorRelayState) (float64, bool) {
+			return float64(s.writeMs) / 1000, s.writeChecked && s.writeAccepted
+		})
+	metric("nak_relay_probes_total", "counter", "number of probes performed",
+		func(s *monitorRelayState) (float64, bool) { return float64(s.probes), true })
+	metric("nak_relay_probe_failures_total", "counter", "number of probes in which the relay was offline",
+		func(s *monitorRelayState) (float64, bool) { return float64(s.failures), true })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(out.String()))
+}
+
+// probeRelayWrite publishes an ephemeral event signed by a throwaway key and returns how long it
+// took for the relay to accept it.
+func probeRelayWrite(ctx context.Context, url string, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	r, err := nostr.RelayConnect(ctx, url, nostr.RelayOptions{
+		NoticeHandler: func(*nostr.Relay, string) {},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	evt := nostr.Event{
+		Kind:      20000,
+		CreatedAt: nostr.Now(),
+		Content:   "nak monitor write check",
+	}
+	if err := evt.Sign(nostr.Generate()); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if err := r.Publish(ctx, evt); err != nil {
+		return 0, err
+	}
+	return time.Since(start).Milliseconds(), nil
+}
+
+func publishRelayDiscovery(ctx context.Context, kr nostr.Keyer, publishTo []string, res relayPingResult, writeOk bool, writeMs int64) {
+	network := "clearnet"
+	if strings.Contains(res.URL, ".onion") {
+		network = "tor"
+	}
+
+	evt := nostr.Event{
+		Kind:      KindRelayDiscovery,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"d", res.URL},
+			{"n", network},
+			{"rtt-open", strconv.FormatInt(res.ConnectMs, 10)},
+			{"rtt-read", strconv.FormatInt(res.REQMs, 10)},
+		},
+	}
+	if writeOk {
+		evt.Tags = append(evt.Tags, nostr.Tag{"rtt-write", strconv.FormatInt(writeMs, 10)})
+	}
+	if err := kr.SignEvent(ctx, &evt); err != nil {
+		log("failed to sign discovery event for %s: %s\n", res.URL, err)
+		return
+	}
+
+	for pr := range sys.Pool.PublishMany(ctx, publishTo, evt) {
+		if pr.Error != nil {
+			logverbose("failed to publish discovery event for %s to %s: %s\n", res.URL, pr.RelayURL, pr.Error)
+		}
+	}
+}
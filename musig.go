@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip17"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip59"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/urfave/cli/v3"
+)
+
+// musigSession is passed around between the signers, either as a file or through dms, and
+// accumulates nonces and partial signatures until the event can be signed.
+type musigSession struct {
+	Event       nostr.Event       `json:"event"`
+	Signers     []string          `json:"signers"`
+	Nonces      map[string]string `json:"nonces"`
+	PartialSigs map[string]string `json:"partial_sigs"`
+}
+
+var musigSendFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "send",
+		Usage: "also send the resulting session to the other signers as nip17 dms",
+	},
+	&cli.StringSliceFlag{
+		Name:  "relay",
+		Usage: "relays to send dms to when a signer (or ourselves) doesn't have a dm relay list",
+	},
+}
+
+var musigCmd = &cli.Command{
+	Name:  "musig",
+	Usage: "creates shared keys and collaboratively signs events with them, n-of-n with musig2 or t-of-n with 'threshold'",
+	Description: `with musig2 all signers must take part in every signature, for keys that any t of n participants can sign with see 'nak musig threshold'. the flow is:
+
+  1. each signer runs 'nak musig pubkey' and shares the result;
+  2. anyone can run 'nak musig keygen' with all these to get the shared public key;
+  3. someone starts a signing session from an unsigned event with 'nak musig start';
+  4. each signer adds a nonce to the session with 'nak musig nonce';
+  5. once all nonces are there each signer adds a partial signature with 'nak musig sign';
+  6. once all partial signatures are there anyone can run 'nak musig finish' to get the signed event.
+
+sessions are json objects that can be passed around as files (multiple copies of the same session are merged when given together) or sent through nip17 dms with --send and read with 'nak musig receive'.
+
+example:
+		nak musig keygen 02abc... 03def... 02fed...
+		nak event --unsigned -c 'hello from all of us' | nak musig start --signer 02abc... --signer 03def... > session.json
+		nak musig nonce --sec <key-a> session.json > a.json
+		nak musig nonce --sec <key-b> session.json > b.json
+		nak musig sign --sec <key-a> a.json b.json > a2.json
+		nak musig sign --sec <key-b> a.json b.json > b2.json
+		nak musig finish a2.json b2.json | nak event relay.damus.io`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:  "pubkey",
+			Usage: "prints the public key with the parity byte, in the format used to identify musig signers",
+			Flags: defaultKeyFlags,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				sec, _, err := gatherSecretKeyOrBunkerFromArguments(ctx, c)
+				if err != nil {
+					return err
+				}
+				if sec == [32]byte{} {
+					return fmt.Errorf("musig requires a local secret key")
+				}
+				_, pub := btcec.PrivKeyFromBytes(sec[:])
+				stdout(hex.EncodeToString(pub.SerializeCompressed()))
+				return nil
+			},
+		},
+		{
+			Name:      "keygen",
+			Usage:     "computes the shared public key from the public keys of all signers",
+			ArgsUsage: "<signer-pubkey>...",
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 2 {
+					return fmt.Errorf("at least 2 signer pubkeys are needed")
+				}
+				pk, err := getMusigAggregatedKey(ctx, c.Args().Slice())
+				if err != nil {
+					return err
+				}
+				stdout(pk.Hex())
+				log("%s\n", nip19.EncodeNpub(pk))
+				return nil
+			},
+		},
+		{
+			Name:                      "start",
+			Usage:                     "starts a signing session from an unsigned event read from stdin",
+			DisableSliceFlagSeparator: true,
+			Flags: append([]cli.Flag{
+				&cli.StringSliceFlag{
+					Name:     "signer",
+					Usage:    "public key (with the parity byte) of each signer",
+					Required: true,
+				},
+			}, append(slices.Clip(defaultKeyFlags), musigSendFlags...)...),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				signers := c.StringSlice("signer")
+				if len(signers) < 2 {
+					return fmt.Errorf("at least 2 signers are needed")
+				}
+				aggpk, err := getMusigAggregatedKey(ctx, signers)
+				if err != nil {
+					return err
+				}
+
+				for stdinEvent := range getJsonsOrBlank() {
+					if stdinEvent == "{}" {
+						return fmt.Errorf("an unsigned event must be given on stdin")
+					}
+
+					var evt nostr.Event
+					if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+						ctx = lineProcessingError(ctx, "invalid event: %s", err)
+						continue
+					}
+					if evt.CreatedAt == 0 {
+						evt.CreatedAt = nostr.Now()
+					}
+					if evt.Tags == nil {
+						evt.Tags = nostr.Tags{}
+					}
+					evt.PubKey = aggpk
+					evt.ID = evt.GetID()
+					evt.Sig = [64]byte{}
+
+					session := &musigSession{
+						Event:       evt,
+						Signers:     signers,
+						Nonces:      make(map[string]string),
+						PartialSigs: make(map[string]string),
+					}
+					if err := outputMusigSession(ctx, c, session); err != nil {
+						return err
+					}
+				}
+
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:                      "nonce",
+			Usage:                     "adds our nonce to a session",
+			Description:               `the secret part of the nonce is stored in the config directory and deleted after signing. never reuse nonces.`,
+			ArgsUsage:                 "[session-file...]",
+			DisableSliceFlagSeparator: true,
+			Flags:                     append(slices.Clip(defaultKeyFlags), musigSendFlags...),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				session, err := readMusigSession(c)
+				if err != nil {
+					return err
+				}
+				seck, pubk, err := musigOurKey(ctx, c, session)
+				if err != nil {
+					return err
+				}
+				ourpk := hex.EncodeToString(pubk.SerializeCompressed())
+
+				if _, ok := session.Nonces[ourpk]; ok {
+					return fmt.Errorf("our nonce is already in this session")
+				}
+
+				nonces, err := musig2.GenNonces(
+					musig2.WithPublicKey(pubk),
+					musig2.WithNonceSecretKeyAux(seck),
+					musig2.WithNonceMessageAux(session.Event.ID),
+				)
+				if err != nil {
+					return fmt.Errorf("failed to generate nonce: %w", err)
+				}
+
+				noncePath := musigNoncePath(c, session, ourpk)
+				os.MkdirAll(filepath.Dir(noncePath), 0700)
+				if err := os.WriteFile(noncePath, nonces.SecNonce[:], 0600); err != nil {
+					return fmt.Errorf("failed to store secret nonce: %w", err)
+				}
+
+				session.Nonces[ourpk] = hex.EncodeToString(nonces.PubNonce[:])
+				log("added our nonce, %d of %d\n", len(session.Nonces), len(session.Signers))
+				return outputMusigSession(ctx, c, session)
+			},
+		},
+		{
+			Name:                      "sign",
+			Usage:                     "adds our partial signature to a session that has all the nonces",
+			ArgsUsage:                 "[session-file...]",
+			DisableSliceFlagSeparator: true,
+			Flags:                     append(slices.Clip(defaultKeyFlags), musigSendFlags...),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				session, err := readMusigSession(c)
+				if err != nil {
+					return err
+				}
+				seck, pubk, err := musigOurKey(ctx, c, session)
+				if err != nil {
+					return err
+				}
+				ourpk := hex.EncodeToString(pubk.SerializeCompressed())
+
+				if len(session.Nonces) < len(session.Signers) {
+					return fmt.Errorf("still missing nonces from %d signers", len(session.Signers)-len(session.Nonces))
+				}
+				if _, ok := session.PartialSigs[ourpk]; ok {
+					return fmt.Errorf("our partial signature is already in this session")
+				}
+
+				noncePath := musigNoncePath(c, session, ourpk)
+				secNonceB, err := os.ReadFile(noncePath)
+				if err != nil || len(secNonceB) != musig2.SecNonceSize {
+					return fmt.Errorf("secret nonce for this session not found at '%s', was it created on another machine?", noncePath)
+				}
+				var secNonce [musig2.SecNonceSize]byte
+				copy(secNonce[:], secNonceB)
+
+				keys, _, combinedNonce, err := musigSessionParams(session)
+				if err != nil {
+					return err
+				}
+
+				ps, err := musig2.Sign(secNonce, seck, combinedNonce, keys, session.Event.ID, musig2.WithSortedKeys())
+				if err != nil {
+					return fmt.Errorf("failed to produce partial signature: %w", err)
+				}
+
+				// a nonce must never be used twice
+				os.Remove(noncePath)
+
+				w := &bytes.Buffer{}
+				ps.Encode(w)
+				session.PartialSigs[ourpk] = hex.EncodeToString(w.Bytes())
+				log("added our partial signature, %d of %d\n", len(session.PartialSigs), len(session.Signers))
+				return outputMusigSession(ctx, c, session)
+			},
+		},
+		{
+			Name:      "finish",
+			Usage:     "combines all partial signatures and prints the signed event",
+			ArgsUsage: "[session-file...]",
+			Action: func(ctx context.Context, c *cli.Command) error {
+				session, err := readMusigSession(c)
+				if err != nil {
+					return err
+				}
+				if len(session.PartialSigs) < len(session.Signers) {
+					return fmt.Errorf("still missing partial signatures from %d signers", len(session.Signers)-len(session.PartialSigs))
+				}
+
+				keys, pubNonces, combinedNonce, err := musigSessionParams(session)
+				if err != nil {
+					return err
+				}
+
+				sigs := make([]*musig2.PartialSignature, len(session.Signers))
+				for i, signer := range session.Signers {
+					bps, err := hex.DecodeString(session.PartialSigs[signer])
+					if err != nil {
+						return fmt.Errorf("invalid partial signature from %s: %w", signer, err)
+					}
+					var ps musig2.PartialSignature
+					if err := ps.Decode(bytes.NewBuffer(bps)); err != nil {
+						return fmt.Errorf("invalid partial signature from %s: %w", signer, err)
+					}
+					if !ps.Verify(pubNonces[i], combinedNonce, keys, keys[i], session.Event.ID, musig2.WithSortedKeys()) {
+						return fmt.Errorf("partial signature from %s is invalid", signer)
+					}
+					sigs[i] = &ps
+				}
+
+				aggpk, _, _, err := musig2.AggregateKeys(keys, true)
+				if err != nil {
+					return fmt.Errorf("aggregation failed: %w", err)
+				}
+				r, err := musigFinalNonce(combinedNonce, aggpk.FinalKey, session.Event.ID)
+				if err != nil {
+					return err
+				}
+
+				evt := session.Event
+				evt.Sig = [64]byte(musig2.CombineSigs(r, sigs).Serialize())
+				if !evt.VerifySignature() {
+					return fmt.Errorf("combined signature is invalid")
+				}
+
+				stdout(evt)
+				return nil
+			},
+		},
+		musigThreshold,
+		{
+			Name:  "receive",
+			Usage: "prints musig sessions received as nip17 dms",
+			Flags: append(slices.Clip(defaultKeyFlags),
+				&cli.StringSliceFlag{
+					Name:  "relay",
+					Usage: "relays to read dms from, in addition to our dm relay list",
+				},
+				&NaturalTimeFlag{
+					Name:  "since",
+					Usage: "only dms received after this",
+					Value: nostr.Now() - 60*60*24*7,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				kr, _, err := gatherKeyerFromArguments(ctx, c)
+				if err != nil {
+					return err
+				}
+				us, _ := kr.GetPublicKey(ctx)
+
+				relays := appendUnique(nip17.GetDMRelays(ctx, us, sys.Pool, sys.RelayListRelays.URLs), c.StringSlice("relay")...)
+				if len(relays) == 0 {
+					return fmt.Errorf("we don't have a dm relay list, use --relay")
+				}
+
+				// gift wraps have randomized timestamps up to 2 days in the past
+				since := getNaturalDate(c, "since") - 60*60*24*2
+				for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{
+					Kinds: []nostr.Kind{nostr.KindGiftWrap},
+					Tags:  nostr.TagMap{"p": []string{us.Hex()}},
+					Since: since,
+				}, nostr.SubscriptionOptions{Label: "nak-musig"}) {
+					rumor, err := nip59.GiftUnwrap(ie.Event, func(otherpubkey nostr.PubKey, ciphertext string) (string, error) {
+						return kr.Decrypt(ctx, ciphertext, otherpubkey)
+					})
+					if err != nil {
+						continue
+					}
+
+					var session musigSession
+					if err := json.Unmarshal([]byte(rumor.Content), &session); err != nil || len(session.Signers) == 0 {
+						continue
+					}
+					logverbose("session for event %s from %s\n", session.Event.ID.Hex(), rumor.PubKey.Hex())
+					stdout(rumor.Content)
+				}
+
+				return nil
+			},
+		},
+	},
+}
+
+// readMusigSession reads sessions from the given files or from stdin, merging them all into one.
+func readMusigSession(c *cli.Command) (*musigSession, error) {
+	var jsons []string
+	if c.Args().Len() > 0 {
+		for _, path := range c.Args().Slice() {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read session file: %w", err)
+			}
+			jsons = append(jsons, string(b))
+		}
+	} else {
+		for j := range getJsonsOrBlank() {
+			if j != "{}" {
+				jsons = append(jsons, j)
+			}
+		}
+	}
+	if len(jsons) == 0 {
+		return nil, fmt.Errorf("no session given")
+	}
+
+	var session *musigSession
+	for _, j := range jsons {
+		var s musigSession
+		if err := json.Unmarshal([]byte(j), &s); err != nil {
+			return nil, fmt.Errorf("invalid session: %w", err)
+		}
+		if session == nil {
+			session = &s
+			if session.Nonces == nil {
+				session.Nonces = make(map[string]string)
+			}
+			if session.PartialSigs == nil {
+				session.PartialSigs = make(map[string]string)
+			}
+			continue
+		}
+		if s.Event.ID != session.Event.ID {
+			return nil, fmt.Errorf("can't merge sessions for different events (%s and %s)", session.Event.ID.Hex(), s.Event.ID.Hex())
+		}
+		for k, v := range s.Nonces {
+			session.Nonces[k] = v
+		}
+		for k, v := range s.PartialSigs {
+			session.PartialSigs[k] = v
+		}
+	}
+
+	if session.Event.GetID() != session.Event.ID {
+		return nil, fmt.Errorf("session event id doesn't match its contents")
+	}
+	if aggpk, err := getMusigAggregatedKey(context.Background(), session.Signers); err != nil {
+		return nil, err
+	} else if aggpk != session.Event.PubKey {
+		return nil, fmt.Errorf("session event pubkey doesn't match the signers")
+	}
+
+	return session, nil
+}
+
+func outputMusigSession(ctx context.Context, c *cli.Command, session *musigSession) error {
+	j, _ := json.Marshal(session)
+	stdout(string(j))
+
+	if !c.Bool("send") {
+		return nil
+	}
+
+	kr, _, err := gatherKeyerFromArguments(ctx, c)
+	if err != nil {
+		return err
+	}
+	us, _ := kr.GetPublicKey(ctx)
+	ourRelays := appendUnique(nip17.GetDMRelays(ctx, us, sys.Pool, sys.RelayListRelays.URLs), c.StringSlice("relay")...)
+
+	for _, signer := range session.Signers {
+		b, _ := hex.DecodeString(signer)
+		pk := nostr.PubKey(b[1:])
+		if pk == us {
+			continue
+		}
+
+		theirRelays := appendUnique(nip17.GetDMRelays(ctx, pk, sys.Pool, sys.RelayListRelays.URLs), c.StringSlice("relay")...)
+		if err := nip17.PublishMessage(ctx, string(j), nostr.Tags{}, sys.Pool, ourRelays, theirRelays, kr, pk, nil); err != nil {
+			log("failed to send session to %s: %s\n", nip19.EncodeNpub(pk), err)
+		} else {
+			log("sent session to %s\n", nip19.EncodeNpub(pk))
+		}
+	}
+
+	return nil
+}
+
+func musigOurKey(ctx context.Context, c *cli.Command, session *musigSession) (*btcec.PrivateKey, *btcec.PublicKey, error) {
+	sec, _, err := gatherSecretKeyOrBunkerFromArguments(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sec == [32]byte{} {
+		return nil, nil, fmt.Errorf("musig requires a local secret key")
+	}
+
+	seck, pubk := btcec.PrivKeyFromBytes(sec[:])
+	if !slices.Contains(session.Signers, hex.EncodeToString(pubk.SerializeCompressed())) {
+		return nil, nil, fmt.Errorf("we are not one of the signers of this session")
+	}
+	return seck, pubk, nil
+}
+
+func musigNoncePath(c *cli.Command, session *musigSession, ourpk string) string {
+	return filepath.Join(c.String("config-path"), "musig", session.Event.ID.Hex()+"-"+ourpk)
+}
+
+// musigSessionParams parses the signer keys and public nonces (in the same order as the signers)
+// and aggregates the nonces.
+func musigSessionParams(session *musigSession) (keys []*btcec.PublicKey, pubNonces [][musig2.PubNonceSize]byte, combinedNonce [musig2.PubNonceSize]byte, err error) {
+	keys = make([]*btcec.PublicKey, len(session.Signers))
+	pubNonces = make([][musig2.PubNonceSize]byte, len(session.Signers))
+	for i, signer := range session.Signers {
+		b, err := hex.DecodeString(signer)
+		if err != nil {
+			return nil, nil, combinedNonce, fmt.Errorf("invalid signer '%s': %w", signer, err)
+		}
+		keys[i], err = btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, nil, combinedNonce, fmt.Errorf("invalid signer '%s': %w", signer, err)
+		}
+
+		bn, err := hex.DecodeString(session.Nonces[signer])
+		if err != nil || len(bn) != musig2.PubNonceSize {
+			return nil, nil, combinedNonce, fmt.Errorf("invalid or missing nonce from %s", signer)
+		}
+		copy(pubNonces[i][:], bn)
+	}
+
+	combinedNonce, err = musig2.AggregateNonces(pubNonces)
+	if err != nil {
+		return nil, nil, combinedNonce, fmt.Errorf("failed to aggregate nonces: %w", err)
+	}
+	return keys, pubNonces, combinedNonce, nil
+}
+
+// musigFinalNonce computes R = R1 + b*R2 like the signers did, so anyone can combine the signatures.
+func musigFinalNonce(combinedNonce [musig2.PubNonceSize]byte, aggpk *btcec.PublicKey, msg [32]byte) (*btcec.PublicKey, error) {
+	tag := sha256.Sum256(musig2.NonceBlindTag)
+	h := sha256.New()
+	h.Write(tag[:])
+	h.Write(tag[:])
+	h.Write(combinedNonce[:])
+	h.Write(aggpk.SerializeCompressed()[1:])
+	h.Write(msg[:])
+	var b btcec.ModNScalar
+	b.SetByteSlice(h.Sum(nil))
+
+	r1, err := btcec.ParseJacobian(combinedNonce[:btcec.PubKeyBytesLenCompressed])
+	if err != nil {
+		return nil, fmt.Errorf("invalid combined nonce: %w", err)
+	}
+	r2, err := btcec.ParseJacobian(combinedNonce[btcec.PubKeyBytesLenCompressed:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid combined nonce: %w", err)
+	}
+
+	var r btcec.JacobianPoint
+	btcec.ScalarMultNonConst(&b, &r2, &r2)
+	btcec.AddNonConst(&r1, &r2, &r)
+	if (r.X.IsZero() && r.Y.IsZero()) || r.Z.IsZero() {
+		btcec.GeneratorJacobian(&r)
+	}
+	r.ToAffine()
+
+	return btcec.NewPublicKey(&r.X, &r.Y), nil
+}
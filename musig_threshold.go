@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/urfave/cli/v3"
+)
+
+// thresholdShare is what each participant keeps: its secret share of the key and the public shares
+// of everybody, which are needed to check the partial signatures of the others.
+type thresholdShare struct {
+	PubKey             nostr.PubKey `json:"pubkey"`
+	Threshold          int          `json:"threshold"`
+	Index              int          `json:"index"`
+	Secret             string       `json:"secret"`
+	VerificationShares []string     `json:"verification_shares"`
+}
+
+// thresholdSession is like musigSession, but participants are identified by the index of their share.
+type thresholdSession struct {
+	Event              nostr.Event    `json:"event"`
+	Threshold          int            `json:"threshold"`
+	VerificationShares []string       `json:"verification_shares"`
+	Participants       []int          `json:"participants,omitempty"`
+	Nonces             map[int]string `json:"nonces"`
+	PartialSigs        map[int]string `json:"partial_sigs"`
+}
+
+var thresholdShareFlag = &cli.StringFlag{
+	Name:     "share",
+	Usage:    "file with our share of the key, as output by 'nak musig threshold split'",
+	Required: true,
+}
+
+var musigThreshold = &cli.Command{
+	Name:  "threshold",
+	Usage: "splits a key into t-of-n shares and signs events with any t of them using frost (rfc9591, with bip340 signatures)",
+	Description: `the key is split once by someone who has it (a trusted dealer), then each share is given to a participant and the original key should be deleted. after that any 'threshold' participants can sign events together and the result is a normal signature from the original key. the flow is:
+
+  1. 'nak musig threshold split' prints one share per participant;
+  2. someone starts a signing session from an unsigned event with 'nak musig threshold start';
+  3. at least 'threshold' participants add a nonce to the session with 'nak musig threshold nonce';
+  4. each of them adds a partial signature with 'nak musig threshold sign', the first one to sign fixes who the participants are;
+  5. once all partial signatures are there anyone can run 'nak musig threshold finish' to get the signed event.
+
+sessions are json objects passed around as files, multiple copies of the same session are merged when given together.
+
+example:
+		nak musig threshold split --threshold 2 --shares 3 --sec <key> | split -l 1 - share-
+		nak event --unsigned -c 'hello from 2 of us' | nak musig threshold start --share share-aa > session.json
+		nak musig threshold nonce --share share-aa session.json > a.json
+		nak musig threshold nonce --share share-ac session.json > c.json
+		nak musig threshold sign --share share-aa a.json c.json > a2.json
+		nak musig threshold sign --share share-ac a.json c.json > c2.json
+		nak musig threshold finish a2.json c2.json | nak event relay.damus.io`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:  "split",
+			Usage: "splits a secret key into shares, any 'threshold' of which can sign for it",
+			Flags: append(slices.Clip(defaultKeyFlags),
+				&cli.IntFlag{
+					Name:     "threshold",
+					Usage:    "how many shares are needed to sign",
+					Required: true,
+				},
+				&cli.IntFlag{
+					Name:     "shares",
+					Usage:    "how many shares to create",
+					Required: true,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				threshold, n := int(c.Int("threshold")), int(c.Int("shares"))
+				if threshold < 2 || threshold > n || n > 255 {
+					return fmt.Errorf("need 2 <= threshold <= shares <= 255")
+				}
+
+				sec, _, err := gatherSecretKeyOrBunkerFromArguments(ctx, c)
+				if err != nil {
+					return err
+				}
+				if sec == [32]byte{} {
+					return fmt.Errorf("splitting requires a local secret key")
+				}
+
+				shares, err := splitThresholdKey(sec, threshold, n)
+				if err != nil {
+					return err
+				}
+				for _, share := range shares {
+					j, _ := json.Marshal(share)
+					stdout(string(j))
+				}
+				log("split %s into %d shares, %d of them are needed to sign\n", nip19.EncodeNpub(shares[0].PubKey), n, threshold)
+				return nil
+			},
+		},
+		{
+			Name:                      "start",
+			Usage:                     "starts a signing session from an unsigned event read from stdin",
+			DisableSliceFlagSeparator: true,
+			Flags:                     []cli.Flag{thresholdShareFlag},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				share, _, err := readThresholdShare(c.String("share"))
+				if err != nil {
+					return err
+				}
+
+				for stdinEvent := range getJsonsOrBlank() {
+					if stdinEvent == "{}" {
+						return fmt.Errorf("an unsigned event must be given on stdin")
+					}
+
+					var evt nostr.Event
+					if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+						ctx = lineProcessingError(ctx, "invalid event: %s", err)
+						continue
+					}
+					if evt.CreatedAt == 0 {
+						evt.CreatedAt = nostr.Now()
+					}
+					if evt.Tags == nil {
+						evt.Tags = nostr.Tags{}
+					}
+					evt.PubKey = share.PubKey
+					evt.ID = evt.GetID()
+					evt.Sig = [64]byte{}
+
+					j, _ := json.Marshal(thresholdSession{
+						Event:              evt,
+						Threshold:          share.Threshold,
+						VerificationShares: share.VerificationShares,
+						Nonces:             make(map[int]string),
+						PartialSigs:        make(map[int]string),
+					})
+					stdout(string(j))
+				}
+
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:                      "nonce",
+			Usage:                     "adds our nonce to a session",
+			Description:               `the secret part of the nonce is stored in the config directory and deleted after signing. never reuse nonces.`,
+			ArgsUsage:                 "[session-file...]",
+			DisableSliceFlagSeparator: true,
+			Flags:                     []cli.Flag{thresholdShareFlag},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				share, secret, err := readThresholdShare(c.String("share"))
+				if err != nil {
+					return err
+				}
+				session, err := readThresholdSession(c, share)
+				if err != nil {
+					return err
+				}
+				if _, ok := session.Nonces[share.Index]; ok {
+					return fmt.Errorf("our nonce is already in this session")
+				}
+				if session.Participants != nil {
+					return fmt.Errorf("signing has already started with participants %v", session.Participants)
+				}
+
+				// the nonce is a pair of secret scalars d and e, we publish D=dG and E=eG
+				secNonce := make([]byte, 64)
+				var pubNonce []byte
+				for i := range 2 {
+					random := make([]byte, 32)
+					if _, err := rand.Read(random); err != nil {
+						return err
+					}
+					k := nostrFrost.nonce(random, secret)
+					b := k.Bytes()
+					copy(secNonce[i*32:], b[:])
+					var p btcec.JacobianPoint
+					btcec.ScalarBaseMultNonConst(k, &p)
+					pubNonce = append(pubNonce, btcec.JacobianToByteSlice(p)...)
+				}
+
+				noncePath := thresholdNoncePath(c, session, share.Index)
+				os.MkdirAll(filepath.Dir(noncePath), 0700)
+				if err := os.WriteFile(noncePath, secNonce, 0600); err != nil {
+					return fmt.Errorf("failed to store secret nonce: %w", err)
+				}
+
+				session.Nonces[share.Index] = hex.EncodeToString(pubNonce)
+				log("added our nonce, %d of at least %d\n", len(session.Nonces), session.Threshold)
+				j, _ := json.Marshal(session)
+				stdout(string(j))
+				return nil
+			},
+		},
+		{
+			Name:                      "sign",
+			Usage:                     "adds our partial signature to a session that has enough nonces",
+			ArgsUsage:                 "[session-file...]",
+			DisableSliceFlagSeparator: true,
+			Flags:                     []cli.Flag{thresholdShareFlag},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				share, secret, err := readThresholdShare(c.String("share"))
+				if err != nil {
+					return err
+				}
+				session, err := readThresholdSession(c, share)
+				if err != nil {
+					return err
+				}
+				if _, ok := session.PartialSigs[share.Index]; ok {
+					return fmt.Errorf("our partial signature is already in this session")
+				}
+
+				if session.Participants == nil {
+					if len(session.Nonces) < session.Threshold {
+						return fmt.Errorf("still missing nonces from %d participants", session.Threshold-len(session.Nonces))
+					}
+					for idx := range session.Nonces {
+						session.Participants = append(session.Participants, idx)
+					}
+					slices.Sort(session.Participants)
+				}
+				if !slices.Contains(session.Participants, share.Index) {
+					return fmt.Errorf("we are not one of the participants %v", session.Participants)
+				}
+
+				noncePath := thresholdNoncePath(c, session, share.Index)
+				secNonce, err := os.ReadFile(noncePath)
+				if err != nil || len(secNonce) != 64 {
+					return fmt.Errorf("secret nonce for this session not found at '%s', was it created on another machine?", noncePath)
+				}
+				var d, e btcec.ModNScalar
+				d.SetByteSlice(secNonce[0:32])
+				e.SetByteSlice(secNonce[32:64])
+
+				params, err := thresholdSessionParams(session)
+				if err != nil {
+					return err
+				}
+
+				z := params.signShare(share.Index, &d, &e, secret)
+
+				// a nonce must never be used twice
+				os.Remove(noncePath)
+
+				zb := z.Bytes()
+				session.PartialSigs[share.Index] = hex.EncodeToString(zb[:])
+				log("added our partial signature, %d of %d\n", len(session.PartialSigs), len(session.Participants))
+				j, _ := json.Marshal(session)
+				stdout(string(j))
+				return nil
+			},
+		},
+		{
+			Name:      "finish",
+			Usage:     "combines all partial signatures and prints the signed event",
+			ArgsUsage: "[session-file...]",
+			Action: func(ctx context.Context, c *cli.Command) error {
+				session, err := readThresholdSession(c, nil)
+				if err != nil {
+					return err
+				}
+				if session.Participants == nil {
+					return fmt.Errorf("nobody has signed this session yet")
+				}
+				if len(session.PartialSigs) < len(session.Participants) {
+					return fmt.Errorf("still missing partial signatures from %d participants", len(session.Participants)-len(session.PartialSigs))
+				}
+
+				params, err := thresholdSessionParams(session)
+				if err != nil {
+					return err
+				}
+
+				var s btcec.ModNScalar
+				for _, idx := range session.Participants {
+					z, err := thresholdPartialSig(session, idx, params)
+					if err != nil {
+						return err
+					}
+					s.Add(z)
+				}
+
+				evt := session.Event
+				r := params.r.X.Bytes()
+				sb := s.Bytes()
+				copy(evt.Sig[0:32], r[:])
+				copy(evt.Sig[32:64], sb[:])
+				if !evt.VerifySignature() {
+					return fmt.Errorf("combined signature is invalid")
+				}
+
+				stdout(evt)
+				return nil
+			},
+		},
+	},
+}
+
+// splitThresholdKey makes the shares f(1)...f(n) of a random polynomial of degree threshold-1 with
+// f(0) being the secret key, negated if needed so the public key has an even y like nostr keys do.
+func splitThresholdKey(sec nostr.SecretKey, threshold int, n int) ([]thresholdShare, error) {
+	coeffs := make([]*btcec.ModNScalar, threshold)
+	coeffs[0] = new(btcec.ModNScalar)
+	if overflow := coeffs[0].SetByteSlice(sec[:]); overflow || coeffs[0].IsZero() {
+		return nil, fmt.Errorf("invalid secret key")
+	}
+	var pub btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(coeffs[0], &pub)
+	pub.ToAffine()
+	if pub.Y.IsOdd() {
+		coeffs[0].Negate()
+	}
+	for i := 1; i < threshold; i++ {
+		k, err := randomScalar()
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = k
+	}
+
+	return thresholdShares(coeffs, n), nil
+}
+
+// thresholdShares evaluates the polynomial with the given coefficients at 1...n, the first coefficient being
+// the secret key.
+func thresholdShares(coeffs []*btcec.ModNScalar, n int) []thresholdShare {
+	var pub btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(coeffs[0], &pub)
+	pub.ToAffine()
+
+	shares := make([]thresholdShare, n)
+	verificationShares := make([]string, n)
+	for i := range n {
+		var x, y btcec.ModNScalar
+		x.SetInt(uint32(i + 1))
+		for j := len(coeffs) - 1; j >= 0; j-- {
+			y.Mul(&x).Add(coeffs[j])
+		}
+		var p btcec.JacobianPoint
+		btcec.ScalarBaseMultNonConst(&y, &p)
+		verificationShares[i] = hex.EncodeToString(btcec.JacobianToByteSlice(p))
+
+		yb := y.Bytes()
+		shares[i] = thresholdShare{
+			PubKey:             nostr.PubKey(pub.X.Bytes()[:]),
+			Threshold:          len(coeffs),
+			Index:              i + 1,
+			Secret:             hex.EncodeToString(yb[:]),
+			VerificationShares: verificationShares,
+		}
+	}
+	return shares
+}
+
+// readThresholdShare reads a share file and checks the secret matches its public share.
+func readThresholdShare(path string) (*thresholdShare, *btcec.ModNScalar, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read share file: %w", err)
+	}
+	var share thresholdShare
+	if err := json.Unmarshal(b, &share); err != nil {
+		return nil, nil, fmt.Errorf("invalid share: %w", err)
+	}
+	if share.Index < 1 || share.Index > len(share.VerificationShares) {
+		return nil, nil, fmt.Errorf("invalid share index %d", share.Index)
+	}
+
+	sb, err := hex.DecodeString(share.Secret)
+	if err != nil || len(sb) != 32 {
+		return nil, nil, fmt.Errorf("invalid share secret")
+	}
+	secret := new(btcec.ModNScalar)
+	secret.SetByteSlice(sb)
+	var p btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(secret, &p)
+	if hex.EncodeToString(btcec.JacobianToByteSlice(p)) != share.VerificationShares[share.Index-1] {
+		return nil, nil, fmt.Errorf("share secret doesn't match its public share")
+	}
+
+	return &share, secret, nil
+}
+
+// readThresholdSession reads sessions from the given files or from stdin, merging them all into one.
+// when our share is given the session must be for the same key.
+func readThresholdSession(c *cli.Command, share *thresholdShare) (*thresholdSession, error) {
+	var jsons []string
+	if c.Args().Len() > 0 {
+		for _, path := range c.Args().Slice() {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read session file: %w", err)
+			}
+			jsons = append(jsons, string(b))
+		}
+	} else {
+		for j := range getJsonsOrBlank() {
+			if j != "{}" {
+				jsons = append(jsons, j)
+			}
+		}
+	}
+	if len(jsons) == 0 {
+		return nil, fmt.Errorf("no session given")
+	}
+
+	var session *thresholdSession
+	for _, j := range jsons {
+		var s thresholdSession
+		if err := json.Unmarshal([]byte(j), &s); err != nil {
+			return nil, fmt.Errorf("invalid session: %w", err)
+		}
+		if session == nil {
+			session = &s
+			if session.Nonces == nil {
+				session.Nonces = make(map[int]string)
+			}
+			if session.PartialSigs == nil {
+				session.PartialSigs = make(map[int]string)
+			}
+			continue
+		}
+		if s.Event.ID != session.Event.ID {
+			return nil, fmt.Errorf("can't merge sessions for different events (%s and %s)", session.Event.ID.Hex(), s.Event.ID.Hex())
+		}
+		if s.Participants != nil {
+			if session.Participants != nil && !slices.Equal(s.Participants, session.Participants) {
+				return nil, fmt.Errorf("can't merge sessions signed by different participants (%v and %v)", session.Participants, s.Participants)
+			}
+			session.Participants = s.Participants
+		}
+		for k, v := range s.Nonces {
+			session.Nonces[k] = v
+		}
+		for k, v := range s.PartialSigs {
+			session.PartialSigs[k] = v
+		}
+	}
+
+	if session.Event.GetID() != session.Event.ID {
+		return nil, fmt.Errorf("session event id doesn't match its contents")
+	}
+	if share != nil && (share.PubKey != session.Event.PubKey || !slices.Equal(share.VerificationShares, session.VerificationShares)) {
+		return nil, fmt.Errorf("session is for another key")
+	}
+	if pubkey, err := thresholdGroupKey(session); err != nil {
+		return nil, err
+	} else if pubkey != session.Event.PubKey {
+		return nil, fmt.Errorf("session event pubkey doesn't match the shares")
+	}
+
+	return session, nil
+}
+
+// thresholdGroupKey recovers the public key from the first 'threshold' public shares.
+func thresholdGroupKey(session *thresholdSession) (nostr.PubKey, error) {
+	if session.Threshold < 2 || session.Threshold > len(session.VerificationShares) {
+		return nostr.ZeroPK, fmt.Errorf("invalid threshold %d for %d shares", session.Threshold, len(session.VerificationShares))
+	}
+
+	set := make([]int, session.Threshold)
+	for i := range set {
+		set[i] = i + 1
+	}
+
+	var sum btcec.JacobianPoint
+	for _, idx := range set {
+		y, err := parseThresholdPoint(session.VerificationShares[idx-1])
+		if err != nil {
+			return nostr.ZeroPK, fmt.Errorf("invalid public share %d: %w", idx, err)
+		}
+		var p btcec.JacobianPoint
+		btcec.ScalarMultNonConst(lagrangeCoefficient(idx, set), &y, &p)
+		btcec.AddNonConst(&sum, &p, &sum)
+	}
+	sum.ToAffine()
+	if sum.Y.IsOdd() {
+		return nostr.ZeroPK, fmt.Errorf("public shares don't add up to a nostr key")
+	}
+	return nostr.PubKey(sum.X.Bytes()[:]), nil
+}
+
+type thresholdParams struct {
+	rho         map[int]*btcec.ModNScalar
+	lambda      map[int]*btcec.ModNScalar
+	commitments map[int]btcec.JacobianPoint // D + ρE for each participant
+	r           btcec.JacobianPoint
+	negateNonce bool
+	challenge   *btcec.ModNScalar
+}
+
+// thresholdSessionParams computes the binding factors, the group nonce R and the bip340 challenge,
+// which are the same for all participants.
+func thresholdSessionParams(session *thresholdSession) (*thresholdParams, error) {
+	nonces := make(map[int][2]btcec.JacobianPoint, len(session.Participants))
+	for _, idx := range session.Participants {
+		b, err := hex.DecodeString(session.Nonces[idx])
+		if err != nil || len(b) != 66 {
+			return nil, fmt.Errorf("invalid or missing nonce from participant %d", idx)
+		}
+		d, err := btcec.ParseJacobian(b[0:33])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nonce from participant %d: %w", idx, err)
+		}
+		e, err := btcec.ParseJacobian(b[33:66])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nonce from participant %d: %w", idx, err)
+		}
+		nonces[idx] = [2]btcec.JacobianPoint{d, e}
+	}
+
+	groupKey, err := btcec.ParseJacobian(append([]byte{2}, session.Event.PubKey[:]...))
+	if err != nil {
+		return nil, fmt.Errorf("invalid session pubkey: %w", err)
+	}
+	return nostrFrost.signingParams(groupKey, session.Event.ID[:], nonces)
+}
+
+// frostCiphersuite is FROST(secp256k1, SHA-256) from rfc9591, except that with bip340 set the challenge and the
+// signature are the bip340 ones over the x coordinates, so the result is a normal nostr signature.
+type frostCiphersuite struct {
+	context string
+	bip340  bool
+}
+
+var nostrFrost = frostCiphersuite{context: "nak-FROST-secp256k1-SHA256-BIP340-v1", bip340: true}
+
+// nonce is nonce_generate from rfc9591, which mixes our secret share into the randomness so a bad random
+// number generator alone can't leak it.
+func (cs frostCiphersuite) nonce(random []byte, secret *btcec.ModNScalar) *btcec.ModNScalar {
+	sb := secret.Bytes()
+	return cs.hashToScalar("nonce", random, sb[:])
+}
+
+// signingParams computes the binding factors, the group commitment and the challenge from the commitments
+// (D, E) of each participant, as in compute_binding_factors and compute_group_commitment from rfc9591.
+func (cs frostCiphersuite) signingParams(
+	groupKey btcec.JacobianPoint,
+	msg []byte,
+	nonces map[int][2]btcec.JacobianPoint,
+) (*thresholdParams, error) {
+	participants := slices.Sorted(maps.Keys(nonces))
+	params := &thresholdParams{
+		rho:         make(map[int]*btcec.ModNScalar, len(participants)),
+		lambda:      make(map[int]*btcec.ModNScalar, len(participants)),
+		commitments: make(map[int]btcec.JacobianPoint, len(participants)),
+	}
+
+	// all the nonces of the participants go into each binding factor, so nobody can change theirs
+	// after seeing the others
+	encoded := &bytes.Buffer{}
+	for _, idx := range participants {
+		encoded.Write(frostScalar(idx))
+		encoded.Write(btcec.JacobianToByteSlice(nonces[idx][0]))
+		encoded.Write(btcec.JacobianToByteSlice(nonces[idx][1]))
+	}
+	msgHash := cs.hash("msg", msg)
+	commitmentsHash := cs.hash("com", encoded.Bytes())
+	groupKeyEncoded := btcec.JacobianToByteSlice(groupKey)
+
+	for _, idx := range participants {
+		rho := cs.hashToScalar("rho", groupKeyEncoded, msgHash[:], commitmentsHash[:], frostScalar(idx))
+		params.rho[idx] = rho
+		params.lambda[idx] = lagrangeCoefficient(idx, participants)
+
+		n := nonces[idx]
+		var c btcec.JacobianPoint
+		btcec.ScalarMultNonConst(rho, &n[1], &c)
+		btcec.AddNonConst(&n[0], &c, &c)
+		params.commitments[idx] = c
+		btcec.AddNonConst(&params.r, &c, &params.r)
+	}
+
+	params.r.ToAffine()
+	if params.r.X.IsZero() && params.r.Y.IsZero() {
+		return nil, fmt.Errorf("nonces add up to infinity")
+	}
+
+	if cs.bip340 {
+		params.negateNonce = params.r.Y.IsOdd()
+		rx := params.r.X.Bytes()
+		gx := groupKeyEncoded[1:]
+		h := taggedHash("BIP0340/challenge", rx[:], gx, msg)
+		params.challenge = new(btcec.ModNScalar)
+		params.challenge.SetByteSlice(h[:])
+	} else {
+		params.challenge = cs.hashToScalar("chal", btcec.JacobianToByteSlice(params.r), groupKeyEncoded, msg)
+	}
+
+	return params, nil
+}
+
+// signShare is our partial signature z = ±(d + ρe) + λsc, with the nonce negated when R has an odd y like
+// bip340 requires.
+func (params *thresholdParams) signShare(idx int, d, e, secret *btcec.ModNScalar) *btcec.ModNScalar {
+	z := new(btcec.ModNScalar).Mul2(e, params.rho[idx]).Add(d)
+	if params.negateNonce {
+		z.Negate()
+	}
+	return z.Add(new(btcec.ModNScalar).Mul2(params.lambda[idx], secret).Mul(params.challenge))
+}
+
+// hash is H4 and H5 from rfc9591.
+func (cs frostCiphersuite) hash(tag string, msg []byte) [32]byte {
+	return sha256.Sum256(slices.Concat([]byte(cs.context), []byte(tag), msg))
+}
+
+// hashToScalar is H1, H2 and H3 from rfc9591: hash_to_field from rfc9380 using expand_message_xmd with sha256.
+func (cs frostCiphersuite) hashToScalar(tag string, msg ...[]byte) *btcec.ModNScalar {
+	const length = 48
+	dst := slices.Concat([]byte(cs.context), []byte(tag))
+	dst = append(dst, byte(len(dst)))
+
+	h := sha256.New()
+	h.Write(make([]byte, sha256.BlockSize))
+	for _, m := range msg {
+		h.Write(m)
+	}
+	h.Write([]byte{0, length, 0})
+	h.Write(dst)
+	b0 := h.Sum(nil)
+
+	uniform := make([]byte, 0, length+sha256.Size)
+	prev := make([]byte, sha256.Size)
+	for i := byte(1); len(uniform) < length; i++ {
+		h.Reset()
+		for j := range prev {
+			prev[j] ^= b0[j]
+		}
+		h.Write(prev)
+		h.Write([]byte{i})
+		h.Write(dst)
+		prev = h.Sum(nil)
+		uniform = append(uniform, prev...)
+	}
+
+	n := new(big.Int).SetBytes(uniform[:length])
+	n.Mod(n, btcec.S256().N)
+	var b [32]byte
+	n.FillBytes(b[:])
+	k := new(btcec.ModNScalar)
+	k.SetBytes(&b)
+	return k
+}
+
+func frostScalar(idx int) []byte {
+	b := new(btcec.ModNScalar).SetInt(uint32(idx)).Bytes()
+	return b[:]
+}
+
+// thresholdPartialSig parses and checks the partial signature of a participant: zG = ±(D + ρE) + cλY.
+func thresholdPartialSig(session *thresholdSession, idx int, params *thresholdParams) (*btcec.ModNScalar, error) {
+	b, err := hex.DecodeString(session.PartialSigs[idx])
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("invalid or missing partial signature from participant %d", idx)
+	}
+	z := new(btcec.ModNScalar)
+	if overflow := z.SetByteSlice(b); overflow {
+		return nil, fmt.Errorf("invalid partial signature from participant %d", idx)
+	}
+
+	if idx < 1 || idx > len(session.VerificationShares) {
+		return nil, fmt.Errorf("unknown participant %d", idx)
+	}
+	y, err := parseThresholdPoint(session.VerificationShares[idx-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public share %d: %w", idx, err)
+	}
+
+	var expected btcec.JacobianPoint
+	commitment := params.commitments[idx]
+	commitment.ToAffine()
+	if params.negateNonce {
+		commitment.Y.Negate(1).Normalize()
+	}
+	btcec.ScalarMultNonConst(new(btcec.ModNScalar).Mul2(params.challenge, params.lambda[idx]), &y, &expected)
+	btcec.AddNonConst(&commitment, &expected, &expected)
+
+	var actual btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(z, &actual)
+	if !bytes.Equal(btcec.JacobianToByteSlice(actual), btcec.JacobianToByteSlice(expected)) {
+		return nil, fmt.Errorf("partial signature from participant %d is invalid", idx)
+	}
+	return z, nil
+}
+
+// lagrangeCoefficient is the λ that turns the share at idx into its part of f(0) for this set of shares.
+func lagrangeCoefficient(idx int, set []int) *btcec.ModNScalar {
+	num := new(btcec.ModNScalar).SetInt(1)
+	den := new(btcec.ModNScalar).SetInt(1)
+	var xi btcec.ModNScalar
+	xi.SetInt(uint32(idx))
+	for _, j := range set {
+		if j == idx {
+			continue
+		}
+		var xj btcec.ModNScalar
+		xj.SetInt(uint32(j))
+		num.Mul(&xj)
+		den.Mul(new(btcec.ModNScalar).NegateVal(&xi).Add(&xj))
+	}
+	return num.Mul(den.InverseNonConst())
+}
+
+func parseThresholdPoint(h string) (btcec.JacobianPoint, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return btcec.JacobianPoint{}, err
+	}
+	return btcec.ParseJacobian(b)
+}
+
+func randomScalar() (*btcec.ModNScalar, error) {
+	b := make([]byte, 32)
+	k := new(btcec.ModNScalar)
+	for k.IsZero() {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		k.SetByteSlice(b)
+	}
+	return k, nil
+}
+
+func taggedHash(tag string, parts ...[]byte) [32]byte {
+	t := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(t[:])
+	h.Write(t[:])
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+func thresholdNoncePath(c *cli.Command, session *thresholdSession, index int) string {
+	return filepath.Join(c.String("config-path"), "musig", session.Event.ID.Hex()+"-share-"+strconv.Itoa(index))
+}
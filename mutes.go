@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var applyMutesFlag = &cli.StringFlag{
+	Name:  "apply-mutes",
+	Usage: "hide events from the pubkeys, threads, hashtags and words in this mute list (a pubkey whose kind 10000 list will be fetched or a file with the list event), private items are read when the list is yours and --sec is given",
+}
+
+type muteList struct {
+	pubkeys  map[nostr.PubKey]struct{}
+	threads  map[string]struct{}
+	hashtags map[string]struct{}
+	words    []string
+}
+
+// loadMuteList reads a kind 10000 mute list either from a file or from the relays of the given pubkey.
+func loadMuteList(ctx context.Context, c *cli.Command, value string) (*muteList, error) {
+	var evt *nostr.Event
+	if data, err := os.ReadFile(value); err == nil {
+		evt = &nostr.Event{}
+		if err := easyjson.Unmarshal(data, evt); err != nil {
+			return nil, fmt.Errorf("invalid mute list event in %s: %w", value, err)
+		}
+	} else {
+		pk, err := parsePubKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("--apply-mutes must be a pubkey or a file: %w", err)
+		}
+		evt = sys.FetchMuteList(ctx, pk).Event
+		if evt == nil {
+			return nil, fmt.Errorf("no mute list found for %s", pk.Hex())
+		}
+	}
+	if evt.Kind != 10000 {
+		return nil, fmt.Errorf("event %s is a kind %d, not a mute list", evt.ID.Hex(), evt.Kind)
+	}
+
+	tags := evt.Tags
+	if evt.Content != "" && c.IsSet("sec") {
+		if kr, sec, err := gatherKeyerFromArguments(ctx, c); err == nil {
+			if us, _ := kr.GetPublicKey(ctx); us == evt.PubKey {
+				var plaintext string
+				if strings.Contains(evt.Content, "?iv=") {
+					// legacy lists are encrypted with nip04
+					plaintext, err = decryptNIP04(sec, us, evt.Content)
+				} else {
+					plaintext, err = kr.Decrypt(ctx, evt.Content, us)
+				}
+				if err != nil {
+					log("failed to decrypt private mute list items: %s\n", err)
+				} else {
+					var private nostr.Tags
+					if err := json.Unmarshal([]byte(plaintext), &private); err == nil {
+						tags = append(tags, private...)
+					}
+				}
+			}
+		}
+	}
+
+	mutes := &muteList{
+		pubkeys:  make(map[nostr.PubKey]struct{}),
+		threads:  make(map[string]struct{}),
+		hashtags: make(map[string]struct{}),
+	}
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "p":
+			if pk, err := nostr.PubKeyFromHex(tag[1]); err == nil {
+				mutes.pubkeys[pk] = struct{}{}
+			}
+		case "e":
+			mutes.threads[tag[1]] = struct{}{}
+		case "t":
+			mutes.hashtags[strings.ToLower(tag[1])] = struct{}{}
+		case "word":
+			mutes.words = append(mutes.words, strings.ToLower(tag[1]))
+		}
+	}
+	logverbose("muting %d pubkeys, %d threads, %d hashtags and %d words\n",
+		len(mutes.pubkeys), len(mutes.threads), len(mutes.hashtags), len(mutes.words))
+
+	return mutes, nil
+}
+
+func (m *muteList) isMuted(evt nostr.Event) bool {
+	if _, ok := m.pubkeys[evt.PubKey]; ok {
+		return true
+	}
+	if _, ok := m.threads[evt.ID.Hex()]; ok {
+		return true
+	}
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			if _, ok := m.threads[tag[1]]; ok {
+				return true
+			}
+		case "t":
+			if _, ok := m.hashtags[strings.ToLower(tag[1])]; ok {
+				return true
+			}
+		}
+	}
+	if len(m.words) > 0 {
+		content := strings.ToLower(evt.Content)
+		for _, word := range m.words {
+			if strings.Contains(content, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setupMutes makes stdout skip the events that match the mute list given with --apply-mutes.
+func setupMutes(ctx context.Context, c *cli.Command) error {
+	value := c.String("apply-mutes")
+	if value == "" {
+		return nil
+	}
+
+	mutes, err := loadMuteList(ctx, c, value)
+	if err != nil {
+		return err
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok && mutes.isMuted(evt) {
+				logverbose("hiding muted event %s\n", evt.ID.Hex())
+				return
+			}
+		}
+		printNext(args...)
+	}
+	return nil
+}
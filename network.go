@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	extraRequestHeaders = http.Header{}
+	userAgent           = ""
+)
+
+var networkFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "header",
+		Usage: "extra http header to send when connecting to relays, like 'Authorization: Bearer xyz' (can be repeated)",
+	},
+	&cli.StringFlag{
+		Name:  "user-agent",
+		Usage: "user-agent to use when connecting to relays",
+	},
+	&cli.BoolFlag{
+		Name:        "no-compression",
+		Usage:       "don't negotiate permessage-deflate compression with relays",
+		DefaultText: "false, compression is used whenever the relay supports it",
+	},
+	&cli.StringSliceFlag{
+		Name:  "resolve",
+		Usage: "use a specific address for a host and port, like curl: --resolve relay.example.com:443:1.2.3.4 (can be repeated)",
+	},
+	&cli.BoolFlag{
+		Name:    "ipv4",
+		Aliases: []string{"4"},
+		Usage:   "only connect using ipv4",
+	},
+	&cli.BoolFlag{
+		Name:    "ipv6",
+		Aliases: []string{"6"},
+		Usage:   "only connect using ipv6",
+	},
+	&cli.BoolFlag{
+		Name:  "trace-connect",
+		Usage: "print dns, tcp, tls and websocket upgrade timings for each connection",
+	},
+	&cli.StringFlag{
+		Name:      "cacert",
+		Usage:     "pem file with extra certificate authorities to trust",
+		TakesFile: true,
+	},
+	&cli.StringFlag{
+		Name:      "cert",
+		Usage:     "pem file with a client certificate to present to relays and servers that require it (mtls)",
+		TakesFile: true,
+	},
+	&cli.StringFlag{
+		Name:      "key",
+		Usage:     "pem file with the private key for --cert",
+		TakesFile: true,
+	},
+	&cli.BoolFlag{
+		Name:  "insecure",
+		Usage: "don't verify tls certificates, only use this for testing",
+	},
+}
+
+// setupNetwork applies the global network flags, it must be called before any connection is made.
+func setupNetwork(ctx context.Context, c *cli.Command) error {
+	for _, h := range c.StringSlice("header") {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid --header '%s', expected 'Name: value'", h)
+		}
+		extraRequestHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	userAgent = c.String("user-agent")
+
+	network := "tcp"
+	switch {
+	case c.Bool("ipv4") && c.Bool("ipv6"):
+		return fmt.Errorf("--ipv4 and --ipv6 are incompatible")
+	case c.Bool("ipv4"):
+		network = "tcp4"
+	case c.Bool("ipv6"):
+		network = "tcp6"
+	}
+
+	overrides := make(map[string]string)
+	for _, r := range c.StringSlice("resolve") {
+		// the address may be an ipv6 with colons, so we take the host and port from the start
+		spl := strings.SplitN(r, ":", 3)
+		if len(spl) != 3 || net.ParseIP(strings.Trim(spl[2], "[]")) == nil {
+			return fmt.Errorf("invalid --resolve '%s', expected host:port:address", r)
+		}
+		overrides[net.JoinHostPort(spl[0], spl[1])] = net.JoinHostPort(strings.Trim(spl[2], "[]"), spl[1])
+	}
+
+	tlsConfig, err := tlsConfigFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	// relay connections and most http calls use the default client and transport, so all
+	// customizations are done there
+	if network != "tcp" || len(overrides) > 0 || tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			if override, ok := overrides[addr]; ok {
+				logverbose("connecting to %s instead of %s\n", override, addr)
+				addr = override
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+		http.DefaultTransport = transport
+	}
+
+	http.DefaultClient.Transport = relayTransport{
+		RoundTripper:  http.DefaultTransport,
+		noCompression: c.Bool("no-compression"),
+		trace:         c.Bool("trace-connect"),
+	}
+
+	return nil
+}
+
+// tlsConfigFromFlags returns nil when no tls flags were given.
+func tlsConfigFromFlags(c *cli.Command) (*tls.Config, error) {
+	if !c.IsSet("cacert") && !c.IsSet("cert") && !c.IsSet("key") && !c.Bool("insecure") {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: c.Bool("insecure"),
+	}
+	if config.InsecureSkipVerify {
+		log("%s\n", color.YellowString("tls certificates won't be verified"))
+	}
+
+	if cacert := c.String("cacert"); cacert != "" {
+		pem, err := os.ReadFile(cacert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --cacert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cacert)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.IsSet("cert") != c.IsSet("key") {
+		return nil, fmt.Errorf("--cert and --key must be given together")
+	}
+	if c.IsSet("cert") {
+		cert, err := tls.LoadX509KeyPair(c.String("cert"), c.String("key"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// relayRequestHeader returns the headers to be sent in the websocket upgrade request to relays.
+func relayRequestHeader(defaultUserAgent string) http.Header {
+	header := extraRequestHeaders.Clone()
+	ua := defaultUserAgent
+	if userAgent != "" {
+		ua = userAgent
+	}
+	header[textproto.CanonicalMIMEHeaderKey("user-agent")] = []string{ua}
+	return header
+}
+
+// relayTraffic is how many bytes were received from each relay, through its websocket connection and its --wire
+// endpoint, including the websocket framing and before decompression.
+var relayTraffic = xsync.NewMapOf[string, *atomic.Uint64]()
+
+func relayTrafficCounter(url string) *atomic.Uint64 {
+	counter, _ := relayTraffic.LoadOrCompute(url, func() *atomic.Uint64 { return &atomic.Uint64{} })
+	return counter
+}
+
+// countingReader counts the bytes read into a relayTraffic counter.
+type countingReader struct {
+	io.Reader
+	counter *atomic.Uint64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.counter.Add(uint64(n))
+	return n, err
+}
+
+// countingConn is the body of a websocket upgrade response, which is the connection itself.
+type countingConn struct {
+	io.ReadWriteCloser
+	counter *atomic.Uint64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.counter.Add(uint64(n))
+	return n, err
+}
+
+// relayTransport wraps the default transport to change or inspect the websocket upgrade requests, and to count
+// what is received through the websocket connections.
+type relayTransport struct {
+	http.RoundTripper
+	noCompression bool
+	trace         bool
+}
+
+func (t relayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.noCompression && req.Header.Get("Sec-WebSocket-Extensions") != "" {
+		// without the extension offer the relay won't use compression
+		req = req.Clone(req.Context())
+		req.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	var resp *http.Response
+	var err error
+	if t.trace {
+		resp, err = t.roundTripTraced(req)
+	} else {
+		resp, err = t.RoundTripper.RoundTrip(req)
+	}
+
+	if err == nil && resp.StatusCode == http.StatusSwitchingProtocols {
+		if conn, ok := resp.Body.(io.ReadWriteCloser); ok {
+			resp.Body = countingConn{conn, relayTrafficCounter(nostr.NormalizeURL(req.URL.String()))}
+		}
+	}
+	return resp, err
+}
+
+func (t relayTransport) roundTripTraced(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	start := time.Now()
+	since := func() string { return color.HiBlackString("+%s", time.Since(start).Round(time.Millisecond)) }
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				log("%s %s dns failed: %s\n", since(), host, info.Err)
+				return
+			}
+			addrs := make([]string, len(info.Addrs))
+			for i, addr := range info.Addrs {
+				addrs[i] = addr.String()
+			}
+			log("%s %s dns: %s in %s\n", since(), host, strings.Join(addrs, ", "), time.Since(dnsStart).Round(time.Millisecond))
+		},
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				log("%s %s tcp connection to %s failed: %s\n", since(), host, addr, err)
+				return
+			}
+			log("%s %s tcp: connected to %s in %s\n", since(), host, addr, time.Since(connectStart).Round(time.Millisecond))
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				log("%s %s tls handshake failed: %s\n", since(), host, err)
+				return
+			}
+			log("%s %s tls: %s in %s\n", since(), host, tls.VersionName(state.Version), time.Since(tlsStart).Round(time.Millisecond))
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		log("%s %s request failed: %s\n", since(), host, err)
+		return nil, err
+	}
+	if req.Header.Get("Upgrade") != "" {
+		log("%s %s websocket upgrade: %s, extensions: '%s'\n", since(), host, resp.Status, resp.Header.Get("Sec-WebSocket-Extensions"))
+	} else {
+		log("%s %s http response: %s\n", since(), host, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var noticeFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "show-notices",
+		Usage: "print NOTICE messages from relays to stderr as they arrive, as json objects with the relay, instead of a summary at the end",
+	},
+	&cli.StringFlag{
+		Name:  "fail-on-notice",
+		Usage: "exit with code 6 as soon as a relay sends a NOTICE matching this regular expression",
+	},
+}
+
+// handleNotice is the handler set up by setupNotices, given to every pool and relay connection we make.
+var handleNotice = func(*nostr.Relay, string) {}
+
+// setupNotices returns the handler for NOTICE messages from relays according to the flags. unless
+// --show-notices is given they are only counted and summarized when the output finishes.
+func setupNotices(c *cli.Command) (func(*nostr.Relay, string), error) {
+	var failOn *regexp.Regexp
+	if pattern := c.String("fail-on-notice"); pattern != "" {
+		var err error
+		if failOn, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid --fail-on-notice '%s': %w", pattern, err)
+		}
+	}
+	show := c.Bool("show-notices")
+
+	mu := sync.Mutex{}
+	counts := make(map[[2]string]int)
+
+	if !show {
+		finishNext := finishOutput
+		finishOutput = func() {
+			finishNext()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(counts) == 0 {
+				return
+			}
+			keys := slices.SortedFunc(maps.Keys(counts), func(a, b [2]string) int {
+				if d := strings.Compare(a[0], b[0]); d != 0 {
+					return d
+				}
+				return strings.Compare(a[1], b[1])
+			})
+
+			log("notices received (use --show-notices to see them as they come):\n")
+			for _, key := range keys {
+				times := ""
+				if counts[key] > 1 {
+					times = color.YellowString(" (%dx)", counts[key])
+				}
+				log("  %s: %s%s\n", key[0], key[1], times)
+			}
+		}
+	}
+
+	return func(relay *nostr.Relay, notice string) {
+		if show {
+			j, _ := stdjson.Marshal(struct {
+				Relay  string `json:"relay"`
+				Notice string `json:"notice"`
+				Time   int64  `json:"time"`
+			}{relay.URL, notice, time.Now().Unix()})
+			log("%s\n", j)
+		} else {
+			mu.Lock()
+			counts[[2]string{relay.URL, notice}]++
+			mu.Unlock()
+		}
+
+		if failOn != nil && failOn.MatchString(notice) {
+			log("%s sent a NOTICE matching --fail-on-notice: %s\n", relay.URL, color.RedString(notice))
+			finishOutput()
+			colors.reset()
+			os.Exit(6)
+		}
+	}, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/fiatjaf/nak/lib"
+)
+
+// finishOutput is called right before exiting, so output formats that need it can be closed.
+var finishOutput = func() {}
+
+// setupArrayOutput replaces stdout with a function that writes everything as items of a single
+// json array, writing each item as soon as it arrives so it also works with --stream.
+func setupArrayOutput() {
+	aw := lib.NewJSONArrayWriter(color.Output)
+	stdout = func(args ...any) {
+		aw.Write(fmt.Sprint(args...))
+	}
+	finishOutput = aw.Close
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"github.com/urfave/cli/v3"
+)
+
+var parallelFlag = &cli.UintFlag{
+	Name:     "parallel",
+	Usage:    "process up to this many lines from stdin at the same time, still printing the results in the order of the input",
+	Category: CATEGORY_EXTRAS,
+}
+
+var unorderedFlag = &cli.BoolFlag{
+	Name:     "unordered",
+	Usage:    "with --parallel, print the results as soon as they are ready instead of in the order of the input",
+	Category: CATEGORY_EXTRAS,
+}
+
+type lineOutputKey struct{}
+
+// outputFor returns the function that must be used to print the results of the line being processed in ctx,
+// which is just stdout unless lines are being processed in parallel.
+func outputFor(ctx context.Context) func(args ...any) {
+	if out, ok := ctx.Value(lineOutputKey{}).(func(args ...any)); ok {
+		return out
+	}
+	return stdout
+}
+
+// forEachLine calls handle for each input, one after the other or, with --parallel, many at the same time.
+// handle marks failed lines with lineProcessingError and returns an error only when everything must stop.
+// the returned context is marked if any line failed, so it can be given to exitIfLineProcessingError.
+func forEachLine(
+	ctx context.Context,
+	c *cli.Command,
+	inputs iter.Seq[string],
+	handle func(ctx context.Context, input string) (context.Context, error),
+) (context.Context, error) {
+	n := int(c.Uint("parallel"))
+	if n <= 1 {
+		for input := range inputs {
+			var err error
+			if ctx, err = handle(ctx, input); err != nil {
+				return ctx, err
+			}
+		}
+		return ctx, nil
+	}
+
+	type lineResult struct {
+		outputs [][]any
+		done    chan struct{}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		failed   bool
+		fatalErr error
+	)
+	stop := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fatalErr == nil {
+			fatalErr = err
+		}
+		cancel()
+	}
+
+	// results are printed by this goroutine in the order the lines were read, each one as soon as the
+	// line is done and all the previous ones were printed. the queue is what bounds memory usage when one line is slow.
+	queue := make(chan *lineResult, n)
+	printerDone := make(chan struct{})
+	go func() {
+		defer close(printerDone)
+		for res := range queue {
+			<-res.done
+			for _, args := range res.outputs {
+				stdout(args...)
+			}
+		}
+	}()
+
+	// with --unordered results are printed right away, but the stdout wrappers don't expect concurrent calls
+	outputMu := sync.Mutex{}
+	unordered := func(args ...any) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		stdout(args...)
+	}
+
+	sem := make(chan struct{}, n)
+	wg := sync.WaitGroup{}
+	for input := range inputs {
+		select {
+		case sem <- struct{}{}:
+		case <-runCtx.Done():
+		}
+		if runCtx.Err() != nil {
+			break
+		}
+
+		lineCtx := runCtx
+		var res *lineResult
+		if c.Bool("unordered") {
+			lineCtx = context.WithValue(lineCtx, lineOutputKey{}, unordered)
+		} else {
+			res = &lineResult{done: make(chan struct{})}
+			resMu := sync.Mutex{}
+			lineCtx = context.WithValue(lineCtx, lineOutputKey{}, func(args ...any) {
+				resMu.Lock()
+				defer resMu.Unlock()
+				res.outputs = append(res.outputs, args)
+			})
+			queue <- res
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if res != nil {
+				defer close(res.done)
+			}
+
+			resultCtx, err := handle(lineCtx, input)
+			if err != nil {
+				stop(err)
+				return
+			}
+			if val, ok := resultCtx.Value(LINE_PROCESSING_ERROR).(bool); ok && val {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(queue)
+	<-printerDone
+
+	if failed {
+		ctx = context.WithValue(ctx, LINE_PROCESSING_ERROR, true)
+	}
+	return ctx, fatalErr
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// findPlugin gives the path of the "nak-<name>" executable in $PATH when "nak <name>" isn't one of our
+// commands, git-style.
+func findPlugin(root *cli.Command, name string) (string, bool) {
+	// "help" is only added to the commands when they run
+	if name == "" || name == "help" || strings.HasPrefix(name, "-") || root.Command(name) != nil {
+		return "", false
+	}
+	path, err := exec.LookPath("nak-" + name)
+	return path, err == nil
+}
+
+// dispatchPlugin runs the plugin named by the first argument, if there is one, before the arguments
+// are parsed by us, so all the flags after the plugin name reach it untouched.
+func dispatchPlugin(ctx context.Context, root *cli.Command, args []string) (bool, error) {
+	if len(args) < 2 {
+		return false, nil
+	}
+	path, ok := findPlugin(root, args[1])
+	if !ok {
+		return false, nil
+	}
+	return true, runPlugin(ctx, path, args[2:], pluginEnv(defaultConfigPath(), 0, 0, "ndjson"))
+}
+
+// pluginAction is the action of the root command, reached when the global flags come before an
+// unknown command name, as in "nak -v foo", so these are given to the plugin as environment variables.
+func pluginAction(ctx context.Context, c *cli.Command) error {
+	name := c.Args().First()
+	path, ok := findPlugin(c.Root(), name)
+	if !ok {
+		return fmt.Errorf("unknown command '%s' (and no 'nak-%s' plugin found in $PATH)", name, name)
+	}
+	return runPlugin(ctx, path, c.Args().Tail(),
+		pluginEnv(c.String("config-path"), c.Count("verbose"), c.Count("quiet"), c.String("output")))
+}
+
+func pluginEnv(configPath string, verbose int, quiet int, output string) []string {
+	return []string{
+		"NAK_VERSION=" + version,
+		"NAK_CONFIG_PATH=" + configPath,
+		"NAK_VERBOSE=" + strconv.Itoa(verbose),
+		"NAK_QUIET=" + strconv.Itoa(quiet),
+		"NAK_OUTPUT=" + output,
+	}
+}
+
+// runPlugin runs a plugin with the same stdin, stdout and stderr, so it can read and print events one
+// per line just like the other commands.
+func runPlugin(ctx context.Context, path string, args []string, env []string) error {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	if self, err := os.Executable(); err == nil {
+		// so plugins can call back into nak itself
+		cmd.Env = append(cmd.Env, "NAK="+self)
+	}
+
+	logverbose("running plugin %s\n", path)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			finishOutput()
+			colors.reset()
+			os.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run plugin %s: %w", path, err)
+	}
+
+	return nil
+}
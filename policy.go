@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/khatru"
+	"fiatjaf.com/nostr/nip13"
+)
+
+// relayPolicy is the set of accept rules for 'nak serve', read from a json file like
+//
+//	{
+//	  "allowed_kinds": [0, 1, 3],
+//	  "allowed_pubkeys": ["npub1..."],
+//	  "blocked_pubkeys": ["3bf0c63f..."],
+//	  "max_event_size": 65536,
+//	  "min_pow": 16,
+//	  "rate_limit_per_ip": 30,
+//	  "rate_limit_per_pubkey": 10
+//	}
+//
+// rate limits are in events per minute. all fields are optional.
+type relayPolicy struct {
+	AllowedKinds       []nostr.Kind `json:"allowed_kinds"`
+	AllowedPubKeys     []string     `json:"allowed_pubkeys"`
+	BlockedPubKeys     []string     `json:"blocked_pubkeys"`
+	MaxEventSize       int          `json:"max_event_size"`
+	MinPoW             int          `json:"min_pow"`
+	RateLimitPerIP     int          `json:"rate_limit_per_ip"`
+	RateLimitPerPubKey int          `json:"rate_limit_per_pubkey"`
+
+	allowed map[nostr.PubKey]struct{}
+	blocked map[nostr.PubKey]struct{}
+
+	mu       sync.Mutex
+	counters map[string]*rateCounter
+}
+
+type rateCounter struct {
+	window time.Time
+	count  int
+}
+
+func loadRelayPolicy(path string) (*relayPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &relayPolicy{counters: make(map[string]*rateCounter)}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy json: %w", err)
+	}
+
+	parseAll := func(values []string) (map[nostr.PubKey]struct{}, error) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		set := make(map[nostr.PubKey]struct{}, len(values))
+		for _, value := range values {
+			pk, err := parsePubKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pubkey '%s' in policy: %w", value, err)
+			}
+			set[pk] = struct{}{}
+		}
+		return set, nil
+	}
+	if policy.allowed, err = parseAll(policy.AllowedPubKeys); err != nil {
+		return nil, err
+	}
+	if policy.blocked, err = parseAll(policy.BlockedPubKeys); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// check returns a nip01 machine-readable reason when the event must be rejected.
+func (p *relayPolicy) check(ctx context.Context, evt nostr.Event) (reject bool, msg string) {
+	if len(p.AllowedKinds) > 0 && !slices.Contains(p.AllowedKinds, evt.Kind) {
+		return true, fmt.Sprintf("blocked: kind %d is not accepted here", evt.Kind)
+	}
+	if _, isBlocked := p.blocked[evt.PubKey]; isBlocked {
+		return true, "blocked: you are banned from this relay"
+	}
+	if p.allowed != nil {
+		if _, isAllowed := p.allowed[evt.PubKey]; !isAllowed {
+			return true, "restricted: only allowed pubkeys can publish here"
+		}
+	}
+	if p.MaxEventSize > 0 {
+		if size := len(evt.String()); size > p.MaxEventSize {
+			return true, fmt.Sprintf("invalid: event is too large (%d bytes, max is %d)", size, p.MaxEventSize)
+		}
+	}
+	if p.MinPoW > 0 {
+		if work := nip13.Difficulty(evt.ID); work < p.MinPoW {
+			return true, fmt.Sprintf("pow: difficulty %d is less than %d", work, p.MinPoW)
+		}
+	}
+	if p.RateLimitPerIP > 0 {
+		if ip := khatru.GetIP(ctx); ip != "" && !p.allow("ip:"+ip, p.RateLimitPerIP) {
+			return true, "rate-limited: too many events from your ip, slow down"
+		}
+	}
+	if p.RateLimitPerPubKey > 0 && !p.allow("pk:"+evt.PubKey.Hex(), p.RateLimitPerPubKey) {
+		return true, "rate-limited: too many events from this pubkey, slow down"
+	}
+
+	return false, ""
+}
+
+// allow counts an event for the given key in the current one-minute window.
+func (p *relayPolicy) allow(key string, perMinute int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	counter, ok := p.counters[key]
+	if !ok || now.Sub(counter.window) > time.Minute {
+		p.counters[key] = &rateCounter{window: now, count: 1}
+		return true
+	}
+	counter.count++
+	return counter.count <= perMinute
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	KindPoll         nostr.Kind = 1068
+	KindPollResponse nostr.Kind = 1018
+)
+
+var poll = &cli.Command{
+	Name:  "poll",
+	Usage: "creates nip88 polls, votes on them and tallies their results",
+	Description: `examples:
+		nak poll create "what's the best client?" --option nak --option "something else" --ends 24h wss://relay.example.com
+		nak poll vote nevent1... --choice 1
+		nak poll results nevent1...
+		nak poll results --wot npub1... nevent1...`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "create",
+			Usage:                     "creates a poll (kind 1068), the relays given are where responses should be sent",
+			ArgsUsage:                 "<question> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(defaultKeyFlags,
+				relayFlag,
+				&cli.StringSliceFlag{
+					Name:     "option",
+					Aliases:  []string{"o"},
+					Usage:    "an option voters can choose, can be given multiple times",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:  "multiple",
+					Usage: "allow voters to choose more than one option",
+				},
+				&cli.DurationFlag{
+					Name:  "ends",
+					Usage: "close the poll after this amount of time",
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "nevent",
+					Usage:    "print the nevent code (to stderr) after the event is published",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing poll question")
+				}
+				options := c.StringSlice("option")
+				if len(options) < 2 {
+					return fmt.Errorf("a poll needs at least two options")
+				}
+
+				evt := nostr.Event{
+					Kind:    KindPoll,
+					Content: c.Args().First(),
+				}
+				for i, option := range options {
+					evt.Tags = append(evt.Tags, nostr.Tag{"option", strconv.Itoa(i + 1), option})
+				}
+				relays := getRelayURLs(c, c.Args().Tail())
+				for _, url := range relays {
+					evt.Tags = append(evt.Tags, nostr.Tag{"relay", url})
+				}
+				if c.Bool("multiple") {
+					evt.Tags = append(evt.Tags, nostr.Tag{"polltype", "multiplechoice"})
+				} else {
+					evt.Tags = append(evt.Tags, nostr.Tag{"polltype", "singlechoice"})
+				}
+				if ends := c.Duration("ends"); ends > 0 {
+					evt.Tags = append(evt.Tags, nostr.Tag{"endsAt", strconv.FormatInt(time.Now().Add(ends).Unix(), 10)})
+				}
+
+				return signPrintAndPublish(ctx, c, evt, relays)
+			},
+		},
+		{
+			Name:                      "vote",
+			Usage:                     "responds to a poll (kind 1018), by default publishing to the relays the poll asks for",
+			ArgsUsage:                 "<poll> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(defaultKeyFlags,
+				relayFlag,
+				&cli.StringSliceFlag{
+					Name:     "choice",
+					Usage:    "the option to vote for, either its position starting from 1 or its id, can be given multiple times on multiple choice polls",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "nevent",
+					Usage:    "print the nevent code (to stderr) after the event is published",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				pollEvt, err := fetchPoll(ctx, c)
+				if err != nil {
+					return err
+				}
+
+				if endsAt := pollEndsAt(*pollEvt); endsAt != 0 && endsAt < nostr.Now() {
+					return fmt.Errorf("poll has ended at %s", endsAt.Time().Format(time.DateTime))
+				}
+
+				options := pollOptions(*pollEvt)
+				choices := c.StringSlice("choice")
+				if len(choices) > 1 && !pollIsMultipleChoice(*pollEvt) {
+					return fmt.Errorf("this is a single choice poll, only one --choice is allowed")
+				}
+
+				evt := nostr.Event{
+					Kind: KindPollResponse,
+					Tags: nostr.Tags{{"e", pollEvt.ID.Hex()}},
+				}
+				for _, choice := range choices {
+					id := ""
+					if idx := slices.IndexFunc(options, func(o [2]string) bool { return o[0] == choice }); idx != -1 {
+						id = options[idx][0]
+					} else if n, err := strconv.Atoi(choice); err == nil && n >= 1 && n <= len(options) {
+						id = options[n-1][0]
+					} else {
+						return fmt.Errorf("invalid choice '%s', the poll has %d options", choice, len(options))
+					}
+					evt.Tags = append(evt.Tags, nostr.Tag{"response", id})
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = pollRelays(ctx, *pollEvt)
+				}
+
+				return signPrintAndPublish(ctx, c, evt, relays)
+			},
+		},
+		{
+			Name:                      "results",
+			Usage:                     "collects the responses to a poll and tallies them",
+			ArgsUsage:                 "<poll> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&PubKeyFlag{
+					Name:  "wot",
+					Usage: "only count votes from this pubkey and the pubkeys it follows",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the tally as json",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				pollEvt, err := fetchPoll(ctx, c)
+				if err != nil {
+					return err
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = pollRelays(ctx, *pollEvt)
+				}
+				if len(relays) == 0 {
+					return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+				}
+
+				var allowed []nostr.PubKey
+				if c.IsSet("wot") {
+					root := getPubKey(c, "wot")
+					allowed = append(allowed, root)
+					for _, f := range sys.FetchFollowList(ctx, root).Items {
+						allowed = append(allowed, f.Pubkey)
+					}
+				}
+
+				filter := nostr.Filter{
+					Kinds: []nostr.Kind{KindPollResponse},
+					Tags:  nostr.TagMap{"e": []string{pollEvt.ID.Hex()}},
+				}
+				if endsAt := pollEndsAt(*pollEvt); endsAt != 0 {
+					filter.Until = endsAt
+				}
+
+				// only the latest response from each pubkey counts
+				latest := make(map[nostr.PubKey]nostr.Event)
+				for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-poll"}) {
+					if allowed != nil && !slices.Contains(allowed, ie.Event.PubKey) {
+						continue
+					}
+					if prev, ok := latest[ie.Event.PubKey]; ok && prev.CreatedAt >= ie.Event.CreatedAt {
+						continue
+					}
+					latest[ie.Event.PubKey] = ie.Event
+				}
+
+				options := pollOptions(*pollEvt)
+				counts := make([]int, len(options))
+				multiple := pollIsMultipleChoice(*pollEvt)
+				for _, evt := range latest {
+					var chosen []string
+					for tag := range evt.Tags.FindAll("response") {
+						if !slices.Contains(chosen, tag[1]) {
+							chosen = append(chosen, tag[1])
+						}
+						if !multiple {
+							break
+						}
+					}
+					for _, id := range chosen {
+						if idx := slices.IndexFunc(options, func(o [2]string) bool { return o[0] == id }); idx != -1 {
+							counts[idx]++
+						}
+					}
+				}
+
+				if c.Bool("json") {
+					type result struct {
+						ID    string `json:"id"`
+						Label string `json:"label"`
+						Votes int    `json:"votes"`
+					}
+					results := make([]result, len(options))
+					for i, o := range options {
+						results[i] = result{o[0], o[1], counts[i]}
+					}
+					j, _ := json.Marshal(struct {
+						Question string   `json:"question"`
+						Voters   int      `json:"voters"`
+						Options  []result `json:"options"`
+					}{pollEvt.Content, len(latest), results})
+					stdout(string(j))
+					return nil
+				}
+
+				stdout(colors.bold(pollEvt.Content))
+				for i, o := range options {
+					pct := 0
+					if len(latest) > 0 {
+						pct = counts[i] * 100 / len(latest)
+					}
+					stdout(fmt.Sprintf("  %s %s %s",
+						color.YellowString("%3d%%", pct),
+						strings.Repeat("█", pct/5)+strings.Repeat("░", 20-pct/5),
+						fmt.Sprintf("%s (%d)", o[1], counts[i]),
+					))
+				}
+				stdout(fmt.Sprintf("%d voters", len(latest)))
+
+				return nil
+			},
+		},
+	},
+}
+
+func fetchPoll(ctx context.Context, c *cli.Command) (*nostr.Event, error) {
+	if c.Args().Len() < 1 {
+		return nil, fmt.Errorf("missing poll")
+	}
+	ptr, err := parsePointer(c.Args().First())
+	if err != nil {
+		return nil, err
+	}
+	evt, _, err := sys.FetchSpecificEvent(ctx, ptr, sdk.FetchSpecificEventParameters{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch poll: %w", err)
+	}
+	if evt.Kind != KindPoll {
+		return nil, fmt.Errorf("event is not a poll (expected kind %d, got %d)", KindPoll, evt.Kind)
+	}
+	return evt, nil
+}
+
+// pollOptions returns the [id, label] of each option in the order they appear in the poll.
+func pollOptions(evt nostr.Event) [][2]string {
+	var options [][2]string
+	for tag := range evt.Tags.FindAll("option") {
+		if len(tag) >= 3 {
+			options = append(options, [2]string{tag[1], tag[2]})
+		}
+	}
+	return options
+}
+
+func pollIsMultipleChoice(evt nostr.Event) bool {
+	pt := evt.Tags.Find("polltype")
+	return pt != nil && pt[1] == "multiplechoice"
+}
+
+func pollEndsAt(evt nostr.Event) nostr.Timestamp {
+	if tag := evt.Tags.Find("endsAt"); tag != nil {
+		if ts, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+			return nostr.Timestamp(ts)
+		}
+	}
+	return 0
+}
+
+// pollRelays returns the relays the poll asks responses to be sent to, falling back to the outbox
+// relays of the poll author.
+func pollRelays(ctx context.Context, evt nostr.Event) []string {
+	var relays []string
+	for tag := range evt.Tags.FindAll("relay") {
+		relays = appendUnique(relays, tag[1])
+	}
+	if len(relays) == 0 {
+		relays = sys.FetchOutboxRelays(ctx, evt.PubKey, 3)
+	}
+	return relays
+}
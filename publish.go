@@ -24,6 +24,7 @@ example:
 	echo "tagged post" | nak publish -t t=mytag -t e=someeventid`,
 	DisableSliceFlagSeparator: true,
 	Flags: append(defaultKeyFlags,
+		relayFlag,
 		&cli.StringFlag{
 			Name:  "reply",
 			Usage: "event id, naddr1 or nevent1 code to reply to",
@@ -31,7 +32,7 @@ example:
 		&cli.StringSliceFlag{
 			Name:    "tag",
 			Aliases: []string{"t"},
-			Usage:   "sets a tag field on the event, takes a value like -t e=<id> or -t sometag=\"value one;value two;value three\"",
+			Usage:   "sets a tag field on the event, takes a value like -t e=<id> or -t sometag=\"value one;value two;value three\" (escape ';' in values as '\\;')",
 		},
 		&NaturalTimeFlag{
 			Name:        "created-at",
@@ -123,13 +124,9 @@ example:
 		// handle other tags -- copied from event.go
 		tagFlags := c.StringSlice("tag")
 		for _, tagFlag := range tagFlags {
-			// tags are in the format key=value
-			tagName, tagValue, found := strings.Cut(tagFlag, "=")
-			tag := []string{tagName}
-			if found {
-				// tags may also contain extra elements separated with a ";"
-				tagValues := strings.Split(tagValue, ";")
-				tag = append(tag, tagValues...)
+			tag, err := parseTagFlag(tagFlag)
+			if err != nil {
+				return err
 			}
 			evt.Tags = append(evt.Tags, tag)
 		}
@@ -150,7 +147,10 @@ example:
 		relayUrls := sys.FetchWriteRelays(ctx, pk)
 		relayUrls = nostr.AppendUnique(relayUrls, targetRelays...)
 		relayUrls = nostr.AppendUnique(relayUrls, replyRelays...)
-		relayUrls = nostr.AppendUnique(relayUrls, c.Args().Slice()...)
+		relayUrls = nostr.AppendUnique(relayUrls, getRelayURLs(c, c.Args().Slice())...)
+		if len(relayUrls) == 0 {
+			relayUrls = loadConfigRelays(c.String("config-path"), "default-relays")
+		}
 		relays := connectToAllRelays(ctx, c, relayUrls, nil,
 			nostr.PoolOptions{
 				AuthRequiredHandler: func(ctx context.Context, authEvent *nostr.Event) error {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mdp/qrterminal/v3"
+	"rsc.io/qr"
+)
+
+// isQRWorthy tells if an output is something people usually need to move to their phones.
+func isQRWorthy(value string) bool {
+	for _, prefix := range []string{"npub1", "nsec1", "ncryptsec1", "nprofile1", "bunker://", "nostrconnect://"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return isBolt11(value)
+}
+
+// qrText is what goes in the qr code: bolt11 invoices are uppercased, which makes smaller codes
+// and is what wallets expect.
+func qrText(value string) string {
+	if isBolt11(value) {
+		return strings.ToUpper(value)
+	}
+	return value
+}
+
+// setupQROutput makes stdout also draw a qr code on the terminal for the keys, uris and invoices
+// it prints, when --qr is given.
+func setupQROutput() {
+	printNext := stdout
+	stdout = func(args ...any) {
+		printNext(args...)
+		if len(args) == 1 {
+			if value, ok := args[0].(string); ok && isQRWorthy(value) {
+				qrterminal.GenerateHalfBlock(qrText(value), qrterminal.L, os.Stderr)
+			}
+		}
+	}
+}
+
+// setupQRPNGOutput makes stdout also save a qr code as png for the keys, uris and invoices it prints,
+// to the given path or, after the first, to numbered paths next to it.
+func setupQRPNGOutput(path string) {
+	mu := sync.Mutex{}
+	count := 0
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		printNext(args...)
+		if len(args) != 1 {
+			return
+		}
+		value, ok := args[0].(string)
+		if !ok || !isQRWorthy(value) {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		target := path
+		if count > 1 {
+			ext := filepath.Ext(path)
+			target = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), count, ext)
+		}
+
+		code, err := qr.Encode(qrText(value), qr.M)
+		if err != nil {
+			log("failed to generate qr code: %s\n", err)
+			return
+		}
+		code.Scale = 8
+		if err := os.WriteFile(target, code.PNG(), 0600); err != nil {
+			log("failed to write qr code: %s\n", err)
+			return
+		}
+		logverbose("qr code saved to %s\n", target)
+	}
+}
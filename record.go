@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+// recordedFrame is one line of a recording file.
+type recordedFrame struct {
+	Time      int64              `json:"t"`   // milliseconds since the connection started
+	Direction string             `json:"dir"` // "client" for client->relay, "relay" for relay->client
+	Message   stdjson.RawMessage `json:"msg"`
+}
+
+var record = &cli.Command{
+	Name:  "record",
+	Usage: "proxies connections to a relay, recording all messages with their timing to a file",
+	Description: `starts a local relay that forwards everything to the given relay and writes every message exchanged, in both directions, to the output file. point your client to the local address and reproduce the problem, then the recording can be served with 'nak replay'.
+
+example:
+		nak record wss://relay.damus.io --output session.jsonl
+		# connect the client to ws://localhost:10548`,
+	ArgsUsage:                 "<relay-url>",
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:      "output",
+			Aliases:   []string{"o"},
+			Usage:     "file where to write the recording",
+			Value:     "session.jsonl",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "hostname where to listen for connections",
+			Value: "localhost",
+		},
+		&cli.UintFlag{
+			Name:  "port",
+			Usage: "port where to listen for connections",
+			Value: 10548,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		relayUrl := c.Args().First()
+		if relayUrl == "" {
+			return fmt.Errorf("missing relay url")
+		}
+		relayUrl = normalizeRelayURL(relayUrl)
+
+		file, err := os.Create(c.String("output"))
+		if err != nil {
+			return fmt.Errorf("failed to create recording file: %w", err)
+		}
+		defer file.Close()
+
+		mu := sync.Mutex{}
+		write := func(start time.Time, dir string, msg []byte) {
+			line, _ := stdjson.Marshal(recordedFrame{
+				Time:      time.Since(start).Milliseconds(),
+				Direction: dir,
+				Message:   msg,
+			})
+			mu.Lock()
+			file.Write(append(line, '\n'))
+			mu.Unlock()
+		}
+
+		addr := net.JoinHostPort(c.String("hostname"), strconv.FormatUint(c.Uint("port"), 10))
+		log("%s recording %s at %s to %s\n", color.HiRedString(">"), relayUrl, colors.boldf("ws://%s", addr), c.String("output"))
+
+		return serveWebsocket(ctx, addr, func(ctx context.Context, client *websocket.Conn) {
+			upstream, _, err := websocket.Dial(ctx, relayUrl, nil)
+			if err != nil {
+				log("failed to connect to %s: %s\n", relayUrl, err)
+				client.Close(websocket.StatusInternalError, "upstream unavailable")
+				return
+			}
+			defer upstream.CloseNow()
+			upstream.SetReadLimit(-1)
+
+			log("    client connected\n")
+			start := time.Now()
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			pipe := func(from, to *websocket.Conn, dir string) {
+				defer cancel()
+				for {
+					typ, msg, err := from.Read(ctx)
+					if err != nil {
+						return
+					}
+					write(start, dir, msg)
+					if err := to.Write(ctx, typ, msg); err != nil {
+						return
+					}
+				}
+			}
+			go pipe(client, upstream, "client")
+			pipe(upstream, client, "relay")
+			log("    client disconnected\n")
+		})
+	},
+}
+
+var replay = &cli.Command{
+	Name:  "replay",
+	Usage: "serves a recording made with 'nak record' as a fake relay",
+	Description: `each client that connects gets the same messages the relay sent in the recording, with the original timing (counted from the first message the client sends) or faster with --speed. what the client sends is only logged.
+
+example:
+		nak replay session.jsonl --speed 10`,
+	ArgsUsage:                 "<recording-file>",
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.FloatFlag{
+			Name:  "speed",
+			Usage: "how much faster than the original to replay, 0 sends everything at once",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "hostname where to listen for connections",
+			Value: "localhost",
+		},
+		&cli.UintFlag{
+			Name:  "port",
+			Usage: "port where to listen for connections",
+			Value: 10547,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		path := c.Args().First()
+		if path == "" {
+			return fmt.Errorf("missing recording file")
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open recording: %w", err)
+		}
+		frames := make([]recordedFrame, 0, 100)
+		var firstClientTime int64 = -1
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 16*1024*1024), 256*1024*1024)
+		for i := 1; scanner.Scan(); i++ {
+			var frame recordedFrame
+			if err := stdjson.Unmarshal(scanner.Bytes(), &frame); err != nil {
+				file.Close()
+				return fmt.Errorf("invalid frame at line %d: %w", i, err)
+			}
+			if frame.Direction == "client" && firstClientTime == -1 {
+				firstClientTime = frame.Time
+			}
+			frames = append(frames, frame)
+		}
+		file.Close()
+		if firstClientTime == -1 {
+			firstClientTime = 0
+		}
+
+		speed := c.Float("speed")
+		addr := net.JoinHostPort(c.String("hostname"), strconv.FormatUint(c.Uint("port"), 10))
+		log("%s replaying %d messages from %s at %s\n", color.HiRedString(">"), len(frames), path, colors.boldf("ws://%s", addr))
+
+		return serveWebsocket(ctx, addr, func(ctx context.Context, client *websocket.Conn) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			started := make(chan time.Time, 1)
+			go func() {
+				defer cancel()
+				first := true
+				for {
+					_, msg, err := client.Read(ctx)
+					if err != nil {
+						return
+					}
+					if first {
+						started <- time.Now()
+						first = false
+					}
+					log("    client sent %s\n", colors.italic(string(msg)))
+				}
+			}()
+
+			var start time.Time
+			select {
+			case start = <-started:
+			case <-ctx.Done():
+				return
+			}
+
+			for _, frame := range frames {
+				if frame.Direction != "relay" {
+					continue
+				}
+				if speed > 0 && frame.Time > firstClientTime {
+					wait := time.Duration(float64(time.Duration(frame.Time-firstClientTime)*time.Millisecond) / speed)
+					select {
+					case <-time.After(time.Until(start.Add(wait))):
+					case <-ctx.Done():
+						return
+					}
+				}
+				if err := client.Write(ctx, websocket.MessageText, frame.Message); err != nil {
+					return
+				}
+			}
+			log("    replay finished, keeping the connection open\n")
+			<-ctx.Done()
+		})
+	},
+}
+
+// serveWebsocket accepts websocket connections at addr and calls handle for each, until ctx is done.
+func serveWebsocket(ctx context.Context, addr string, handle func(ctx context.Context, client *websocket.Conn)) error {
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+			if err != nil {
+				return
+			}
+			defer client.CloseNow()
+			client.SetReadLimit(-1)
+			handle(r.Context(), client)
+		}),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -3,21 +3,422 @@ package main
 import (
 	"context"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/nip11"
+	"github.com/fiatjaf/nak/lib"
 	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
 )
 
+const (
+	KindRelayMonitorAnnouncement nostr.Kind = 10166
+	KindRelayDiscovery           nostr.Kind = 30166
+)
+
+var defaultMonitorRelays = []string{"wss://relay.nostr.watch", "wss://monitorlizard.nostr1.com", "wss://relaypag.es"}
+
 var relay = &cli.Command{
 	Name:  "relay",
 	Usage: "gets the relay information document for the given relay, as JSON",
 	Description: `
 		nak relay nostr.wine
+		nak relay discover --nip 50 --free
+		nak relay ping nos.lol relay.damus.io
+		nak relay export nos.lol --author <pubkey> > dump.jsonl
 `,
 	ArgsUsage:                 "<relay-url>",
 	DisableSliceFlagSeparator: true,
+	Flags:                     []cli.Flag{relayFlag}, // also applies to all subcommands
+	Commands: []*cli.Command{
+		relayExport,
+		relayImport,
+		relayAdmin,
+		{
+			Name:  "discover",
+			Usage: "finds relays by querying nip66 relay monitor reports",
+			Description: `queries the kind 30166 relay discovery events published by nip66 relay monitors (the pubkeys that announce themselves with kind 10166) and prints the relays that match the given criteria, ranked by how many monitors have seen them recently and by their connection latency.
+
+the output is one relay url per line, so it can be piped into other commands.
+
+example:
+		nak relay discover --nip 50 --country BR
+		nak relay discover --free --no-auth --limit 5 | nak event -c hello`,
+			ArgsUsage:                 "[monitor-relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.IntSliceFlag{
+					Name:  "nip",
+					Usage: "only relays that support this nip, can be given multiple times",
+				},
+				&cli.StringSliceFlag{
+					Name:  "country",
+					Usage: "only relays located in this country (ISO-3166-1 alpha-2 code), can be given multiple times",
+				},
+				&cli.StringFlag{
+					Name:  "network",
+					Usage: "only relays in this network: clearnet, tor, i2p or loki",
+				},
+				&cli.BoolFlag{
+					Name:  "free",
+					Usage: "only relays that don't require payment",
+				},
+				&cli.BoolFlag{
+					Name:  "paid",
+					Usage: "only relays that require payment",
+				},
+				&cli.BoolFlag{
+					Name:  "no-auth",
+					Usage: "only relays that don't require nip42 authentication",
+				},
+				&cli.DurationFlag{
+					Name:  "max-age",
+					Usage: "ignore monitor reports older than this, relays without recent reports are considered offline",
+					Value: time.Hour * 24,
+				},
+				&cli.UintFlag{
+					Name:  "min-monitors",
+					Usage: "only relays that have been seen by at least this many monitors",
+					Value: 1,
+				},
+				&PubKeySliceFlag{
+					Name:  "monitor",
+					Usage: "only trust reports from these monitors instead of everybody that has published a kind 10166 announcement",
+				},
+				&cli.UintFlag{
+					Name:  "limit",
+					Usage: "maximum number of relays to output",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print details about each relay as json",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Bool("free") && c.Bool("paid") {
+					return fmt.Errorf("incompatible flags --free and --paid")
+				}
+
+				relays := getRelayURLs(c, c.Args().Slice())
+				if len(relays) == 0 {
+					relays = defaultMonitorRelays
+				}
+
+				monitors := getPubKeySlice(c, "monitor")
+				if len(monitors) == 0 {
+					for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{
+						Kinds: []nostr.Kind{KindRelayMonitorAnnouncement},
+					}, nostr.SubscriptionOptions{Label: "nak-discover"}) {
+						monitors = appendUnique(monitors, ie.Event.PubKey)
+					}
+					if len(monitors) == 0 {
+						return fmt.Errorf("no relay monitors found on %v", relays)
+					}
+					log("found %d relay monitors\n", len(monitors))
+				}
+
+				type discovered struct {
+					URL      string          `json:"url"`
+					Monitors []nostr.PubKey  `json:"monitors"`
+					RTTOpen  int             `json:"rtt_open,omitempty"`
+					NIPs     []int           `json:"nips,omitempty"`
+					Network  string          `json:"network,omitempty"`
+					Country  string          `json:"country,omitempty"`
+					Paid     bool            `json:"paid"`
+					Auth     bool            `json:"auth"`
+					LastSeen nostr.Timestamp `json:"last_seen"`
+
+					rtts []int
+				}
+				found := make(map[string]*discovered)
+
+				filter := nostr.Filter{
+					Kinds:   []nostr.Kind{KindRelayDiscovery},
+					Authors: monitors,
+					Since:   nostr.Timestamp(time.Now().Add(-c.Duration("max-age")).Unix()),
+				}
+				if network := c.String("network"); network != "" {
+					filter.Tags = nostr.TagMap{"n": []string{network}}
+				}
+
+				for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-discover"}) {
+					evt := ie.Event
+					url := evt.Tags.GetD()
+					if url == "" {
+						continue
+					}
+					url = normalizeRelayURL(url)
+
+					d, ok := found[url]
+					if !ok {
+						d = &discovered{URL: url}
+						found[url] = d
+					}
+					if slices.Contains(d.Monitors, evt.PubKey) {
+						// we only take the first report from each monitor
+						continue
+					}
+					d.Monitors = append(d.Monitors, evt.PubKey)
+					if evt.CreatedAt > d.LastSeen {
+						d.LastSeen = evt.CreatedAt
+					}
+
+					for _, tag := range evt.Tags {
+						if len(tag) < 2 {
+							continue
+						}
+						switch tag[0] {
+						case "rtt-open":
+							if ms, err := strconv.Atoi(tag[1]); err == nil {
+								d.rtts = append(d.rtts, ms)
+							}
+						case "N":
+							if nip, err := strconv.Atoi(tag[1]); err == nil {
+								d.NIPs = appendUnique(d.NIPs, nip)
+							}
+						case "n":
+							d.Network = tag[1]
+						case "R":
+							switch tag[1] {
+							case "payment":
+								d.Paid = true
+							case "auth":
+								d.Auth = true
+							}
+						case "l":
+							if len(tag) >= 3 && tag[2] == "countryCode" {
+								d.Country = strings.ToUpper(tag[1])
+							}
+						}
+					}
+				}
+
+				countries := c.StringSlice("country")
+				for i, country := range countries {
+					countries[i] = strings.ToUpper(country)
+				}
+
+				results := make([]*discovered, 0, len(found))
+			candidates:
+				for _, d := range found {
+					if uint64(len(d.Monitors)) < c.Uint("min-monitors") {
+						continue
+					}
+					for _, nip := range c.IntSlice("nip") {
+						if !slices.Contains(d.NIPs, int(nip)) {
+							continue candidates
+						}
+					}
+					if len(countries) > 0 && !slices.Contains(countries, d.Country) {
+						continue
+					}
+					if (c.Bool("free") && d.Paid) || (c.Bool("paid") && !d.Paid) {
+						continue
+					}
+					if c.Bool("no-auth") && d.Auth {
+						continue
+					}
+					if len(d.rtts) > 0 {
+						sum := 0
+						for _, rtt := range d.rtts {
+							sum += rtt
+						}
+						d.RTTOpen = sum / len(d.rtts)
+					}
+					slices.Sort(d.NIPs)
+					results = append(results, d)
+				}
+
+				slices.SortFunc(results, func(a, b *discovered) int {
+					if len(a.Monitors) != len(b.Monitors) {
+						return len(b.Monitors) - len(a.Monitors)
+					}
+					if a.RTTOpen == 0 || b.RTTOpen == 0 {
+						// relays with unknown latency go last
+						return b.RTTOpen - a.RTTOpen
+					}
+					return a.RTTOpen - b.RTTOpen
+				})
+				if limit := int(c.Uint("limit")); limit > 0 && len(results) > limit {
+					results = results[0:limit]
+				}
+
+				for _, d := range results {
+					if c.Bool("json") {
+						j, _ := json.Marshal(d)
+						stdout(string(j))
+					} else {
+						stdout(d.URL)
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "normalize",
+			Usage: "prints relay urls in the same canonical form nak uses internally",
+			Description: `reads relay urls from the arguments or from stdin, one per line, and prints each one normalized: with the ws:// or wss:// scheme (inferred when missing), lowercase punycode hostname, no default port and no trailing slash.
+
+common typos like "wss:/" or "wws://" are fixed with a warning, urls that can't be fixed are reported as errors.
+
+example:
+		nak relay normalize Relay.Damus.io/ wss:/nos.lol
+		cat relays.txt | nak relay normalize --strict | sort -u`,
+			ArgsUsage: "[relay-url...]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "strict",
+					Usage: "treat urls that needed typos fixed as errors instead of fixing them",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				for url := range getStdinLinesOrArgumentsFromSlice(append(c.Args().Slice(), c.StringSlice("relay")...)) {
+					if strings.TrimSpace(url) == "" {
+						continue
+					}
+					_, fixes, err := lib.NormalizeRelayURL(url)
+					if err != nil {
+						ctx = lineProcessingError(ctx, "%s", err)
+						continue
+					}
+					if len(fixes) > 0 && c.Bool("strict") {
+						ctx = lineProcessingError(ctx, "relay url '%s' has typos: %s", strings.TrimSpace(url), strings.Join(fixes, ", "))
+						continue
+					}
+					stdout(normalizeRelayURL(url)) // this will warn about the fixes
+				}
+
+				exitIfLineProcessingError(ctx)
+				return nil
+			},
+		},
+		{
+			Name:  "ping",
+			Usage: "checks if relays are alive and reports their latency and software",
+			Description: `for each relay this connects to its websocket, fetches its nip11 information document (using the http date header to estimate how far its clock is from ours) and performs a trivial REQ, all concurrently, then prints a table with the results (or one json object per relay with --json).
+
+example:
+		nak relay ping nos.lol relay.damus.io nostr.wine
+		nak relay discover --nip 50 | nak relay ping --sort latency`,
+			ArgsUsage:                 "[relay-url...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "how long to wait for each relay before considering it offline",
+					Value: time.Second * 10,
+				},
+				&cli.UintFlag{
+					Name:  "concurrency",
+					Usage: "how many relays to check at the same time",
+					Value: 32,
+				},
+				&cli.StringFlag{
+					Name:  "sort",
+					Usage: "sort the results by \"url\", \"latency\" or \"software\"",
+					Value: "latency",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print one json object per relay instead of a table",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				sortBy := c.String("sort")
+				if sortBy != "url" && sortBy != "latency" && sortBy != "software" {
+					return fmt.Errorf("invalid --sort '%s', expected url, latency or software", sortBy)
+				}
+
+				var urls []string
+				for url := range getStdinLinesOrArgumentsFromSlice(getRelayURLs(c, c.Args().Slice())) {
+					if url != "" {
+						urls = appendUnique(urls, normalizeRelayURL(url))
+					}
+				}
+				if len(urls) == 0 {
+					return fmt.Errorf("specify some relay urls")
+				}
+
+				results := make([]relayPingResult, len(urls))
+				errg := errgroup.Group{}
+				errg.SetLimit(int(c.Uint("concurrency")))
+				for i, url := range urls {
+					errg.Go(func() error {
+						results[i] = pingRelay(ctx, url, c.Duration("timeout"))
+						return nil
+					})
+				}
+				errg.Wait()
+
+				slices.SortStableFunc(results, func(a, b relayPingResult) int {
+					if a.Online != b.Online {
+						// offline relays always go last
+						if a.Online {
+							return -1
+						}
+						return 1
+					}
+					switch sortBy {
+					case "latency":
+						return int(a.ConnectMs+a.REQMs) - int(b.ConnectMs+b.REQMs)
+					case "software":
+						return strings.Compare(a.Software+a.Version, b.Software+b.Version)
+					default:
+						return strings.Compare(a.URL, b.URL)
+					}
+				})
+
+				if c.Bool("json") {
+					for _, res := range results {
+						j, _ := json.Marshal(res)
+						stdout(string(j))
+					}
+					return nil
+				}
+
+				table := &strings.Builder{}
+				w := tabwriter.NewWriter(table, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "RELAY\tSTATUS\tCONNECT\tNIP11\tREQ\tCLOCK\tSOFTWARE")
+				for _, res := range results {
+					if !res.Online {
+						fmt.Fprintf(w, "%s\t%s\t\t\t\t\t%s\n", res.URL, colors.error("offline"), res.Error)
+						continue
+					}
+					software := res.Software
+					if res.Version != "" {
+						software += " " + res.Version
+					}
+					nip11ms := "-"
+					if res.NIP11Ms > 0 {
+						nip11ms = fmt.Sprintf("%dms", res.NIP11Ms)
+					}
+					// the skew measurement only has second precision
+					clock := "-"
+					if res.ClockSkew >= 2000 || res.ClockSkew <= -2000 {
+						clock = fmt.Sprintf("%+ds", res.ClockSkew/1000)
+					} else if res.ClockSkew != 0 {
+						clock = "ok"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%dms\t%s\t%dms\t%s\t%s\n",
+						res.URL, colors.success("online"), res.ConnectMs, nip11ms, res.REQMs, clock, software)
+				}
+				w.Flush()
+				stdout(strings.TrimSuffix(table.String(), "\n"))
+
+				return nil
+			},
+		},
+	},
 	Action: func(ctx context.Context, c *cli.Command) error {
-		for url := range getStdinLinesOrArguments(c.Args()) {
+		for url := range getStdinLinesOrArgumentsFromSlice(getRelayURLs(c, c.Args().Slice())) {
 			if url == "" {
 				return fmt.Errorf("specify the <relay-url>")
 			}
@@ -34,3 +435,95 @@ var relay = &cli.Command{
 		return nil
 	},
 }
+
+type relayPingResult struct {
+	URL       string `json:"url"`
+	Online    bool   `json:"online"`
+	ConnectMs int64  `json:"connect_ms,omitempty"`
+	NIP11Ms   int64  `json:"nip11_ms,omitempty"`
+	REQMs     int64  `json:"req_ms,omitempty"`
+	Software  string `json:"software,omitempty"`
+	Version   string `json:"version,omitempty"`
+	ClockSkew int64  `json:"clock_skew_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// pingRelay connects to a relay, fetches its nip11 document and performs a REQ that should
+// be answered immediately, measuring how long each of these steps takes.
+// a relay is considered online if it accepts the connection and answers the REQ with EOSE or CLOSED.
+func pingRelay(ctx context.Context, url string, timeout time.Duration) relayPingResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var info nip11.RelayInformationDocument
+	var nip11ms int64
+	var skew time.Duration
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		if doc, err := nip11.Fetch(ctx, url); err == nil {
+			nip11ms = time.Since(start).Milliseconds()
+			info = doc
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		skew, _ = relayClockSkew(ctx, url)
+	}()
+
+	res := probeRelayWebsocket(ctx, url)
+
+	wg.Wait()
+	res.NIP11Ms = nip11ms
+	res.Software = info.Software
+	res.Version = info.Version
+	if res.Online {
+		res.ClockSkew = skew.Milliseconds()
+	}
+	return res
+}
+
+func probeRelayWebsocket(ctx context.Context, url string) relayPingResult {
+	res := relayPingResult{URL: url}
+
+	start := time.Now()
+	r, err := nostr.RelayConnect(ctx, url, nostr.RelayOptions{
+		NoticeHandler: func(*nostr.Relay, string) {},
+	})
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer r.Close()
+	res.ConnectMs = time.Since(start).Milliseconds()
+
+	start = time.Now()
+	sub, err := r.Subscribe(ctx, nostr.Filter{Kinds: []nostr.Kind{1}, Limit: 1}, nostr.SubscriptionOptions{
+		Label:          "nak-ping",
+		MaxWaitForEOSE: math.MaxInt64,
+	})
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case <-sub.Events:
+		case <-sub.EndOfStoredEvents:
+			res.Online = true
+			res.REQMs = time.Since(start).Milliseconds()
+			return res
+		case <-sub.ClosedReason:
+			res.Online = true
+			res.REQMs = time.Since(start).Milliseconds()
+			return res
+		case <-ctx.Done():
+			res.Error = "timed out waiting for EOSE"
+			return res
+		}
+	}
+}
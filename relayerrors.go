@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+// relayErrorClass says what kind of problem happened when talking to a relay, so commands can
+// report it the same way and know whether trying again could help.
+type relayErrorClass string
+
+const (
+	errClassDial           relayErrorClass = "dial-failure"
+	errClassTLS            relayErrorClass = "tls-failure"
+	errClassAuthRequired   relayErrorClass = "auth-required"
+	errClassRateLimited    relayErrorClass = "rate-limited"
+	errClassClosedByRelay  relayErrorClass = "closed-by-relay"
+	errClassInvalidMessage relayErrorClass = "invalid-message"
+	errClassTimeout        relayErrorClass = "timeout"
+	errClassRejected       relayErrorClass = "rejected"
+	errClassUnknown        relayErrorClass = "unknown"
+)
+
+const maxRelayAttempts = 3
+
+// retriable tells if the same operation may succeed if tried again in a moment.
+func (class relayErrorClass) retriable() bool {
+	switch class {
+	case errClassDial, errClassRateLimited, errClassClosedByRelay, errClassTimeout:
+		return true
+	}
+	return false
+}
+
+// classifyRelayError looks at the error chain and at the message the relay sent to find out what went wrong.
+func classifyRelayError(err error) relayErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCertErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidCertErr) {
+		return errClassTLS
+	}
+
+	msg := err.Error()
+
+	// "msg: <reason>" is how the relay's OK false and CLOSED reasons arrive
+	if _, reason, ok := strings.Cut(msg, "msg: "); ok {
+		switch closedReasonPrefix(reason) {
+		case "auth-required":
+			return errClassAuthRequired
+		case "rate-limited":
+			return errClassRateLimited
+		case "invalid":
+			return errClassInvalidMessage
+		default:
+			return errClassRejected
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return errClassTLS
+	case errors.As(err, &dnsErr), errors.As(err, &opErr) && opErr.Op == "dial",
+		strings.Contains(msg, "error opening websocket"), strings.Contains(msg, "connection took too long"),
+		strings.Contains(msg, "failed to connect"):
+		return errClassDial
+	case errors.Is(err, nostr.ErrDisconnected), strings.Contains(msg, "<closed>"),
+		strings.Contains(msg, "not connected to"), strings.Contains(msg, "relay: "):
+		return errClassClosedByRelay
+	case errors.Is(err, context.DeadlineExceeded), strings.Contains(msg, "given up waiting"),
+		strings.Contains(msg, "took too long"):
+		return errClassTimeout
+	case strings.Contains(msg, "failed to decode") || strings.Contains(msg, "invalid message"):
+		return errClassInvalidMessage
+	}
+
+	return errClassUnknown
+}
+
+// withRelayRetries runs op again, with increasing delays, while it fails with retriable errors,
+// up to maxRelayAttempts times in total or just once with --no-retry. c is nil when there are no flags to check.
+func withRelayRetries(ctx context.Context, c *cli.Command, url string, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		class := classifyRelayError(err)
+		if (c != nil && c.Bool("no-retry")) || !class.retriable() || attempt >= maxRelayAttempts {
+			return err
+		}
+
+		delay := time.Duration(attempt) * time.Second
+		if class == errClassRateLimited {
+			delay *= 5
+		}
+		logverbose("%s failed (%s), trying again in %s: %s\n", url, class, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// publishManyWithRetries is like pool.PublishMany, but retries each relay separately on transient errors.
+func publishManyWithRetries(ctx context.Context, c *cli.Command, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	results := make(chan nostr.PublishResult, len(urls))
+	wg := sync.WaitGroup{}
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var res nostr.PublishResult
+			withRelayRetries(ctx, c, url, func() error {
+				for res = range sys.Pool.PublishMany(ctx, []string{url}, evt) {
+				}
+				return res.Error
+			})
+			results <- res
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var relayExport = &cli.Command{
+	Name:  "export",
+	Usage: "dumps all events from a relay (or the ones matching a filter) as jsonl, like 'strfry export'",
+	Description: `paginates backwards through the relay until nothing else is returned, printing one event per line.
+
+with --resume, the oldest timestamp reached is saved to the given file, and if the same file is given again the export continues from where it stopped.
+
+example:
+		nak relay export wss://relay.example.com --since 2024-01-01 --resume export.marker > dump.jsonl`,
+	ArgsUsage:                 "<relay-url>",
+	DisableSliceFlagSeparator: true,
+	Flags: append(slices.Clip(reqFilterFlags),
+		&cli.StringFlag{
+			Name:      "resume",
+			Usage:     "file where to store (and read) the progress so an interrupted export can be continued",
+			TakesFile: true,
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "time to wait between each page",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		url, _, err := getSingleRelayURL(c)
+		if err != nil {
+			return err
+		}
+
+		filter := nostr.Filter{}
+		if err := applyFlagsToFilter(c, &filter); err != nil {
+			return err
+		}
+
+		marker := c.String("resume")
+		if marker != "" {
+			if data, err := os.ReadFile(marker); err == nil {
+				if ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+					filter.Until = nostr.Timestamp(ts)
+					log("resuming export from %s\n", filter.Until.Time().Format(time.DateTime))
+				}
+			}
+		}
+
+		count := 0
+		oldest := filter.Until
+		saveMarker := func() {
+			if marker != "" && oldest != 0 {
+				os.WriteFile(marker, []byte(strconv.FormatInt(int64(oldest), 10)), 0644)
+			}
+		}
+		defer saveMarker()
+
+		paginator := sys.Pool.PaginatorWithInterval(c.Duration("interval"))
+		for ie := range paginator(ctx, []string{url}, filter, nostr.SubscriptionOptions{Label: "nak-export"}) {
+			stdout(ie.Event)
+			count++
+			if oldest == 0 || ie.Event.CreatedAt < oldest {
+				oldest = ie.Event.CreatedAt
+			}
+			if count%1000 == 0 {
+				saveMarker()
+				log("exported %d events, now at %s\n", count, oldest.Time().Format(time.DateTime))
+			}
+		}
+
+		log("exported %d events\n", count)
+		return nil
+	},
+}
+
+var relayImport = &cli.Command{
+	Name:  "import",
+	Usage: "publishes events from a jsonl dump (like the ones from 'strfry export' or 'nak relay export') to a relay",
+	Description: `reads events from stdin, one per line, validates them and publishes them to the relay one by one, reporting progress.
+
+with --resume, the number of lines already processed is saved to the given file, and if the same file is given again these lines are skipped.
+
+example:
+		nak relay import wss://other.example.com --resume import.marker < dump.jsonl`,
+	ArgsUsage:                 "<relay-url>",
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:      "resume",
+			Usage:     "file where to store (and read) the progress so an interrupted import can be continued",
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:  "no-validate",
+			Usage: "don't check ids and signatures before publishing",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		url, _, err := getSingleRelayURL(c)
+		if err != nil {
+			return err
+		}
+		if !isPiped() {
+			return fmt.Errorf("no events given on stdin")
+		}
+
+		relay, err := sys.Pool.EnsureRelay(url)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", url, err)
+		}
+
+		marker := c.String("resume")
+		skip := 0
+		if marker != "" {
+			if data, err := os.ReadFile(marker); err == nil {
+				skip, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+				log("resuming import after line %d\n", skip)
+			}
+		}
+
+		line := 0
+		published, rejected, invalid := 0, 0, 0
+		saveMarker := func() {
+			if marker != "" {
+				os.WriteFile(marker, []byte(strconv.Itoa(line)), 0644)
+			}
+		}
+		defer saveMarker()
+
+		start := time.Now()
+		for stdinLine := range getStdinLinesOrBlank() {
+			line++
+			if line <= skip || stdinLine == "" {
+				continue
+			}
+
+			var evt nostr.Event
+			if err := easyjson.Unmarshal([]byte(stdinLine), &evt); err != nil {
+				log("line %d: invalid event: %s\n", line, err)
+				invalid++
+				continue
+			}
+			if !c.Bool("no-validate") {
+				if validID, validSig := verifyEventCached(evt); !validID || !validSig {
+					log("line %d: event %s has an invalid id or signature\n", line, evt.ID.Hex())
+					invalid++
+					continue
+				}
+			}
+
+			if err := relay.Publish(ctx, evt); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logverbose("line %d: %s rejected: %s\n", line, evt.ID.Hex(), err)
+				rejected++
+			} else {
+				published++
+			}
+
+			if line%1000 == 0 {
+				saveMarker()
+				log("%d lines processed, %d published, %d rejected, %d invalid (%.0f events/s)\n",
+					line, published, rejected, invalid, float64(line-skip)/time.Since(start).Seconds())
+			}
+		}
+
+		log("%d published, %d rejected, %d invalid\n", published, rejected, invalid)
+		return nil
+	},
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var smartRelaysFlag = &cli.BoolFlag{
+	Name: "smart-relays",
+	Usage: "choose the relays for each query from the ones given, the hints and the ones used before, ranked by how often they " +
+		"answered, how fast and whether they had events from the authors in the filter",
+	Category: CATEGORY_EXTRAS,
+}
+
+var smartRelaysLimitFlag = &cli.UintFlag{
+	Name:     "smart-relays-limit",
+	Usage:    "how many relays --smart-relays should pick for each query",
+	Value:    3,
+	Category: CATEGORY_EXTRAS,
+}
+
+// stats for each relay are kept under the 's' prefix, the list of relays we have stats for under 'S'
+// and the relays where events from each author were found under 'p'.
+const (
+	relayStatsPrefix  = byte('s')
+	relayStatsListKey = "S"
+	authorRelaysKey   = byte('p')
+
+	authorRelaysMax = 12
+)
+
+type relayStats struct {
+	Queries     uint64  `json:"queries"`
+	Answered    uint64  `json:"answered"` // queries that returned at least one event
+	Failures    uint64  `json:"failures"` // queries that were CLOSED or couldn't connect
+	LatencyMs   float64 `json:"latency_ms"`
+	LastSuccess int64   `json:"last_success,omitempty"`
+	LastFailure int64   `json:"last_failure,omitempty"`
+}
+
+func makeAuthorRelaysKey(pk nostr.PubKey) []byte {
+	key := make([]byte, 9)
+	key[0] = authorRelaysKey
+	copy(key[1:], pk[:8])
+	return key
+}
+
+func loadRelayStats(url string) relayStats {
+	var stats relayStats
+	if data, _ := sys.KVStore.Get(append([]byte{relayStatsPrefix}, url...)); len(data) > 0 {
+		json.Unmarshal(data, &stats)
+	}
+	return stats
+}
+
+// recordRelayQuery updates the stats of a relay after a query, latency is the time until the first
+// event arrived and is ignored when there were no events.
+func recordRelayQuery(url string, events uint64, latency time.Duration, failed bool) {
+	if !nostr.IsValidRelayURL(url) {
+		return
+	}
+
+	key := append([]byte{relayStatsPrefix}, url...)
+	if data, _ := sys.KVStore.Get(key); len(data) == 0 {
+		sys.KVStore.Update([]byte(relayStatsListKey), func(data []byte) ([]byte, error) {
+			var urls []string
+			if len(data) > 0 {
+				urls = strings.Split(string(data), " ")
+			}
+			return []byte(strings.Join(appendUnique(urls, url), " ")), nil
+		})
+	}
+
+	sys.KVStore.Update(key, func(data []byte) ([]byte, error) {
+		var stats relayStats
+		if len(data) > 0 {
+			json.Unmarshal(data, &stats)
+		}
+
+		stats.Queries++
+		now := time.Now().Unix()
+		if failed {
+			stats.Failures++
+			stats.LastFailure = now
+		} else {
+			stats.LastSuccess = now
+		}
+		if events > 0 {
+			stats.Answered++
+			ms := float64(latency.Milliseconds())
+			if stats.LatencyMs == 0 {
+				stats.LatencyMs = ms
+			} else {
+				// recent queries count more
+				stats.LatencyMs = 0.7*stats.LatencyMs + 0.3*ms
+			}
+		}
+		return json.Marshal(stats)
+	})
+}
+
+// recordAuthorRelays remembers that the relay had events from these authors, keeping the most recent first.
+func recordAuthorRelays(url string, authors map[nostr.PubKey]struct{}) {
+	for pk := range authors {
+		sys.KVStore.Update(makeAuthorRelaysKey(pk), func(data []byte) ([]byte, error) {
+			urls := []string{url}
+			if len(data) > 0 {
+				for _, existing := range strings.Split(string(data), " ") {
+					if existing != url && len(urls) < authorRelaysMax {
+						urls = append(urls, existing)
+					}
+				}
+			}
+			return []byte(strings.Join(urls, " ")), nil
+		})
+	}
+}
+
+func authorRelays(pk nostr.PubKey) []string {
+	if data, _ := sys.KVStore.Get(makeAuthorRelaysKey(pk)); len(data) > 0 {
+		return strings.Split(string(data), " ")
+	}
+	return nil
+}
+
+// smartRelaysForFilter ranks the given relays, the hinted ones and every relay we have stats for,
+// returning the best n for this filter.
+func smartRelaysForFilter(filter nostr.Filter, given []string, n int, perPubKey int) []string {
+	candidates := slices.Clone(given)
+	candidates = appendUnique(candidates, hintedRelaysForFilter(filter, perPubKey)...)
+	for _, pk := range filter.Authors {
+		candidates = appendUnique(candidates, authorRelays(pk)...)
+	}
+	if data, _ := sys.KVStore.Get([]byte(relayStatsListKey)); len(data) > 0 {
+		candidates = appendUnique(candidates, strings.Split(string(data), " ")...)
+	}
+
+	// how many of the authors each relay is known to have events from
+	coverage := make(map[string]int, len(candidates))
+	for _, pk := range filter.Authors {
+		for _, url := range appendUnique(authorRelays(pk), sys.Hints.TopN(pk, perPubKey)...) {
+			coverage[url]++
+		}
+	}
+
+	scores := make(map[string]float64, len(candidates))
+	for _, url := range candidates {
+		stats := loadRelayStats(url)
+		answered := float64(stats.Answered+1) / float64(stats.Queries+2)
+		reliable := float64(stats.Queries-stats.Failures+1) / float64(stats.Queries+2)
+		latency := stats.LatencyMs
+		if latency == 0 {
+			latency = 1000 // unknown, assume it's not that fast
+		}
+		score := answered * reliable / (1 + latency/1000)
+		if len(filter.Authors) > 0 {
+			score *= 1 + 2*float64(coverage[url])/float64(len(filter.Authors))
+		}
+		if slices.Contains(given, url) {
+			// the user probably had a reason to mention it
+			score *= 1.5
+		}
+		scores[url] = score
+	}
+
+	slices.SortStableFunc(candidates, func(a, b string) int {
+		if scores[a] > scores[b] {
+			return -1
+		} else if scores[a] < scores[b] {
+			return 1
+		}
+		return 0
+	})
+	for _, url := range candidates[:min(n, len(candidates))] {
+		logverbose("smart relay %s scored %.3f\n", url, scores[url])
+	}
+	return candidates[:min(n, len(candidates))]
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var relayStrategyFlag = &cli.StringFlag{
+	Name: "relay-strategy",
+	Usage: "how to use the relays when there are many: 'all' queries every one of them, 'race' queries all but stops as soon as " +
+		"--limit events arrive from the fastest, 'round-robin' queries one at a time, starting from a different one at each " +
+		"query, and only moves to the next while --limit isn't reached",
+	Value: "all",
+	Validator: func(s string) error {
+		if s != "all" && s != "race" && s != "round-robin" {
+			return fmt.Errorf("invalid --relay-strategy '%s', expected all, race or round-robin", s)
+		}
+		return nil
+	},
+	Category: CATEGORY_EXTRAS,
+}
+
+// which relay round-robin queries start from, advanced at each query
+var roundRobinNext atomic.Uint64
+
+// performReqWithStrategy runs performReq with the flags from req, choosing the relays to query according to --relay-strategy.
+func performReqWithStrategy(ctx context.Context, c *cli.Command, filter nostr.Filter, relayUrls []string, outbox bool) {
+	label := subscriptionID(c, "nak-req", filter)
+	run := func(ctx context.Context, filter nostr.Filter, relayUrls []string, maxEvents uint64) {
+		performReq(ctx, filter, relayUrls, reqOptions{
+			stream:                c.Bool("stream"),
+			outbox:                outbox,
+			outboxRelaysPerPubKey: c.Uint("outbox-relays-per-pubkey"),
+			paginate:              c.Bool("paginate"),
+			paginateInterval:      c.Duration("paginate-interval"),
+			maxBytes:              c.Uint("max-bytes"),
+			maxEvents:             maxEvents,
+			requireEOSEs:          c.Uint("require-eoses"),
+			onClosed:              c.String("on-closed"),
+			summary:               c.Bool("summary"),
+			wire:                  c.String("wire"),
+			skipVerify:            c.Bool("skip-verify"),
+			label:                 label,
+		})
+	}
+
+	switch c.String("relay-strategy") {
+	case "race":
+		maxEvents := c.Uint("max-events")
+		if filter.Limit > 0 && (maxEvents == 0 || uint64(filter.Limit) < maxEvents) {
+			maxEvents = uint64(filter.Limit)
+		}
+		run(ctx, filter, relayUrls, maxEvents)
+
+	case "round-robin":
+		// events are counted only once even if more than one relay returns them
+		seen := newStreamingDedup()
+		got := 0
+		printNext := outputFor(ctx)
+		countingCtx := context.WithValue(ctx, lineOutputKey{}, func(args ...any) {
+			if len(args) == 1 {
+				if evt, ok := args[0].(nostr.Event); ok {
+					if seen.check(evt.ID, "") {
+						return
+					}
+					got++
+				}
+			}
+			printNext(args...)
+		})
+
+		start := int(roundRobinNext.Add(1) - 1)
+		for i := range relayUrls {
+			url := relayUrls[(start+i)%len(relayUrls)]
+			remaining := filter
+			if filter.Limit > 0 {
+				remaining.Limit = filter.Limit - got
+			}
+			run(countingCtx, remaining, []string{url}, c.Uint("max-events"))
+
+			if (filter.Limit > 0 && got >= filter.Limit) || (filter.Limit == 0 && got > 0) {
+				return
+			}
+			if i < len(relayUrls)-1 {
+				logverbose("got %d events so far, asking the next relay\n", got)
+			}
+		}
+
+	default:
+		run(ctx, filter, relayUrls, c.Uint("max-events"))
+	}
+}
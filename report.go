@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/urfave/cli/v3"
+)
+
+var reportTypes = []string{"nudity", "malware", "profanity", "illegal", "spam", "impersonation", "other"}
+
+var report = &cli.Command{
+	Name:  "report",
+	Usage: "creates a nip56 report event (kind 1984) about an event or a profile",
+	Description: `the target can be an nevent, note, hex event id, npub or nprofile. if relays are given after the target the report is published to them.
+
+example:
+		nak report npub1... --type impersonation --reason "pretending to be someone else" wss://relay.example.com
+		nak report nevent1... --type spam`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		relayFlag,
+		&cli.StringFlag{
+			Name:     "type",
+			Usage:    "the report type, one of " + strings.Join(reportTypes, ", "),
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "reason",
+			Aliases: []string{"c", "content"},
+			Usage:   "additional information about the report",
+		},
+		&cli.BoolFlag{
+			Name:     "auth",
+			Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "nevent",
+			Usage:    "print the nevent code (to stderr) after the event is published",
+			Category: CATEGORY_EXTRAS,
+		},
+		&cli.BoolFlag{
+			Name:     "confirm",
+			Usage:    "ask before publishing the event",
+			Category: CATEGORY_EXTRAS,
+		},
+	),
+	ArgsUsage: "<target> [relay...]",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() < 1 {
+			return fmt.Errorf("missing target")
+		}
+
+		ptr, err := parsePointer(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		reportType := c.String("type")
+		if !slices.Contains(reportTypes, reportType) {
+			return fmt.Errorf("invalid report type '%s', expected one of %s", reportType, strings.Join(reportTypes, ", "))
+		}
+
+		evt := nostr.Event{
+			Kind:    nostr.KindReporting,
+			Content: c.String("reason"),
+		}
+
+		switch v := ptr.(type) {
+		case nostr.ProfilePointer:
+			evt.Tags = nostr.Tags{{"p", v.PublicKey.Hex(), reportType}}
+		case nostr.EventPointer:
+			if v.Author == nostr.ZeroPK {
+				// nip56 wants the author of the reported event to be tagged too
+				if reported, _, err := sys.FetchSpecificEvent(ctx, v, sdk.FetchSpecificEventParameters{}); err == nil {
+					v.Author = reported.PubKey
+				} else {
+					log("couldn't find the author of the reported event, the report won't have a \"p\" tag\n")
+				}
+			}
+			evt.Tags = nostr.Tags{{"e", v.ID.Hex(), reportType}}
+			if v.Author != nostr.ZeroPK {
+				evt.Tags = append(evt.Tags, nostr.Tag{"p", v.Author.Hex()})
+			}
+		case nostr.EntityPointer:
+			evt.Tags = nostr.Tags{
+				{"a", v.AsTagReference(), reportType},
+				{"p", v.PublicKey.Hex()},
+			}
+		}
+
+		return signPrintAndPublish(ctx, c, evt, c.Args().Tail())
+	},
+}
+
+var reports = &cli.Command{
+	Name:  "reports",
+	Usage: "fetches nip56 reports filed against an event or a profile and summarizes them",
+	Description: `queries the given relays (or the relay hints and the outbox relays of the target) for kind 1984 events pointing to the target and prints one line for each report type with the number of distinct reporters.
+
+example:
+		nak reports npub1...
+		nak reports --full nevent1... wss://relay.example.com`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		relayFlag,
+		&cli.BoolFlag{
+			Name:  "full",
+			Usage: "print the report events themselves instead of the summary",
+		},
+	},
+	ArgsUsage: "<target> [relay...]",
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() < 1 {
+			return fmt.Errorf("missing target")
+		}
+
+		ptr, err := parsePointer(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		tag := ptr.AsTag()
+		filter := nostr.Filter{
+			Kinds: []nostr.Kind{nostr.KindReporting},
+			Tags:  nostr.TagMap{tag[0]: []string{tag[1]}},
+		}
+
+		relays := pointerRelays(ctx, ptr, getRelayURLs(c, c.Args().Tail()))
+		if len(relays) == 0 {
+			return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+		}
+
+		type summary struct {
+			Type      string         `json:"type"`
+			Count     int            `json:"count"`
+			Reporters []nostr.PubKey `json:"reporters"`
+			Reasons   []string       `json:"reasons,omitempty"`
+		}
+		summaries := make([]*summary, 0, len(reportTypes))
+
+		for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-reports"}) {
+			// reports against an event or an address also tag its author, those aren't reports against the profile
+			if tag[0] == "p" && (ie.Event.Tags.Find("e") != nil || ie.Event.Tags.Find("a") != nil) {
+				continue
+			}
+
+			if c.Bool("full") {
+				stdout(ie.Event)
+				continue
+			}
+
+			// the report type is the third item of the tag that points to our target
+			reportType := "other"
+			for t := range ie.Event.Tags.FindAll(tag[0]) {
+				if t[1] == tag[1] && len(t) >= 3 && t[2] != "" {
+					reportType = t[2]
+					break
+				}
+			}
+
+			idx := slices.IndexFunc(summaries, func(s *summary) bool { return s.Type == reportType })
+			if idx == -1 {
+				idx = len(summaries)
+				summaries = append(summaries, &summary{Type: reportType})
+			}
+			if !slices.Contains(summaries[idx].Reporters, ie.Event.PubKey) {
+				summaries[idx].Reporters = append(summaries[idx].Reporters, ie.Event.PubKey)
+				summaries[idx].Count++
+				if ie.Event.Content != "" {
+					summaries[idx].Reasons = append(summaries[idx].Reasons, ie.Event.Content)
+				}
+			}
+		}
+
+		slices.SortStableFunc(summaries, func(a, b *summary) int { return b.Count - a.Count })
+		for _, s := range summaries {
+			j, _ := json.Marshal(s)
+			stdout(string(j))
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+// readFiltersFile reads filters from a file containing either a json array of filters or one filter
+// per line, the flags given are applied to each one of them. they're keyed by their serialization.
+func readFiltersFile(c *cli.Command, path string) (map[string]nostr.Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raws := make([][]byte, 0, 10)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var arr []stdjson.RawMessage
+		if err := stdjson.Unmarshal(trimmed, &arr); err != nil {
+			return nil, fmt.Errorf("invalid json array: %w", err)
+		}
+		for _, raw := range arr {
+			raws = append(raws, raw)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 16*1024*1024), 256*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			raws = append(raws, bytes.Clone(line))
+		}
+	}
+
+	filters := make(map[string]nostr.Filter, len(raws))
+	for _, raw := range raws {
+		filter := nostr.Filter{}
+		if err := easyjson.Unmarshal(raw, &filter); err != nil {
+			return nil, fmt.Errorf("invalid filter '%s': %w", raw, err)
+		}
+		if err := applyFlagsToFilter(c, &filter); err != nil {
+			return nil, err
+		}
+		filters[filter.String()] = filter
+	}
+
+	return filters, nil
+}
+
+// performFiltersFileReq opens one subscription for each filter in the file, all sharing the same
+// relay connections. when streaming the file is watched and subscriptions are opened and closed
+// as filters are added to or removed from it.
+func performFiltersFileReq(ctx context.Context, c *cli.Command, path string, relayUrls []string) error {
+	filters, err := readFiltersFile(c, path)
+	if err != nil {
+		return fmt.Errorf("failed to read filters file: %w", err)
+	}
+
+	stream := c.Bool("stream")
+	wg := sync.WaitGroup{}
+	running := make(map[string]context.CancelFunc, len(filters))
+	start := func(key string, filter nostr.Filter) {
+		subCtx, cancel := context.WithCancel(ctx)
+		running[key] = cancel
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			performReq(subCtx, filter, relayUrls, reqOptions{
+				stream:                stream,
+				outbox:                c.Bool("outbox"),
+				outboxRelaysPerPubKey: c.Uint("outbox-relays-per-pubkey"),
+				maxBytes:              c.Uint("max-bytes"),
+				maxEvents:             c.Uint("max-events"),
+				requireEOSEs:          c.Uint("require-eoses"),
+				onClosed:              c.String("on-closed"),
+				wire:                  c.String("wire"),
+				skipVerify:            c.Bool("skip-verify"),
+				label:                 subscriptionID(c, "nak-req", filter),
+			})
+		}()
+	}
+
+	for key, filter := range filters {
+		logverbose("opening subscription for %s\n", key)
+		start(key, filter)
+	}
+
+	if !stream {
+		wg.Wait()
+		return nil
+	}
+
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+
+		filters, err := readFiltersFile(c, path)
+		if err != nil {
+			log("failed to reload filters file, keeping current subscriptions: %s\n", err)
+			continue
+		}
+
+		for key, cancel := range running {
+			if _, ok := filters[key]; !ok {
+				log("closing subscription for %s\n", key)
+				cancel()
+				delete(running, key)
+			}
+		}
+		for key, filter := range filters {
+			if _, ok := running[key]; !ok {
+				log("opening subscription for %s\n", key)
+				start(key, filter)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var routeFlag = &cli.StringSliceFlag{
+	Name: "route",
+	Usage: "send the events that match some conditions to a destination instead of stdout, as <conditions>:<destination>, can be given multiple times. " +
+		"conditions are comma-separated kind=<n>, author=<pubkey> or #<tag>=<value> (all must match) or * for everything, " +
+		"the destination is - for stdout, a file or fifo path (appended to) or |<command> to pipe into a shell command. " +
+		"events go to all the routes they match and are discarded if they don't match any",
+}
+
+type route struct {
+	kinds   []nostr.Kind
+	authors []nostr.PubKey
+	tags    nostr.TagMap
+	target  string
+	write   func(evt nostr.Event) error
+}
+
+func (r route) matches(evt nostr.Event) bool {
+	if len(r.kinds) > 0 && !slices.Contains(r.kinds, evt.Kind) {
+		return false
+	}
+	if len(r.authors) > 0 && !nostr.ContainsPubKey(r.authors, evt.PubKey) {
+		return false
+	}
+	for tagName, values := range r.tags {
+		if !evt.Tags.ContainsAny(tagName, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRoute parses a <conditions>:<destination> route spec. tag values in conditions can't contain ':'.
+func parseRoute(spec string) (route, error) {
+	r := route{}
+
+	conditions, target, ok := strings.Cut(spec, ":")
+	if !ok || target == "" {
+		return r, fmt.Errorf("invalid route '%s', expected <conditions>:<destination>", spec)
+	}
+	r.target = target
+
+	if conditions == "*" {
+		return r, nil
+	}
+	for _, cond := range strings.Split(conditions, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(cond), "=")
+		if !ok || value == "" {
+			return r, fmt.Errorf("invalid condition '%s' in route '%s'", cond, spec)
+		}
+		switch {
+		case key == "kind":
+			kind, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return r, fmt.Errorf("invalid kind '%s' in route '%s'", value, spec)
+			}
+			r.kinds = append(r.kinds, nostr.Kind(kind))
+		case key == "author":
+			pk, err := parsePubKey(value)
+			if err != nil {
+				return r, fmt.Errorf("invalid author in route '%s': %w", spec, err)
+			}
+			r.authors = append(r.authors, pk)
+		case len(key) == 2 && key[0] == '#':
+			if r.tags == nil {
+				r.tags = nostr.TagMap{}
+			}
+			r.tags[key[1:]] = append(r.tags[key[1:]], decodeTagValue(value))
+		default:
+			return r, fmt.Errorf("unknown condition '%s' in route '%s', expected kind, author or #<tag>", key, spec)
+		}
+	}
+
+	return r, nil
+}
+
+// setupRoutes makes stdout send events to the destinations given with --route.
+func setupRoutes(c *cli.Command) error {
+	specs := c.StringSlice("route")
+	if len(specs) == 0 {
+		return nil
+	}
+
+	printNext := stdout
+	routes := make([]route, 0, len(specs))
+	closers := make([]func() error, 0, len(specs))
+
+	// routes with the same destination share the writer
+	writers := make(map[string]func(evt nostr.Event) error)
+	for _, spec := range specs {
+		r, err := parseRoute(spec)
+		if err != nil {
+			return err
+		}
+
+		if write, ok := writers[r.target]; ok {
+			r.write = write
+			routes = append(routes, r)
+			continue
+		}
+
+		var w io.Writer
+		switch {
+		case r.target == "-":
+			r.write = func(evt nostr.Event) error {
+				printNext(evt)
+				return nil
+			}
+		case strings.HasPrefix(r.target, "|"):
+			cmd := exec.Command("sh", "-c", r.target[1:])
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			pipe, err := cmd.StdinPipe()
+			if err != nil {
+				return err
+			}
+			if err := cmd.Start(); err != nil {
+				return fmt.Errorf("failed to start '%s': %w", r.target[1:], err)
+			}
+			w = pipe
+			closers = append(closers, func() error {
+				pipe.Close()
+				return cmd.Wait()
+			})
+		default:
+			file, err := os.OpenFile(r.target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to open route destination: %w", err)
+			}
+			w = file
+			closers = append(closers, file.Close)
+		}
+
+		if w != nil {
+			r.write = func(evt nostr.Event) error {
+				_, err := io.WriteString(w, evt.String()+"\n")
+				return err
+			}
+		}
+		writers[r.target] = r.write
+		routes = append(routes, r)
+	}
+
+	mu := sync.Mutex{}
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				mu.Lock()
+				defer mu.Unlock()
+
+				written := make(map[string]struct{}, len(routes))
+				for _, r := range routes {
+					if _, done := written[r.target]; done || !r.matches(evt) {
+						continue
+					}
+					written[r.target] = struct{}{}
+					if err := r.write(evt); err != nil {
+						logverbose("failed to write %s to %s: %s\n", evt.ID.Hex(), r.target, err)
+					}
+				}
+				return
+			}
+		}
+		printNext(args...)
+	}
+
+	finishNext := finishOutput
+	finishOutput = func() {
+		mu.Lock()
+		for _, closeDestination := range closers {
+			if err := closeDestination(); err != nil {
+				logverbose("failed to close route destination: %s\n", err)
+			}
+		}
+		mu.Unlock()
+		finishNext()
+	}
+
+	return nil
+}
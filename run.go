@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var runCmd = &cli.Command{
+	Name:  "run",
+	Usage: "runs the nak commands from a script file in this same process, sharing relay connections, bunker sessions and caches between them",
+	Description: `the script has one nak command per line (the leading "nak" is optional), with shell-like quoting, $VARIABLES from the environment, '#' comments and '\' at the end of a line to continue the command on the next one.
+
+commands can be joined with '|', in which case the output of each one is given as the input of the next, like in a shell pipeline, but they run one after the other (so the ones before the last can't use --stream). commands that don't get an input from a pipe don't read anything.
+
+global flags given to 'nak run' (like --verbose or --relay) apply to all commands. the script stops at the first command that fails, unless --keep-going is given, but commands that exit with their own exit codes (like when some input lines are invalid) end the whole script.
+
+example script:
+		# publish a note with a bunker and then react to it, connecting to the bunker only once
+		event --sec bunker://... -c "hello" nos.lol
+		req -k 1 -a $MY_PUBKEY -l 1 nos.lol | event --sec bunker://... -k 7 -c + nos.lol
+
+example:
+		nak run publish.nak`,
+	ArgsUsage:                 "<script-file>",
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "run the next commands even when one of them fails",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		path := c.Args().First()
+		if path == "" {
+			return fmt.Errorf("missing script file")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		pipelines, err := parseRunScript(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		keySessions = make(map[string]keySession)
+		defer func() { keySessions = nil }()
+
+		nfailed := 0
+		for _, pipeline := range pipelines {
+			logverbose("running line %d: %s\n", pipeline.line, pipeline.text)
+			if err := runPipeline(ctx, c, pipeline.commands); err != nil {
+				if isShutdownError(err) {
+					return err
+				}
+				err = fmt.Errorf("%s:%d: %w", path, pipeline.line, err)
+				if !c.Bool("keep-going") {
+					return err
+				}
+				log("%s\n", color.RedString(err.Error()))
+				nfailed++
+			}
+		}
+
+		if nfailed > 0 {
+			return fmt.Errorf("%d of %d commands failed", nfailed, len(pipelines))
+		}
+		return nil
+	},
+}
+
+type runPipelineSpec struct {
+	line     int
+	text     string
+	commands [][]string
+}
+
+// parseRunScript splits a script into pipelines, each a list of commands with their arguments.
+func parseRunScript(script string) ([]runPipelineSpec, error) {
+	var pipelines []runPipelineSpec
+
+	lines := strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		text := lines[i]
+		for strings.HasSuffix(text, "\\") && i+1 < len(lines) {
+			i++
+			text = text[:len(text)-1] + " " + lines[i]
+		}
+
+		commands, err := splitRunLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		pipelines = append(pipelines, runPipelineSpec{line: start, text: strings.TrimSpace(text), commands: commands})
+	}
+
+	return pipelines, nil
+}
+
+// splitRunLine splits a line into words like a shell would, with '|' separating commands.
+func splitRunLine(text string) ([][]string, error) {
+	var commands [][]string
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	endWord := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() error {
+		endWord()
+		if len(args) > 0 && args[0] == "nak" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("empty command in pipeline")
+		}
+		commands = append(commands, args)
+		args = nil
+		return nil
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`, runes[i+1]):
+				i++
+				word.WriteRune(runes[i])
+			case r == '$':
+				i += expandRunVariable(runes[i+1:], &word)
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '$':
+			i += expandRunVariable(runes[i+1:], &word)
+			inWord = true
+		case r == '#' && !inWord:
+			i = len(runes)
+		case r == '|':
+			if err := endCommand(); err != nil {
+				return nil, err
+			}
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+
+	endWord()
+	if len(args) == 0 && len(commands) == 0 {
+		return nil, nil
+	}
+	if err := endCommand(); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// expandRunVariable writes the value of the $NAME or ${NAME} at the start of rest and returns how
+// many runes it took, a lone '$' is kept as it is.
+func expandRunVariable(rest []rune, word *strings.Builder) int {
+	if len(rest) > 0 && rest[0] == '{' {
+		if end := strings.IndexRune(string(rest), '}'); end != -1 {
+			name := string(rest[1:end])
+			word.WriteString(os.Getenv(name))
+			return len([]rune(string(rest[:end+1])))
+		}
+	}
+	n := 0
+	for n < len(rest) && (rest[n] == '_' || ('a' <= rest[n] && rest[n] <= 'z') || ('A' <= rest[n] && rest[n] <= 'Z') || ('0' <= rest[n] && rest[n] <= '9' && n > 0)) {
+		n++
+	}
+	if n == 0 {
+		word.WriteRune('$')
+		return 0
+	}
+	word.WriteString(os.Getenv(string(rest[:n])))
+	return n
+}
+
+// runPipeline runs each command with the output of the previous one (kept in a temporary file) as its stdin.
+func runPipeline(ctx context.Context, c *cli.Command, commands [][]string) error {
+	var input *os.File
+	defer func() {
+		if input != nil {
+			input.Close()
+			os.Remove(input.Name())
+		}
+	}()
+
+	for i, args := range commands {
+		cmd := c.Root().Command(args[0])
+		if cmd == nil {
+			return fmt.Errorf("unknown command '%s'", args[0])
+		}
+		if cmd == c {
+			return fmt.Errorf("scripts can't call 'run'")
+		}
+
+		stdin := input
+		if stdin == nil {
+			devnull, err := os.Open(os.DevNull)
+			if err != nil {
+				return err
+			}
+			defer devnull.Close()
+			stdin = devnull
+		}
+
+		var output *os.File
+		if i < len(commands)-1 {
+			var err error
+			output, err = os.CreateTemp("", "nak-run-*")
+			if err != nil {
+				return err
+			}
+		}
+
+		err := runScriptCommand(ctx, cmd, args, stdin, output)
+
+		if input != nil {
+			input.Close()
+			os.Remove(input.Name())
+			input = nil
+		}
+		if output != nil {
+			if _, serr := output.Seek(0, io.SeekStart); serr != nil && err == nil {
+				err = serr
+			}
+			input = output
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runScriptCommand runs one command with the given stdin and stdout (nil for the normal output),
+// restoring afterwards everything commands change about the output.
+func runScriptCommand(ctx context.Context, cmd *cli.Command, args []string, stdin *os.File, output *os.File) error {
+	savedStdin, savedOutput := os.Stdin, color.Output
+	savedStdout, savedFinish := stdout, finishOutput
+	savedStatusBoard, savedKeepalive := statusBoard, keepalive
+	defer func() {
+		os.Stdin, color.Output = savedStdin, savedOutput
+		stdout, finishOutput = savedStdout, savedFinish
+		statusBoard, keepalive = savedStatusBoard, savedKeepalive
+	}()
+
+	os.Stdin = stdin
+	if output != nil {
+		// commands in the middle of a pipeline always print plainly to the next one
+		color.Output = output
+		stdout = func(args ...any) { fmt.Fprintln(output, args...) }
+	}
+	finishOutput = func() {}
+
+	resetFlags(cmd)
+	err := cmd.Run(ctx, args)
+	finishOutput()
+	return err
+}
+
+// resetFlags replaces the flags of the command and its subcommands with fresh copies, since the cli
+// library keeps the values parsed in one run around for the next. the help and version flags are kept
+// as they are, the library appends them again unless those same pointers are already there.
+func resetFlags(cmd *cli.Command) {
+	for i, flag := range cmd.Flags {
+		if flag == cli.HelpFlag || flag == cli.VersionFlag {
+			continue
+		}
+		v := reflect.ValueOf(flag)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		fresh := reflect.New(v.Elem().Type())
+		for f := range v.Elem().NumField() {
+			if field := fresh.Elem().Field(f); field.CanSet() {
+				field.Set(v.Elem().Field(f))
+			}
+		}
+		cmd.Flags[i] = fresh.Interface().(cli.Flag)
+	}
+	for _, sub := range cmd.Commands {
+		resetFlags(sub)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"github.com/mailru/easyjson"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// setupEventScript loads a lua script that must define a global function process(event), which is
+// called for every event about to be printed. it gets the event as a table and can return nil or
+// false to drop it, true to print it as it is or a table that will be printed instead.
+// the standard lua libraries are available, so scripts can also have side effects.
+func setupEventScript(path string) error {
+	L := lua.NewState()
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			args[i] = L.Get(i + 1).String()
+		}
+		log("%s\n", strings.Join(args, " "))
+		return 0
+	}))
+
+	if err := L.DoFile(path); err != nil {
+		L.Close()
+		return fmt.Errorf("failed to load script: %w", err)
+	}
+	process, ok := L.GetGlobal("process").(*lua.LFunction)
+	if !ok {
+		L.Close()
+		return fmt.Errorf("script %s doesn't define a 'process' function", path)
+	}
+
+	var mu sync.Mutex
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) != 1 {
+			printNext(args...)
+			return
+		}
+		evt, ok := args[0].(nostr.Event)
+		if !ok {
+			printNext(args...)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		j, _ := easyjson.Marshal(evt)
+		var generic map[string]any
+		json.Unmarshal(j, &generic)
+
+		if err := L.CallByParam(lua.P{Fn: process, NRet: 1, Protect: true}, goToLua(L, generic)); err != nil {
+			log("script failed on event %s: %s\n", evt.ID.Hex(), err)
+			return
+		}
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		switch ret := ret.(type) {
+		case *lua.LNilType:
+		case lua.LBool:
+			if ret {
+				printNext(evt)
+			}
+		case *lua.LTable:
+			j, _ := json.Marshal(luaToGo(ret))
+			var modified nostr.Event
+			if err := easyjson.Unmarshal(j, &modified); err != nil {
+				log("script returned an invalid event for %s: %s\n", evt.ID.Hex(), err)
+				return
+			}
+			printNext(modified)
+		default:
+			log("script returned unexpected %s for event %s\n", ret.Type(), evt.ID.Hex())
+		}
+	}
+
+	return nil
+}
+
+func goToLua(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []any:
+		tbl := L.NewTable()
+		for _, item := range v {
+			tbl.Append(goToLua(L, item))
+		}
+		return tbl
+	case map[string]any:
+		tbl := L.NewTable()
+		for k, item := range v {
+			tbl.RawSetString(k, goToLua(L, item))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+func luaToGo(v lua.LValue) any {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		// tables with only sequential keys are arrays (and empty tables too, for tags)
+		if n := v.MaxN(); n > 0 || v.Len() == 0 {
+			isArray := true
+			v.ForEach(func(k, _ lua.LValue) {
+				if _, ok := k.(lua.LNumber); !ok {
+					isArray = false
+				}
+			})
+			if isArray {
+				arr := make([]any, 0, n)
+				for i := 1; i <= n; i++ {
+					arr = append(arr, luaToGo(v.RawGetInt(i)))
+				}
+				return arr
+			}
+		}
+		obj := make(map[string]any)
+		v.ForEach(func(k, item lua.LValue) {
+			obj[k.String()] = luaToGo(item)
+		})
+		return obj
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip57"
+	"github.com/urfave/cli/v3"
+)
+
+var searchCmd = &cli.Command{
+	Name:  "search",
+	Usage: "searches for content on multiple nip50 relays, merging and ranking the results",
+	Description: `sends the same nip50 search filter to all the given search relays (or a default list) and prints the results as jsonl, without duplicates.
+
+results can be ranked by recency (the default), by the total amount of zaps they received or by the web of trust of a given pubkey (events from the pubkey and the people it follows come first).
+
+with --stream the subscriptions are kept open and new matching events are printed as they arrive, without ranking.
+
+example:
+		nak search "bitcoin conference" --kind 1 --limit 20
+		nak search nostr --rank zaps --relay wss://relay.nostr.band
+		nak search "gm" --rank wot --wot npub1h8spmtw9m2huyv6v2j2qd5zv956z2zdugl6mgx02f2upffwpm3nqv0j4ps
+		nak search "#bitcoin" --stream`,
+	ArgsUsage:                 "<query>",
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.IntSliceFlag{
+			Name:    "kind",
+			Aliases: []string{"k"},
+			Usage:   "only events of these kinds",
+		},
+		&PubKeySliceFlag{
+			Name:    "author",
+			Aliases: []string{"a"},
+			Usage:   "only events from these authors",
+		},
+		relayFlag,
+		&cli.UintFlag{
+			Name:    "limit",
+			Aliases: []string{"l"},
+			Usage:   "maximum number of results to print",
+			Value:   50,
+		},
+		&cli.StringFlag{
+			Name:  "rank",
+			Usage: "how to sort the results: recency, zaps or wot",
+			Value: "recency",
+			Validator: func(s string) error {
+				if s != "recency" && s != "zaps" && s != "wot" {
+					return fmt.Errorf("invalid --rank '%s', expected recency, zaps or wot", s)
+				}
+				return nil
+			},
+		},
+		&PubKeyFlag{
+			Name:  "wot",
+			Usage: "pubkey whose follows will be ranked first when using --rank wot",
+		},
+		&cli.BoolFlag{
+			Name:  "stream",
+			Usage: "keep the subscriptions open and print new results as they arrive",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		query := strings.TrimSpace(strings.Join(c.Args().Slice(), " "))
+		if query == "" {
+			return fmt.Errorf("missing search query")
+		}
+
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("relay")); err != nil {
+			return err
+		}
+		relays := getRelayURLs(c, nil)
+		if len(relays) == 0 {
+			relays = sys.NoteSearchRelays.URLs
+		}
+
+		filter := nostr.Filter{
+			Search:  query,
+			Authors: getPubKeySlice(c, "author"),
+			Limit:   int(c.Uint("limit")),
+		}
+		for _, kind := range c.IntSlice("kind") {
+			filter.Kinds = append(filter.Kinds, nostr.Kind(kind))
+		}
+		if len(filter.Kinds) == 0 {
+			filter.Kinds = []nostr.Kind{1}
+		}
+
+		seen := make(map[nostr.ID]struct{})
+
+		if c.Bool("stream") {
+			if c.IsSet("rank") {
+				return fmt.Errorf("--rank can't be used with --stream")
+			}
+			for ie := range sys.Pool.SubscribeMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-search"}) {
+				if _, ok := seen[ie.ID]; ok {
+					continue
+				}
+				seen[ie.ID] = struct{}{}
+				stdout(ie.Event)
+			}
+			return nil
+		}
+
+		results := make([]nostr.Event, 0, filter.Limit)
+		for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-search"}) {
+			if _, ok := seen[ie.ID]; ok {
+				continue
+			}
+			seen[ie.ID] = struct{}{}
+			results = append(results, ie.Event)
+		}
+		logverbose("got %d unique results from %d relays\n", len(results), len(relays))
+
+		// always sort by recency first so it works as a tiebreaker for the other rankings
+		slices.SortStableFunc(results, func(a, b nostr.Event) int { return int(b.CreatedAt - a.CreatedAt) })
+
+		switch c.String("rank") {
+		case "zaps":
+			zaps := searchZapTotals(ctx, relays, results)
+			slices.SortStableFunc(results, func(a, b nostr.Event) int {
+				if zaps[a.ID] > zaps[b.ID] {
+					return -1
+				} else if zaps[a.ID] < zaps[b.ID] {
+					return 1
+				}
+				return 0
+			})
+		case "wot":
+			root := getPubKey(c, "wot")
+			if root == nostr.ZeroPK {
+				return fmt.Errorf("--rank wot requires --wot <pubkey>")
+			}
+			trusted := map[nostr.PubKey]struct{}{root: {}}
+			for _, item := range sys.FetchFollowList(ctx, root).Items {
+				trusted[item.Pubkey] = struct{}{}
+			}
+			slices.SortStableFunc(results, func(a, b nostr.Event) int {
+				_, ta := trusted[a.PubKey]
+				_, tb := trusted[b.PubKey]
+				if ta && !tb {
+					return -1
+				} else if tb && !ta {
+					return 1
+				}
+				return 0
+			})
+		}
+
+		if filter.Limit > 0 && len(results) > filter.Limit {
+			results = results[0:filter.Limit]
+		}
+		for _, evt := range results {
+			stdout(evt)
+		}
+
+		return nil
+	},
+}
+
+// searchZapTotals returns the total amount in millisatoshis of the zap receipts found for each event.
+func searchZapTotals(ctx context.Context, relays []string, events []nostr.Event) map[nostr.ID]uint64 {
+	totals := make(map[nostr.ID]uint64, len(events))
+	if len(events) == 0 {
+		return totals
+	}
+
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID.Hex()
+	}
+
+	seen := make(map[nostr.ID]struct{})
+	for ie := range sys.Pool.FetchMany(ctx, appendUnique(slices.Clone(relays), sys.FallbackRelays.URLs...), nostr.Filter{
+		Kinds: []nostr.Kind{9735},
+		Tags:  nostr.TagMap{"e": ids},
+	}, nostr.SubscriptionOptions{Label: "nak-search-zaps"}) {
+		if _, ok := seen[ie.ID]; ok {
+			continue
+		}
+		seen[ie.ID] = struct{}{}
+
+		if e := ie.Tags.Find("e"); e != nil {
+			if id, err := nostr.IDFromHex(e[1]); err == nil {
+				totals[id] += nip57.GetAmountFromZap(ie.Event)
+			}
+		}
+	}
+
+	return totals
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var seenDBFlag = &cli.StringFlag{
+	Name:      "seen-db",
+	Usage:     "file where the ids of printed events are remembered (in a rotating bloom filter), so events already printed by a previous run are not printed again",
+	TakesFile: true,
+}
+
+const (
+	seenDBMagic    = "nakseen1"
+	seenDBCapacity = 500_000 // ids per generation, the filter remembers between one and two generations
+	seenDBBits     = 9_600_000
+	seenDBHashes   = 13
+)
+
+// seenDB is a pair of bloom filters: new ids go into the current one and when it gets full it
+// becomes the previous one, so memory and disk usage stay constant and only the oldest ids are forgotten.
+type seenDB struct {
+	mu       sync.Mutex
+	path     string
+	dirty    bool
+	current  []uint64
+	previous []uint64
+	count    uint64
+}
+
+func openSeenDB(path string) (*seenDB, error) {
+	db := &seenDB{
+		path:     path,
+		current:  make([]uint64, seenDBBits/64+1),
+		previous: make([]uint64, seenDBBits/64+1),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read seen-db: %w", err)
+	}
+
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(seenDBMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != seenDBMagic {
+		return nil, fmt.Errorf("%s is not a seen-db file", path)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &db.count); err != nil {
+		return nil, fmt.Errorf("corrupted seen-db: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, db.current); err != nil {
+		return nil, fmt.Errorf("corrupted seen-db: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, db.previous); err != nil {
+		return nil, fmt.Errorf("corrupted seen-db: %w", err)
+	}
+
+	logverbose("loaded seen-db from %s with %d ids in the current generation\n", path, db.count)
+	return db, nil
+}
+
+func seenDBPositions(id nostr.ID) [seenDBHashes]uint64 {
+	// the id is already a hash, so we can take two numbers from it and combine them
+	h1 := binary.LittleEndian.Uint64(id[0:8])
+	h2 := binary.LittleEndian.Uint64(id[8:16]) | 1
+	var positions [seenDBHashes]uint64
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % seenDBBits
+	}
+	return positions
+}
+
+func bloomHas(bits []uint64, positions [seenDBHashes]uint64) bool {
+	for _, p := range positions {
+		if bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAndAdd returns true if the id was (probably) seen before, otherwise it remembers it.
+func (db *seenDB) checkAndAdd(id nostr.ID) bool {
+	positions := seenDBPositions(id)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if bloomHas(db.current, positions) || bloomHas(db.previous, positions) {
+		return true
+	}
+
+	if db.count >= seenDBCapacity {
+		db.previous, db.current = db.current, db.previous
+		clear(db.current)
+		db.count = 0
+	}
+	for _, p := range positions {
+		db.current[p/64] |= 1 << (p % 64)
+	}
+	db.count++
+	db.dirty = true
+	return false
+}
+
+func (db *seenDB) save() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.dirty {
+		return nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(seenDBMagic)+8+len(db.current)*16))
+	buf.WriteString(seenDBMagic)
+	binary.Write(buf, binary.LittleEndian, db.count)
+	binary.Write(buf, binary.LittleEndian, db.current)
+	binary.Write(buf, binary.LittleEndian, db.previous)
+
+	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+		return err
+	}
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return err
+	}
+	db.dirty = false
+	return nil
+}
+
+// setupSeenDB makes stdout skip events whose ids are in the --seen-db file, and keeps the file
+// updated with every event printed.
+func setupSeenDB(c *cli.Command) error {
+	path := c.String("seen-db")
+	if path == "" {
+		return nil
+	}
+
+	db, err := openSeenDB(path)
+	if err != nil {
+		return err
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok && db.checkAndAdd(evt.ID) {
+				logverbose("skipping already seen event %s\n", evt.ID.Hex())
+				return
+			}
+		}
+		printNext(args...)
+	}
+
+	save := func() {
+		if err := db.save(); err != nil {
+			log("failed to save seen-db: %s\n", color.RedString(err.Error()))
+		}
+	}
+
+	finishNext := finishOutput
+	finishOutput = func() {
+		save()
+		finishNext()
+	}
+
+	// long-running streams may be killed without warning, so also save periodically
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			save()
+		}
+	}()
+
+	return nil
+}
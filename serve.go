@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync/atomic"
 	"time"
 
@@ -27,7 +28,7 @@ var serve = &cli.Command{
 	Name:                      "serve",
 	Usage:                     "starts an in-memory relay for testing purposes",
 	DisableSliceFlagSeparator: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "hostname",
 			Usage: "hostname where to listen for connections",
@@ -61,7 +62,20 @@ var serve = &cli.Command{
 			Name:  "blossom",
 			Usage: "enable blossom server",
 		},
-	},
+		&cli.StringSliceFlag{
+			Name:  "require-auth",
+			Usage: "simulate nip42 auth behaviors: 'connect' sends a challenge as soon as a client connects, 'req' and 'event' reject these from unauthenticated clients with auth-required (can be repeated)",
+		},
+		&PubKeySliceFlag{
+			Name:  "auth-pubkey",
+			Usage: "when requiring auth, only accept these pubkeys, others get a restricted: rejection",
+		},
+		&cli.StringFlag{
+			Name:      "policy",
+			Usage:     "json file with rules for accepting events: allowed_kinds, allowed_pubkeys, blocked_pubkeys, max_event_size, min_pow, rate_limit_per_ip, rate_limit_per_pubkey (per minute)",
+			TakesFile: true,
+		},
+	}, chaosFlags...),
 	Action: func(ctx context.Context, c *cli.Command) error {
 		db := &slicestore.SliceStore{}
 
@@ -92,6 +106,39 @@ var serve = &cli.Command{
 			}
 		}
 
+		var policy *relayPolicy
+		if path := c.String("policy"); path != "" {
+			var err error
+			policy, err = loadRelayPolicy(path)
+			if err != nil {
+				return fmt.Errorf("failed to load policy: %w", err)
+			}
+		}
+
+		authOn := make(map[string]bool)
+		for _, when := range c.StringSlice("require-auth") {
+			switch when {
+			case "connect", "req", "event":
+				authOn[when] = true
+			default:
+				return fmt.Errorf("invalid --require-auth '%s', expected connect, req or event", when)
+			}
+		}
+		authPubKeys := getPubKeySlice(c, "auth-pubkey")
+		checkAuth := func(ctx context.Context, what string) (reject bool, msg string) {
+			if !authOn[what] {
+				return false, ""
+			}
+			pubkey, isAuthed := khatru.GetAuthed(ctx)
+			if !isAuthed {
+				return true, "auth-required: this relay requires authentication for " + what
+			}
+			if len(authPubKeys) > 0 && !slices.Contains(authPubKeys, pubkey) {
+				return true, "restricted: " + pubkey.Hex() + " is not allowed here"
+			}
+			return false, ""
+		}
+
 		rl := khatru.NewRelay()
 
 		rl.Info.Name = "nak serve"
@@ -162,10 +209,25 @@ var serve = &cli.Command{
 			}
 		}
 
-		go func() {
-			err := rl.Start(hostname, port, started)
-			exited <- err
-		}()
+		if cc := chaosConfigFromFlags(c); cc != nil {
+			// the actual relay runs at an internal port and we put a misbehaving proxy in front of it
+			internalPort, err := freeLocalPort()
+			if err != nil {
+				return fmt.Errorf("failed to find a port for the internal relay: %w", err)
+			}
+			go func() {
+				err := rl.Start("127.0.0.1", internalPort, started)
+				exited <- err
+			}()
+			go func() {
+				exited <- runChaosProxy(ctx, cc, hostname, port, internalPort)
+			}()
+		} else {
+			go func() {
+				err := rl.Start(hostname, port, started)
+				exited <- err
+			}()
+		}
 
 		// relay logging
 		rl.OnRequest = func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
@@ -174,6 +236,12 @@ var serve = &cli.Command{
 				negentropy = color.HiBlueString("negentropy ")
 			}
 
+			if reject, msg := checkAuth(ctx, "req"); reject {
+				log("    %s%s %v: %s\n", negentropy, color.RedString("rejected request"), colors.italic(filter), msg)
+				printStatus()
+				return true, msg
+			}
+
 			log("    got %s%s %v\n", negentropy, color.HiYellowString("request"), colors.italic(filter))
 			printStatus()
 			return false, ""
@@ -186,6 +254,19 @@ var serve = &cli.Command{
 		}
 
 		rl.OnEvent = func(ctx context.Context, event nostr.Event) (reject bool, msg string) {
+			if reject, msg := checkAuth(ctx, "event"); reject {
+				log("    %s %v: %s\n", color.RedString("rejected event"), colors.italic(event), msg)
+				printStatus()
+				return true, msg
+			}
+			if policy != nil {
+				if reject, msg := policy.check(ctx, event); reject {
+					log("    %s %v: %s\n", color.RedString("rejected event"), colors.italic(event), msg)
+					printStatus()
+					return true, msg
+				}
+			}
+
 			log("    got %s %v\n", color.BlueString("event"), colors.italic(event))
 			printStatus()
 			return false, ""
@@ -193,6 +274,9 @@ var serve = &cli.Command{
 
 		totalConnections := atomic.Int32{}
 		rl.OnConnect = func(ctx context.Context) {
+			if authOn["connect"] {
+				khatru.RequestAuth(ctx)
+			}
 			totalConnections.Add(1)
 			go func() {
 				<-ctx.Done()
@@ -251,6 +335,12 @@ var serve = &cli.Command{
 			log(" (grasp repos at %s)", repoDir)
 		}
 		log("\n")
+		notifyReady()
+
+		go func() {
+			<-ctx.Done()
+			rl.Shutdown(context.Background())
+		}()
 
 		return <-exited
 	},
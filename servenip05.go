@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var serveNip05 = &cli.Command{
+	Name:  "serve-nip05",
+	Usage: "serves /.well-known/nostr.json for self-hosted nip05 identities, or writes it as a static file",
+	Description: `names can be given with --name or in a json config file like this:
+
+  {
+    "alice": "npub1...",
+    "bob": {"pubkey": "npub1...", "relays": ["wss://relay.example.com"]}
+  }
+
+the config file is read again on every request, so it can be edited without restarting.
+
+example:
+		nak serve-nip05 --name _=npub1... --name bob=npub1...
+		nak serve-nip05 --config names.json --port 8080
+		nak serve-nip05 --config names.json --generate ./public`,
+	DisableSliceFlagSeparator: true,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:      "config",
+			Usage:     "json file with the names and their pubkeys and relays",
+			TakesFile: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "name",
+			Usage: "a name and its pubkey as <name>=<pubkey>, can be given multiple times",
+		},
+		&cli.StringSliceFlag{
+			Name:  "relay",
+			Usage: "relay hint included for every name that doesn't have its own relays, can be given multiple times",
+		},
+		&cli.StringFlag{
+			Name:      "generate",
+			Usage:     "instead of serving, write .well-known/nostr.json inside this directory and exit",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "hostname where to listen for connections",
+			Value: "localhost",
+		},
+		&cli.UintFlag{
+			Name:  "port",
+			Usage: "port where to listen for connections",
+			Value: 10551,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if err := normalizeAndValidateRelayURLs(c.StringSlice("relay")); err != nil {
+			return err
+		}
+
+		// check everything is valid before starting
+		doc, err := loadNip05Names(c)
+		if err != nil {
+			return err
+		}
+
+		if dir := c.String("generate"); dir != "" {
+			path := filepath.Join(dir, ".well-known", "nostr.json")
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			data, _ := json.MarshalIndent(doc, "", "  ")
+			if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			log("wrote %d names to %s\n", len(doc.Names), path)
+			return nil
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /.well-known/nostr.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Content-Type", "application/json")
+
+			doc, err := loadNip05Names(c)
+			if err != nil {
+				log("failed to load names: %s\n", color.RedString(err.Error()))
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "failed to load names"})
+				return
+			}
+
+			if name := r.URL.Query().Get("name"); name != "" {
+				// only reply with what was asked
+				filtered := nip05Document{Names: map[string]string{}, Relays: map[string][]string{}}
+				if pk, ok := doc.Names[strings.ToLower(name)]; ok {
+					filtered.Names[strings.ToLower(name)] = pk
+					if relays, ok := doc.Relays[pk]; ok {
+						filtered.Relays[pk] = relays
+					}
+				}
+				doc = filtered
+			}
+
+			logverbose("GET %s\n", r.URL)
+			json.NewEncoder(w).Encode(doc)
+		})
+
+		server := &http.Server{
+			Addr:    net.JoinHostPort(c.String("hostname"), strconv.FormatUint(c.Uint("port"), 10)),
+			Handler: mux,
+		}
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		log("%s nip05 server running at %s\n", color.HiRedString(">"), colors.boldf("http://%s/.well-known/nostr.json", server.Addr))
+		notifyReady()
+
+		go func() {
+			<-ctx.Done()
+			server.Shutdown(context.Background())
+		}()
+
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	},
+}
+
+type nip05Document struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays,omitempty"`
+}
+
+// loadNip05Names merges the names from --config and --name into a nostr.json document with hex pubkeys.
+func loadNip05Names(c *cli.Command) (nip05Document, error) {
+	doc := nip05Document{Names: map[string]string{}, Relays: map[string][]string{}}
+
+	add := func(name string, pubkey string, relays []string) error {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.ContainsAny(name, "@/ ") {
+			return fmt.Errorf("invalid name '%s'", name)
+		}
+		pk, err := parsePubKey(pubkey)
+		if err != nil {
+			return fmt.Errorf("invalid pubkey for '%s': %w", name, err)
+		}
+		if len(relays) == 0 {
+			relays = c.StringSlice("relay")
+		} else if err := normalizeAndValidateRelayURLs(relays); err != nil {
+			return fmt.Errorf("invalid relays for '%s': %w", name, err)
+		}
+
+		doc.Names[name] = pk.Hex()
+		if len(relays) > 0 {
+			doc.Relays[pk.Hex()] = appendUnique(doc.Relays[pk.Hex()], relays...)
+		}
+		return nil
+	}
+
+	if path := c.String("config"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return doc, fmt.Errorf("failed to read config: %w", err)
+		}
+		var config map[string]any
+		if err := json.Unmarshal(data, &config); err != nil {
+			return doc, fmt.Errorf("invalid config in %s: %w", path, err)
+		}
+		for name, value := range config {
+			switch v := value.(type) {
+			case string:
+				if err := add(name, v, nil); err != nil {
+					return doc, err
+				}
+			case map[string]any:
+				pubkey, _ := v["pubkey"].(string)
+				var relays []string
+				if list, ok := v["relays"].([]any); ok {
+					for _, r := range list {
+						if s, ok := r.(string); ok {
+							relays = append(relays, s)
+						}
+					}
+				}
+				if err := add(name, pubkey, relays); err != nil {
+					return doc, err
+				}
+			default:
+				return doc, fmt.Errorf("invalid entry for '%s' in config, expected a pubkey or an object", name)
+			}
+		}
+	}
+
+	for _, entry := range c.StringSlice("name") {
+		name, pubkey, ok := strings.Cut(entry, "=")
+		if !ok {
+			return doc, fmt.Errorf("invalid --name '%s', expected <name>=<pubkey>", entry)
+		}
+		if err := add(name, pubkey, nil); err != nil {
+			return doc, err
+		}
+	}
+
+	if len(doc.Names) == 0 {
+		return doc, fmt.Errorf("no names given, use --name or --config")
+	}
+
+	return doc, nil
+}
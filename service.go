@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// how long we wait for subscriptions to be closed and output to be flushed after a signal
+const shutdownGracePeriod = 2 * time.Second
+
+var (
+	shuttingDown  atomic.Bool
+	systemdNotify bool
+	readyOnce     sync.Once
+)
+
+// setupService writes the --pidfile and handles termination signals: the first SIGINT or SIGTERM cancels the
+// returned context, so subscriptions are closed with a CLOSE and the command can end by itself, flushing its
+// output (closing the --output array, saving the --seen-db and so on). if it doesn't end soon enough, or a
+// second signal comes, we flush the output ourselves and exit. commands with their own signal handling must
+// also stop when the context is canceled.
+func setupService(ctx context.Context, c *cli.Command) (context.Context, error) {
+	systemdNotify = c.Bool("systemd-notify")
+	if systemdNotify && os.Getenv("NOTIFY_SOCKET") == "" {
+		logverbose("--systemd-notify given but $NOTIFY_SOCKET is not set, ignoring\n")
+		systemdNotify = false
+	}
+
+	if pidfile := c.String("pidfile"); pidfile != "" {
+		if err := writePidfile(pidfile); err != nil {
+			return ctx, err
+		}
+		finishNext := finishOutput
+		finishOutput = func() {
+			finishNext()
+			os.Remove(pidfile)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		ch := make(chan os.Signal, 2)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+		var sig os.Signal
+		select {
+		case sig = <-ch:
+		case <-ctx.Done():
+			// the command was run in-process and is over
+			signal.Stop(ch)
+			return
+		}
+		shuttingDown.Store(true)
+		sdNotify("STOPPING=1")
+		logverbose("received %s, closing subscriptions...\n", sig)
+		cancel()
+
+		select {
+		case <-ch:
+		case <-time.After(shutdownGracePeriod):
+			logverbose("command didn't stop after %s, exiting anyway\n", shutdownGracePeriod)
+		}
+
+		finishOutput()
+		colors.reset()
+		os.Exit(130)
+	}()
+
+	return ctx, nil
+}
+
+func writePidfile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() {
+			if p, err := os.FindProcess(pid); err == nil && p.Signal(syscall.Signal(0)) == nil {
+				return fmt.Errorf("pidfile %s says nak is already running with pid %d", path, pid)
+			}
+		}
+		logverbose("overwriting stale pidfile %s\n", path)
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	return nil
+}
+
+// notifyReady is called by long-running commands once they are actually doing their work
+// (subscriptions are open, the relay is listening), it only does something with --systemd-notify.
+func notifyReady() {
+	readyOnce.Do(func() {
+		sdNotify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+	})
+}
+
+func sdNotify(state string) {
+	if !systemdNotify {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", os.Getenv("NOTIFY_SOCKET"))
+	if err != nil {
+		log("failed to connect to systemd notify socket: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log("failed to notify systemd: %s\n", err)
+	}
+}
+
+// isShutdownError tells if an error is just the consequence of a graceful shutdown.
+func isShutdownError(err error) bool {
+	return shuttingDown.Load() && errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/keyer"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var sign = &cli.Command{
+	Name:  "sign",
+	Usage: "signs unsigned events read from stdin",
+	Description: `reads event templates (like the ones printed by 'nak event --unsigned') from stdin and prints them signed, without ever talking to any relay, so it can be used in an air-gapped machine.
+
+the event contents are kept exactly as given, only the pubkey, id and sig are set (and created_at, if missing).
+
+example:
+		# on the online machine
+		nak event --unsigned -c 'hello from cold storage' > unsigned.json
+		# on the offline machine
+		nak sign --sec ncryptsec1... < unsigned.json > signed.json
+		# back on the online machine
+		nak event relay.damus.io nos.lol < signed.json`,
+	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		&cli.BoolFlag{
+			Name:  "confirm",
+			Usage: "show each event and ask before signing it",
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		kr, _, err := gatherKeyerFromArguments(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		for stdinEvent := range getJsonsOrBlank() {
+			if stdinEvent == "{}" {
+				return fmt.Errorf("no events given on stdin")
+			}
+
+			var evt nostr.Event
+			if err := easyjson.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+				ctx = lineProcessingError(ctx, "invalid event received from stdin: %s", err)
+				continue
+			}
+			if evt.CreatedAt == 0 {
+				evt.CreatedAt = nostr.Now()
+			}
+			if evt.Tags == nil {
+				evt.Tags = nostr.Tags{}
+			}
+
+			if c.Bool("confirm") {
+				log("%s\n", colors.italic(stdinEvent))
+				if !askConfirmation("sign this event? ") {
+					continue
+				}
+			}
+
+			if err := kr.SignEvent(ctx, &evt); err != nil {
+				if _, isBunker := kr.(keyer.BunkerSigner); isBunker && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					err = fmt.Errorf("timeout waiting for bunker to respond")
+				}
+				ctx = lineProcessingError(ctx, "error signing with provided key: %s", err)
+				continue
+			}
+
+			j, _ := easyjson.Marshal(&evt)
+			stdout(string(j))
+		}
+
+		exitIfLineProcessingError(ctx)
+		return nil
+	},
+}
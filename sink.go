@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var sinkFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name: "sink",
+		Usage: "publish each event received to a message queue: kafka://broker:9092/topic, nats://host:4222/subject " +
+			"or mqtt://host:1883/topic (mqtts:// for tls), credentials can be given as user:pass@host, or POST it as " +
+			"json to a webhook at an http(s):// url (can be repeated)",
+		Category: CATEGORY_EXTRAS,
+	},
+	&cli.StringFlag{
+		Name: "sink-key",
+		Usage: "what to use as the key of the messages sent to --sink: 'id', 'pubkey', 'kind' or 'none' (kafka " +
+			"partitions by it, nats and mqtt append it to the subject or topic, webhooks get it in the X-Nak-Key header)",
+		Value: "id",
+		Validator: func(s string) error {
+			if s != "id" && s != "pubkey" && s != "kind" && s != "none" {
+				return fmt.Errorf("invalid --sink-key '%s', expected id, pubkey, kind or none", s)
+			}
+			return nil
+		},
+		Category: CATEGORY_EXTRAS,
+	},
+	&cli.StringFlag{
+		Name: "sink-secret",
+		Usage: "key for signing the webhook requests made by --sink, the signature is sent as 'X-Nak-Signature: " +
+			"sha256=<hex hmac of the body>'",
+		Sources:  cli.EnvVars("NAK_SINK_SECRET"),
+		Category: CATEGORY_EXTRAS,
+	},
+}
+
+// eventSink is a connection to a message queue.
+type eventSink interface {
+	publish(key string, value []byte) error
+	close()
+}
+
+func openSink(ctx context.Context, target *url.URL, secret string) (eventSink, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	switch target.Scheme {
+	case "kafka":
+		return dialKafkaSink(ctx, target)
+	case "nats":
+		return dialNATSSink(ctx, target)
+	case "mqtt", "mqtts":
+		return dialMQTTSink(ctx, target)
+	case "http", "https":
+		return &webhookSink{url: target.String(), secret: secret}, nil
+	default:
+		return nil, fmt.Errorf("unsupported sink '%s', expected kafka://, nats://, mqtt://, mqtts://, http:// or https://", target.Scheme)
+	}
+}
+
+// sinkQueueSize is how many events can be waiting for each sink before new ones start being dropped.
+const sinkQueueSize = 10000
+
+type sinkMessage struct {
+	id    nostr.ID
+	key   string
+	value []byte
+}
+
+// sinkConnection publishes the events in its queue from its own goroutine, so a slow or broken sink never
+// holds the output.
+type sinkConnection struct {
+	target  *url.URL
+	secret  string
+	sink    eventSink
+	queue   chan sinkMessage
+	dropped atomic.Int64
+}
+
+// run publishes everything from the queue until it is closed. a sink that fails (other than a webhook) is
+// reconnected to in the background with exponential backoff while the events pile up in the queue, then the
+// failed event is tried again once.
+func (conn *sinkConnection) run(ctx context.Context) {
+	for msg := range conn.queue {
+		if conn.sink != nil {
+			err := conn.sink.publish(msg.key, msg.value)
+			if err == nil {
+				continue
+			}
+			if _, ok := conn.sink.(*webhookSink); ok {
+				// webhooks have no connection and already retry on their own whenever it can help
+				log("failed to publish %s to %s: %s\n", msg.id.Hex(), conn.target.Redacted(), err)
+				conn.dropped.Add(1)
+				continue
+			}
+			log("failed to publish %s to %s, reconnecting: %s\n", msg.id.Hex(), conn.target.Redacted(), err)
+			conn.sink.close()
+			conn.sink = nil
+		}
+
+		if !conn.reconnect(ctx) {
+			conn.dropped.Add(1)
+			continue
+		}
+		if err := conn.sink.publish(msg.key, msg.value); err != nil {
+			log("failed to publish %s to %s: %s\n", msg.id.Hex(), conn.target.Redacted(), err)
+			conn.dropped.Add(1)
+		}
+	}
+}
+
+func (conn *sinkConnection) reconnect(ctx context.Context) bool {
+	backoff := time.Second
+	for {
+		sink, err := openSink(ctx, conn.target, conn.secret)
+		if err == nil {
+			logverbose("reconnected to sink %s\n", conn.target.Redacted())
+			conn.sink = sink
+			return true
+		}
+		log("failed to reconnect to sink %s, trying again in %s: %s\n", conn.target.Redacted(), backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// setupSinks makes stdout also publish each event to the --sink message queues. events are queued for each sink
+// and published in the background, when the queue of a sink is full its new events are dropped.
+func setupSinks(ctx context.Context, c *cli.Command) error {
+	if len(c.StringSlice("sink")) == 0 {
+		return nil
+	}
+
+	secret := c.String("sink-secret")
+	conns := make([]*sinkConnection, 0, len(c.StringSlice("sink")))
+	for _, raw := range c.StringSlice("sink") {
+		target, err := url.Parse(raw)
+		isWebhook := err == nil && (target.Scheme == "http" || target.Scheme == "https")
+		if err != nil || target.Host == "" || (len(target.Path) <= 1 && !isWebhook) {
+			return fmt.Errorf("invalid --sink '%s', expected scheme://host/topic", raw)
+		}
+		sink, err := openSink(ctx, target, secret)
+		if err != nil {
+			return fmt.Errorf("failed to connect to sink %s: %w", target.Redacted(), err)
+		}
+		logverbose("connected to sink %s\n", target.Redacted())
+		conns = append(conns, &sinkConnection{
+			target: target,
+			secret: secret,
+			sink:   sink,
+			queue:  make(chan sinkMessage, sinkQueueSize),
+		})
+	}
+
+	// the workers outlive the command context so the queues can still be flushed at the end
+	workersCtx, cancelWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	wg := sync.WaitGroup{}
+	for _, conn := range conns {
+		wg.Go(func() { conn.run(workersCtx) })
+	}
+
+	keyBy := c.String("sink-key")
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				msg := sinkMessage{id: evt.ID, value: []byte(evt.String())}
+				switch keyBy {
+				case "id":
+					msg.key = evt.ID.Hex()
+				case "pubkey":
+					msg.key = evt.PubKey.Hex()
+				case "kind":
+					msg.key = strconv.Itoa(int(evt.Kind))
+				}
+
+				for _, conn := range conns {
+					select {
+					case conn.queue <- msg:
+					default:
+						if conn.dropped.Add(1) == 1 {
+							log("sink %s is falling behind, dropping events\n", conn.target.Redacted())
+						}
+					}
+				}
+			}
+		}
+		printNext(args...)
+	}
+
+	finishNext := finishOutput
+	finishOnce := sync.Once{}
+	finishOutput = func() {
+		finishNext()
+		finishOnce.Do(func() {
+			for _, conn := range conns {
+				close(conn.queue)
+			}
+
+			// give the sinks some time to catch up before giving up on what is left
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(30 * time.Second):
+				cancelWorkers()
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					log("gave up waiting for the sinks to finish publishing\n")
+					return
+				}
+			}
+			cancelWorkers()
+
+			for _, conn := range conns {
+				if n := conn.dropped.Load() + int64(len(conn.queue)); n > 0 {
+					log("%d events were not published to sink %s\n", n, conn.target.Redacted())
+				}
+				if conn.sink != nil {
+					conn.sink.close()
+					conn.sink = nil
+				}
+			}
+		})
+	}
+
+	return nil
+}
+
+// sinkAddress returns the host:port from a sink url, with the given default port if none was specified.
+func sinkAddress(target *url.URL, defaultPort string) string {
+	port := target.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(target.Hostname(), port)
+}
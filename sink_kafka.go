@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// kafkaSink produces to a topic, events with a key go to the partition the key hashes to, as with the
+// java client's default partitioner.
+type kafkaSink struct {
+	client *kgo.Client
+}
+
+func dialKafkaSink(ctx context.Context, target *url.URL) (eventSink, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(sinkAddress(target, "9092")),
+		kgo.ClientID("nak"),
+		kgo.DefaultProduceTopic(strings.Trim(target.Path, "/")),
+		kgo.AllowAutoTopicCreation(),
+		kgo.RecordDeliveryTimeout(30 * time.Second),
+	}
+	if target.User != nil {
+		pass, _ := target.User.Password()
+		opts = append(opts, kgo.SASL(plain.Auth{User: target.User.Username(), Pass: pass}.AsMechanism()))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// the client only connects when it needs to, so we check the broker is there now
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &kafkaSink{client: client}, nil
+}
+
+func (ks *kafkaSink) publish(key string, value []byte) error {
+	record := &kgo.Record{Value: value}
+	if key != "" {
+		record.Key = []byte(key)
+	}
+	return ks.client.ProduceSync(context.Background(), record).FirstErr()
+}
+
+func (ks *kafkaSink) close() {
+	ks.client.Close()
+}
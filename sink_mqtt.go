@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttSink publishes to a topic on an mqtt broker with qos 0, the key is appended to the topic as its
+// last level.
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+}
+
+func dialMQTTSink(ctx context.Context, target *url.URL) (eventSink, error) {
+	opts := mqtt.NewClientOptions().
+		SetClientID("nak-" + randString(12)).
+		SetCleanSession(true).
+		SetKeepAlive(60 * time.Second).
+		// sinkConnection takes care of reconnecting
+		SetAutoReconnect(false)
+	if target.Scheme == "mqtts" {
+		opts.AddBroker("tls://" + sinkAddress(target, "8883"))
+		opts.SetTLSConfig(&tls.Config{ServerName: target.Hostname()})
+	} else {
+		opts.AddBroker("tcp://" + sinkAddress(target, "1883"))
+	}
+	if target.User != nil {
+		opts.SetUsername(target.User.Username())
+		if pass, ok := target.User.Password(); ok {
+			opts.SetPassword(pass)
+		}
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		client.Disconnect(0)
+		return nil, ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	return &mqttSink{
+		client: client,
+		topic:  strings.Trim(target.Path, "/"),
+	}, nil
+}
+
+func (ms *mqttSink) publish(key string, value []byte) error {
+	topic := ms.topic
+	if key != "" {
+		topic += "/" + key
+	}
+	token := ms.client.Publish(topic, 0, false, value)
+	token.Wait()
+	return token.Error()
+}
+
+func (ms *mqttSink) close() {
+	ms.client.Disconnect(250)
+}
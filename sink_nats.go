@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes to a subject on a nats server, the key is appended to the subject as its last token.
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func dialNATSSink(ctx context.Context, target *url.URL) (eventSink, error) {
+	timeout := 15 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	// credentials go in the url, a user without a password is taken as a token
+	server := url.URL{Scheme: "nats", User: target.User, Host: sinkAddress(target, "4222")}
+	conn, err := nats.Connect(server.String(),
+		nats.Name("nak"),
+		nats.Timeout(timeout),
+		// sinkConnection takes care of reconnecting
+		nats.NoReconnect(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsSink{
+		conn:    conn,
+		subject: strings.ReplaceAll(strings.Trim(target.Path, "/"), "/", "."),
+	}, nil
+}
+
+func (ns *natsSink) publish(key string, value []byte) error {
+	subject := ns.subject
+	if key != "" {
+		subject += "." + key
+	}
+	return ns.conn.Publish(subject, value)
+}
+
+func (ns *natsSink) close() {
+	ns.conn.Flush()
+	ns.conn.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// webhookSink POSTs each event to a url, retrying with exponential backoff when the server fails or asks
+// us to slow down.
+type webhookSink struct {
+	url    string
+	secret string
+}
+
+func (ws *webhookSink) publish(key string, value []byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := ws.post(key, value)
+		if err == nil {
+			return nil
+		}
+		if attempt == 3 || retryAfter < 0 {
+			return err
+		}
+
+		wait := max(backoff, retryAfter)
+		logverbose("webhook failed (%s), trying again in %s\n", err, wait)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// post returns how long to wait before trying again when it fails, or -1 if trying again won't help.
+func (ws *webhookSink) post(key string, value []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ws.url, bytes.NewReader(value))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nak/"+version)
+	if key != "" {
+		req.Header.Set("X-Nak-Key", key)
+	}
+	if ws.secret != "" {
+		mac := hmac.New(sha256.New, []byte(ws.secret))
+		mac.Write(value)
+		req.Header.Set("X-Nak-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == 429 || resp.StatusCode >= 500:
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = min(time.Duration(seconds)*time.Second, time.Minute)
+		}
+		return retryAfter, fmt.Errorf("webhook returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	default:
+		return -1, fmt.Errorf("webhook returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+}
+
+func (ws *webhookSink) close() {}
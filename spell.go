@@ -254,7 +254,13 @@ func runSpell(
 
 	// execute
 	logSpellDetails(spell)
-	performReq(ctx, spellFilter, spellRelays, stream, outbox, c.Uint("outbox-relays-per-pubkey"), false, 0, "nak-spell")
+	performReq(ctx, spellFilter, spellRelays, reqOptions{
+		stream:                stream,
+		outbox:                outbox,
+		outboxRelaysPerPubKey: c.Uint("outbox-relays-per-pubkey"),
+		onClosed:              "report",
+		label:                 "nak-spell",
+	})
 
 	return nil
 }
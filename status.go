@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var statusPublishFlags = append(defaultKeyFlags,
+	relayFlag,
+	&cli.DurationFlag{
+		Name:  "expires",
+		Usage: "make the status expire after this amount of time (adds a nip40 \"expiration\" tag)",
+	},
+	&cli.StringFlag{
+		Name:  "url",
+		Usage: "a link related to the status (adds an \"r\" tag)",
+	},
+	&cli.StringFlag{
+		Name:  "reference",
+		Usage: "an nevent, naddr or npub related to the status (adds an \"e\", \"a\" or \"p\" tag)",
+	},
+	&cli.BoolFlag{
+		Name:     "auth",
+		Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+		Category: CATEGORY_EXTRAS,
+	},
+	&cli.BoolFlag{
+		Name:     "confirm",
+		Usage:    "ask before publishing the event",
+		Category: CATEGORY_EXTRAS,
+	},
+)
+
+var statusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "sets or reads nip38 user statuses (kind 30315)",
+	Description: `examples:
+		nak status set "coding nak" --expires 2h wss://relay.example.com
+		nak status music "Never Gonna Give You Up - Rick Astley" --expires 3m33s --url spotify:track:4cOdK2wGLETKBW3PvgPWqT
+		nak status set "" # clears the general status
+		nak status get npub1...`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "set",
+			Usage:                     "publishes a \"general\" status",
+			ArgsUsage:                 "<status> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(slices.Clip(statusPublishFlags),
+				&cli.StringFlag{
+					Name:  "type",
+					Usage: "the status type, i.e. the \"d\" tag",
+					Value: "general",
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				return publishStatus(ctx, c, c.String("type"))
+			},
+		},
+		{
+			Name:                      "music",
+			Usage:                     "publishes a \"music\" status",
+			ArgsUsage:                 "<song> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags:                     statusPublishFlags,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				return publishStatus(ctx, c, "music")
+			},
+		},
+		{
+			Name:                      "get",
+			Usage:                     "prints the current statuses of a user",
+			ArgsUsage:                 "<pubkey> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the status events instead of a human-readable description",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing pubkey")
+				}
+				pk, err := parsePubKey(c.Args().First())
+				if err != nil {
+					return err
+				}
+
+				relays := getRelayURLs(c, c.Args().Tail())
+				if len(relays) == 0 {
+					relays = sys.FetchOutboxRelays(ctx, pk, 3)
+				}
+				if len(relays) == 0 {
+					return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+				}
+
+				results := sys.Pool.FetchManyReplaceable(ctx, relays, nostr.Filter{
+					Kinds:   []nostr.Kind{nostr.KindUserStatuses},
+					Authors: []nostr.PubKey{pk},
+				}, nostr.SubscriptionOptions{Label: "nak-status"})
+
+				now := nostr.Now()
+				for _, evt := range results.Range {
+					if evt.Content == "" {
+						// cleared
+						continue
+					}
+					if exp := evt.Tags.Find("expiration"); exp != nil {
+						if ts, err := strconv.ParseInt(exp[1], 10, 64); err == nil && nostr.Timestamp(ts) < now {
+							continue
+						}
+					}
+
+					if c.Bool("json") {
+						stdout(evt)
+						continue
+					}
+
+					line := color.CyanString(evt.Tags.GetD()) + ": " + evt.Content
+					if r := evt.Tags.Find("r"); r != nil {
+						line += " " + color.BlueString(r[1])
+					}
+					stdout(line)
+				}
+
+				return nil
+			},
+		},
+	},
+}
+
+func publishStatus(ctx context.Context, c *cli.Command, statusType string) error {
+	if c.Args().Len() < 1 {
+		return fmt.Errorf("missing status text")
+	}
+
+	evt := nostr.Event{
+		Kind:      nostr.KindUserStatuses,
+		Content:   c.Args().First(),
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"d", statusType}},
+	}
+
+	if expires := c.Duration("expires"); expires > 0 {
+		evt.Tags = append(evt.Tags, nostr.Tag{"expiration", strconv.FormatInt(time.Now().Add(expires).Unix(), 10)})
+	}
+	if url := c.String("url"); url != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"r", url})
+	}
+	if ref := c.String("reference"); ref != "" {
+		ptr, err := parsePointer(ref)
+		if err != nil {
+			return err
+		}
+		evt.Tags = append(evt.Tags, ptr.AsTag())
+	}
+
+	return signPrintAndPublish(ctx, c, evt, c.Args().Tail())
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v3"
+)
+
+var statusFlag = &cli.BoolFlag{
+	Name:     "status",
+	Usage:    "with --stream, keep a table with the state of each relay, events per second, the time of the last event and reconnections on stderr",
+	Category: CATEGORY_EXTRAS,
+}
+
+var statusIntervalFlag = &cli.DurationFlag{
+	Name:     "status-interval",
+	Usage:    "how often the --status table is refreshed",
+	Value:    2 * time.Second,
+	Category: CATEGORY_EXTRAS,
+}
+
+// statusBoard is set when --status is given, performReq reports to it and it's safe to call when nil.
+var statusBoard *relayStatusBoard
+
+type relayStatusBoard struct {
+	mu     sync.Mutex
+	relays map[string]*relayStatus
+	order  []string
+}
+
+type relayStatus struct {
+	state      string
+	reason     string
+	events     uint64
+	lastEvent  time.Time
+	reconnects int
+
+	// for computing the rate since the previous refresh
+	prevEvents uint64
+	rate       float64
+}
+
+func setupStatusBoard(ctx context.Context, c *cli.Command) error {
+	if !c.Bool("status") {
+		return nil
+	}
+	if !c.Bool("stream") {
+		return fmt.Errorf("--status only makes sense with --stream")
+	}
+	interval := c.Duration("status-interval")
+	if interval <= 0 {
+		return fmt.Errorf("invalid --status-interval %s", interval)
+	}
+
+	statusBoard = &relayStatusBoard{relays: make(map[string]*relayStatus)}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// on a terminal we redraw the table in place, otherwise we print it again each time
+		inPlace := isatty.IsTerminal(os.Stderr.Fd())
+		lastLines := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			table := statusBoard.render(interval)
+			if inPlace {
+				clearLines(lastLines)
+			}
+			os.Stderr.WriteString(table)
+			lastLines = strings.Count(table, "\n")
+		}
+	}()
+
+	return nil
+}
+
+func (board *relayStatusBoard) get(url string) *relayStatus {
+	rs, ok := board.relays[url]
+	if !ok {
+		rs = &relayStatus{state: "connecting"}
+		board.relays[url] = rs
+		board.order = append(board.order, url)
+	}
+	return rs
+}
+
+func (board *relayStatusBoard) subscribed(url string) {
+	if board == nil {
+		return
+	}
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	rs := board.get(url)
+	if rs.state == "resubscribing" {
+		rs.reconnects++
+	}
+	rs.state = "subscribed"
+	rs.reason = ""
+}
+
+func (board *relayStatusBoard) event(url string) {
+	if board == nil {
+		return
+	}
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	rs := board.get(url)
+	rs.events++
+	rs.lastEvent = time.Now()
+}
+
+func (board *relayStatusBoard) closed(url string, reason string) {
+	if board == nil {
+		return
+	}
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	rs := board.get(url)
+	rs.state = "closed"
+	rs.reason = reason
+}
+
+func (board *relayStatusBoard) resubscribing(url string) {
+	if board == nil {
+		return
+	}
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	board.get(url).state = "resubscribing"
+}
+
+func (board *relayStatusBoard) render(interval time.Duration) string {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%-40s %-14s %8s %8s %10s %10s\n", "relay", "state", "events", "ev/s", "last", "reconnects")
+	for _, url := range board.order {
+		rs := board.relays[url]
+
+		// the pool reconnects by itself, so we check if the connection is alive
+		if relay, ok := sys.Pool.Relays.Load(url); ok && (rs.state == "subscribed" || rs.state == "disconnected") {
+			if !relay.IsConnected() {
+				rs.state = "disconnected"
+			} else if rs.state == "disconnected" {
+				rs.reconnects++
+				rs.state = "subscribed"
+			}
+		}
+		state := rs.state
+
+		rs.rate = float64(rs.events-rs.prevEvents) / interval.Seconds()
+		rs.prevEvents = rs.events
+
+		last := "-"
+		if !rs.lastEvent.IsZero() {
+			last = time.Since(rs.lastEvent).Truncate(time.Second).String() + " ago"
+		}
+
+		stateText := fmt.Sprintf("%-14s", state)
+		switch state {
+		case "subscribed":
+			stateText = color.GreenString(stateText)
+		case "closed", "disconnected":
+			stateText = color.RedString(stateText)
+		default:
+			stateText = color.YellowString(stateText)
+		}
+
+		name := strings.TrimPrefix(url, "wss://")
+		if len(name) > 40 {
+			name = name[0:39] + "…"
+		}
+		fmt.Fprintf(&b, "%-40s %s %8d %8.1f %10s %10d\n", name, stateText, rs.events, rs.rate, last, rs.reconnects)
+		if rs.reason != "" {
+			fmt.Fprintf(&b, "  %s\n", color.New(color.Faint).Sprint(rs.reason))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"github.com/urfave/cli/v3"
+)
+
+var subIDFlag = &cli.StringFlag{
+	Name: "sub-id",
+	Usage: "subscription id to use instead of the default 'nak' (when talking to relays a counter is always " +
+		"prepended to it, as in '1:<sub-id>', so nak can tell its subscriptions apart)",
+	Category: CATEGORY_EXTRAS,
+}
+
+var subIDKeyFlag = &cli.StringFlag{
+	Name: "sub-id-key",
+	Usage: "derive the subscription id from an HMAC of the filters with this key, so the same query always gets " +
+		"the same id, which makes replayed scripts idempotent and relay logs easier to correlate",
+	Category: CATEGORY_EXTRAS,
+}
+
+// subscriptionID returns the subscription id for the given filters according to --sub-id and --sub-id-key,
+// or the fallback if none of them was given.
+func subscriptionID(c *cli.Command, fallback string, filters ...nostr.Filter) string {
+	id := fallback
+	if name := c.String("sub-id"); name != "" {
+		id = name
+	}
+
+	if key := c.String("sub-id-key"); key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		for _, filter := range filters {
+			mac.Write(canonicalFilter(filter))
+		}
+		id += "-" + hex.EncodeToString(mac.Sum(nil))[0:16]
+	}
+
+	return id
+}
+
+// canonicalFilter serializes a filter such that equal filters always give the same bytes, which isn't
+// the case for the json since the tags are a map.
+func canonicalFilter(filter nostr.Filter) []byte {
+	tags := filter.Tags
+	filter.Tags = nil
+
+	b := []byte(filter.String())
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		b = append(b, '\n')
+		b = append(b, name...)
+		for _, value := range tags[name] {
+			b = append(b, 0)
+			b = append(b, value...)
+		}
+	}
+	return append(b, '\n')
+}
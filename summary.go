@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var summaryFlag = &cli.BoolFlag{
+	Name:     "summary",
+	Usage:    "after the query ends, print for each relay how many events it returned, how many were duplicates, how many only it had, how long it took to send EOSE and how many bytes it sent",
+	Category: CATEGORY_EXTRAS,
+}
+
+// relayProvenance is what we know about the events each relay returned in a query.
+type relayProvenance struct {
+	events     int
+	duplicates int
+	eose       time.Duration
+	ended      string // "eose", "closed" or "" if the query was interrupted
+}
+
+// fetchManyWithSummary queries each relay separately so we can tell where each event came from and when each
+// relay sent its EOSE, passing on each event only once. the returned function prints the report.
+func fetchManyWithSummary(
+	ctx context.Context,
+	urls []string,
+	filter nostr.Filter,
+	opts nostr.SubscriptionOptions,
+) (chan nostr.RelayEvent, chan nostr.RelayClosed, func()) {
+	results := make(chan nostr.RelayEvent)
+	closeds := make(chan nostr.RelayClosed)
+
+	mu := sync.Mutex{}
+	start := time.Now()
+	stats := make(map[string]*relayProvenance, len(urls))
+	sources := make(map[nostr.ID][]string)
+	for _, url := range urls {
+		stats[url] = &relayProvenance{}
+	}
+
+	// each relay call must see all its events, the deduplication across relays is done here
+	opts.CheckDuplicate = nil
+
+	wg := sync.WaitGroup{}
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rp := stats[url]
+			relayResults, relayCloseds := sys.Pool.FetchManyNotifyClosed(ctx, []string{url}, filter, opts)
+			for {
+				select {
+				case ie, ok := <-relayResults:
+					if !ok {
+						mu.Lock()
+						if rp.ended == "" && ctx.Err() == nil {
+							rp.ended = "eose"
+							rp.eose = time.Since(start)
+						}
+						mu.Unlock()
+						return
+					}
+
+					mu.Lock()
+					rp.events++
+					duplicate := len(sources[ie.Event.ID]) > 0
+					if duplicate {
+						rp.duplicates++
+					}
+					if !slices.Contains(sources[ie.Event.ID], url) {
+						sources[ie.Event.ID] = append(sources[ie.Event.ID], url)
+					}
+					mu.Unlock()
+					if duplicate {
+						continue
+					}
+
+					select {
+					case results <- ie:
+					case <-ctx.Done():
+						return
+					}
+				case closed := <-relayCloseds:
+					mu.Lock()
+					rp.ended = "closed"
+					rp.eose = time.Since(start)
+					mu.Unlock()
+					select {
+					case closeds <- closed:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		unique := make(map[string]int, len(urls))
+		for _, relays := range sources {
+			if len(relays) == 1 {
+				unique[relays[0]]++
+			}
+		}
+
+		// the relays that are more worth keeping come first
+		sorted := slices.Clone(urls)
+		slices.SortStableFunc(sorted, func(a, b string) int {
+			if d := unique[b] - unique[a]; d != 0 {
+				return d
+			}
+			return stats[b].events - stats[a].events
+		})
+
+		width := 5
+		for _, url := range sorted {
+			width = max(width, len(url))
+		}
+		b := strings.Builder{}
+		fmt.Fprintf(&b, "%-*s %8s %8s %8s %10s\n", width, "relay", "events", "dupes", "unique", "eose")
+		for _, url := range sorted {
+			rp := stats[url]
+			eose := color.YellowString("%10s", "-")
+			switch rp.ended {
+			case "eose":
+				eose = fmt.Sprintf("%10s", rp.eose.Round(time.Millisecond))
+			case "closed":
+				eose = color.RedString("%10s", "closed")
+			}
+			fmt.Fprintf(&b, "%-*s %8d %8d %8d %s\n", width, url, rp.events, rp.duplicates, unique[url], eose)
+		}
+		fmt.Fprintf(&b, "%d distinct events\n", len(sources))
+		log("%s", b.String())
+	}
+
+	return results, closeds, report
+}
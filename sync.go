@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
 
 	"fiatjaf.com/nostr"
@@ -19,11 +20,11 @@ var syncCmd = &cli.Command{
 	Usage:       "sync events between two relays using negentropy",
 	Description: `uses nip77 negentropy to sync events between two relays`,
 	ArgsUsage:   "<relay1> <relay2>",
-	Flags:       reqFilterFlags,
+	Flags:       append(slices.Clip(reqFilterFlags), relayFlag),
 	Action: func(ctx context.Context, c *cli.Command) error {
-		args := c.Args().Slice()
+		args := getRelayURLs(c, c.Args().Slice())
 		if len(args) != 2 {
-			return fmt.Errorf("need exactly two relay URLs: source and target")
+			return fmt.Errorf("need exactly two relay URLs (as arguments or with --relay): source and target")
 		}
 
 		filter := nostr.Filter{}
@@ -134,6 +135,7 @@ func NewRelayThirdPartyRemote(ctx context.Context, url string) (*RelayThirdParty
 
 	var err error
 	rtpr.relay, err = nostr.RelayConnect(ctx, url, nostr.RelayOptions{
+		NoticeHandler: handleNotice,
 		CustomHandler: func(data string) {
 			envelope := nip77.ParseNegMessage(data)
 			if envelope == nil {
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/fiatjaf/nak/lib"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	// most relays reject events too far in the future, usually more than 15 minutes
+	maxFutureCreatedAt = 10 * time.Minute
+	maxPastCreatedAt   = 7 * 24 * time.Hour
+
+	// nothing on nostr was created before this (2020-11-01)
+	nostrGenesis nostr.Timestamp = 1604188800
+)
+
+var strictTimeFlag = &cli.BoolFlag{
+	Name:  "strict-time",
+	Usage: "refuse to publish events with created_at more than 10 minutes in the future or more than 7 days in the past instead of just warning",
+}
+
+var saneTimestampsFlag = &cli.BoolFlag{
+	Name:  "sane-timestamps",
+	Usage: "hide events with created_at more than 10 minutes in the future or from before nostr existed",
+}
+
+// checkCreatedAt warns (or fails with --strict-time) when an event about to be published has a created_at
+// too far from the system time. for events in the future it also checks the local clock against the relays.
+func checkCreatedAt(ctx context.Context, c *cli.Command, evt nostr.Event, relays []*nostr.Relay) error {
+	diff := time.Until(evt.CreatedAt.Time())
+
+	var problem string
+	switch {
+	case diff > maxFutureCreatedAt:
+		problem = fmt.Sprintf("created_at of %s is %s in the future", evt.ID.Hex(), diff.Round(time.Second))
+	case -diff > maxPastCreatedAt:
+		problem = fmt.Sprintf("created_at of %s is %s in the past", evt.ID.Hex(), (-diff).Round(time.Hour))
+	default:
+		return nil
+	}
+
+	if c.Bool("strict-time") {
+		return fmt.Errorf("%s", problem)
+	}
+	log("%s\n", color.YellowString("warning: %s, some relays may reject it", problem))
+
+	if diff > 0 && len(relays) > 0 {
+		// maybe it's our clock that is wrong
+		if skew, err := relayClockSkew(ctx, relays[0].URL); err == nil && skew < -time.Minute {
+			log("%s\n", color.YellowString("warning: the system clock seems to be %s ahead of %s", (-skew).Round(time.Second), relays[0].URL))
+		}
+	}
+
+	return nil
+}
+
+// setupSaneTimestamps makes stdout skip events with absurd timestamps when --sane-timestamps is given.
+func setupSaneTimestamps(c *cli.Command) {
+	if !c.Bool("sane-timestamps") {
+		return
+	}
+
+	printNext := stdout
+	stdout = func(args ...any) {
+		if len(args) == 1 {
+			if evt, ok := args[0].(nostr.Event); ok {
+				if evt.CreatedAt < nostrGenesis || time.Until(evt.CreatedAt.Time()) > maxFutureCreatedAt {
+					logverbose("hiding event %s with created_at %d\n", evt.ID.Hex(), evt.CreatedAt)
+					return
+				}
+			}
+		}
+		printNext(args...)
+	}
+}
+
+// relayClockSkew estimates how far ahead the relay clock is from ours using the Date header of its
+// nip11 response, compensating for half of the round trip like ntp does. the result has second precision.
+func relayClockSkew(ctx context.Context, url string) (time.Duration, error) {
+	url, _, err := lib.NormalizeRelayURL(url)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 7*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http"+url[2:], nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("relay didn't send a valid date header")
+	}
+
+	// the date header is truncated to the second, so on average it is half a second behind
+	serverTime := date.Add(500 * time.Millisecond)
+	return serverTime.Sub(start.Add(rtt / 2)), nil
+}
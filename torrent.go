@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v3"
+)
+
+var torrent = &cli.Command{
+	Name:  "torrent",
+	Usage: "announces .torrent files as nip35 events and searches for them",
+	Description: `examples:
+		nak torrent announce ubuntu.torrent --tag linux --description "ubuntu desktop image" wss://relay.example.com
+		nak torrent search --infohash 2c6b6858d61da9543d4231a71db4b1c9264b0685 wss://relay.example.com
+		nak torrent search --search ubuntu wss://relay.example.com`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "announce",
+			Usage:                     "parses a .torrent file and publishes it as a kind 2003 event",
+			ArgsUsage:                 "<file.torrent> [relay...]",
+			DisableSliceFlagSeparator: true,
+			Flags: append(slices.Clip(defaultKeyFlags),
+				relayFlag,
+				&cli.StringFlag{
+					Name:        "title",
+					Usage:       "title of the torrent",
+					DefaultText: "the name from the .torrent file",
+				},
+				&cli.StringFlag{
+					Name:    "description",
+					Aliases: []string{"c"},
+					Usage:   "long description of the torrent, used as the event content",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tag",
+					Aliases: []string{"t"},
+					Usage:   "hashtag for the torrent, can be given multiple times",
+				},
+				&cli.BoolFlag{
+					Name:  "no-trackers",
+					Usage: "don't include the trackers from the .torrent file in the event",
+				},
+				&cli.BoolFlag{
+					Name:     "auth",
+					Usage:    "always perform nip42 \"AUTH\" when facing an \"auth-required: \" rejection and try again",
+					Category: CATEGORY_EXTRAS,
+				},
+				&cli.BoolFlag{
+					Name:     "confirm",
+					Usage:    "ask before publishing the event",
+					Category: CATEGORY_EXTRAS,
+				},
+			),
+			Action: func(ctx context.Context, c *cli.Command) error {
+				if c.Args().Len() < 1 {
+					return fmt.Errorf("missing .torrent file")
+				}
+				data, err := os.ReadFile(c.Args().First())
+				if err != nil {
+					return fmt.Errorf("failed to read torrent file: %w", err)
+				}
+
+				meta, err := parseTorrent(data)
+				if err != nil {
+					return fmt.Errorf("invalid torrent file: %w", err)
+				}
+
+				title := c.String("title")
+				if title == "" {
+					title = meta.name
+				}
+
+				evt := nostr.Event{
+					Kind:    2003,
+					Content: c.String("description"),
+					Tags: nostr.Tags{
+						{"title", title},
+						{"x", meta.infohash},
+					},
+				}
+				for _, file := range meta.files {
+					evt.Tags = append(evt.Tags, nostr.Tag{"file", file.path, strconv.FormatInt(file.size, 10)})
+				}
+				if !c.Bool("no-trackers") {
+					for _, tracker := range meta.trackers {
+						evt.Tags = append(evt.Tags, nostr.Tag{"tracker", tracker})
+					}
+				}
+				for _, t := range c.StringSlice("tag") {
+					evt.Tags = append(evt.Tags, nostr.Tag{"t", strings.TrimPrefix(t, "#")})
+				}
+
+				return signPrintAndPublish(ctx, c, evt, c.Args().Tail())
+			},
+		},
+		{
+			Name:                      "search",
+			Usage:                     "searches for kind 2003 torrent events by infohash or by text",
+			ArgsUsage:                 "<relay...>",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				relayFlag,
+				&cli.StringSliceFlag{
+					Name:    "infohash",
+					Aliases: []string{"x"},
+					Usage:   "infohash (or magnet link) of the torrent to search for, can be given multiple times",
+				},
+				&cli.StringFlag{
+					Name:  "search",
+					Usage: "a nip50 search query, for relays that support it",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tag",
+					Aliases: []string{"t"},
+					Usage:   "only torrents with this hashtag, can be given multiple times",
+				},
+				&cli.UintFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "maximum number of torrents to fetch",
+					Value:   50,
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the torrent events as json instead of a human-readable description",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				relays := getRelayURLs(c, c.Args().Slice())
+				if len(relays) == 0 {
+					return fmt.Errorf("no relays to query, specify some as arguments or with --relay")
+				}
+
+				filter := nostr.Filter{
+					Kinds:  []nostr.Kind{2003},
+					Search: c.String("search"),
+					Limit:  int(c.Uint("limit")),
+				}
+				if infohashes := c.StringSlice("infohash"); len(infohashes) > 0 {
+					xs := make([]string, len(infohashes))
+					for i, ih := range infohashes {
+						x, err := parseInfohash(ih)
+						if err != nil {
+							return err
+						}
+						xs[i] = x
+					}
+					filter.Tags = nostr.TagMap{"x": xs}
+				}
+				if ts := c.StringSlice("tag"); len(ts) > 0 {
+					if filter.Tags == nil {
+						filter.Tags = nostr.TagMap{}
+					}
+					for _, t := range ts {
+						filter.Tags["t"] = append(filter.Tags["t"], strings.TrimPrefix(t, "#"))
+					}
+				}
+
+				for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-torrent"}) {
+					if c.Bool("json") {
+						stdout(ie.Event)
+						continue
+					}
+
+					title := ""
+					var infohash string
+					var size int64
+					files := 0
+					for _, tag := range ie.Event.Tags {
+						if len(tag) < 2 {
+							continue
+						}
+						switch tag[0] {
+						case "title":
+							title = tag[1]
+						case "x":
+							infohash = tag[1]
+						case "file":
+							files++
+							if len(tag) >= 3 {
+								s, _ := strconv.ParseInt(tag[2], 10, 64)
+								size += s
+							}
+						}
+					}
+
+					line := colors.bold(title) + " " + color.YellowString(infohash)
+					line += fmt.Sprintf(" %d files, %d bytes", files, size)
+					line += " " + color.BlueString(ie.Event.ID.Hex())
+					stdout(line)
+				}
+
+				return nil
+			},
+		},
+	},
+}
+
+type torrentFile struct {
+	path string
+	size int64
+}
+
+type torrentMeta struct {
+	name     string
+	infohash string
+	files    []torrentFile
+	trackers []string
+}
+
+// parseTorrent reads the bencoded metainfo of a .torrent file. the infohash is the sha1 of the
+// exact bytes of the "info" dictionary, so we keep track of where it starts and ends.
+func parseTorrent(data []byte) (torrentMeta, error) {
+	var meta torrentMeta
+
+	d := &bdecoder{data: data}
+	root, err := d.decode()
+	if err != nil {
+		return meta, err
+	}
+	if d.pos != len(data) {
+		return meta, fmt.Errorf("trailing data after metainfo")
+	}
+	dict, ok := root.(map[string]any)
+	if !ok {
+		return meta, fmt.Errorf("metainfo is not a dictionary")
+	}
+	info, ok := dict["info"].(map[string]any)
+	if !ok || d.infoEnd == 0 {
+		return meta, fmt.Errorf("missing info dictionary")
+	}
+
+	hash := sha1.Sum(data[d.infoStart:d.infoEnd])
+	meta.infohash = hex.EncodeToString(hash[:])
+
+	meta.name, _ = info["name"].(string)
+	if length, ok := info["length"].(int64); ok {
+		meta.files = append(meta.files, torrentFile{meta.name, length})
+	} else if files, ok := info["files"].([]any); ok {
+		for _, f := range files {
+			file, ok := f.(map[string]any)
+			if !ok {
+				continue
+			}
+			size, _ := file["length"].(int64)
+			parts, _ := file["path"].([]any)
+			segments := make([]string, 0, len(parts))
+			for _, p := range parts {
+				if s, ok := p.(string); ok {
+					segments = append(segments, s)
+				}
+			}
+			meta.files = append(meta.files, torrentFile{strings.Join(segments, "/"), size})
+		}
+	} else {
+		return meta, fmt.Errorf("info dictionary has neither 'length' nor 'files'")
+	}
+
+	if announce, ok := dict["announce"].(string); ok && announce != "" {
+		meta.trackers = append(meta.trackers, announce)
+	}
+	if tiers, ok := dict["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			urls, _ := tier.([]any)
+			for _, u := range urls {
+				if s, ok := u.(string); ok && s != "" && !slices.Contains(meta.trackers, s) {
+					meta.trackers = append(meta.trackers, s)
+				}
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// parseInfohash accepts a hex infohash or a magnet link and returns the lowercase hex infohash.
+func parseInfohash(s string) (string, error) {
+	if strings.HasPrefix(s, "magnet:") {
+		idx := strings.Index(s, "xt=urn:btih:")
+		if idx == -1 {
+			return "", fmt.Errorf("magnet link '%s' has no btih infohash", s)
+		}
+		s = s[idx+len("xt=urn:btih:"):]
+		if end := strings.IndexByte(s, '&'); end != -1 {
+			s = s[0:end]
+		}
+	}
+	s = strings.ToLower(s)
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 40 {
+		return "", fmt.Errorf("invalid infohash '%s'", s)
+	}
+	return s, nil
+}
+
+type bdecoder struct {
+	data      []byte
+	pos       int
+	depth     int
+	infoStart int
+	infoEnd   int
+}
+
+func (d *bdecoder) decode() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+
+	switch ch := d.data[d.pos]; {
+	case ch == 'i':
+		end := d.indexFrom('e')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated integer at %d", d.pos)
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos+1:end]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer at %d: %w", d.pos, err)
+		}
+		d.pos = end + 1
+		return n, nil
+	case ch == 'l':
+		d.pos++
+		list := make([]any, 0)
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			item, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("unterminated list")
+		}
+		d.pos++
+		return list, nil
+	case ch == 'd':
+		d.pos++
+		d.depth++
+		dict := make(map[string]any)
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.decodeString()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			value, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			if d.depth == 1 && key == "info" {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[key] = value
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("unterminated dictionary")
+		}
+		d.pos++
+		d.depth--
+		return dict, nil
+	case ch >= '0' && ch <= '9':
+		return d.decodeString()
+	default:
+		return nil, fmt.Errorf("unexpected character '%c' at %d", ch, d.pos)
+	}
+}
+
+func (d *bdecoder) decodeString() (string, error) {
+	colon := d.indexFrom(':')
+	if colon == -1 {
+		return "", fmt.Errorf("invalid string at %d", d.pos)
+	}
+	length, err := strconv.Atoi(string(d.data[d.pos:colon]))
+	if err != nil || length < 0 || colon+1+length > len(d.data) {
+		return "", fmt.Errorf("invalid string length at %d", d.pos)
+	}
+	s := string(d.data[colon+1 : colon+1+length])
+	d.pos = colon + 1 + length
+	return s, nil
+}
+
+func (d *bdecoder) indexFrom(b byte) int {
+	for i := d.pos; i < len(d.data); i++ {
+		if d.data[i] == b {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip04"
+	"fiatjaf.com/nostr/nip10"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/sdk"
+	"github.com/mattn/go-tty"
+	"github.com/urfave/cli/v3"
+)
+
+const tuiMaxEvents = 2000
+
+var tui = &cli.Command{
+	Name:  "tui",
+	Usage: "browse a live feed of events in the terminal",
+	Description: `subscribes to the given relays with the given filter and shows the events as they arrive. the feed can be navigated with the keyboard and events can be reacted to, replied to and zapped.
+
+keys:
+		j/k or arrows   move down and up
+		g/G             go to the newest and oldest events
+		enter           expand the thread around the selected event
+		p               show the profile of the author
+		r               react with a '+'
+		R               reply (type the text, enter sends, esc cancels)
+		z               zap the author through nostr wallet connect (--nwc)
+		esc             go back to the feed
+		q               quit
+
+example:
+		nak tui -k 1 --limit 50 relay.damus.io nos.lol
+		nak tui -k 1 -a npub1... --nwc nostr+walletconnect://... --zap-amount 100 relay.primal.net`,
+	ArgsUsage:                 "[relay...]",
+	DisableSliceFlagSeparator: true,
+	Flags: append(append(slices.Clip(reqFilterFlags), defaultKeyFlags...),
+		relayFlag,
+		&cli.StringFlag{
+			Name:    "nwc",
+			Usage:   "nostr wallet connect uri (nostr+walletconnect://...) used for paying zaps",
+			Sources: cli.EnvVars("NOSTR_WALLET_CONNECT"),
+		},
+		&cli.UintFlag{
+			Name:  "zap-amount",
+			Usage: "amount in sats sent with each zap",
+			Value: 21,
+		},
+	),
+	Action: func(ctx context.Context, c *cli.Command) error {
+		relays := getRelayURLsOrDefaults(c, c.Args().Slice())
+		if len(relays) == 0 {
+			return fmt.Errorf("no relays given")
+		}
+		if err := normalizeAndValidateRelayURLs(relays); err != nil {
+			return err
+		}
+
+		filter := nostr.Filter{}
+		if err := applyFlagsToFilter(c, &filter); err != nil {
+			return err
+		}
+		if filter.Limit == 0 {
+			filter.Limit = 100
+		}
+
+		var wallet *nwcConnection
+		if uri := c.String("nwc"); uri != "" {
+			var err error
+			if wallet, err = parseNWCURI(uri); err != nil {
+				return err
+			}
+		}
+
+		kr, _, err := gatherKeyerFromArguments(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		term, err := tty.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open the terminal: %w", err)
+		}
+		defer term.Close()
+		restore, err := term.Raw()
+		if err != nil {
+			return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+		}
+		defer restore()
+
+		out := term.Output()
+		fmt.Fprint(out, "\x1b[?1049h\x1b[?25l") // alternate screen, hidden cursor
+		defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ui := &tuiState{
+			ctx:       ctx,
+			c:         c,
+			kr:        kr,
+			wallet:    wallet,
+			relays:    relays,
+			names:     make(map[nostr.PubKey]string),
+			seen:      make(map[nostr.ID]struct{}),
+			updates:   make(chan func(), 64),
+			zapAmount: c.Uint("zap-amount"),
+		}
+
+		keys := make(chan string)
+		go readTUIKeys(term, keys)
+
+		events := sys.Pool.SubscribeMany(ctx, relays, filter, nostr.SubscriptionOptions{Label: "nak-tui"})
+		resize := term.SIGWINCH()
+		redraw := time.NewTicker(time.Second) // keeps the relative times fresh
+		defer redraw.Stop()
+
+		for {
+			ui.width, ui.height, _ = term.Size()
+			fmt.Fprint(out, ui.render())
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case ie, ok := <-events:
+				if !ok {
+					events = nil
+					ui.status = "subscription ended"
+					continue
+				}
+				ui.addEvent(ie.Event)
+			case key, ok := <-keys:
+				if !ok {
+					return nil
+				}
+				if quit := ui.handleKey(key); quit {
+					return nil
+				}
+			case update := <-ui.updates:
+				update()
+			case <-resize:
+			case <-redraw.C:
+			}
+		}
+	},
+}
+
+// tuiList is a list of events and which of them is selected.
+type tuiList struct {
+	events   []nostr.Event
+	selected int
+	offset   int
+}
+
+type tuiState struct {
+	ctx    context.Context
+	c      *cli.Command
+	kr     nostr.Keyer
+	wallet *nwcConnection
+	relays []string
+
+	width, height int
+
+	feed    tuiList
+	thread  *tuiList
+	profile *sdk.ProfileMetadata
+
+	// when replying, what is being typed
+	replyingTo *nostr.Event
+	input      []rune
+
+	names     map[nostr.PubKey]string
+	seen      map[nostr.ID]struct{}
+	status    string
+	zapAmount uint64
+
+	// functions that modify the state, sent from the goroutines doing network calls
+	updates chan func()
+}
+
+func (ui *tuiState) current() *tuiList {
+	if ui.thread != nil {
+		return ui.thread
+	}
+	return &ui.feed
+}
+
+func (ui *tuiState) selectedEvent() *nostr.Event {
+	list := ui.current()
+	if list.selected < 0 || list.selected >= len(list.events) {
+		return nil
+	}
+	return &list.events[list.selected]
+}
+
+// async runs a network call in the background, its result is applied to the state in the main loop.
+func (ui *tuiState) async(status string, fn func() func()) {
+	ui.status = status
+	go func() {
+		update := fn()
+		select {
+		case ui.updates <- update:
+		case <-ui.ctx.Done():
+		}
+	}()
+}
+
+func (ui *tuiState) addEvent(evt nostr.Event) {
+	if _, ok := ui.seen[evt.ID]; ok {
+		return
+	}
+	ui.seen[evt.ID] = struct{}{}
+
+	// newest first, keeping the selection on the same event
+	idx, _ := slices.BinarySearchFunc(ui.feed.events, evt, func(a, b nostr.Event) int {
+		return int(b.CreatedAt) - int(a.CreatedAt)
+	})
+	ui.feed.events = slices.Insert(ui.feed.events, idx, evt)
+	if ui.feed.selected > 0 && idx <= ui.feed.selected {
+		ui.feed.selected++
+		ui.feed.offset++
+	}
+	if len(ui.feed.events) > tuiMaxEvents {
+		ui.feed.events = ui.feed.events[0:tuiMaxEvents]
+		ui.feed.selected = min(ui.feed.selected, tuiMaxEvents-1)
+	}
+
+	ui.loadName(evt.PubKey)
+}
+
+func (ui *tuiState) loadName(pubkey nostr.PubKey) {
+	if _, ok := ui.names[pubkey]; ok {
+		return
+	}
+	ui.names[pubkey] = ""
+	go func() {
+		pm := sys.FetchProfileMetadata(ui.ctx, pubkey)
+		select {
+		case ui.updates <- func() { ui.names[pubkey] = pm.ShortName() }:
+		case <-ui.ctx.Done():
+		}
+	}()
+}
+
+func (ui *tuiState) name(pubkey nostr.PubKey) string {
+	if name := ui.names[pubkey]; name != "" {
+		return name
+	}
+	npub := nip19.EncodeNpub(pubkey)
+	return npub[0:10] + "…" + npub[len(npub)-4:]
+}
+
+// handleKey applies a key press and tells if we should quit.
+func (ui *tuiState) handleKey(key string) bool {
+	if ui.replyingTo != nil {
+		ui.handleInputKey(key)
+		return false
+	}
+
+	list := ui.current()
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "esc":
+		if ui.profile != nil {
+			ui.profile = nil
+		} else {
+			ui.thread = nil
+		}
+		ui.status = ""
+	case "j", "down":
+		list.selected = max(min(list.selected+1, len(list.events)-1), 0)
+	case "k", "up":
+		list.selected = max(list.selected-1, 0)
+	case "g", "home":
+		list.selected = 0
+	case "G", "end":
+		list.selected = max(len(list.events)-1, 0)
+	case "enter":
+		if evt := ui.selectedEvent(); evt != nil && ui.profile == nil {
+			ui.expandThread(*evt)
+		}
+	case "p":
+		if evt := ui.selectedEvent(); evt != nil {
+			pubkey := evt.PubKey
+			ui.async("loading profile...", func() func() {
+				pm := sys.FetchProfileMetadata(ui.ctx, pubkey)
+				return func() {
+					ui.profile = &pm
+					ui.status = ""
+				}
+			})
+		}
+	case "r":
+		if evt := ui.selectedEvent(); evt != nil {
+			ui.react(*evt)
+		}
+	case "R":
+		if evt := ui.selectedEvent(); evt != nil {
+			target := *evt
+			ui.replyingTo = &target
+			ui.input = nil
+		}
+	case "z":
+		if evt := ui.selectedEvent(); evt != nil {
+			ui.zap(*evt)
+		}
+	}
+	return false
+}
+
+func (ui *tuiState) handleInputKey(key string) {
+	switch key {
+	case "esc", "ctrl-c":
+		ui.replyingTo = nil
+		ui.input = nil
+	case "enter":
+		if text := strings.TrimSpace(string(ui.input)); text != "" {
+			ui.reply(*ui.replyingTo, text)
+		}
+		ui.replyingTo = nil
+		ui.input = nil
+	case "backspace":
+		if len(ui.input) > 0 {
+			ui.input = ui.input[0 : len(ui.input)-1]
+		}
+	default:
+		if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+			ui.input = append(ui.input, r[0])
+		}
+	}
+}
+
+// expandThread fetches the root of the thread the event is in and everything that references it.
+func (ui *tuiState) expandThread(evt nostr.Event) {
+	ui.async("loading thread...", func() func() {
+		rootID := evt.ID
+		if root, ok := nip10.GetThreadRoot(evt.Tags).(nostr.EventPointer); ok {
+			rootID = root.ID
+		}
+
+		thread := make(map[nostr.ID]nostr.Event)
+		thread[evt.ID] = evt
+		ctx, cancel := context.WithTimeout(ui.ctx, 10*time.Second)
+		defer cancel()
+		for _, filter := range []nostr.Filter{
+			{IDs: []nostr.ID{rootID}},
+			{Tags: nostr.TagMap{"e": []string{rootID.Hex()}}, Kinds: []nostr.Kind{nostr.KindTextNote}, Limit: 500},
+			{Tags: nostr.TagMap{"e": []string{evt.ID.Hex()}}, Kinds: []nostr.Kind{nostr.KindTextNote}, Limit: 500},
+		} {
+			for ie := range sys.Pool.FetchMany(ctx, ui.relays, filter, nostr.SubscriptionOptions{Label: "nak-tui-thread"}) {
+				thread[ie.Event.ID] = ie.Event
+			}
+		}
+
+		return func() {
+			list := &tuiList{}
+			for _, item := range thread {
+				list.events = append(list.events, item)
+				ui.loadName(item.PubKey)
+			}
+			slices.SortFunc(list.events, func(a, b nostr.Event) int { return int(a.CreatedAt) - int(b.CreatedAt) })
+			list.selected = slices.IndexFunc(list.events, func(item nostr.Event) bool { return item.ID == evt.ID })
+			ui.thread = list
+			ui.status = fmt.Sprintf("%d events in this thread", len(list.events))
+		}
+	})
+}
+
+// publish signs and sends an event to the relays we're reading from, reporting the result in the status line.
+func (ui *tuiState) publish(evt nostr.Event, what string) {
+	ui.async("sending "+what+"...", func() func() {
+		if err := ui.kr.SignEvent(ui.ctx, &evt); err != nil {
+			return func() { ui.status = fmt.Sprintf("failed to sign %s: %s", what, err) }
+		}
+		ok := 0
+		var lastErr error
+		for res := range publishManyWithRetries(ui.ctx, ui.c, ui.relays, evt) {
+			if res.Error == nil {
+				ok++
+			} else {
+				lastErr = res.Error
+			}
+		}
+		return func() {
+			if ok == 0 {
+				ui.status = fmt.Sprintf("failed to publish %s: %s", what, lastErr)
+				return
+			}
+			ui.status = fmt.Sprintf("%s published to %d of %d relays", what, ok, len(ui.relays))
+			if evt.Kind == nostr.KindTextNote {
+				ui.addEvent(evt)
+				if ui.thread != nil {
+					ui.thread.events = append(ui.thread.events, evt)
+				}
+			}
+		}
+	})
+}
+
+func (ui *tuiState) react(target nostr.Event) {
+	ui.publish(nostr.Event{
+		Kind:      nostr.KindReaction,
+		CreatedAt: nostr.Now(),
+		Content:   "+",
+		Tags: nostr.Tags{
+			{"e", target.ID.Hex()},
+			{"p", target.PubKey.Hex()},
+			{"k", strconv.Itoa(int(target.Kind))},
+		},
+	}, "reaction")
+}
+
+func (ui *tuiState) reply(parent nostr.Event, text string) {
+	tags := nostr.Tags{}
+	if root, ok := nip10.GetThreadRoot(parent.Tags).(nostr.EventPointer); ok && root.ID != parent.ID {
+		tags = append(tags, nostr.Tag{"e", root.ID.Hex(), "", "root"}, nostr.Tag{"e", parent.ID.Hex(), "", "reply"})
+	} else {
+		tags = append(tags, nostr.Tag{"e", parent.ID.Hex(), "", "root"})
+	}
+
+	// everybody in the conversation gets notified
+	tags = append(tags, nostr.Tag{"p", parent.PubKey.Hex()})
+	for tag := range parent.Tags.FindAll("p") {
+		if len(tag) >= 2 && !tags.ContainsAny("p", []string{tag[1]}) {
+			tags = append(tags, nostr.Tag{"p", tag[1]})
+		}
+	}
+
+	ui.publish(nostr.Event{
+		Kind:      nostr.KindTextNote,
+		CreatedAt: nostr.Now(),
+		Content:   text,
+		Tags:      tags,
+	}, "reply")
+}
+
+func (ui *tuiState) zap(target nostr.Event) {
+	if ui.wallet == nil {
+		ui.status = "can't zap without a wallet, use --nwc"
+		return
+	}
+
+	amount := ui.zapAmount * 1000
+	ui.async(fmt.Sprintf("zapping %d sats to %s...", ui.zapAmount, ui.name(target.PubKey)), func() func() {
+		pm := sys.FetchProfileMetadata(ui.ctx, target.PubKey)
+		if pm.LUD16 == "" {
+			return func() { ui.status = "the author has no lightning address" }
+		}
+
+		invoice, err := fetchZapInvoice(ui.ctx, ui.kr, pm.LUD16, target, amount, ui.relays)
+		if err != nil {
+			return func() { ui.status = fmt.Sprintf("failed to get an invoice from %s: %s", pm.LUD16, err) }
+		}
+
+		if _, err := ui.wallet.payInvoice(ui.ctx, invoice); err != nil {
+			return func() { ui.status = fmt.Sprintf("payment failed: %s", err) }
+		}
+		return func() { ui.status = fmt.Sprintf("zapped %d sats to %s", amount/1000, pm.ShortName()) }
+	})
+}
+
+func (ui *tuiState) render() string {
+	width, height := max(ui.width, 20), max(ui.height, 5)
+	b := strings.Builder{}
+	b.WriteString("\x1b[H\x1b[2J")
+
+	line := func(text string, style string) {
+		text = tuiTruncate(text, width)
+		if style != "" {
+			b.WriteString(style + text + strings.Repeat(" ", width-len([]rune(text))) + "\x1b[0m")
+		} else {
+			b.WriteString(text)
+		}
+		b.WriteString("\r\n")
+	}
+
+	list := ui.current()
+	header := fmt.Sprintf(" nak tui · %d events · %s", len(ui.feed.events), strings.Join(ui.relays, " "))
+	if ui.thread != nil {
+		header = fmt.Sprintf(" thread · %d events", len(ui.thread.events))
+	}
+	line(header, "\x1b[1;7m")
+
+	body := height - 3
+	if ui.profile != nil {
+		ui.renderProfile(line, body)
+	} else {
+		if list.selected < list.offset {
+			list.offset = list.selected
+		}
+		if list.selected >= list.offset+body {
+			list.offset = list.selected - body + 1
+		}
+		list.offset = max(min(list.offset, len(list.events)-body), 0)
+
+		for i := list.offset; i < list.offset+body; i++ {
+			if i >= len(list.events) {
+				line("", "")
+				continue
+			}
+			evt := list.events[i]
+			text := fmt.Sprintf(" %5s  %-16s  %s", tuiRelativeTime(evt.CreatedAt),
+				tuiTruncate(ui.name(evt.PubKey), 16), tuiOneLine(evt.Content))
+			if evt.Kind != nostr.KindTextNote {
+				text = fmt.Sprintf(" %5s  %-16s  [kind %d] %s", tuiRelativeTime(evt.CreatedAt),
+					tuiTruncate(ui.name(evt.PubKey), 16), evt.Kind, tuiOneLine(evt.Content))
+			}
+			if i == list.selected {
+				line(text, "\x1b[7m")
+			} else {
+				line(text, "")
+			}
+		}
+	}
+
+	if ui.replyingTo != nil {
+		line(fmt.Sprintf(" reply to %s: %s_", ui.name(ui.replyingTo.PubKey), string(ui.input)), "\x1b[1m")
+	} else {
+		line(" "+ui.status, "\x1b[2m")
+	}
+	b.WriteString(tuiTruncate(" j/k move · enter thread · p profile · r react · R reply · z zap · esc back · q quit", width))
+	return b.String()
+}
+
+func (ui *tuiState) renderProfile(line func(string, string), lines int) {
+	pm := ui.profile
+	rows := []string{
+		"",
+		"  " + pm.ShortName(),
+		"",
+		"  npub:    " + pm.Npub(),
+	}
+	if pm.Name != "" {
+		rows = append(rows, "  name:    "+pm.Name)
+	}
+	if pm.NIP05 != "" {
+		rows = append(rows, "  nip05:   "+pm.NIP05)
+	}
+	if pm.LUD16 != "" {
+		rows = append(rows, "  lud16:   "+pm.LUD16)
+	}
+	if pm.Website != "" {
+		rows = append(rows, "  website: "+pm.Website)
+	}
+	if pm.About != "" {
+		rows = append(rows, "")
+		for _, paragraph := range strings.Split(pm.About, "\n") {
+			rows = append(rows, tuiWrap(paragraph, max(ui.width-4, 10), "  ")...)
+		}
+	}
+	if pm.Event == nil {
+		rows = append(rows, "", "  (no profile metadata found)")
+	}
+
+	for i := range lines {
+		if i < len(rows) {
+			line(rows[i], "")
+		} else {
+			line("", "")
+		}
+	}
+}
+
+// readTUIKeys turns the runes and escape sequences typed into key names.
+func readTUIKeys(term *tty.TTY, keys chan<- string) {
+	for {
+		r, err := term.ReadRune()
+		if err != nil {
+			close(keys)
+			return
+		}
+
+		switch r {
+		case 3:
+			keys <- "ctrl-c"
+		case '\r', '\n':
+			keys <- "enter"
+		case 127, 8:
+			keys <- "backspace"
+		case 27:
+			if !term.Buffered() {
+				keys <- "esc"
+				continue
+			}
+			if next, _ := term.ReadRune(); next != '[' && next != 'O' {
+				keys <- "esc"
+				continue
+			}
+			switch code, _ := term.ReadRune(); code {
+			case 'A':
+				keys <- "up"
+			case 'B':
+				keys <- "down"
+			case 'H':
+				keys <- "home"
+			case 'F':
+				keys <- "end"
+			}
+		default:
+			keys <- string(r)
+		}
+	}
+}
+
+func tuiOneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func tuiTruncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width <= 1 {
+		return string(runes[0:width])
+	}
+	return string(runes[0:width-1]) + "…"
+}
+
+func tuiWrap(text string, width int, indent string) []string {
+	var lines []string
+	current := indent
+	for _, word := range strings.Fields(text) {
+		if len([]rune(current))+len([]rune(word)) > width && current != indent {
+			lines = append(lines, current)
+			current = indent
+		}
+		if current != indent {
+			current += " "
+		}
+		current += word
+	}
+	return append(lines, current)
+}
+
+func tuiRelativeTime(ts nostr.Timestamp) string {
+	ago := time.Since(ts.Time())
+	switch {
+	case ago < time.Minute:
+		return fmt.Sprintf("%ds", max(int(ago.Seconds()), 0))
+	case ago < time.Hour:
+		return fmt.Sprintf("%dm", int(ago.Minutes()))
+	case ago < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(ago.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(ago.Hours()/24))
+	}
+}
+
+// fetchZapInvoice gets an invoice with a zap request for the given event from the lightning address of its author.
+func fetchZapInvoice(
+	ctx context.Context,
+	kr nostr.Keyer,
+	address string,
+	target nostr.Event,
+	amount uint64,
+	relays []string,
+) (string, error) {
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return "", fmt.Errorf("invalid lightning address '%s'", address)
+	}
+	scheme := "https"
+	if strings.HasSuffix(domain, ".onion") {
+		scheme = "http"
+	}
+
+	var params struct {
+		Callback    string `json:"callback"`
+		MinSendable uint64 `json:"minSendable"`
+		MaxSendable uint64 `json:"maxSendable"`
+		AllowsNostr bool   `json:"allowsNostr"`
+		Status      string `json:"status"`
+		Reason      string `json:"reason"`
+	}
+	if err := lnurlGetJSON(ctx, fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, name), &params); err != nil {
+		return "", err
+	}
+	if params.Status == "ERROR" {
+		return "", fmt.Errorf("%s", params.Reason)
+	}
+	if !params.AllowsNostr {
+		return "", fmt.Errorf("zaps are not supported")
+	}
+	if amount < params.MinSendable || amount > params.MaxSendable {
+		return "", fmt.Errorf("amount must be between %d and %d sats", params.MinSendable/1000, params.MaxSendable/1000)
+	}
+
+	zapRequest := nostr.Event{
+		Kind:      nostr.KindZapRequest,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			append(nostr.Tag{"relays"}, relays...),
+			{"amount", strconv.FormatUint(amount, 10)},
+			{"p", target.PubKey.Hex()},
+			{"e", target.ID.Hex()},
+		},
+	}
+	if err := kr.SignEvent(ctx, &zapRequest); err != nil {
+		return "", fmt.Errorf("failed to sign zap request: %w", err)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", fmt.Errorf("invalid callback '%s'", params.Callback)
+	}
+	q := callback.Query()
+	q.Set("amount", strconv.FormatUint(amount, 10))
+	q.Set("nostr", zapRequest.String())
+	callback.RawQuery = q.Encode()
+
+	var invoiceResp struct {
+		PR     string `json:"pr"`
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := lnurlGetJSON(ctx, callback.String(), &invoiceResp); err != nil {
+		return "", err
+	}
+	if invoiceResp.Status == "ERROR" || invoiceResp.PR == "" {
+		return "", fmt.Errorf("no invoice given: %s", invoiceResp.Reason)
+	}
+	return invoiceResp.PR, nil
+}
+
+// nwcConnection is a nip47 wallet connection, parsed from a nostr+walletconnect:// uri.
+type nwcConnection struct {
+	wallet nostr.PubKey
+	relays []string
+	secret nostr.SecretKey
+}
+
+func parseNWCURI(uri string) (*nwcConnection, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "nostr+walletconnect" && u.Scheme != "nostrwalletconnect") {
+		return nil, fmt.Errorf("invalid nwc uri, expected nostr+walletconnect://<pubkey>?relay=...&secret=...")
+	}
+
+	walletHex := u.Host
+	if walletHex == "" {
+		walletHex = u.Opaque
+	}
+	wallet, err := nostr.PubKeyFromHex(walletHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet pubkey in nwc uri: %w", err)
+	}
+	secret, err := nostr.SecretKeyFromHex(u.Query().Get("secret"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret in nwc uri: %w", err)
+	}
+	relays := u.Query()["relay"]
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("nwc uri has no relay")
+	}
+	if err := normalizeAndValidateRelayURLs(relays); err != nil {
+		return nil, fmt.Errorf("nwc uri has an invalid relay: %w", err)
+	}
+
+	return &nwcConnection{wallet: wallet, relays: relays, secret: secret}, nil
+}
+
+// payInvoice asks the wallet to pay the invoice and waits for its response, returning the preimage.
+func (conn *nwcConnection) payInvoice(ctx context.Context, invoice string) (string, error) {
+	ss, err := nip04.ComputeSharedSecret(conn.wallet, conn.secret)
+	if err != nil {
+		return "", err
+	}
+
+	payload, _ := stdjson.Marshal(map[string]any{
+		"method": "pay_invoice",
+		"params": map[string]any{"invoice": invoice},
+	})
+	content, err := nip04.Encrypt(string(payload), ss)
+	if err != nil {
+		return "", err
+	}
+
+	request := nostr.Event{
+		Kind:      nostr.KindNWCWalletRequest,
+		CreatedAt: nostr.Now(),
+		Content:   content,
+		Tags:      nostr.Tags{{"p", conn.wallet.Hex()}},
+	}
+	if err := request.Sign(conn.secret); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	// listen for the response before sending the request so we don't miss it
+	responses := sys.Pool.SubscribeMany(ctx, conn.relays, nostr.Filter{
+		Kinds:   []nostr.Kind{nostr.KindNWCWalletResponse},
+		Authors: []nostr.PubKey{conn.wallet},
+		Tags:    nostr.TagMap{"e": []string{request.ID.Hex()}},
+	}, nostr.SubscriptionOptions{Label: "nak-nwc"})
+
+	published := false
+	for res := range sys.Pool.PublishMany(ctx, conn.relays, request) {
+		if res.Error == nil {
+			published = true
+		}
+	}
+	if !published {
+		return "", fmt.Errorf("couldn't send the request to the wallet relays")
+	}
+
+	for ie := range responses {
+		plaintext, err := nip04.Decrypt(ie.Event.Content, ss)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt wallet response: %w", err)
+		}
+		var response struct {
+			Error *struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+			Result struct {
+				Preimage string `json:"preimage"`
+			} `json:"result"`
+		}
+		if err := stdjson.Unmarshal([]byte(plaintext), &response); err != nil {
+			return "", fmt.Errorf("invalid wallet response: %w", err)
+		}
+		if response.Error != nil {
+			return "", fmt.Errorf("%s: %s", response.Error.Code, response.Error.Message)
+		}
+		return response.Result.Preimage, nil
+	}
+
+	return "", fmt.Errorf("the wallet didn't respond in time")
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"fiatjaf.com/nostr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/urfave/cli/v3"
 )
 
@@ -13,40 +24,381 @@ var verify = &cli.Command{
 	Description: `example:
 		echo '{"id":"a889df6a387419ff204305f4c2d296ee328c3cd4f8b62f205648a541b4554dfb","pubkey":"c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5","created_at":1698623783,"kind":1,"tags":[],"content":"hello from the nostr army knife","sig":"84876e1ee3e726da84e5d195eb79358b2b3eaa4d9bd38456fde3e8a2af3f1cd4cda23f23fda454869975b3688797d4c66e12f4c51c1b43c6d2997c5e61865661"}' | nak verify
 
-it outputs nothing if the verification is successful.`,
+it outputs nothing if the verification is successful.
+
+events from stdin are verified in parallel and valid signatures are cached, so verifying the same events again is fast.
+
+with --explain it prints the canonical serialization that is hashed to produce the id, where the serialization of the fields as given diverges from it and the result of checking the signature against each candidate hash, which helps debugging hand-rolled signers.
+
+with --canonical the strings are checked for escapes other json encoders produce that differ from the canonical serialization (uppercase hex in \u escapes, escaped forward slashes, non-ascii characters written as \u escapes), which make the id not match when the signer hashed its own encoding. with --fix and the key of the author these events are signed again with the right id and printed, the others are printed as they are.
+
+example:
+		nak req -a <pubkey> relay.example.com | nak verify --fix --sec <key> | nak event relay.example.com`,
 	DisableSliceFlagSeparator: true,
+	Flags: append(defaultKeyFlags,
+		&cli.IntFlag{
+			Name:        "workers",
+			Usage:       "number of events to verify in parallel",
+			DefaultText: "number of cpus",
+		},
+		&cli.BoolFlag{
+			Name:  "explain",
+			Usage: "print a step-by-step report of how the id and signature were checked",
+		},
+		&cli.BoolFlag{
+			Name:  "canonical",
+			Usage: "check for non-canonical escapes in the strings and whether they are the reason the id doesn't match",
+		},
+		&cli.BoolFlag{
+			Name:  "fix",
+			Usage: "sign again the events whose id doesn't match because of non-canonical escapes (implies --canonical, requires the author key)",
+		},
+	),
 	Action: func(ctx context.Context, c *cli.Command) error {
+		workers := int(c.Int("workers"))
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+
+		if c.Bool("explain") {
+			for stdinEvent := range getJsonsOrBlank() {
+				if stdinEvent == "{}" {
+					stdinEvent = c.Args().First()
+					if stdinEvent == "" {
+						continue
+					}
+				}
+				if !explainEvent(stdinEvent) {
+					ctx = lineProcessingError(ctx, "event is invalid")
+				}
+			}
+			exitIfLineProcessingError(ctx)
+			return nil
+		}
+
+		if c.Bool("canonical") || c.Bool("fix") {
+			var kr nostr.Keyer
+			for stdinEvent := range getJsonsOrBlank() {
+				if stdinEvent == "{}" {
+					stdinEvent = c.Args().First()
+					if stdinEvent == "" {
+						continue
+					}
+				}
+				if err := checkCanonicalEscapes(ctx, c, stdinEvent, &kr); err != nil {
+					ctx = lineProcessingError(ctx, "%s", err)
+				}
+			}
+			exitIfLineProcessingError(ctx)
+			return nil
+		}
+
+		mu := sync.Mutex{}
+		failed := false
+		fail := func(msg string, args ...any) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = true
+			log(msg+"\n", args...)
+		}
+
+		queue := make(chan string)
+		wg := sync.WaitGroup{}
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for stdinEvent := range queue {
+					evt := nostr.Event{}
+					if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
+						fail("invalid event: %s", err)
+						logverbose("<>: invalid event.\n", evt.ID.Hex())
+						continue
+					}
+
+					validID, validSig := verifyEventCached(evt)
+					if !validID {
+						fail("invalid .id, expected %s, got %s", evt.GetID(), evt.ID)
+						logverbose("%s: invalid id.\n", evt.ID.Hex())
+						continue
+					}
+					if !validSig {
+						fail("invalid signature")
+						logverbose("%s: invalid signature.\n", evt.ID.Hex())
+						continue
+					}
+
+					logverbose("%s: valid.\n", evt.ID.Hex())
+				}
+			}()
+		}
+
 		for stdinEvent := range getJsonsOrBlank() {
-			evt := nostr.Event{}
-			if stdinEvent == "" {
+			if stdinEvent == "{}" {
 				stdinEvent = c.Args().First()
 				if stdinEvent == "" {
 					continue
 				}
 			}
+			queue <- stdinEvent
+		}
+		close(queue)
+		wg.Wait()
 
-			if err := json.Unmarshal([]byte(stdinEvent), &evt); err != nil {
-				ctx = lineProcessingError(ctx, "invalid event: %s", err)
-				logverbose("<>: invalid event.\n", evt.ID.Hex())
-				continue
-			}
+		if failed {
+			ctx = context.WithValue(ctx, LINE_PROCESSING_ERROR, true)
+		}
+		exitIfLineProcessingError(ctx)
+		return nil
+	},
+}
+
+// explainEvent prints how the id and signature of an event are checked and returns whether both are valid.
+func explainEvent(raw string) bool {
+	ok := true
+	pass := func(msg string, args ...any) {
+		stdout(colors.success("✓ ") + fmt.Sprintf(msg, args...))
+	}
+	fail := func(msg string, args ...any) {
+		ok = false
+		stdout(colors.error("✗ ") + fmt.Sprintf(msg, args...))
+	}
+
+	fields := make(map[string]stdjson.RawMessage)
+	if err := stdjson.Unmarshal([]byte(raw), &fields); err != nil {
+		fail("not a json object: %s", err)
+		return false
+	}
+	for _, key := range []string{"id", "pubkey", "created_at", "kind", "tags", "content", "sig"} {
+		if _, exists := fields[key]; !exists {
+			fail("field '%s' is missing", key)
+		}
+	}
+	if !ok {
+		return false
+	}
+
+	evt := nostr.Event{}
+	if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+		fail("invalid event: %s", err)
+		return false
+	}
+
+	// the id is the sha256 of [0,pubkey,created_at,kind,tags,content] serialized with no whitespace
+	// and with only the minimal escapes in strings
+	canonical := evt.Serialize()
+	id := sha256.Sum256(canonical)
+	stdout(colors.bold("canonical serialization:"))
+	stdout(string(canonical))
+	stdout(colors.bold("sha256: ") + hex.EncodeToString(id[:]))
 
-			if evt.GetID() != evt.ID {
-				ctx = lineProcessingError(ctx, "invalid .id, expected %s, got %s", evt.GetID(), evt.ID)
-				logverbose("%s: invalid id.\n", evt.ID.Hex())
-				continue
+	given := serializeAsGiven(fields)
+	givenId := sha256.Sum256(given.Bytes())
+
+	if evt.ID == nostr.ID(id) {
+		pass("id matches the canonical serialization")
+	} else {
+		fail("id is %s, expected %s", evt.ID.Hex(), hex.EncodeToString(id[:]))
+
+		if bytes.Equal(given.Bytes(), canonical) {
+			stdout("  the fields as given serialize to the canonical form, so the id was computed from something else")
+		} else {
+			start, end := divergence(canonical, given.Bytes())
+			stdout(fmt.Sprintf("  the serialization of the fields as given diverges from the canonical one at byte %d:", start))
+			stdout("  canonical: " + snippet(canonical, start, len(canonical)-end))
+			stdout("      given: " + snippet(given.Bytes(), start, given.Len()-end))
+			if evt.ID == nostr.ID(givenId) {
+				stdout("  the id matches the serialization of the fields as given, the signer didn't produce the canonical form")
 			}
+		}
+	}
+
+	pubkey, err := schnorr.ParsePubKey(evt.PubKey[:])
+	if err != nil {
+		fail("pubkey %s is not a valid point: %s", evt.PubKey.Hex(), err)
+		return false
+	}
+	pass("pubkey is a valid point")
+
+	sig, err := schnorr.ParseSignature(evt.Sig[:])
+	if err != nil {
+		fail("signature is malformed: %s", err)
+		return false
+	}
+
+	switch {
+	case sig.Verify(id[:], pubkey):
+		pass("signature is valid for the canonical id and pubkey %s", evt.PubKey.Hex())
+	case sig.Verify(evt.ID[:], pubkey):
+		fail("signature is valid for the given id but not for the canonical one")
+	case sig.Verify(givenId[:], pubkey):
+		fail("signature is valid for the hash of the fields as given but not for the canonical id")
+	default:
+		fail("signature doesn't match pubkey %s for any of the candidate hashes", evt.PubKey.Hex())
+	}
+
+	return ok
+}
+
+// serializeAsGiven puts the fields exactly as they were given in the id array, which is what a signer
+// that doesn't reencode them would have hashed.
+func serializeAsGiven(fields map[string]stdjson.RawMessage) *bytes.Buffer {
+	given := &bytes.Buffer{}
+	given.WriteString("[0")
+	for _, key := range []string{"pubkey", "created_at", "kind", "tags", "content"} {
+		given.WriteByte(',')
+		stdjson.Compact(given, fields[key])
+	}
+	given.WriteByte(']')
+	return given
+}
+
+// checkCanonicalEscapes reports the non-canonical escapes in an event and, with --fix, signs it again when
+// they are why the id doesn't match. the keyer is only gathered when the first event needs it.
+func checkCanonicalEscapes(ctx context.Context, c *cli.Command, raw string, kr *nostr.Keyer) error {
+	fields := make(map[string]stdjson.RawMessage)
+	if err := stdjson.Unmarshal([]byte(raw), &fields); err != nil {
+		return fmt.Errorf("not a json object: %w", err)
+	}
+	evt := nostr.Event{}
+	if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+
+	issues, fixable := canonicalEscapeIssues(fields)
+
+	if validID, validSig := verifyEventCached(evt); validID {
+		// the id was computed over the canonical form, so how the strings were escaped in transit doesn't matter
+		for _, issue := range issues {
+			logverbose("%s: %s (harmless, the id is right)\n", evt.ID.Hex(), issue)
+		}
+		if !validSig {
+			return fmt.Errorf("%s: invalid signature", evt.ID.Hex())
+		}
+		if c.Bool("fix") {
+			stdout(evt.String())
+		}
+		return nil
+	}
 
-			if !evt.VerifySignature() {
-				ctx = lineProcessingError(ctx, "invalid signature")
-				logverbose("%s: invalid signature.\n", evt.ID.Hex())
-				continue
+	for _, issue := range issues {
+		log("%s: %s\n", evt.ID.Hex(), issue)
+	}
+	givenId := sha256.Sum256(serializeAsGiven(fields).Bytes())
+	if len(issues) == 0 || evt.ID != nostr.ID(givenId) {
+		return fmt.Errorf("%s: invalid .id, expected %s, and not because of how the strings were escaped", evt.ID.Hex(), evt.GetID())
+	}
+	if !c.Bool("fix") {
+		return fmt.Errorf("%s: id was computed over a non-canonical serialization, expected %s", evt.ID.Hex(), evt.GetID())
+	}
+	if !fixable {
+		return fmt.Errorf("%s: can't be fixed, the strings don't decode to valid utf-8", evt.ID.Hex())
+	}
+
+	if *kr == nil {
+		keyer, _, err := gatherKeyerFromArguments(ctx, c)
+		if err != nil {
+			return err
+		}
+		*kr = keyer
+	}
+	pk, err := (*kr).GetPublicKey(ctx)
+	if err != nil {
+		return err
+	}
+	if pk != evt.PubKey {
+		return fmt.Errorf("%s: authored by %s, can't sign it again with the key of %s", evt.ID.Hex(), evt.PubKey.Hex(), pk.Hex())
+	}
+
+	oldId := evt.ID
+	if err := (*kr).SignEvent(ctx, &evt); err != nil {
+		return fmt.Errorf("error signing with provided key: %w", err)
+	}
+	log("%s: signed again as %s\n", oldId.Hex(), evt.ID.Hex())
+	stdout(evt.String())
+	return nil
+}
+
+// canonicalEscapeIssues describes the escapes in the strings of the tags and content that the canonical
+// serialization writes differently, one for each kind of problem found in each field. fixable is false
+// when the strings don't decode to valid unicode, so the intended text can't be known.
+func canonicalEscapeIssues(fields map[string]stdjson.RawMessage) (issues []string, fixable bool) {
+	fixable = true
+	for _, key := range []string{"tags", "content"} {
+		raw := fields[key]
+		seen := make(map[string]bool)
+		add := func(problem string, example []byte) {
+			if !seen[problem] {
+				seen[problem] = true
+				if example == nil {
+					issues = append(issues, fmt.Sprintf("%s has %s", key, problem))
+				} else {
+					issues = append(issues, fmt.Sprintf("%s has %s, like %s", key, problem, example))
+				}
 			}
+		}
 
-			logverbose("%s: valid.\n", evt.ID.Hex())
+		if !utf8.Valid(raw) {
+			add("invalid utf-8", nil)
+			fixable = false
 		}
 
-		exitIfLineProcessingError(ctx)
-		return nil
-	},
+		inString := false
+		for i := 0; i < len(raw); i++ {
+			switch {
+			case raw[i] == '"':
+				inString = !inString
+			case inString && raw[i] == '\\':
+				i++
+				switch raw[i] {
+				case '/':
+					add("escaped forward slashes", raw[i-1:i+1])
+				case 'u':
+					start := i - 1
+					cp, _ := strconv.ParseUint(string(raw[i+1:i+5]), 16, 32)
+					i += 4
+					switch {
+					case utf16.IsSurrogate(rune(cp)):
+						if i+6 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+							low, _ := strconv.ParseUint(string(raw[i+3:i+7]), 16, 32)
+							if utf16.DecodeRune(rune(cp), rune(low)) != utf8.RuneError {
+								i += 6
+								add("non-ascii characters escaped as \\u that should be written as utf-8", raw[start:i+1])
+								continue
+							}
+						}
+						add("unpaired surrogates", raw[start:i+1])
+						fixable = false
+					case cp >= 0x80:
+						add("non-ascii characters escaped as \\u that should be written as utf-8", raw[start:i+1])
+					case cp >= 0x20:
+						add("ascii characters escaped as \\u that don't need it", raw[start:i+1])
+					case cp == '\b' || cp == '\t' || cp == '\n' || cp == '\f' || cp == '\r':
+						add("control characters escaped as \\u instead of their short form", raw[start:i+1])
+					case bytes.ContainsAny(raw[start+2:i+1], "ABCDEF"):
+						add("uppercase hex in \\u escapes", raw[start:i+1])
+					}
+				}
+			}
+		}
+	}
+	return issues, fixable
+}
+
+// divergence returns the length of the common prefix and the length of the common suffix of a and b.
+func divergence(a, b []byte) (start int, end int) {
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+	return start, end
+}
+
+// snippet shows data[start:end] highlighted with some context around it.
+func snippet(data []byte, start int, end int) string {
+	from := max(start-16, 0)
+	to := min(end+16, len(data))
+	return fmt.Sprintf("%q", data[from:start]) + colors.errorf("%q", data[start:end]) + fmt.Sprintf("%q", data[end:to])
 }
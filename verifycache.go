@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+
+	"fiatjaf.com/nostr"
+)
+
+// signatures we've already verified are stored under the 'v' prefix, keyed by the event id and
+// with the signature as the value (so an event with the same id but a different sig is checked again).
+const verifiedPrefix = byte('v')
+
+// verifiedInMemory keeps at most two generations of verifiedGenerationSize signatures: when the newest
+// one fills up the oldest is dropped, so long-running commands don't grow it forever.
+var verifiedInMemory = &verifiedGenerations{current: make(map[nostr.ID][64]byte)}
+
+const verifiedGenerationSize = 50_000
+
+type verifiedGenerations struct {
+	mu       sync.Mutex
+	current  map[nostr.ID][64]byte
+	previous map[nostr.ID][64]byte
+}
+
+func (v *verifiedGenerations) Load(id nostr.ID) ([64]byte, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if sig, ok := v.current[id]; ok {
+		return sig, true
+	}
+	sig, ok := v.previous[id]
+	return sig, ok
+}
+
+func (v *verifiedGenerations) Store(id nostr.ID, sig [64]byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.current) >= verifiedGenerationSize {
+		v.previous = v.current
+		v.current = make(map[nostr.ID][64]byte, verifiedGenerationSize)
+	}
+	v.current[id] = sig
+}
+
+func makeVerifiedKey(id nostr.ID) []byte {
+	key := make([]byte, 1+32)
+	key[0] = verifiedPrefix
+	copy(key[1:], id[:])
+	return key
+}
+
+// verifyEventCached checks the id and signature of an event, remembering the valid ones so they
+// don't have to be checked again, which is what takes most of the time when going over many events.
+func verifyEventCached(evt nostr.Event) (validID bool, validSig bool) {
+	if evt.GetID() != evt.ID {
+		return false, false
+	}
+
+	if sig, ok := verifiedInMemory.Load(evt.ID); ok && sig == evt.Sig {
+		return true, true
+	}
+	if sys != nil && sys.KVStore != nil {
+		if sig, _ := sys.KVStore.Get(makeVerifiedKey(evt.ID)); bytes.Equal(sig, evt.Sig[:]) {
+			verifiedInMemory.Store(evt.ID, evt.Sig)
+			return true, true
+		}
+	}
+
+	if !evt.VerifySignature() {
+		return true, false
+	}
+
+	verifiedInMemory.Store(evt.ID, evt.Sig)
+	if sys != nil && sys.KVStore != nil {
+		sys.KVStore.Set(makeVerifiedKey(evt.ID), evt.Sig[:])
+	}
+	return true, true
+}
+
+// trackPoolEvent is the pool's event middleware: besides tracking hints and relays it remembers the
+// signatures the relay connections have just checked, so verifyEventCached doesn't check them again.
+func trackPoolEvent(ie nostr.RelayEvent) {
+	sys.TrackEventHintsAndRelays(ie)
+	if ie.Relay != nil && !ie.Relay.AssumeValid {
+		verifiedInMemory.Store(ie.Event.ID, ie.Event.Sig)
+	}
+}
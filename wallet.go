@@ -538,5 +538,6 @@ var wallet = &cli.Command{
 				},
 			},
 		},
+		walletCashu,
 	},
 }
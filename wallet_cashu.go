@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/nostr/nip60"
+	"fiatjaf.com/nostr/nip60/client"
+	"fiatjaf.com/nostr/nip61"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/elnosh/gonuts/cashu"
+	"github.com/elnosh/gonuts/cashu/nuts/nut01"
+	"github.com/urfave/cli/v3"
+)
+
+var walletCashu = &cli.Command{
+	Name:  "cashu",
+	Usage: "low-level tools for debugging nip60 wallets and nip61 nutzaps",
+	Description: `to send a nutzap use 'nak wallet nutzap'.
+
+example:
+		nak wallet cashu inspect
+		nak wallet cashu nutzaps
+		nak wallet cashu redeem`,
+	DisableSliceFlagSeparator: true,
+	Commands: []*cli.Command{
+		{
+			Name:                      "inspect",
+			Usage:                     "prints the wallet (kinds 17375 and 37375), token (7375) and history (7376) events with their contents decrypted",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "history",
+					Usage: "also print the history events",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				kr, _, err := gatherKeyerFromArguments(ctx, c)
+				if err != nil {
+					return err
+				}
+				pk, err := kr.GetPublicKey(ctx)
+				if err != nil {
+					return err
+				}
+
+				kinds := []nostr.Kind{17375, 37375, 7375}
+				if c.Bool("history") {
+					kinds = append(kinds, 7376)
+				}
+
+				relays := sys.FetchOutboxRelays(ctx, pk, 3)
+				for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{
+					Kinds:   kinds,
+					Authors: []nostr.PubKey{pk},
+				}, nostr.SubscriptionOptions{Label: "nak-wallet"}) {
+					out := struct {
+						nostr.Event
+						Decrypted any    `json:"decrypted,omitempty"`
+						Error     string `json:"error,omitempty"`
+					}{Event: ie.Event}
+
+					if ie.Event.Content != "" {
+						plaintext, err := kr.Decrypt(ctx, ie.Event.Content, pk)
+						if err != nil {
+							out.Error = fmt.Sprintf("failed to decrypt: %s", err)
+						} else if err := stdjson.Unmarshal([]byte(plaintext), &out.Decrypted); err != nil {
+							out.Decrypted = plaintext
+						}
+					}
+
+					j, _ := stdjson.Marshal(out)
+					stdout(string(j))
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:                      "nutzaps",
+			Usage:                     "lists nutzaps (kind 9321) received in the mints and relays from our kind 10019, checking their dleq proofs",
+			DisableSliceFlagSeparator: true,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the nutzap events instead of a summary",
+				},
+			},
+			Action: func(ctx context.Context, c *cli.Command) error {
+				pk, info, relays, err := loadNutzapInfo(ctx, c)
+				if err != nil {
+					return err
+				}
+
+				redeemed := redeemedNutzaps(ctx, pk, relays)
+				for nutzap := range fetchNutzaps(ctx, pk, info) {
+					if c.Bool("json") {
+						stdout(nutzap.evt)
+						continue
+					}
+
+					status := colors.successf("valid")
+					if !nutzap.valid {
+						status = colors.errorf("invalid")
+					}
+					if _, ok := redeemed[nutzap.evt.ID]; ok {
+						status += " redeemed"
+					}
+					stdout(fmt.Sprintf("%s %d sat from %s at %s, %s",
+						nutzap.evt.ID.Hex(), nutzap.amount, nip19.EncodeNpub(nutzap.evt.PubKey), nutzap.mint, status))
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:                      "redeem",
+			Usage:                     "swaps the proofs of received nutzaps into the wallet and marks them as redeemed with a kind 7376 event",
+			DisableSliceFlagSeparator: true,
+			Action: func(ctx context.Context, c *cli.Command) error {
+				pk, info, relays, err := loadNutzapInfo(ctx, c)
+				if err != nil {
+					return err
+				}
+
+				w, closew, err := prepareWallet(ctx, c)
+				if err != nil {
+					return err
+				}
+				defer closew()
+				if w.PrivateKey == nil {
+					return fmt.Errorf("wallet has no private key to unlock nutzaps, run 'nak wallet nutzap setup'")
+				}
+
+				kr, _, _ := gatherKeyerFromArguments(ctx, c)
+				redeemed := redeemedNutzaps(ctx, pk, relays)
+				total := uint64(0)
+				for nutzap := range fetchNutzaps(ctx, pk, info) {
+					if _, ok := redeemed[nutzap.evt.ID]; ok {
+						continue
+					}
+					if !nutzap.valid {
+						log("- skipping invalid nutzap %s\n", nutzap.evt.ID.Hex())
+						continue
+					}
+
+					log("- redeeming %d sat from nutzap %s... ", nutzap.amount, nutzap.evt.ID.Hex())
+					if err := w.Receive(ctx, nutzap.proofs, nutzap.mint, nip60.ReceiveOptions{
+						IntoMint: slices.Clone(w.Mints),
+						IsNutzap: true,
+					}); err != nil {
+						log("%s\n", colors.errorf("failed: %s", err))
+						continue
+					}
+					log("%s\n", colors.successf("ok"))
+					total += nutzap.amount
+
+					// the redemption marker is public so the sender and others can see it
+					content, _ := stdjson.Marshal(nostr.Tags{
+						{"direction", "in"},
+						{"amount", strconv.FormatUint(nutzap.amount, 10)},
+					})
+					history := nostr.Event{
+						Kind:      7376,
+						CreatedAt: nostr.Now(),
+						Tags: nostr.Tags{
+							{"e", nutzap.evt.ID.Hex(), nutzap.relay, "redeemed"},
+							{"p", nutzap.evt.PubKey.Hex()},
+						},
+					}
+					if history.Content, err = kr.Encrypt(ctx, string(content), pk); err != nil {
+						return fmt.Errorf("failed to encrypt history entry: %w", err)
+					}
+					if err := kr.SignEvent(ctx, &history); err != nil {
+						return fmt.Errorf("failed to sign history entry: %w", err)
+					}
+					for res := range sys.Pool.PublishMany(ctx, relays, history) {
+						if res.Error != nil {
+							logverbose("failed to publish redemption marker to %s: %s\n", res.RelayURL, res.Error)
+						}
+					}
+				}
+
+				log("redeemed %d sat, balance: ", total)
+				stdout(w.Balance())
+				return nil
+			},
+		},
+	},
+}
+
+type receivedNutzap struct {
+	evt    nostr.Event
+	relay  string
+	mint   string
+	amount uint64
+	proofs cashu.Proofs
+	valid  bool
+}
+
+// loadNutzapInfo gets our kind 10019, which says where we accept nutzaps, and our write relays.
+func loadNutzapInfo(ctx context.Context, c *cli.Command) (nostr.PubKey, nip61.Info, []string, error) {
+	info := nip61.Info{}
+
+	kr, _, err := gatherKeyerFromArguments(ctx, c)
+	if err != nil {
+		return nostr.ZeroPK, info, nil, err
+	}
+	pk, err := kr.GetPublicKey(ctx)
+	if err != nil {
+		return nostr.ZeroPK, info, nil, err
+	}
+
+	relays := sys.FetchWriteRelays(ctx, pk)
+	ie := sys.Pool.QuerySingle(ctx, relays, nostr.Filter{
+		Kinds:   []nostr.Kind{10019},
+		Authors: []nostr.PubKey{pk},
+	}, nostr.SubscriptionOptions{Label: "nak-wallet"})
+	if ie == nil {
+		return pk, info, relays, fmt.Errorf("no kind 10019 found, run 'nak wallet nutzap setup'")
+	}
+	if err := info.ParseEvent(ie.Event); err != nil {
+		return pk, info, relays, fmt.Errorf("invalid kind 10019: %w", err)
+	}
+	if len(info.Relays) == 0 {
+		info.Relays = relays
+	}
+
+	return pk, info, relays, nil
+}
+
+// redeemedNutzaps gets the ids of nutzaps marked as redeemed in our kind 7376 events.
+func redeemedNutzaps(ctx context.Context, pk nostr.PubKey, relays []string) map[nostr.ID]struct{} {
+	redeemed := make(map[nostr.ID]struct{})
+	for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{
+		Kinds:   []nostr.Kind{7376},
+		Authors: []nostr.PubKey{pk},
+	}, nostr.SubscriptionOptions{Label: "nak-wallet"}) {
+		for _, tag := range ie.Event.Tags {
+			if len(tag) >= 4 && tag[0] == "e" && tag[3] == "redeemed" {
+				if id, err := nostr.IDFromHex(tag[1]); err == nil {
+					redeemed[id] = struct{}{}
+				}
+			}
+		}
+	}
+	return redeemed
+}
+
+// fetchNutzaps gets the nutzaps sent to us in the mints we accept and checks their dleq proofs
+// against the mint keys.
+func fetchNutzaps(ctx context.Context, pk nostr.PubKey, info nip61.Info) chan receivedNutzap {
+	ch := make(chan receivedNutzap)
+
+	go func() {
+		defer close(ch)
+
+		keysets := make(map[string]map[uint64]*btcec.PublicKey)
+		for ie := range sys.Pool.FetchMany(ctx, info.Relays, nostr.Filter{
+			Kinds: []nostr.Kind{nostr.KindNutZap},
+			Tags:  nostr.TagMap{"p": []string{pk.Hex()}, "u": info.Mints},
+		}, nostr.SubscriptionOptions{Label: "nak-wallet"}) {
+			nutzap := receivedNutzap{evt: ie.Event, relay: ie.Relay.URL}
+			if tag := ie.Event.Tags.Find("u"); tag != nil {
+				nutzap.mint, _ = nostr.NormalizeHTTPURL(tag[1])
+			}
+			for _, tag := range ie.Event.Tags {
+				if len(tag) >= 2 && tag[0] == "proof" {
+					var proof cashu.Proof
+					if err := stdjson.Unmarshal([]byte(tag[1]), &proof); err == nil {
+						nutzap.proofs = append(nutzap.proofs, proof)
+					}
+				}
+			}
+
+			// all proofs must be from the same keyset for us to verify them in one go
+			if len(nutzap.proofs) > 0 && nutzap.mint != "" {
+				keysetId := nutzap.proofs[0].Id
+				keys, ok := keysets[nutzap.mint+keysetId]
+				if !ok {
+					if km, err := client.GetKeysetById(ctx, nutzap.mint, keysetId); err == nil {
+						keys, _ = nip60.ParseKeysetKeys(nut01.KeysMap(km))
+					} else {
+						logverbose("failed to get keyset %s from %s: %s\n", keysetId, nutzap.mint, err)
+					}
+					keysets[nutzap.mint+keysetId] = keys
+				}
+				if keys != nil {
+					nutzap.amount, nutzap.valid = nip61.VerifyNutzap(keys, ie.Event)
+				}
+			}
+			if !nutzap.valid {
+				nutzap.amount = nip61.GetAmountFromNutzap(ie.Event)
+			}
+
+			select {
+			case ch <- nutzap:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// cashuTokenJSON describes a cashuA or cashuB token for 'nak decode'.
+func cashuTokenJSON(input string) (string, error) {
+	token, err := cashu.DecodeToken(input)
+	if err != nil {
+		return "", err
+	}
+
+	out := struct {
+		Mint   string       `json:"mint"`
+		Unit   string       `json:"unit,omitempty"`
+		Memo   string       `json:"memo,omitempty"`
+		Amount uint64       `json:"amount"`
+		Proofs cashu.Proofs `json:"proofs"`
+	}{
+		Mint:   token.Mint(),
+		Amount: token.Amount(),
+		Proofs: token.Proofs(),
+	}
+	switch t := token.(type) {
+	case *cashu.TokenV4:
+		out.Unit, out.Memo = t.Unit, t.Memo
+	case *cashu.TokenV3:
+		out.Unit, out.Memo = t.Unit, t.Memo
+	}
+
+	j, _ := stdjson.MarshalIndent(out, "", "  ")
+	return string(j), nil
+}
+
+func isCashuToken(input string) bool {
+	return strings.HasPrefix(input, "cashuA") || strings.HasPrefix(input, "cashuB")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/fiatjaf/nak/lib"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/mailru/easyjson"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/urfave/cli/v3"
+)
+
+var wireFlag = &cli.StringFlag{
+	Name: "wire",
+	Usage: "'jsonl' or 'cbor' fetch events over http from relays that advertise an endpoint for that encoding in their nip11 " +
+		"document, skipping the parsing of websocket envelopes, the other relays (or any that fail) are queried through the websocket as usual",
+	Value: "websocket",
+	Validator: func(s string) error {
+		if s != "websocket" && s != "jsonl" && s != "cbor" {
+			return fmt.Errorf("invalid --wire '%s', expected websocket, jsonl or cbor", s)
+		}
+		return nil
+	},
+	Category: CATEGORY_EXTRAS,
+}
+
+// the content types we ask for and expect back from each encoding endpoint
+var wireContentTypes = map[string]string{
+	"jsonl": "application/x-ndjson",
+	"cbor":  "application/cbor-seq",
+}
+
+var (
+	// the "encodings" advertised by each relay in its nip11 document, fetched once per run
+	wireEndpoints = xsync.NewMapOf[string, map[string]string]()
+
+	// relays we got events from over http, they may never have had a websocket connection
+	wireRelays = xsync.NewMapOf[string, *nostr.Relay]()
+)
+
+// cborEvent is how events are encoded in the cbor sequences, like the json but with the
+// id, pubkey and sig as byte strings.
+type cborEvent struct {
+	ID        []byte     `cbor:"id"`
+	PubKey    []byte     `cbor:"pubkey"`
+	CreatedAt int64      `cbor:"created_at"`
+	Kind      uint16     `cbor:"kind"`
+	Tags      [][]string `cbor:"tags"`
+	Content   string     `cbor:"content"`
+	Sig       []byte     `cbor:"sig"`
+}
+
+func (ce cborEvent) toEvent() (nostr.Event, error) {
+	if len(ce.ID) != 32 || len(ce.PubKey) != 32 || len(ce.Sig) != 64 {
+		return nostr.Event{}, fmt.Errorf("invalid id, pubkey or sig length")
+	}
+	evt := nostr.Event{
+		CreatedAt: nostr.Timestamp(ce.CreatedAt),
+		Kind:      nostr.Kind(ce.Kind),
+		Tags:      make(nostr.Tags, len(ce.Tags)),
+		Content:   ce.Content,
+	}
+	copy(evt.ID[:], ce.ID)
+	copy(evt.PubKey[:], ce.PubKey)
+	copy(evt.Sig[:], ce.Sig)
+	for i, tag := range ce.Tags {
+		evt.Tags[i] = nostr.Tag(tag)
+	}
+	return evt, nil
+}
+
+// wireEndpoint returns the http endpoint a relay advertises for the given encoding, or "" if it doesn't.
+// the relay says that with an "encodings" object in its nip11 document, like
+// {"encodings": {"jsonl": "https://relay.example.com/query", "cbor": "https://relay.example.com/query"}}.
+func wireEndpoint(ctx context.Context, relayURL string, encoding string) string {
+	if endpoints, ok := wireEndpoints.Load(relayURL); ok {
+		return endpoints[encoding]
+	}
+
+	endpoints := make(map[string]string)
+	defer func() { wireEndpoints.Store(relayURL, endpoints) }()
+
+	normalized, _, err := lib.NormalizeRelayURL(relayURL)
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 7*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http"+normalized[2:], nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Encodings map[string]string `json:"encodings"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return ""
+	}
+	for enc, endpoint := range info.Encodings {
+		if u, err := url.Parse(endpoint); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			endpoints[enc] = endpoint
+		}
+	}
+	return endpoints[encoding]
+}
+
+// queryWireEndpoint posts the filter to an encoding endpoint and calls emit with each event in the response,
+// which ends like an EOSE or, when streaming, is kept open with new events as they arrive.
+// events that fail verification are skipped, the returned error means the relay must be queried some other way.
+func queryWireEndpoint(
+	ctx context.Context,
+	relayURL string,
+	endpoint string,
+	encoding string,
+	filter nostr.Filter,
+	stream bool,
+	skipVerify bool,
+	emit func(nostr.Event) bool,
+) error {
+	if stream {
+		u, _ := url.Parse(endpoint)
+		q := u.Query()
+		q.Set("stream", "true")
+		u.RawQuery = q.Encode()
+		endpoint = u.String()
+	}
+
+	body, _ := easyjson.Marshal(filter)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = relayRequestHeader("nak/s")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", wireContentTypes[encoding])
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, wireContentTypes[encoding]) {
+		return fmt.Errorf("got content-type '%s' instead of '%s'", ct, wireContentTypes[encoding])
+	}
+
+	handle := func(evt nostr.Event) bool {
+		if !skipVerify {
+			if validID, validSig := verifyEventCached(evt); !validID || !validSig {
+				logverbose("%s sent an event with an invalid id or signature: %s\n", endpoint, evt.ID.Hex())
+				return true
+			}
+		}
+		return emit(evt)
+	}
+
+	received := countingReader{resp.Body, relayTrafficCounter(relayURL)}
+	switch encoding {
+	case "jsonl":
+		scanner := bufio.NewScanner(received)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var evt nostr.Event
+			if err := easyjson.Unmarshal(line, &evt); err != nil {
+				return fmt.Errorf("invalid event: %w", err)
+			}
+			if !handle(evt) {
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		return scanner.Err()
+
+	case "cbor":
+		decoder := cbor.NewDecoder(received)
+		for {
+			var ce cborEvent
+			if err := decoder.Decode(&ce); err != nil {
+				if errors.Is(err, io.EOF) || ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("invalid cbor: %w", err)
+			}
+			evt, err := ce.toEvent()
+			if err != nil {
+				return fmt.Errorf("invalid event: %w", err)
+			}
+			if !handle(evt) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("unknown encoding '%s'", encoding)
+}
+
+// fetchManyWire is like sys.Pool.FetchManyNotifyClosed (or SubscribeManyNotifyClosed when streaming) but gets
+// the events over http from the relays that advertise an endpoint for the given encoding, using the websocket
+// for the others and for those whose endpoint fails, or stops sending events while streaming.
+func fetchManyWire(
+	ctx context.Context,
+	urls []string,
+	filter nostr.Filter,
+	opts nostr.SubscriptionOptions,
+	encoding string,
+	stream bool,
+	skipVerify bool,
+) (chan nostr.RelayEvent, chan nostr.RelayClosed) {
+	results := make(chan nostr.RelayEvent)
+	closeds := make(chan nostr.RelayClosed)
+
+	wg := sync.WaitGroup{}
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if endpoint := wireEndpoint(ctx, url, encoding); endpoint != "" {
+				relay, _ := wireRelays.LoadOrCompute(url, func() *nostr.Relay { return &nostr.Relay{URL: url} })
+				logverbose("querying %s through %s with %s\n", url, endpoint, encoding)
+				err := queryWireEndpoint(ctx, url, endpoint, encoding, filter, stream, skipVerify, func(evt nostr.Event) bool {
+					if opts.CheckDuplicate != nil && opts.CheckDuplicate(evt.ID, url) {
+						return true
+					}
+					select {
+					case results <- nostr.RelayEvent{Event: evt, Relay: relay}:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil && !stream {
+					return
+				}
+				if err == nil {
+					err = fmt.Errorf("stream ended")
+				}
+				log("%s %s endpoint failed (%s), falling back to the websocket\n", url, encoding, err)
+			}
+
+			var relayResults chan nostr.RelayEvent
+			var relayCloseds chan nostr.RelayClosed
+			if stream {
+				relayResults, relayCloseds = sys.Pool.SubscribeManyNotifyClosed(ctx, []string{url}, filter, opts)
+			} else {
+				relayResults, relayCloseds = sys.Pool.FetchManyNotifyClosed(ctx, []string{url}, filter, opts)
+			}
+			for {
+				select {
+				case ie, ok := <-relayResults:
+					if !ok {
+						return
+					}
+					select {
+					case results <- ie:
+					case <-ctx.Done():
+						return
+					}
+				case closed := <-relayCloseds:
+					select {
+					case closeds <- closed:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results, closeds
+}